@@ -1,52 +1,257 @@
 package goi
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"reflect"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	gos "github.com/grafana/go-generic-object-store"
 	"github.com/tmthrgd/shoco"
 )
 
+// ErrCompressionMismatch is returned when objects stored with one compression
+// are loaded into an ObjectIntern configured with a different compression.
+var ErrCompressionMismatch = errors.New("Compression mismatch")
+
+// ErrNoRefCounting is returned by methods that need reference counts when
+// reference counting is turned off.
+var ErrNoRefCounting = errors.New("Reference counting is turned off")
+
+// ErrEmptyObject is returned when trying to intern or look up an empty object.
+// Empty objects are never interned.
+var ErrEmptyObject = errors.New("Empty object")
+
+// ErrClosed is returned when an ObjectIntern is used after it has been closed.
+var ErrClosed = errors.New("ObjectIntern is closed")
+
+// ErrJoinTooLarge is returned by JoinStrings when the length of the joined string
+// would overflow an int.
+var ErrJoinTooLarge = errors.New("Joined string too large")
+
+// ErrCorruptObject is returned when the object store returns an object which is too
+// short to be a valid interned object.
+var ErrCorruptObject = errors.New("Corrupt object")
+
 // ObjectIntern stores a map of uintptrs to interned objects.
 // The string key itself uses an interned object for its data pointer
 type ObjectIntern struct {
 	sync.RWMutex
-	conf       ObjectInternConfig
-	store      gos.ObjectStore
-	objIndex   map[string]uintptr
-	compress   func(in []byte) []byte
-	decompress func(in []byte) ([]byte, error)
+	conf           ObjectInternConfig
+	store          Store
+	objIndex       map[string]uintptr
+	hashIndex      map[uint64]uintptr   // replaces objIndex if HashedIndex is turned on
+	hashCollisions map[uint64][]uintptr // colliding entries of hashIndex
+	unindexed      map[uintptr]struct{} // objects longer than MaxInternLen
+	nsIndex        map[string]uintptr   // objects in namespaces other than DefaultNamespace
+	nsOf           map[uintptr]Namespace
+	keyOf          map[uintptr]string    // index keys by address, nil unless ReverseIndex is turned on
+	deadlines      map[uintptr]time.Time // objects added by AddOrGetWithTTL
+	pinned         map[uintptr]struct{}  // objects pinned by InternConstants
+	live           map[uintptr]struct{}  // all interned objects, nil until the object store frees a slab, see mapped
+	frozen         uint32                // 1 once Freeze has been called, accessed atomically
+	frozenReaders  int64                 // readers of a frozen ObjectIntern without the read lock, see readLock
+	closing        uint32                // 1 once Close waits for frozenReaders, accessed atomically
+	relocations    map[uintptr]uintptr   // objects moved by the most recent compaction, see RelocationMap
+	model          *shoco.Model          // ShocoModel, or shoco.DefaultModel if it is not set
+	modelSum       uint64                // identifies model in snapshots, see shocoModelSum
+	compress       func(in []byte) []byte
+	compressInto   func(dst, in []byte) []byte // appends the compressed in to dst
+	decompress     func(in []byte) ([]byte, error)
+	decompressInto func(dst, in []byte) ([]byte, error) // appends the decompressed in to dst
+	cache          *decompressionCache
+	strCache       *stringCache
+	lastStr        *lastString // nil unless compression is turned on and strCache is disabled
+	handles        *handleTable
+	lockStats      *lockStats // nil unless LockStats is turned on
+	compactor      *compactor // nil unless AutoCompact is turned on
+	logQueue       []logEntry // events to pass to the Logger once the write lock is released
+	placeholders   []uintptr  // objects keeping the slabs mapped by PrewarmSizes, GrowSlabs and Reserve
+	slabAllocs     uint64     // slabs allocated by the object store, see SlabChurn
+	slabFrees      uint64     // slabs freed by the object store, see SlabChurn
+	hits           uint64     // objects found by AddOrGet and its variants, see HitStats
+	misses         uint64     // objects added by AddOrGet and its variants, see HitStats
+	fault          error      // first unexpected failure of the object store, see Health
+	refCntSize     uintptr    // 4 bytes in front of every object, 0 if DisableRefCounting is turned on
 }
 
 // NewObjectIntern returns a new ObjectIntern with the settings
 // provided in the ObjectInternConfig.
+// It panics if the ObjectInternConfig is invalid, see NewObjectInternE.
 func NewObjectIntern(c ObjectInternConfig) *ObjectIntern {
+	oi, err := NewObjectInternE(c)
+	if err != nil {
+		panic(err.Error())
+	}
+	return oi
+}
+
+// NewObjectInternE returns a new ObjectIntern with the settings
+// provided in the ObjectInternConfig and nil.
+// If the Compression is not recognized or not implemented yet, the
+// SlabSize is 0, the InitialIndexCapacity is negative, AutoCompact is
+// turned on without HandlesOnly or a CompactInterval, PrewarmSizes contains 0,
+// PrewarmSlabs is negative, or ShocoModel is not a valid model, it returns nil and an error.
+//
+// If AutoCompact is turned on this starts the background compaction, which
+// keeps running until Close is called.
+func NewObjectInternE(c ObjectInternConfig) (*ObjectIntern, error) {
+	if err := checkCompression(c.Compression); err != nil {
+		return nil, err
+	}
+	if c.SlabSize == 0 {
+		return nil, fmt.Errorf("SlabSize must be larger than 0")
+	}
+	if c.InitialIndexCapacity < 0 {
+		return nil, fmt.Errorf("InitialIndexCapacity must not be negative")
+	}
+	if c.AutoCompact && !c.HandlesOnly {
+		return nil, fmt.Errorf("AutoCompact requires HandlesOnly, compaction moves objects to different addresses")
+	}
+	if c.AutoCompact && c.CompactInterval <= 0 {
+		return nil, fmt.Errorf("CompactInterval must be larger than 0")
+	}
+	if c.PrewarmSlabs < 0 {
+		return nil, fmt.Errorf("PrewarmSlabs must not be negative")
+	}
+	for _, size := range c.PrewarmSizes {
+		if size == 0 {
+			return nil, fmt.Errorf("PrewarmSizes must not contain 0")
+		}
+	}
+
+	model := shoco.DefaultModel
+	if len(c.ShocoModel) > 0 {
+		var err error
+		if model, err = parseShocoModel(c.ShocoModel); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.NewStore == nil {
+		c.NewStore = NewGosStore
+	}
+	if c.HashSeed == 0 {
+		c.HashSeed = randomSeed()
+	}
+
 	oi := ObjectIntern{
 		conf:     c,
-		store:    gos.NewObjectStore(c.SlabSize),
-		objIndex: make(map[string]uintptr),
+		store:    c.NewStore(c.SlabSize),
+		handles:  newHandleTable(),
+		model:    model,
+		modelSum: shocoModelSum(model),
+	}
+	if !c.DisableRefCounting {
+		oi.refCntSize = 4
+	}
+	if c.LockStats {
+		oi.lockStats = &lockStats{}
+	}
+	oi.newIndex()
+
+	oi.setCompression()
+
+	if err := oi.prewarm(); err != nil {
+		return nil, fmt.Errorf("Could not prewarm the object store: %s", err)
+	}
+
+	if oi.conf.AutoCompact {
+		oi.compactor = newCompactor()
+		go oi.compactLoop(oi.compactor)
+	}
+
+	return &oi, nil
+}
+
+// randomSeed returns a random HashSeed, which is never 0
+func randomSeed() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// the seed only needs to be hard to guess, so the time will do if there's no entropy
+		return uint64(time.Now().UnixNano()) | 1
+	}
+	return binary.LittleEndian.Uint64(b[:]) | 1
+}
+
+// checkCompression returns nil if c is implemented, otherwise an error
+func checkCompression(c Compression) error {
+	switch c {
+	case None, Shoco:
+		return nil
+	case ShocoDict:
+		return fmt.Errorf("Compression ShocoDict not implemented yet")
+	default:
+		return fmt.Errorf("Compression %d not recognized", c)
 	}
+}
 
-	// set compression and decompression functions
+// setCompression sets the compression and decompression functions and the caches
+// for the compression in oi.conf, replacing any previous ones.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) setCompression() {
 	switch oi.conf.Compression {
 	case Shoco:
-		oi.compress = shoco.Compress
-		oi.decompress = shoco.Decompress
-	case ShocoDict:
-		panic("Compression ShocoDict not implemented yet")
+		compressible := oi.conf.CompressibilityFn
+		verify := oi.conf.VerifyCompression
+		model := oi.model
+		oi.compressInto = func(dst, in []byte) []byte {
+			// objects which are unlikely to compress are stored in shoco's literal encoding,
+			// which decompresses like any other object, so reads don't need to tell them apart
+			if compressible != nil && !compressible(in) {
+				return appendShocoLiteral(dst, in)
+			}
+			if verify {
+				return shocoCompressVerified(model, dst, in)
+			}
+			return shocoCompressInto(model, dst, in)
+		}
+		oi.compress = func(in []byte) []byte {
+			return oi.compressInto(make([]byte, 0, len(in)), in)
+		}
+		oi.decompress = model.Decompress
+		oi.decompressInto = func(dst, in []byte) ([]byte, error) {
+			return shocoDecompressInto(model, dst, in)
+		}
 	case None:
 		oi.compress = func(in []byte) []byte { return in }
+		oi.compressInto = func(dst, in []byte) []byte { return append(dst, in...) }
 		oi.decompress = func(in []byte) ([]byte, error) { return in, nil }
-	default:
-		panic(fmt.Sprintf("Compression %d not recognized", oi.conf.Compression))
+		oi.decompressInto = func(dst, in []byte) ([]byte, error) { return append(dst, in...), nil }
+	}
+
+	oi.cache = nil
+	oi.strCache = nil
+	oi.lastStr = nil
+
+	// there is nothing to cache if we never decompress
+	if oi.conf.Compression != None {
+		oi.cache = newDecompressionCache(oi.conf.CacheSize)
+		oi.strCache = newStringCache(oi.conf.StringCacheSize, oi.conf.CacheTTL)
+		// the string cache already remembers recently read strings, and they must expire after CacheTTL
+		if oi.strCache == nil {
+			oi.lastStr = newLastString()
+		}
 	}
+}
 
-	return &oi
+// Compression returns the compression the ObjectIntern was configured with, or switched
+// to by Recompress. If it is not None, methods such as GetStringFromPtr or ObjBytes return
+// freshly decompressed copies instead of referencing the interned data.
+func (oi *ObjectIntern) Compression() Compression {
+	return oi.conf.Compression
 }
 
 // CompressionFunc returns the current compression func used by the library
@@ -92,6 +297,110 @@ func (oi *ObjectIntern) DecompressString(in string) (string, error) {
 	return string(b), err
 }
 
+// CompressBatch returns a compressed version of every []byte in ins. Its indexes match the
+// indexes of ins, and every result is the same as the one returned by Compress.
+//
+// If compression is turned on all objects are compressed into a single buffer, which
+// is allocated once per call instead of once per object. The returned slices are capped,
+// so appending to one of them never overwrites another one. If compression is turned off
+// the returned slices are the ones in ins, just like Compress returns its input.
+func (oi *ObjectIntern) CompressBatch(ins [][]byte) [][]byte {
+	outs := make([][]byte, len(ins))
+	if oi.conf.Compression == None {
+		copy(outs, ins)
+		return outs
+	}
+
+	var size int
+	for _, in := range ins {
+		size += len(in)
+	}
+	buf := make([]byte, 0, size)
+	for idx, in := range ins {
+		start := len(buf)
+		buf = oi.compressInto(buf, in)
+		outs[idx] = buf[start:]
+	}
+
+	return rebase(outs, buf)
+}
+
+// DecompressBatch returns a decompressed version of every []byte in ins and a slice of
+// errors. The indexes of both match the indexes of ins, and every result is the same as
+// the one returned by Decompress. If an object could not be decompressed its result is
+// nil and the error at the same index is set, otherwise the error is nil.
+//
+// Just like CompressBatch, all objects are decompressed into a single buffer, and the
+// returned slices are capped.
+func (oi *ObjectIntern) DecompressBatch(ins [][]byte) ([][]byte, []error) {
+	outs := make([][]byte, len(ins))
+	errs := make([]error, len(ins))
+	if oi.conf.Compression == None {
+		copy(outs, ins)
+		return outs, errs
+	}
+
+	var size int
+	for _, in := range ins {
+		size += len(in)
+	}
+	// compressed objects are usually smaller than the original ones
+	buf := make([]byte, 0, size*2)
+	for idx, in := range ins {
+		start := len(buf)
+		out, err := oi.decompressInto(buf, in)
+		if err != nil {
+			errs[idx] = err
+			continue
+		}
+		buf = out
+		outs[idx] = buf[start:]
+	}
+
+	return rebase(outs, buf), errs
+}
+
+// rebase points every slice in outs into buf and returns outs. The slices must have been
+// appended to buf back to back, in the same order, but buf may have been reallocated while
+// it grew, so earlier slices may still point into a previous array. Nil slices are kept.
+// The returned slices are capped at their length.
+func rebase(outs [][]byte, buf []byte) [][]byte {
+	var start int
+	for idx, out := range outs {
+		if out == nil {
+			continue
+		}
+		end := start + len(out)
+		outs[idx] = buf[start:end:end]
+		start = end
+	}
+	return outs
+}
+
+// normalize applies the configured Normalize func to obj before it is used to
+// add or look up an object, followed by trimming whitespace if TrimSpace is turned on.
+// If neither is configured obj is returned as is.
+func (oi *ObjectIntern) normalize(obj []byte) []byte {
+	if oi.conf.Normalize != nil {
+		obj = oi.conf.Normalize(obj)
+	}
+	if oi.conf.TrimSpace {
+		obj = bytes.TrimSpace(obj)
+	}
+	return obj
+}
+
+// normalizeString is the same as normalize, but for strings
+func (oi *ObjectIntern) normalizeString(obj string) string {
+	if oi.conf.Normalize != nil {
+		obj = string(oi.conf.Normalize([]byte(obj)))
+	}
+	if oi.conf.TrimSpace {
+		obj = strings.TrimSpace(obj)
+	}
+	return obj
+}
+
 // getAndIncrement increments the reference count of an object in the
 // index and returns its address and true.
 //
@@ -99,17 +408,49 @@ func (oi *ObjectIntern) DecompressString(in string) (string, error) {
 //
 // The caller is responsible for locking and unlocking.
 func (oi *ObjectIntern) getAndIncrement(obj []byte) (uintptr, bool) {
+	return oi.getAndIncrementNS(DefaultNamespace, obj)
+}
+
+// getAndIncrementNS is the same as getAndIncrement, but looks up obj in the namespace ns
+func (oi *ObjectIntern) getAndIncrementNS(ns Namespace, obj []byte) (uintptr, bool) {
+	// the reference count of a frozen object can't change, add returns ErrFrozen instead
+	if oi.Frozen() {
+		return 0, false
+	}
+
 	// try to find the object in the index
-	addr, ok := oi.objIndex[string(obj)]
+	addr, ok := oi.indexGetNS(ns, obj)
 	if ok {
-		// increment reference count by 1
-		atomic.AddUint32((*uint32)(unsafe.Pointer(addr)), 1)
+		if !oi.conf.DisableRefCounting {
+			// increment reference count by 1
+			atomic.AddUint32((*uint32)(unsafe.Pointer(addr)), 1)
+		}
+		atomic.AddUint64(&oi.hits, 1)
+		return addr, true
+	}
+	return 0, false
+}
+
+// getAndIncrementString is the same as getAndIncrement, but takes obj as a string
+func (oi *ObjectIntern) getAndIncrementString(obj string) (uintptr, bool) {
+	if oi.Frozen() {
+		return 0, false
+	}
+
+	addr, ok := oi.indexGetString(obj)
+	if ok {
+		if !oi.conf.DisableRefCounting {
+			// increment reference count by 1
+			atomic.AddUint32((*uint32)(unsafe.Pointer(addr)), 1)
+		}
+		atomic.AddUint64(&oi.hits, 1)
 		return addr, true
 	}
 	return 0, false
 }
 
 // add sets the initial reference count for a new object and adds it to the store and index.
+// It counts the object as a miss of AddOrGet, see HitStats.
 //
 // Upon success it returns the address of the newly stored object and nil
 //
@@ -117,7 +458,21 @@ func (oi *ObjectIntern) getAndIncrement(obj []byte) (uintptr, bool) {
 //
 // The caller is responsible for locking and unlocking.
 func (oi *ObjectIntern) add(obj []byte) (uintptr, error) {
-	objString := string(obj)
+	addr, err := oi.addNS(DefaultNamespace, obj)
+	if err == nil {
+		oi.misses++
+	}
+	return addr, err
+}
+
+// addNS is the same as add, but adds obj to the namespace ns. It doesn't count a miss, since
+// it is also used to move objects which are already interned.
+func (oi *ObjectIntern) addNS(ns Namespace, obj []byte) (uintptr, error) {
+	if oi.Frozen() {
+		return 0, ErrFrozen
+	}
+
+	key := obj
 
 	// We need to set its initial reference count to 1 before adding it.
 	//
@@ -125,18 +480,27 @@ func (oi *ObjectIntern) add(obj []byte) (uintptr, error) {
 	// we need to manage it at this layer. Here we add 4 bytes to be used
 	// henceforth as the reference count for this object. Reference count is
 	// always placed as the FIRST 4 bytes of an object and is NEVER compressed.
-	obj = append([]byte{0x1, 0x0, 0x0, 0x0}, obj...)
-	addr, err := oi.store.Add(obj)
+	// If reference counting is turned off the object is stored as is.
+	if !oi.conf.DisableRefCounting {
+		obj = append([]byte{0x1, 0x0, 0x0, 0x0}, obj...)
+	}
+	addr, err := oi.storeAdd(obj)
 	if err != nil {
 		return 0, err
 	}
+	if oi.conf.Logger != nil {
+		oi.log(EventCreated, map[string]interface{}{"addr": addr, "len": len(key)})
+	}
 
-	// set objString data to the object inside the object store
-	// we need to add 4 at the beginning for the reference count
-	((*reflect.StringHeader)(unsafe.Pointer(&objString))).Data = addr + 4
+	// objects longer than MaxInternLen are never deduplicated, so they are not indexed
+	if oi.conf.MaxInternLen > 0 && len(key) > oi.conf.MaxInternLen {
+		oi.unindexed[addr] = struct{}{}
+		oi.track(addr)
+		return addr, nil
+	}
 
 	// add the object to the index
-	oi.objIndex[objString] = addr
+	oi.indexAddNS(ns, key, addr)
 
 	return addr, nil
 }
@@ -147,9 +511,15 @@ func (oi *ObjectIntern) add(obj []byte) (uintptr, error) {
 // that might modify the backing array.
 // On failure it returns 0 and an error
 //
+// Empty objects, including nil, are never interned. For them it returns 0 and ErrEmptyObject.
+//
 // If the object is found in the store its reference count is increased by 1.
 // If the object is added to the store its reference count is set to 1.
 func (oi *ObjectIntern) AddOrGet(obj []byte, safe bool) (uintptr, error) {
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return 0, ErrEmptyObject
+	}
 
 	// if either of these two terms is true then the rest of this block
 	// requires a lot of allocations
@@ -191,7 +561,7 @@ func (oi *ObjectIntern) AddOrGet(obj []byte, safe bool) (uintptr, error) {
 
 		oi.RUnlock()
 
-		oi.Lock()
+		oi.lock()
 
 		// re-check everything
 		addr, ok = oi.getAndIncrement(objComp)
@@ -222,7 +592,7 @@ func (oi *ObjectIntern) AddOrGet(obj []byte, safe bool) (uintptr, error) {
 
 	oi.RUnlock()
 
-	oi.Lock()
+	oi.lock()
 
 	// re-check everything
 	addr, ok = oi.getAndIncrement(obj)
@@ -242,16 +612,214 @@ func (oi *ObjectIntern) AddOrGet(obj []byte, safe bool) (uintptr, error) {
 
 }
 
+// AddOrGetFromString is the same as AddOrGet, but takes the object as a string.
+// On failure it returns 0 and an error
+//
+// If compression is turned off and the object is already interned, it is looked up with s
+// itself, so finding it doesn't allocate. s is only converted to a []byte when the object
+// needs to be compressed or added to the store. Since strings are immutable the conversion
+// always creates a copy, so safe has no effect, it is only kept for symmetry with AddOrGet.
+//
+// If the object is found in the store its reference count is increased by 1.
+// If the object is added to the store its reference count is set to 1.
+func (oi *ObjectIntern) AddOrGetFromString(s string, safe bool) (uintptr, error) {
+	s = oi.normalizeString(s)
+	if len(s) == 0 {
+		return 0, ErrEmptyObject
+	}
+
+	var obj []byte
+
+	if oi.conf.Compression == None {
+		// acquire lock
+		oi.RLock()
+
+		addr, ok := oi.getAndIncrementString(s)
+		if ok {
+			oi.RUnlock()
+			return addr, nil
+		}
+
+		oi.RUnlock()
+
+		// we add 4 bytes to the capacity in case we need to append a reference count
+		obj = make([]byte, len(s), len(s)+4)
+		copy(obj, s)
+	} else {
+		// this returns a new byte slice
+		obj = oi.compress([]byte(s))
+
+		// acquire lock
+		oi.RLock()
+
+		addr, ok := oi.getAndIncrement(obj)
+		if ok {
+			oi.RUnlock()
+			return addr, nil
+		}
+
+		oi.RUnlock()
+	}
+
+	oi.lock()
+
+	// re-check everything
+	addr, ok := oi.getAndIncrement(obj)
+	if ok {
+		oi.Unlock()
+		return addr, nil
+	}
+
+	addr, err := oi.add(obj)
+	if err != nil {
+		oi.Unlock()
+		return 0, err
+	}
+
+	oi.Unlock()
+	return addr, nil
+}
+
+// AddOrGetMap finds or adds every object in objs and returns a map from each distinct
+// object, as it was passed in, to its address and nil. safe has the same meaning as for AddOrGet.
+// On failure it returns nil and an error, and the references acquired so far are released.
+//
+// Duplicates in objs are only interned once, so the reference count of every object is
+// increased by 1 for each distinct input, no matter how often it occurs in objs.
+func (oi *ObjectIntern) AddOrGetMap(objs [][]byte, safe bool) (map[string]uintptr, error) {
+	addrs := make(map[string]uintptr, len(objs))
+	for _, obj := range objs {
+		if _, ok := addrs[string(obj)]; ok {
+			continue
+		}
+
+		addr, err := oi.AddOrGet(obj, safe)
+		if err != nil {
+			for _, addr := range addrs {
+				oi.Delete(addr)
+			}
+			return nil, err
+		}
+		addrs[string(obj)] = addr
+	}
+	return addrs, nil
+}
+
+// readerBufPool holds the buffers used by AddOrGetReader
+var readerBufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// AddOrGetReader reads an object from r until EOF and then does the same thing as AddOrGet.
+// sizeHint is the expected size of the object, it is used to size the buffer the object is read into.
+// The buffers are reused, so callers don't need to allocate a []byte for every object.
+// On failure it returns 0 and an error. If reading from r fails nothing is interned.
+func (oi *ObjectIntern) AddOrGetReader(r io.Reader, sizeHint int) (uintptr, error) {
+	buf := readerBufPool.Get().(*bytes.Buffer)
+	defer readerBufPool.Put(buf)
+
+	buf.Reset()
+	if sizeHint > 0 {
+		buf.Grow(sizeHint)
+	}
+
+	_, err := buf.ReadFrom(r)
+	if err != nil {
+		return 0, err
+	}
+
+	// the buffer needs to be copied, it gets reused once we return
+	return oi.AddOrGet(buf.Bytes(), true)
+}
+
+// AddOrGetConcat interns the concatenation of parts as a single object and does the same
+// thing as AddOrGet. The parts are joined in a pooled buffer, so callers building keys from
+// a shared prefix and varying suffixes don't need to allocate the joined slice themselves.
+// On failure it returns 0 and an error.
+func (oi *ObjectIntern) AddOrGetConcat(parts ...[]byte) (uintptr, error) {
+	bufPtr := joinBufPool.Get().(*[]byte)
+	buf := (*bufPtr)[:0]
+	defer func() {
+		// keep the grown buffer for the next call
+		*bufPtr = buf
+		joinBufPool.Put(bufPtr)
+	}()
+
+	for _, part := range parts {
+		buf = append(buf, part...)
+	}
+
+	// the buffer needs to be copied, it gets reused once we return
+	return oi.AddOrGet(buf, true)
+}
+
+// AddOrGetAllLines splits data at every occurrence of sep and does the same thing as AddOrGet
+// for every line, returning their addresses in the order of the lines and nil. Empty lines,
+// including the one following a trailing separator, are skipped, since empty objects are
+// never interned. On failure it returns nil and an error, and the references acquired so
+// far are released.
+//
+// The lines are interned in place, data is never modified and only the interned objects
+// are copied into the object store, so data may be a read-only memory mapped file. If
+// compression is turned on, all lines are compressed into a single reused buffer.
+func (oi *ObjectIntern) AddOrGetAllLines(data []byte, sep byte) ([]uintptr, error) {
+	addrs := make([]uintptr, 0, bytes.Count(data, []byte{sep})+1)
+
+	var scratch []byte
+	for len(data) > 0 {
+		line := data
+		if idx := bytes.IndexByte(data, sep); idx >= 0 {
+			line, data = data[:idx], data[idx+1:]
+		} else {
+			data = nil
+		}
+		if len(line) == 0 {
+			continue
+		}
+
+		var addr uintptr
+		var err error
+		addr, scratch, err = oi.AddOrGetBuf(line, scratch, false)
+		if err != nil {
+			for _, addr := range addrs {
+				oi.Delete(addr)
+			}
+			return nil, fmt.Errorf("Could not intern line %d: %s", len(addrs), err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
 // AddOrGetString finds or adds an object and then returns a string with its Data pointer set to the newly interned object and nil.
 // This method takes a []byte of the object, and a bool. If safe is set to true
 // then this method will create a copy of the []byte before performing any operations
 // that might modify the backing array. If compression is turned on this method returns
 // a decompressed version of the string, which means it does not use the interned data.
+// If the decompression cache is enabled as well, the string aliases the cached decompressed
+// object, so repeated calls for the same object only allocate for its compression.
 // On failure it returns an empty string and an error
 //
 // If the object is found in the store its reference count is increased by 1.
 // If the object is added to the store its reference count is set to 1.
 func (oi *ObjectIntern) AddOrGetString(obj []byte, safe bool) (string, error) {
+	str, _, err := oi.AddOrGetStringAddr(obj, safe)
+	return str, err
+}
+
+// AddOrGetStringAddr is the same as AddOrGetString, but it also returns the address of the
+// interned object, so it can later be used with Delete or RefCnt without looking it up again.
+// On failure it returns an empty string, 0 and an error
+//
+// In every branch the length of the returned string is the length of the normalized but
+// uncompressed object, never the length of its stored form.
+func (oi *ObjectIntern) AddOrGetStringAddr(obj []byte, safe bool) (string, uintptr, error) {
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return "", 0, ErrEmptyObject
+	}
 
 	// if either of these two terms is true then the rest of this block
 	// requires a lot of allocations
@@ -266,13 +834,9 @@ func (oi *ObjectIntern) AddOrGetString(obj []byte, safe bool) (string, error) {
 
 			addr, ok := oi.getAndIncrement(obj)
 			if ok {
-				stringHeader := &reflect.StringHeader{
-					// add 4 for reference count
-					Data: addr + 4,
-					Len:  len(obj),
-				}
+				str := oi.internedString(addr, obj)
 				oi.RUnlock()
-				return (*(*string)(unsafe.Pointer(stringHeader))), nil
+				return str, addr, nil
 			}
 
 			oi.RUnlock()
@@ -296,61 +860,49 @@ func (oi *ObjectIntern) AddOrGetString(obj []byte, safe bool) (string, error) {
 		addr, ok := oi.getAndIncrement(objComp)
 		if ok {
 			if oi.conf.Compression == None {
-				// create a StringHeader and set its values appropriately
-				stringHeader := &reflect.StringHeader{
-					// add 4 for reference count
-					Data: addr + 4,
-					Len:  len(objComp),
-				}
+				str := oi.internedString(addr, obj)
 				oi.RUnlock()
-				return (*(*string)(unsafe.Pointer(stringHeader))), nil
+				return str, addr, nil
 			}
 			// don't want to return compressed data, so we create a string from the original object
+			str := oi.decompressedString(addr, obj)
 			oi.RUnlock()
-			return string(obj), nil
+			return str, addr, nil
 		}
 
 		oi.RUnlock()
 
-		oi.Lock()
+		oi.lock()
 
 		// re-check everything
 		addr, ok = oi.getAndIncrement(objComp)
 		if ok {
 			if oi.conf.Compression == None {
-				// create a StringHeader and set its values appropriately
-				stringHeader := &reflect.StringHeader{
-					// add 4 for reference count
-					Data: addr + 4,
-					Len:  len(objComp),
-				}
+				str := oi.internedString(addr, obj)
 				oi.Unlock()
-				return (*(*string)(unsafe.Pointer(stringHeader))), nil
+				return str, addr, nil
 			}
 			// don't want to return compressed data, so we create a string from the original object
+			str := oi.decompressedString(addr, obj)
 			oi.Unlock()
-			return string(obj), nil
+			return str, addr, nil
 		}
 
 		addr, err := oi.add(objComp)
 		if err != nil {
 			oi.Unlock()
-			return "", err
+			return "", 0, err
 		}
 
-		oi.Unlock()
 		if oi.conf.Compression != None {
 			// don't want to return compressed data, so we create a string from the original object
-			return string(obj), nil
+			str := oi.decompressedString(addr, obj)
+			oi.Unlock()
+			return str, addr, nil
 		}
+		oi.Unlock()
 
-		// create a StringHeader and set its values appropriately
-		stringHeader := &reflect.StringHeader{
-			// add 4 for reference count
-			Data: addr + 4,
-			Len:  len(objComp),
-		}
-		return (*(*string)(unsafe.Pointer(stringHeader))), nil
+		return oi.internedString(addr, obj), addr, nil
 	}
 
 	// if neither of those terms is true then we can avoid costly allocations
@@ -359,92 +911,448 @@ func (oi *ObjectIntern) AddOrGetString(obj []byte, safe bool) (string, error) {
 
 	addr, ok := oi.getAndIncrement(obj)
 	if ok {
-		// create a StringHeader and set its values appropriately
-		stringHeader := &reflect.StringHeader{
-			// add 4 for reference count
-			Data: addr + 4,
-			Len:  len(obj),
-		}
+		str := oi.internedString(addr, obj)
 		oi.RUnlock()
-		return (*(*string)(unsafe.Pointer(stringHeader))), nil
+		return str, addr, nil
 	}
 
 	oi.RUnlock()
 
-	oi.Lock()
+	oi.lock()
 
 	// re-check everything
 	addr, ok = oi.getAndIncrement(obj)
 	if ok {
-		// create a StringHeader and set its values appropriately
-		stringHeader := &reflect.StringHeader{
-			// add 4 for reference count
-			Data: addr + 4,
-			Len:  len(obj),
-		}
+		str := oi.internedString(addr, obj)
 		oi.Unlock()
-		return (*(*string)(unsafe.Pointer(stringHeader))), nil
+		return str, addr, nil
 	}
 
 	addr, err := oi.add(obj)
 	if err != nil {
 		oi.Unlock()
-		return "", err
+		return "", 0, err
 	}
 
-	// create a StringHeader and set its values appropriately
-	stringHeader := &reflect.StringHeader{
-		// add 4 for reference count
-		Data: addr + 4,
-		Len:  len(obj),
-	}
+	str := oi.internedString(addr, obj)
 
 	oi.Unlock()
-	return (*(*string)(unsafe.Pointer(stringHeader))), nil
+	return str, addr, nil
 }
 
-// GetPtrFromByte finds an interned object and returns its address as a uintptr.
-// Upon failure it returns 0 and an error.
+// AddOrGetBoth is the same as AddOrGetStringAddr, but returns the address first, in the same
+// order as AddOrGet. It replaces calling AddOrGet followed by GetStringFromPtr.
+// On failure it returns 0, an empty string and an error
+func (oi *ObjectIntern) AddOrGetBoth(obj []byte, safe bool) (uintptr, string, error) {
+	str, addr, err := oi.AddOrGetStringAddr(obj, safe)
+	return addr, str, err
+}
+
+// ReplaceValue replaces the value of the object stored at objAddr with newValue, keeping
+// its reference count. It returns the address of the object and nil on success.
+// On failure it returns 0 and an error.
 //
-// This method is designed specifically to be used with map keys that are interned,
-// since the only way to retrieve the key itself is by iterating over the entire map.
-// This method should be faster than iterating over a map (depending on the size of the map).
-// This is usually called directly before deleting an interned map key from its map so that we
-// can properly decrement the reference count of that interned object.
+// If the stored form of newValue has the same length as the old one, the object is
+// overwritten in place and keeps its address. Otherwise it is moved to a new address,
+// which is returned, and handles of the object are updated to the new address.
+// If newValue is already interned the reference count of the old object is added to it,
+// the old object is removed and the address of the existing object is returned.
+// If the old object could not be removed from the object store after moving it, the new
+// address is returned together with the error.
 //
-// This method does not increase the reference count of the interned object.
-func (oi *ObjectIntern) GetPtrFromByte(obj []byte) (uintptr, error) {
-	if oi.conf.Compression != None {
-		oi.RLock()
-		// try to find the compressed object in the index
-		addr, ok := oi.objIndex[string(oi.compress(obj))]
-		if ok {
-			oi.RUnlock()
-			return addr, nil
-		}
-
-		oi.RUnlock()
-		return 0, fmt.Errorf("Could not find object in store: %s", string(obj))
+// WARNING: strings returned by AddOrGetString or GetStringFromPtr which alias the old
+// value become stale. They either show the new value or point to freed memory.
+func (oi *ObjectIntern) ReplaceValue(objAddr uintptr, newValue []byte) (uintptr, error) {
+	if oi.Frozen() {
+		return 0, ErrFrozen
 	}
 
-	oi.RLock()
-	// try to find the object in the index
-	addr, ok := oi.objIndex[string(obj)]
-	if ok {
-		oi.RUnlock()
-		return addr, nil
+	newValue = oi.normalize(newValue)
+	if len(newValue) == 0 {
+		return 0, ErrEmptyObject
+	}
+	if oi.conf.Compression != None {
+		newValue = oi.compress(newValue)
 	}
 
-	oi.RUnlock()
+	oi.lock()
+	defer oi.Unlock()
+
+	obj, err := oi.store.Get(objAddr)
+	if err != nil {
+		return 0, err
+	}
+	if oi.isPinned(objAddr) {
+		return 0, ErrPinned
+	}
+	// remove leading bytes for reference count
+	old := obj[oi.refCntSize:]
+	if bytes.Equal(old, newValue) {
+		return objAddr, nil
+	}
+
+	var refCnt uint32
+	if !oi.conf.DisableRefCounting {
+		refCnt = atomic.LoadUint32((*uint32)(unsafe.Pointer(objAddr)))
+	}
+
+	ns := oi.namespaceOf(objAddr)
+
+	// the new value is already interned, merge both objects
+	if existing, ok := oi.indexGetNS(ns, newValue); ok {
+		if !oi.conf.DisableRefCounting {
+			atomic.AddUint32((*uint32)(unsafe.Pointer(existing)), refCnt)
+		}
+		oi.handles.relocate(objAddr, existing)
+		return existing, oi.remove(obj, objAddr)
+	}
+
+	if len(old) == len(newValue) {
+		// delete the old key first, it aliases the memory we are about to overwrite
+		oi.indexDelete(old, objAddr)
+		copy(old, newValue)
+		oi.indexAddNS(ns, old, objAddr)
+		oi.cache.remove(objAddr)
+		oi.strCache.remove(objAddr)
+		oi.lastStr.remove(objAddr)
+		return objAddr, nil
+	}
+
+	// add copies newValue, so it is safe if it is still owned by the caller
+	newAddr, err := oi.addNS(ns, newValue)
+	if err != nil {
+		return 0, err
+	}
+	if !oi.conf.DisableRefCounting {
+		atomic.StoreUint32((*uint32)(unsafe.Pointer(newAddr)), refCnt)
+	}
+	if deadline, ok := oi.deadlines[objAddr]; ok {
+		oi.deadlines[newAddr] = deadline
+	}
+	oi.handles.relocate(objAddr, newAddr)
+	return newAddr, oi.remove(obj, objAddr)
+}
+
+// keyBufPool holds the buffers indexGetCompressed compresses the objects to look up into
+var keyBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 64)
+		return &buf
+	},
+}
+
+// indexGetCompressed is the same as indexGet, but takes the uncompressed obj. The compressed
+// object is only needed for the lookup, so it is compressed into a pooled buffer instead of
+// allocating a new one for every lookup.
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) indexGetCompressed(obj []byte) (uintptr, bool) {
+	bufPtr := keyBufPool.Get().(*[]byte)
+	key := oi.compressInto((*bufPtr)[:0], obj)
+
+	addr, ok := oi.indexGet(key)
+
+	// keep the grown buffer for the next call
+	*bufPtr = key
+	keyBufPool.Put(bufPtr)
+
+	return addr, ok
+}
+
+// GetPtrFromByte finds an interned object and returns its address as a uintptr.
+// Upon failure it returns 0 and an error.
+//
+// This method is designed specifically to be used with map keys that are interned,
+// since the only way to retrieve the key itself is by iterating over the entire map.
+// This method should be faster than iterating over a map (depending on the size of the map).
+// This is usually called directly before deleting an interned map key from its map so that we
+// can properly decrement the reference count of that interned object.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) GetPtrFromByte(obj []byte) (uintptr, error) {
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return 0, ErrEmptyObject
+	}
+	if oi.conf.Compression != None {
+		oi.RLock()
+		// try to find the compressed object in the index
+		addr, ok := oi.indexGetCompressed(obj)
+		if ok && !oi.expired(addr) {
+			oi.RUnlock()
+			return addr, nil
+		}
+
+		oi.RUnlock()
+		return 0, fmt.Errorf("Could not find object in store: %s", string(obj))
+	}
+
+	oi.RLock()
+	// try to find the object in the index
+	addr, ok := oi.indexGet(obj)
+	if ok && !oi.expired(addr) {
+		oi.RUnlock()
+		return addr, nil
+	}
+
+	oi.RUnlock()
 	return 0, fmt.Errorf("Could not find object in store: %s", string(obj))
 }
 
+// GetPtrFromByteBatch does the same thing as GetPtrFromByte for every object in objs, but
+// only acquires the read lock once. The returned slices indexes match the indexes of objs.
+// If an object could not be found its address is 0 and the error at the same index is set,
+// otherwise the error is nil.
+//
+// This pairs with DeleteBatch, to resolve interned map keys before deleting them.
+//
+// This method does not increase the reference count of the interned objects.
+func (oi *ObjectIntern) GetPtrFromByteBatch(objs [][]byte) ([]uintptr, []error) {
+	addrs := make([]uintptr, len(objs))
+	errs := make([]error, len(objs))
+
+	// compress everything before acquiring the lock
+	keys := make([][]byte, len(objs))
+	for idx, obj := range objs {
+		obj = oi.normalize(obj)
+		if len(obj) == 0 {
+			errs[idx] = ErrEmptyObject
+			continue
+		}
+		if oi.conf.Compression != None {
+			obj = oi.compress(obj)
+		}
+		keys[idx] = obj
+	}
+
+	oi.RLock()
+	for idx, key := range keys {
+		if errs[idx] != nil {
+			continue
+		}
+		// try to find the object in the index, expired objects are treated as absent
+		addr, ok := oi.indexGet(key)
+		if !ok || oi.expired(addr) {
+			errs[idx] = fmt.Errorf("Could not find object in store: %s", string(objs[idx]))
+			continue
+		}
+		addrs[idx] = addr
+	}
+	oi.RUnlock()
+
+	return addrs, errs
+}
+
+// GetAllByPrefix returns the addresses of all interned objects which start with prefix and nil.
+// If compression is turned on every object is decompressed before comparing it to prefix.
+// On failure it returns nil and an error.
+//
+// prefix is compared to the objects as they have been interned, so if a Normalize func is
+// configured the caller needs to take care of normalizing prefix accordingly.
+//
+// This method iterates over every object in the index while holding the read lock, so its
+// cost is O(n) in the number of interned objects. It should not be used in a hot path.
+//
+// This method does not increase the reference count of the interned objects.
+func (oi *ObjectIntern) GetAllByPrefix(prefix []byte) ([]uintptr, error) {
+	var matches []uintptr
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	err := oi.rangePrefix(prefix, func(addr uintptr, obj []byte) {
+		matches = append(matches, addr)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return matches, nil
+}
+
+// RefCntsByPrefix returns a map from every interned object which starts with prefix to its
+// reference count and nil. The objects are decompressed if compression is turned on. If the
+// same value is interned more than once, for example in different namespaces, the reference
+// counts of all of its copies are summed up.
+// On failure it returns nil and an error.
+//
+// prefix is matched the same way, and at the same cost, as by GetAllByPrefix.
+//
+// This method does not increase the reference count of the interned objects.
+func (oi *ObjectIntern) RefCntsByPrefix(prefix []byte) (map[string]uint32, error) {
+	if oi.conf.DisableRefCounting {
+		return nil, ErrNoRefCounting
+	}
+
+	refCnts := make(map[string]uint32)
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	err := oi.rangePrefix(prefix, func(addr uintptr, obj []byte) {
+		refCnts[string(obj)] += atomic.LoadUint32((*uint32)(unsafe.Pointer(addr)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refCnts, nil
+}
+
+// SortedValues returns the values of all interned objects in ascending order and nil. The
+// objects are decompressed if compression is turned on. If the same value is interned more
+// than once, for example in different namespaces, it is returned once per copy.
+// On failure it returns nil and an error.
+//
+// The values are collected while holding the read lock, they are sorted after it has been
+// released. This is meant for debugging and exporting, such as diffing two tables, and
+// should not be used in a hot path.
+func (oi *ObjectIntern) SortedValues() ([]string, error) {
+	oi.RLock()
+
+	values := make([]string, 0, oi.indexLen()+len(oi.unindexed))
+	err := oi.rangePrefix(nil, func(addr uintptr, obj []byte) {
+		values = append(values, string(obj))
+	})
+
+	oi.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(values)
+	return values, nil
+}
+
+// rangePrefix calls fn with the address and the decompressed object of every interned
+// object which starts with prefix. obj must not be retained or modified by fn.
+// It returns nil on success and the first error encountered on failure.
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) rangePrefix(prefix []byte, fn func(addr uintptr, obj []byte)) error {
+	var err error
+
+	oi.indexRange(func(addr uintptr) bool {
+		var b []byte
+		b, err = oi.store.Get(addr)
+		if err != nil {
+			return false
+		}
+
+		// remove leading bytes for reference count
+		b = b[oi.refCntSize:]
+		if oi.conf.Compression != None {
+			b, err = oi.decompress(b)
+			if err != nil {
+				return false
+			}
+		}
+
+		if bytes.HasPrefix(b, prefix) {
+			fn(addr, b)
+		}
+		return true
+	})
+
+	return err
+}
+
 // GetStringFromPtr returns an interned version of a string stored at objAddr and nil.
 // If compression is turned on it returns a non-interned string and nil.
-// Upon failure it returns an empty string and an error.
+// Upon failure it returns an empty string and an error. If the object store returns an
+// object which doesn't hold at least one byte of data after the reference count, the
+// error is ErrCorruptObject.
+//
+// If compression is turned on and CacheTTL is set, the returned string is cached for
+// CacheTTL, so repeated calls for the same address do not need to decompress the object.
+//
+// If compression is turned on and the read-through cache is enabled, the returned
+// string aliases the cached decompressed data instead of being freshly allocated.
+// The cached data is never modified, but once the object is deleted from the store
+// the string is no longer tied to it. Use GetStringFromPtrCopy if you need a
+// string that does not share memory with the cache.
 //
 // This method does not increase the reference count of the interned object.
 func (oi *ObjectIntern) GetStringFromPtr(objAddr uintptr) (string, error) {
+	defer oi.readUnlock(oi.readLock())
+
+	return oi.getStringFromPtr(objAddr)
+}
+
+// ToStringSlice returns the objects stored at addrs as a []string and nil.
+// Every address is resolved the same way as by GetStringFromPtr, but the read lock
+// is only acquired once. The returned slice indexes match the indexes of addrs.
+// Upon failure it returns nil and an error identifying the first address which could
+// not be resolved.
+//
+// This complements JoinStrings for callers who need the individual strings.
+//
+// This method does not increase the reference count of the interned objects.
+func (oi *ObjectIntern) ToStringSlice(addrs []uintptr) ([]string, error) {
+	strs := make([]string, len(addrs))
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	for idx, addr := range addrs {
+		str, err := oi.getStringFromPtr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get string of object %d at %d: %s", idx, addr, err)
+		}
+		strs[idx] = str
+	}
+	return strs, nil
+}
+
+// ResolveInto is the same as ToStringSlice, but it stores the strings in dst, resliced to the
+// length of addrs, and returns it and nil. A new slice is only allocated if dst is too small,
+// so callers should keep using the returned slice to reuse it across many calls, for example
+// one per rendered series. Upon failure it returns dst[:0] and an error identifying the first
+// address which could not be resolved.
+//
+// This method does not increase the reference count of the interned objects.
+func (oi *ObjectIntern) ResolveInto(addrs []uintptr, dst []string) ([]string, error) {
+	if cap(dst) < len(addrs) {
+		dst = make([]string, len(addrs))
+	}
+	dst = dst[:len(addrs)]
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	for idx, addr := range addrs {
+		str, err := oi.getStringFromPtr(addr)
+		if err != nil {
+			return dst[:0], fmt.Errorf("Could not get string of object %d at %d: %s", idx, addr, err)
+		}
+		dst[idx] = str
+	}
+	return dst, nil
+}
+
+// getStringFromPtr does the work of GetStringFromPtr.
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) getStringFromPtr(objAddr uintptr) (string, error) {
+	b, err := oi.store.Get(objAddr)
+	if err != nil {
+		return "", err
+	}
+	// empty objects are never interned
+	if len(b) <= int(oi.refCntSize) {
+		return "", ErrCorruptObject
+	}
+	return oi.stringFromStored(objAddr, b)
+}
+
+// GetStringFromPtrChecked is the same as GetStringFromPtr, but it validates the length of
+// the object returned by the object store before using it. If the object is too short to
+// hold the reference count and at least one byte of data it returns an empty string and
+// ErrCorruptObject, instead of building a string which points outside of the object. If
+// SafeMode is turned on it also returns ErrCorruptObject if there is no interned object
+// at objAddr.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) GetStringFromPtrChecked(objAddr uintptr) (string, error) {
 	oi.RLock()
 	defer oi.RUnlock()
 
@@ -452,24 +1360,327 @@ func (oi *ObjectIntern) GetStringFromPtr(objAddr uintptr) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := oi.checkStored(objAddr, b); err != nil {
+		return "", err
+	}
+	return oi.stringFromStored(objAddr, b)
+}
+
+// stringFromStored returns the string of the object b, as returned by the object store
+// for objAddr, the same way as GetStringFromPtr.
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) stringFromStored(objAddr uintptr, b []byte) (string, error) {
+	var err error
 
 	if oi.conf.Compression != None {
+		// the same object is often read several times in a row
+		if str, ok := oi.lastStr.get(objAddr); ok {
+			return str, nil
+		}
+		if str, ok := oi.strCache.get(objAddr); ok {
+			return str, nil
+		}
+
+		if oi.cache != nil {
+			b, err = oi.cachedDecompress(objAddr, b)
+			if err != nil {
+				return "", err
+			}
+			// alias the cached []byte, it is never modified after being cached
+			stringHeader := &reflect.StringHeader{
+				Data: (*reflect.SliceHeader)(unsafe.Pointer(&b)).Data,
+				Len:  len(b),
+			}
+			str := *(*string)(unsafe.Pointer(stringHeader))
+			oi.strCache.add(objAddr, str)
+			oi.lastStr.set(objAddr, str)
+			return str, nil
+		}
+
 		// get decompressed []byte after removing the leading 4 bytes for the reference count
-		b, err = oi.decompress(b[4:])
+		b, err = oi.decompress(b[oi.refCntSize:])
+		if err != nil {
+			return "", err
+		}
 		// because compression is turned on we can't just set string's Data to the address,
 		// we need to actually create a new string from the decompressed []byte
-		return string(b), err
+		str := string(b)
+		oi.strCache.add(objAddr, str)
+		oi.lastStr.set(objAddr, str)
+		return str, nil
 	}
 
 	// create a StringHeader and set its values appropriately
 	stringHeader := &reflect.StringHeader{
-		// add 4 for reference count
-		Data: objAddr + 4,
-		Len:  len(b) - 4,
+		// skip the reference count
+		Data: objAddr + oi.refCntSize,
+		Len:  len(b) - int(oi.refCntSize),
 	}
 	return (*(*string)(unsafe.Pointer(stringHeader))), nil
 }
 
+// GetStringFromPtrUnsafe returns an interned version of a string stored at objAddr
+// with the given length. It does not acquire any locks and does not check whether the
+// object actually exists in the object store, it simply points the string at the
+// interned data.
+//
+// WARNING: This method is only valid when compression is turned off. It is up to the
+// caller to ensure that the object exists and that length matches the length of the
+// interned object, otherwise this will return corrupt data or result in a panic. This
+// method is dangerous, use at your own risk.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) GetStringFromPtrUnsafe(objAddr uintptr, length int) string {
+	// create a StringHeader and set its values appropriately
+	stringHeader := &reflect.StringHeader{
+		// skip the reference count
+		Data: objAddr + oi.refCntSize,
+		Len:  length,
+	}
+	return (*(*string)(unsafe.Pointer(stringHeader)))
+}
+
+// GetStringFromPtrCopy returns a newly allocated string containing the object stored
+// at objAddr and nil. The returned string never shares memory with the object store
+// or the read-through cache.
+// Upon failure it returns an empty string and an error.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) GetStringFromPtrCopy(objAddr uintptr) (string, error) {
+	return oi.ObjString(objAddr)
+}
+
+// cachedDecompress returns the decompressed version of the stored object b found at
+// objAddr, using the read-through cache. b must still contain the leading 4 bytes
+// for the reference count.
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) cachedDecompress(objAddr uintptr, b []byte) ([]byte, error) {
+	if cached, ok := oi.cache.get(objAddr); ok {
+		return cached, nil
+	}
+
+	// remove leading bytes for reference count and decompress
+	b, err := oi.decompress(b[oi.refCntSize:])
+	if err != nil {
+		return nil, err
+	}
+	oi.cache.add(objAddr, b)
+	return b, nil
+}
+
+// internedString returns the object stored at objAddr as a string which aliases the interned
+// data. obj must be the object as it has been interned, compression must be turned off.
+//
+// The length of the string is always the length of obj. The stored object has exactly the
+// same length, so the string never includes bytes of the object store which belong to
+// another object, regardless of the copy of obj that was actually interned.
+func (oi *ObjectIntern) internedString(objAddr uintptr, obj []byte) string {
+	stringHeader := &reflect.StringHeader{
+		// skip the reference count
+		Data: objAddr + oi.refCntSize,
+		Len:  len(obj),
+	}
+	return *(*string)(unsafe.Pointer(stringHeader))
+}
+
+// decompressedString returns obj, the normalized but uncompressed form of the object stored at
+// objAddr, as a string. If the decompression cache is enabled the string aliases the cached
+// copy of obj, which is added on a miss, so the same object is only copied once.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) decompressedString(objAddr uintptr, obj []byte) string {
+	if oi.cache == nil {
+		return string(obj)
+	}
+
+	b, ok := oi.cache.get(objAddr)
+	if !ok {
+		// obj may be owned by the caller, so the cache needs a copy
+		b = append([]byte(nil), obj...)
+		oi.cache.add(objAddr, b)
+	}
+
+	// alias the cached []byte, it is never modified after being cached
+	stringHeader := &reflect.StringHeader{
+		Data: (*reflect.SliceHeader)(unsafe.Pointer(&b)).Data,
+		Len:  len(b),
+	}
+	return *(*string)(unsafe.Pointer(stringHeader))
+}
+
+// Reference counts are only ever modified with atomic operations. Incrementing them
+// only requires a read lock, as does decrementing them as long as they stay above 0.
+// The check that a reference count is larger than 1 and its decrement happen in a
+// single compare-and-swap in decRefCnt, so concurrent deletes can never decrement the
+// same reference count below 1. Dropping the final reference, and therefore removing
+// the object, only ever happens while holding the write lock, after the reference
+// count has been checked again. The Unsafe methods skip the locks, but they use the
+// same atomic operations.
+
+// decRefCnt decrements the reference count of the object at objAddr by 1, unless it
+// is the final reference. It returns true if the reference count was decremented and
+// false if the caller needs to remove the object instead.
+func decRefCnt(objAddr uintptr) bool {
+	refCnt := (*uint32)(unsafe.Pointer(objAddr))
+	for {
+		old := atomic.LoadUint32(refCnt)
+		if old <= 1 {
+			return false
+		}
+		if atomic.CompareAndSwapUint32(refCnt, old, old-1) {
+			return true
+		}
+	}
+}
+
+// interned returns true if oi still tracks the object obj, which is stored at objAddr.
+// obj must be the object as returned by the object store, including the reference count.
+//
+// The object store doesn't return an error for the address of an object which has
+// already been removed as long as the slab it was stored in is still mapped, so every
+// method which removes an object must check this under the write lock first. Otherwise
+// two callers racing to drop the final reference of the same object would both remove it.
+// Once the slab has been unmapped the object store still doesn't return an error, so the
+// address is checked with mapped before obj is read.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) interned(obj []byte, objAddr uintptr) bool {
+	// the slab of a removed object may have been unmapped, so obj must not be read
+	if !oi.mapped(objAddr) {
+		return false
+	}
+	// empty objects are never interned
+	if len(obj) <= int(oi.refCntSize) {
+		return false
+	}
+	if _, ok := oi.unindexed[objAddr]; ok {
+		return true
+	}
+	// the reverse index tracks every indexed object by its address
+	if oi.keyOf != nil {
+		_, ok := oi.keyOf[objAddr]
+		return ok
+	}
+	addr, ok := oi.indexGetNS(oi.namespaceOf(objAddr), obj[oi.refCntSize:])
+	return ok && addr == objAddr
+}
+
+// checkStored returns ErrCorruptObject if b, the object stored at objAddr as returned by
+// the object store, is too short to be an interned object, or if SafeMode is turned on
+// and oi doesn't track an object at objAddr. Otherwise it returns nil.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) checkStored(objAddr uintptr, b []byte) error {
+	// empty objects are never interned
+	if len(b) <= int(oi.refCntSize) {
+		return ErrCorruptObject
+	}
+	if oi.conf.SafeMode && !oi.interned(b, objAddr) {
+		return ErrCorruptObject
+	}
+	return nil
+}
+
+// remove deletes the object obj, which is stored at objAddr, from the index, the caches
+// and the object store. obj must be the object as returned by the object store, including
+// the reference count. It returns nil on success and an error on failure.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) remove(obj []byte, objAddr uintptr) error {
+	// If one of these operations fails it is still safe to perform the others
+	// Once we get to this point we are just going to remove all traces of the object
+
+	// delete object from index first
+	// If you delete all of the objects in the slab then the slab will be deleted
+	// When this happens the memory that the slab was using is MUnmapped, which is
+	// the same memory pointed to by the key stored in the ObjIndex. When you try to
+	// access the key to delete it from the ObjIndex you will get a SEGFAULT
+	//
+	// remove leading bytes for reference count since ObjIndex does not store reference count in the key
+	oi.indexDelete(obj[oi.refCntSize:], objAddr)
+	delete(oi.unindexed, objAddr)
+	delete(oi.deadlines, objAddr)
+	delete(oi.pinned, objAddr)
+	oi.cache.remove(objAddr)
+	oi.strCache.remove(objAddr)
+	oi.lastStr.remove(objAddr)
+	oi.handles.release(objAddr)
+
+	// The object store does not clear the memory of deleted objects, and when it reuses the
+	// slot it ORs the trailing bytes of the new object into the old ones, which corrupts the
+	// new object. Zero the memory before handing it back to avoid that.
+	for i := range obj {
+		obj[i] = 0
+	}
+
+	// delete object from object store
+	objSize := uint8(len(obj))
+	unmapped, err := oi.storeDelete(objAddr, objSize)
+	if err != nil {
+		return err
+	}
+
+	if oi.conf.Logger != nil {
+		oi.log(EventFreed, map[string]interface{}{"addr": objAddr})
+		if unmapped > 0 {
+			oi.log(EventSlabDeleted, map[string]interface{}{"objSize": objSize, "bytes": unmapped})
+		}
+	}
+	return nil
+}
+
+// eviction is an object removed by one of the Delete methods, to be passed to OnEvict
+type eviction struct {
+	addr uintptr
+	data []byte
+}
+
+// evict does the same thing as remove, but if OnEvict is set it first appends the address
+// and a decompressed copy of the object to evicted. It returns evicted and the error of remove.
+// The object is only appended if it has been removed successfully.
+//
+// The caller is responsible for locking and unlocking, and for passing evicted to
+// notifyEvicted once the write lock has been released.
+func (oi *ObjectIntern) evict(obj []byte, objAddr uintptr, evicted []eviction) ([]eviction, error) {
+	if oi.isPinned(objAddr) {
+		return evicted, ErrPinned
+	}
+	if oi.conf.OnEvict == nil {
+		return evicted, oi.remove(obj, objAddr)
+	}
+
+	// copy the object, its memory is zeroed and possibly unmapped by remove
+	// remove leading bytes for reference count
+	data := obj[oi.refCntSize:]
+	if oi.conf.Compression != None {
+		decompressed, err := oi.decompress(data)
+		if err == nil {
+			// decompress always returns a new []byte
+			data = decompressed
+		} else {
+			data = append([]byte(nil), data...)
+		}
+	} else {
+		data = append([]byte(nil), data...)
+	}
+
+	if err := oi.remove(obj, objAddr); err != nil {
+		return evicted, err
+	}
+	return append(evicted, eviction{addr: objAddr, data: data}), nil
+}
+
+// notifyEvicted calls OnEvict for every eviction.
+//
+// This must be called without holding any locks, since OnEvict may use the ObjectIntern.
+func (oi *ObjectIntern) notifyEvicted(evicted []eviction) {
+	for _, ev := range evicted {
+		oi.conf.OnEvict(ev.addr, ev.data)
+	}
+}
+
 // Delete decrements the reference count of an object identified by its address.
 // Possible return values are as follows:
 //
@@ -479,7 +1690,22 @@ func (oi *ObjectIntern) GetStringFromPtr(objAddr uintptr) (string, error) {
 // false, nil - reference count was decremented by 1 and no further action was taken.
 //
 // false, error - the object was not found in the object store or could not be deleted
+//
+// If IgnoreMissingOnDelete is turned on, an object which is not found in the object store
+// returns false, nil instead.
+//
+// If several callers race to drop the final reference of the same object, only one of them
+// removes it, the others get an error. The address of a removed object must still not be
+// used, since its memory may have been unmapped or reused for a different object.
 func (oi *ObjectIntern) Delete(objAddr uintptr) (bool, error) {
+	if oi.Frozen() {
+		return false, ErrFrozen
+	}
+
+	if oi.conf.DisableRefCounting {
+		return false, ErrNoRefCounting
+	}
+
 	var obj []byte
 	var err error
 
@@ -490,128 +1716,376 @@ func (oi *ObjectIntern) Delete(objAddr uintptr) (bool, error) {
 	obj, err = oi.store.Get(objAddr)
 	if err != nil {
 		oi.RUnlock()
-		return false, err
+		return false, oi.missingErr(err)
+	}
+	// the slab of a removed object may have been unmapped, so it must not be read
+	if !oi.mapped(objAddr) {
+		oi.RUnlock()
+		return false, oi.missingErr(fmt.Errorf("Could not find object in store: %d", objAddr))
 	}
 
 	// most likely case is that we will just decrement the reference count and return
-	if atomic.LoadUint32((*uint32)(unsafe.Pointer(objAddr))) > 1 {
-		// decrement reference count by 1
-		atomic.AddUint32((*uint32)(unsafe.Pointer(objAddr)), ^uint32(0))
-
+	if decRefCnt(objAddr) {
 		oi.RUnlock()
 		return false, nil
 	}
 
 	oi.RUnlock()
 
-	oi.Lock()
+	oi.lock()
 
 	// re-check if object exists in the object store
 	obj, err = oi.store.Get(objAddr)
 	if err != nil {
 		oi.Unlock()
-		return false, err
+		return false, oi.missingErr(err)
+	}
+	// another caller may have removed it in the meantime
+	if !oi.interned(obj, objAddr) {
+		oi.Unlock()
+		return false, oi.missingErr(fmt.Errorf("Could not find object in store: %d", objAddr))
 	}
 
 	// most likely case is that we will just decrement the reference count and return
-	if atomic.LoadUint32((*uint32)(unsafe.Pointer(objAddr))) > 1 {
-		// decrement reference count by 1
-		atomic.AddUint32((*uint32)(unsafe.Pointer(objAddr)), ^uint32(0))
-
+	if decRefCnt(objAddr) {
 		oi.Unlock()
 		return false, nil
 	}
 
-	// if reference count is 1 or less, delete the object and remove it from index
-	// If one of these operations fails it is still safe to perform the other
-	// Once we get to this point we are just going to remove all traces of the object
+	// if reference count is 1 or less, delete the object and remove all traces of it
+	evicted, err := oi.evict(obj, objAddr, nil)
 
-	// delete object from index first
-	// If you delete all of the objects in the slab then the slab will be deleted
-	// When this happens the memory that the slab was using is MUnmapped, which is
-	// the same memory pointed to by the key stored in the ObjIndex. When you try to
-	// access the key to delete it from the ObjIndex you will get a SEGFAULT
-	//
-	// remove 4 leading bytes for reference count since ObjIndex does not store reference count in the key
-	delete(oi.objIndex, string(obj[4:]))
+	oi.Unlock()
 
-	// delete object from object store
-	err = oi.store.Delete(objAddr)
+	oi.notifyEvicted(evicted)
+
+	if err == nil {
+		return true, nil
+	}
+	return false, err
+}
+
+// DeleteIfRefCnt does the same thing as Delete, but only if the reference count of the
+// object identified by objAddr is expected. The reference count is compared and decremented
+// with a single compare-and-swap, so a delete based on a count which has been read before
+// another caller changed it does nothing. Possible return values are as follows:
+//
+// true, nil - the reference count was expected and it was decremented by 1, or the object
+// was removed if expected is 1.
+//
+// false, nil - the reference count was not expected and no action was taken.
+//
+// false, error - the object was not found in the object store or could not be deleted
+func (oi *ObjectIntern) DeleteIfRefCnt(objAddr uintptr, expected uint32) (bool, error) {
+	if oi.Frozen() {
+		return false, ErrFrozen
+	}
+
+	if oi.conf.DisableRefCounting {
+		return false, ErrNoRefCounting
+	}
+
+	// an interned object is never referenced 0 times
+	if expected == 0 {
+		return false, nil
+	}
+
+	if expected > 1 {
+		oi.RLock()
+		defer oi.RUnlock()
+
+		// check if object exists in the object store
+		if _, err := oi.store.Get(objAddr); err != nil {
+			return false, err
+		}
+		// the slab of a removed object may have been unmapped, so it must not be read
+		if !oi.mapped(objAddr) {
+			return false, fmt.Errorf("Could not find object in store: %d", objAddr)
+		}
+
+		return atomic.CompareAndSwapUint32((*uint32)(unsafe.Pointer(objAddr)), expected, expected-1), nil
+	}
+
+	// dropping the final reference requires the write lock, which also keeps any other
+	// caller from changing the reference count until the object has been removed
+	oi.lock()
+
+	obj, err := oi.store.Get(objAddr)
+	if err != nil {
+		oi.Unlock()
+		return false, err
+	}
+	if !oi.interned(obj, objAddr) {
+		oi.Unlock()
+		return false, fmt.Errorf("Could not find object in store: %d", objAddr)
+	}
+	if atomic.LoadUint32((*uint32)(unsafe.Pointer(objAddr))) != 1 {
+		oi.Unlock()
+		return false, nil
+	}
+
+	evicted, err := oi.evict(obj, objAddr, nil)
 
 	oi.Unlock()
 
+	oi.notifyEvicted(evicted)
+
 	if err == nil {
 		return true, nil
 	}
 	return false, err
 }
 
+// missingErr returns err, which reports that an object to delete could not be found.
+// If IgnoreMissingOnDelete is turned on it returns nil instead, unless the ObjectIntern
+// has been closed.
+func (oi *ObjectIntern) missingErr(err error) error {
+	if oi.conf.IgnoreMissingOnDelete && err != ErrClosed {
+		return nil
+	}
+	return err
+}
+
 // DeleteBatch decrements the reference count or deletes the objects from the store
 func (oi *ObjectIntern) DeleteBatch(ptrs []uintptr) {
+	if oi.conf.DisableRefCounting || oi.Frozen() {
+		return
+	}
+
+	var obj []byte
+	var err error
+
+	// acquire lock
+	oi.RLock()
+
+	toDelete := ptrs[:0]
+
+	for _, p := range ptrs {
+		// check if object exists in the object store
+		obj, err = oi.store.Get(p)
+		if err != nil {
+			continue
+		}
+		// the slab of a removed object may have been unmapped, so it must not be read
+		if !oi.mapped(p) {
+			continue
+		}
+
+		// most likely case is that we will just decrement the reference count and return
+		if decRefCnt(p) {
+			continue
+		}
+
+		toDelete = append(toDelete, p)
+	}
+
+	oi.RUnlock()
+
+	if len(toDelete) > 0 {
+
+		var evicted []eviction
+
+		oi.lock()
+
+		// an address which is listed twice must not be looked up again once it has been
+		// removed, its slab may have been unmapped
+		sort.Slice(toDelete, func(i, j int) bool { return toDelete[i] < toDelete[j] })
+
+		for i, p := range toDelete {
+			if i > 0 && p == toDelete[i-1] {
+				continue
+			}
+
+			// re-check if object exists in the object store
+			obj, err = oi.store.Get(p)
+			if err != nil || !oi.interned(obj, p) {
+				continue
+			}
+
+			// most likely case is that we will just decrement the reference count and return
+			if decRefCnt(p) {
+				continue
+			}
+
+			// if reference count is 1 or less, delete the object and remove all traces of it
+			evicted, err = oi.evict(obj, p, evicted)
+		}
+
+		oi.Unlock()
+
+		oi.notifyEvicted(evicted)
+	}
+}
+
+// DeleteResult is the outcome of deleting a single object with DeleteBatchResult
+type DeleteResult uint8
+
+const (
+	// NotFound means the object was not found in the object store or could not be deleted
+	NotFound DeleteResult = iota
+	// Decremented means the reference count was decremented by 1 and no further action was taken
+	Decremented
+	// Removed means the reference count reached 0 and the object was removed from both
+	// the index and the object store
+	Removed
+)
+
+// DeleteBatchResult does the same thing as DeleteBatch, but returns the outcome for every object.
+// The DeleteResult at each index belongs to the address at the same index of ptrs.
+// Unlike DeleteBatch it does not modify ptrs.
+func (oi *ObjectIntern) DeleteBatchResult(ptrs []uintptr) []DeleteResult {
 	var obj []byte
 	var err error
 
+	results := make([]DeleteResult, len(ptrs))
+	if oi.conf.DisableRefCounting || oi.Frozen() {
+		return results
+	}
+
 	// acquire lock
 	oi.RLock()
 
-	toDelete := ptrs[:0]
+	var toDelete []int
 
-	for _, p := range ptrs {
+	for i, p := range ptrs {
 		// check if object exists in the object store
 		obj, err = oi.store.Get(p)
 		if err != nil {
 			continue
 		}
+		// the slab of a removed object may have been unmapped, so it must not be read
+		if !oi.mapped(p) {
+			continue
+		}
 
 		// most likely case is that we will just decrement the reference count and return
-		if atomic.LoadUint32((*uint32)(unsafe.Pointer(p))) > 1 {
-			// decrement reference count by 1
-			atomic.AddUint32((*uint32)(unsafe.Pointer(p)), ^uint32(0))
+		if decRefCnt(p) {
+			results[i] = Decremented
 			continue
 		}
 
-		toDelete = append(toDelete, p)
+		toDelete = append(toDelete, i)
 	}
 
 	oi.RUnlock()
 
 	if len(toDelete) > 0 {
 
-		oi.Lock()
+		var evicted []eviction
+
+		oi.lock()
+
+		// an address which is listed twice must not be looked up again once it has been
+		// removed, its slab may have been unmapped
+		sort.SliceStable(toDelete, func(i, j int) bool { return ptrs[toDelete[i]] < ptrs[toDelete[j]] })
+
+		for n, i := range toDelete {
+			p := ptrs[i]
+			if n > 0 && p == ptrs[toDelete[n-1]] {
+				continue
+			}
 
-		for _, p := range toDelete {
 			// re-check if object exists in the object store
 			obj, err = oi.store.Get(p)
-			if err != nil {
+			if err != nil || !oi.interned(obj, p) {
 				continue
 			}
 
 			// most likely case is that we will just decrement the reference count and return
-			if atomic.LoadUint32((*uint32)(unsafe.Pointer(p))) > 1 {
-				// decrement reference count by 1
-				atomic.AddUint32((*uint32)(unsafe.Pointer(p)), ^uint32(0))
+			if decRefCnt(p) {
+				results[i] = Decremented
 				continue
 			}
 
-			// if reference count is 1 or less, delete the object and remove it from index
-			// If one of these operations fails it is still safe to perform the other
-			// Once we get to this point we are just going to remove all traces of the object
+			// if reference count is 1 or less, delete the object and remove all traces of it
+			evicted, err = oi.evict(obj, p, evicted)
+			if err == nil {
+				results[i] = Removed
+			}
+		}
+
+		oi.Unlock()
+
+		oi.notifyEvicted(evicted)
+	}
+
+	return results
+}
+
+// FreeDeadBatch removes every object in ptrs whose reference count is exactly 1 from the
+// index and the object store, and returns the number of objects removed. Unlike DeleteBatch
+// it never decrements the reference count of an object which is referenced more than once,
+// those objects are left untouched. Addresses which can't be found are skipped. OnEvict is
+// called for every removed object. If reference counting is turned off it returns 0.
+//
+// Unlike DeleteBatch it does not modify ptrs.
+func (oi *ObjectIntern) FreeDeadBatch(ptrs []uintptr) int {
+	if oi.conf.DisableRefCounting || oi.Frozen() {
+		return 0
+	}
+
+	// acquire lock
+	oi.RLock()
 
-			// delete object from index first
-			// If you delete all of the objects in the slab then the slab will be deleted
-			// When this happens the memory that the slab was using is MUnmapped, which is
-			// the same memory pointed to by the key stored in the ObjIndex. When you try to
-			// access the key to delete it from the ObjIndex you will get a SEGFAULT
-			//
-			// remove 4 leading bytes for reference count since ObjIndex does not store reference count in the key
-			delete(oi.objIndex, string(obj[4:]))
+	var toDelete []uintptr
 
-			// delete object from object store
-			err = oi.store.Delete(p)
+	for _, p := range ptrs {
+		// check if object exists in the object store
+		if _, err := oi.store.Get(p); err != nil {
+			continue
+		}
+		// the slab of a removed object may have been unmapped, so it must not be read
+		if !oi.mapped(p) {
+			continue
 		}
 
-		oi.Unlock()
+		if atomic.LoadUint32((*uint32)(unsafe.Pointer(p))) == 1 {
+			toDelete = append(toDelete, p)
+		}
+	}
+
+	oi.RUnlock()
+
+	if len(toDelete) == 0 {
+		return 0
+	}
+
+	var evicted []eviction
+	var removed int
+
+	oi.lock()
+
+	// an address which is listed twice must not be looked up again once it has been
+	// removed, its slab may have been unmapped
+	sort.Slice(toDelete, func(i, j int) bool { return toDelete[i] < toDelete[j] })
+
+	for i, p := range toDelete {
+		if i > 0 && p == toDelete[i-1] {
+			continue
+		}
+
+		// re-check if object exists in the object store
+		obj, err := oi.store.Get(p)
+		if err != nil || !oi.interned(obj, p) {
+			continue
+		}
+
+		// the object may have gained references in the meantime
+		if atomic.LoadUint32((*uint32)(unsafe.Pointer(p))) != 1 {
+			continue
+		}
+
+		evicted, err = oi.evict(obj, p, evicted)
+		if err == nil {
+			removed++
+		}
 	}
+
+	oi.Unlock()
+
+	oi.notifyEvicted(evicted)
+
+	return removed
 }
 
 // DeleteBatchUnsafe does the same thing as DeleteBatch, but saves time by not acquiring
@@ -619,14 +2093,15 @@ func (oi *ObjectIntern) DeleteBatch(ptrs []uintptr) {
 // is up to the caller to ensure the objects actually exist in the store. If you are unsure, don't use this
 // method.
 func (oi *ObjectIntern) DeleteBatchUnsafe(ptrs []uintptr) {
+	if oi.conf.DisableRefCounting || oi.Frozen() {
+		return
+	}
 
 	toDelete := ptrs[:0]
 
 	for _, p := range ptrs {
 		// most likely case is that we will just decrement the reference count and return
-		if atomic.LoadUint32((*uint32)(unsafe.Pointer(p))) > 1 {
-			// decrement reference count by 1
-			atomic.AddUint32((*uint32)(unsafe.Pointer(p)), ^uint32(0))
+		if decRefCnt(p) {
 			continue
 		}
 
@@ -638,90 +2113,77 @@ func (oi *ObjectIntern) DeleteBatchUnsafe(ptrs []uintptr) {
 
 		var obj []byte
 		var err error
+		var evicted []eviction
 
-		oi.Lock()
+		oi.lock()
 
 		for _, p := range toDelete {
 			// re-check if object exists in the object store
 			obj, err = oi.store.Get(p)
-			if err != nil {
+			if err != nil || !oi.interned(obj, p) {
 				continue
 			}
 
 			// most likely case is that we will just decrement the reference count and return
-			if atomic.LoadUint32((*uint32)(unsafe.Pointer(p))) > 1 {
-				// decrement reference count by 1
-				atomic.AddUint32((*uint32)(unsafe.Pointer(p)), ^uint32(0))
+			if decRefCnt(p) {
 				continue
 			}
 
-			// if reference count is 1 or less, delete the object and remove it from index
-			// If one of these operations fails it is still safe to perform the other
-			// Once we get to this point we are just going to remove all traces of the object
-
-			// delete object from index first
-			// If you delete all of the objects in the slab then the slab will be deleted
-			// When this happens the memory that the slab was using is MUnmapped, which is
-			// the same memory pointed to by the key stored in the ObjIndex. When you try to
-			// access the key to delete it from the ObjIndex you will get a SEGFAULT
-			//
-			// remove 4 leading bytes for reference count since ObjIndex does not store reference count in the key
-			delete(oi.objIndex, string(obj[4:]))
-
-			// delete object from object store
-			err = oi.store.Delete(p)
+			// if reference count is 1 or less, delete the object and remove all traces of it
+			evicted, err = oi.evict(obj, p, evicted)
 		}
 
 		oi.Unlock()
+
+		oi.notifyEvicted(evicted)
 	}
 }
 
 // DeleteUnsafe is just like Delete but it doesn't acquire read locks or perform
 // checks to ensure that the object at the address exists. This is a dangerous method and
-// should only be used if you know what you are doing.
+// should only be used if you know what you are doing. The reference count is decremented
+// before anything is checked, so objAddr must be the address of an interned object, the
+// memory of a removed object may have been unmapped. Only dropping the final reference is
+// checked under the write lock.
 func (oi *ObjectIntern) DeleteUnsafe(objAddr uintptr) (bool, error) {
+	if oi.Frozen() {
+		return false, ErrFrozen
+	}
+
+	if oi.conf.DisableRefCounting {
+		return false, ErrNoRefCounting
+	}
+
 	// most likely case is that we will just decrement the reference count and return
-	if atomic.LoadUint32((*uint32)(unsafe.Pointer(objAddr))) > 1 {
-		// decrement reference count by 1
-		atomic.AddUint32((*uint32)(unsafe.Pointer(objAddr)), ^uint32(0))
+	if decRefCnt(objAddr) {
 		return false, nil
 	}
 
-	oi.Lock()
+	oi.lock()
 
 	obj, err := oi.store.Get(objAddr)
 	if err != nil {
 		oi.Unlock()
 		return false, err
 	}
+	if !oi.interned(obj, objAddr) {
+		oi.Unlock()
+		return false, fmt.Errorf("Could not find object in store: %d", objAddr)
+	}
 
 	// most likely case is that we will just decrement the reference count and return
-	if atomic.LoadUint32((*uint32)(unsafe.Pointer(objAddr))) > 1 {
-		// decrement reference count by 1
-		atomic.AddUint32((*uint32)(unsafe.Pointer(objAddr)), ^uint32(0))
-
+	if decRefCnt(objAddr) {
 		oi.Unlock()
 		return false, nil
 	}
 
-	// if reference count is 1 or less, delete the object and remove it from index
-	// If one of these operations fails it is still safe to perform the other
-	// Once we get to this point we are just going to remove all traces of the object
-
-	// delete object from index first
-	// If you delete all of the objects in the slab then the slab will be deleted
-	// When this happens the memory that the slab was using is MUnmapped, which is
-	// the same memory pointed to by the key stored in the ObjIndex. When you try to
-	// access the key to delete it from the ObjIndex you will get a SEGFAULT
-	//
-	// remove 4 leading bytes for reference count since ObjIndex does not store reference count in the key
-	delete(oi.objIndex, string(obj[4:]))
-
-	// delete object from object store
-	err = oi.store.Delete(objAddr)
+	// if reference count is 1 or less, delete the object and remove all traces of it
+	evicted, err := oi.evict(obj, objAddr, nil)
 
 	oi.Unlock()
 
+	oi.notifyEvicted(evicted)
+
 	if err == nil {
 		return true, nil
 	}
@@ -737,15 +2199,25 @@ func (oi *ObjectIntern) DeleteUnsafe(objAddr uintptr) (bool, error) {
 // false, nil - reference count was decremented by 1 and no further action was taken.
 //
 // false, error - the object was not found in the object store or could not be deleted
+//
+// If IgnoreMissingOnDelete is turned on, an object which is not found returns false, nil instead.
 func (oi *ObjectIntern) DeleteByByte(obj []byte) (bool, error) {
+	if oi.Frozen() {
+		return false, ErrFrozen
+	}
+
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return false, ErrEmptyObject
+	}
 
 	if oi.conf.Compression != None {
 		oi.RLock()
 		// try to find the compressed object in the index
-		addr, ok := oi.objIndex[string(oi.compress(obj))]
+		addr, ok := oi.indexGetCompressed(obj)
 		if !ok {
 			oi.RUnlock()
-			return false, fmt.Errorf("Could not find object in store: %s", string(obj))
+			return false, oi.missingErr(fmt.Errorf("Could not find object in store: %s", string(obj)))
 		}
 		oi.RUnlock()
 		return oi.Delete(addr)
@@ -753,10 +2225,10 @@ func (oi *ObjectIntern) DeleteByByte(obj []byte) (bool, error) {
 
 	oi.RLock()
 	// try to find the object in the index
-	addr, ok := oi.objIndex[string(obj)]
+	addr, ok := oi.indexGet(obj)
 	if !ok {
 		oi.RUnlock()
-		return false, fmt.Errorf("Could not find object in store: %s", string(obj))
+		return false, oi.missingErr(fmt.Errorf("Could not find object in store: %s", string(obj)))
 	}
 	oi.RUnlock()
 	return oi.Delete(addr)
@@ -772,15 +2244,25 @@ func (oi *ObjectIntern) DeleteByByte(obj []byte) (bool, error) {
 // false, nil - reference count was decremented by 1 and no further action was taken.
 //
 // false, error - the object was not found in the object store or could not be deleted
+//
+// If IgnoreMissingOnDelete is turned on, an object which is not found returns false, nil instead.
 func (oi *ObjectIntern) DeleteByString(obj string) (bool, error) {
+	if oi.Frozen() {
+		return false, ErrFrozen
+	}
+
+	obj = oi.normalizeString(obj)
+	if len(obj) == 0 {
+		return false, ErrEmptyObject
+	}
 
 	if oi.conf.Compression != None {
 		oi.RLock()
-		// try to find the compressed object in the index
-		addr, ok := oi.objIndex[string(oi.compress([]byte(obj)))]
+		// try to find the compressed object in the index, obj is only read from
+		addr, ok := oi.indexGetCompressed(readOnlyBytes(obj))
 		if !ok {
 			oi.RUnlock()
-			return false, fmt.Errorf("Could not find object in store: %s", string(obj))
+			return false, oi.missingErr(fmt.Errorf("Could not find object in store: %s", string(obj)))
 		}
 		oi.RUnlock()
 		return oi.Delete(addr)
@@ -788,10 +2270,10 @@ func (oi *ObjectIntern) DeleteByString(obj string) (bool, error) {
 
 	oi.RLock()
 	// try to find the object in the index
-	addr, ok := oi.objIndex[obj]
+	addr, ok := oi.indexGetString(obj)
 	if !ok {
 		oi.RUnlock()
-		return false, fmt.Errorf("Could not find object in store: %s", obj)
+		return false, oi.missingErr(fmt.Errorf("Could not find object in store: %s", obj))
 	}
 	oi.RUnlock()
 	return oi.Delete(addr)
@@ -802,8 +2284,11 @@ func (oi *ObjectIntern) DeleteByString(obj string) (bool, error) {
 // On failure it returns 0 and an error, which means the object was not found
 // in the object store.
 func (oi *ObjectIntern) RefCnt(objAddr uintptr) (uint32, error) {
-	oi.RLock()
-	defer oi.RUnlock()
+	if oi.conf.DisableRefCounting {
+		return 0, ErrNoRefCounting
+	}
+
+	defer oi.readUnlock(oi.readLock())
 
 	// check if object exists in the object store
 	_, err := oi.store.Get(objAddr)
@@ -814,9 +2299,45 @@ func (oi *ObjectIntern) RefCnt(objAddr uintptr) (uint32, error) {
 	return atomic.LoadUint32((*uint32)(unsafe.Pointer(objAddr))), nil
 }
 
+// StringAndRefCnt returns the object stored at objAddr as a string, the same way as
+// GetStringFromPtr does, together with its current reference count and nil on success.
+// Both are read while holding the read lock once, so listing objects with their counts
+// doesn't need a separate call to RefCnt for every object.
+// On failure it returns an empty string, 0 and an error.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) StringAndRefCnt(objAddr uintptr) (string, uint32, error) {
+	if oi.conf.DisableRefCounting {
+		return "", 0, ErrNoRefCounting
+	}
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	b, err := oi.store.Get(objAddr)
+	if err != nil {
+		return "", 0, err
+	}
+
+	str, err := oi.stringFromStored(objAddr, b)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return str, atomic.LoadUint32((*uint32)(unsafe.Pointer(objAddr))), nil
+}
+
 // IncRefCnt increments the reference count of an object interned in the store.
 // On failure it returns false and an error, on success it returns true and nil
 func (oi *ObjectIntern) IncRefCnt(objAddr uintptr) (bool, error) {
+	if oi.Frozen() {
+		return false, ErrFrozen
+	}
+
+	if oi.conf.DisableRefCounting {
+		return false, ErrNoRefCounting
+	}
+
 	oi.RLock()
 	_, err := oi.store.Get(objAddr)
 	if err != nil {
@@ -837,6 +2358,10 @@ func (oi *ObjectIntern) IncRefCnt(objAddr uintptr) (bool, error) {
 // if used improperly this will likely result in corrupt data or a panic. This method
 // is dangerous, use at your own risk.
 func (oi *ObjectIntern) IncRefCntUnsafe(objAddr uintptr) {
+	if oi.conf.DisableRefCounting || oi.Frozen() {
+		return
+	}
+
 	// increment reference count by 1
 	atomic.AddUint32((*uint32)(unsafe.Pointer(objAddr)), 1)
 }
@@ -844,6 +2369,14 @@ func (oi *ObjectIntern) IncRefCntUnsafe(objAddr uintptr) {
 // IncRefCntByString increments the reference count of an object interned in the store.
 // On failure it returns false and an error, on success it returns true and nil
 func (oi *ObjectIntern) IncRefCntByString(obj string) (bool, error) {
+	if oi.Frozen() {
+		return false, ErrFrozen
+	}
+
+	obj = oi.normalizeString(obj)
+	if len(obj) == 0 {
+		return false, ErrEmptyObject
+	}
 	if oi.conf.Compression != None {
 		obj = string(oi.compress([]byte(obj)))
 	}
@@ -852,7 +2385,7 @@ func (oi *ObjectIntern) IncRefCntByString(obj string) (bool, error) {
 	oi.RLock()
 
 	// try to find the object in the index
-	addr, ok := oi.objIndex[obj]
+	addr, ok := oi.indexGetString(obj)
 	if !ok {
 		oi.RUnlock()
 		return false, fmt.Errorf("Could not find object in store")
@@ -862,64 +2395,350 @@ func (oi *ObjectIntern) IncRefCntByString(obj string) (bool, error) {
 	return oi.IncRefCnt(addr)
 }
 
-// IncRefCntBatch increments the reference count of objects interned in the store.
-func (oi *ObjectIntern) IncRefCntBatch(ptrs []uintptr) {
+// IncRefCntBatch increments the reference count of objects interned in the store.
+func (oi *ObjectIntern) IncRefCntBatch(ptrs []uintptr) {
+	if oi.conf.DisableRefCounting || oi.Frozen() {
+		return
+	}
+
+	oi.RLock()
+	for _, p := range ptrs {
+
+		_, err := oi.store.Get(p)
+		if err != nil {
+			continue
+		}
+
+		// increment reference count by 1
+		atomic.AddUint32((*uint32)(unsafe.Pointer(p)), 1)
+
+	}
+	oi.RUnlock()
+}
+
+// IncRefCntBatchUnsafe increments the reference count of objects interned in the store.
+// Since these operations are atomic we don't need to acquire any read locks, but it is
+// up to the caller to ensure the objects actually exist. If you are not sure, use the safer method.
+func (oi *ObjectIntern) IncRefCntBatchUnsafe(ptrs []uintptr) {
+	if oi.conf.DisableRefCounting || oi.Frozen() {
+		return
+	}
+
+	for _, p := range ptrs {
+		// increment reference count by 1
+		atomic.AddUint32((*uint32)(unsafe.Pointer(p)), 1)
+	}
+}
+
+// IncRefCntBy increments the reference count of an object interned in the store by n
+// in a single atomic operation.
+// On success it returns the new reference count and nil, on failure it returns 0 and an error
+func (oi *ObjectIntern) IncRefCntBy(objAddr uintptr, n uint32) (uint32, error) {
+	if oi.Frozen() {
+		return 0, ErrFrozen
+	}
+
+	if oi.conf.DisableRefCounting {
+		return 0, ErrNoRefCounting
+	}
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	_, err := oi.store.Get(objAddr)
+	if err != nil {
+		return 0, err
+	}
+
+	// increment reference count by n
+	return atomic.AddUint32((*uint32)(unsafe.Pointer(objAddr)), n), nil
+}
+
+// DecRefCntBy decrements the reference count of an object interned in the store by n.
+// On success it returns the new reference count and nil, on failure it returns 0 and an error
+//
+// The reference count saturates at 1, so this method never removes an object from the
+// store. Use one of the Delete methods to drop the final reference.
+func (oi *ObjectIntern) DecRefCntBy(objAddr uintptr, n uint32) (uint32, error) {
+	if oi.Frozen() {
+		return 0, ErrFrozen
+	}
+
+	if oi.conf.DisableRefCounting {
+		return 0, ErrNoRefCounting
+	}
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	_, err := oi.store.Get(objAddr)
+	if err != nil {
+		return 0, err
+	}
+
+	refCnt := (*uint32)(unsafe.Pointer(objAddr))
+	for {
+		old := atomic.LoadUint32(refCnt)
+		if old <= 1 {
+			return old, nil
+		}
+
+		updated := uint32(1)
+		if n < old {
+			updated = old - n
+		}
+
+		if atomic.CompareAndSwapUint32(refCnt, old, updated) {
+			return updated, nil
+		}
+	}
+}
+
+// SwapRefCnt exchanges the reference counts of the objects stored at a and b under the
+// write lock, so no other method sees or modifies either count in between. It returns
+// nil on success. If either object is not interned, or if either reference count is 0,
+// so one of the objects would end up without a reference, it returns an error and
+// neither count is changed. If reference counting is turned off it returns ErrNoRefCounting.
+func (oi *ObjectIntern) SwapRefCnt(a, b uintptr) error {
+	if oi.Frozen() {
+		return ErrFrozen
+	}
+
+	if oi.conf.DisableRefCounting {
+		return ErrNoRefCounting
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	for _, addr := range []uintptr{a, b} {
+		obj, err := oi.store.Get(addr)
+		if err != nil {
+			return err
+		}
+		if !oi.interned(obj, addr) {
+			return fmt.Errorf("Could not find object in store: %d", addr)
+		}
+	}
+
+	refCntA := (*uint32)(unsafe.Pointer(a))
+	refCntB := (*uint32)(unsafe.Pointer(b))
+
+	// the unsafe methods modify reference counts without acquiring any lock,
+	// so the counts still need to be accessed atomically
+	cntA, cntB := atomic.LoadUint32(refCntA), atomic.LoadUint32(refCntB)
+	if cntA == 0 || cntB == 0 {
+		return fmt.Errorf("Could not swap reference counts %d and %d, an object would end up unreferenced", cntA, cntB)
+	}
+	atomic.StoreUint32(refCntA, cntB)
+	atomic.StoreUint32(refCntB, cntA)
+	return nil
+}
+
+// ObjBytes returns a []byte and nil on success.
+// On failure it returns nil and an error. If the object store returns an object which is
+// too short to be valid, or SafeMode is turned on and objAddr is not the address of an
+// interned object, the error is ErrCorruptObject.
+//
+// WARNING: This can be dangerous. You are able to directly modify the values stored
+// in the object store after you retrieve an uncompressed []byte
+//
+// If compression is turned off, this will return a []byte slice with the backing array
+// set to the interned data, otherwise it will return a new decompressed []byte
+func (oi *ObjectIntern) ObjBytes(objAddr uintptr) ([]byte, error) {
+	var err error
+
+	defer oi.readUnlock(oi.readLock())
+
+	b, err := oi.store.Get(objAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := oi.checkStored(objAddr, b); err != nil {
+		return nil, err
+	}
+
+	if oi.conf.Compression != None {
+		// remove leading bytes for reference count and decompress
+		b, err = oi.decompress(b[oi.refCntSize:])
+		return b, err
+	}
+
+	// remove leading bytes for reference count
+	return b[oi.refCntSize:], nil
+}
+
+// EqualAddr returns true and nil if the objects stored at a and b are equal. Since equal
+// objects are only interned once, this is the case if a and b are the same address, so the
+// objects never need to be read or decompressed. Both addresses are still checked to hold an
+// interned object first, upon failure it returns false and an error.
+//
+// This method does not increase the reference count of the interned objects.
+func (oi *ObjectIntern) EqualAddr(a, b uintptr) (bool, error) {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	for _, addr := range []uintptr{a, b} {
+		obj, err := oi.store.Get(addr)
+		if err != nil {
+			return false, err
+		}
+		if !oi.interned(obj, addr) {
+			return false, fmt.Errorf("Could not find object in store: %d", addr)
+		}
+	}
+	return a == b, nil
+}
+
+// EqualValue returns true and nil if the object stored at objAddr is equal to v, after v has
+// been normalized the same way as by AddOrGet. If compression is turned on v is compressed
+// into a pooled buffer and compared to the stored object, instead of decompressing the
+// stored object. If objAddr doesn't hold an interned object it returns false and an error.
+// If v is empty once normalized it returns false and ErrEmptyObject.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) EqualValue(objAddr uintptr, v []byte) (bool, error) {
+	v = oi.normalize(v)
+	if len(v) == 0 {
+		return false, ErrEmptyObject
+	}
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	obj, err := oi.store.Get(objAddr)
+	if err != nil {
+		return false, err
+	}
+	if !oi.interned(obj, objAddr) {
+		return false, fmt.Errorf("Could not find object in store: %d", objAddr)
+	}
+	obj = obj[oi.refCntSize:]
+
+	if oi.conf.Compression == None {
+		return bytes.Equal(obj, v), nil
+	}
+
+	bufPtr := keyBufPool.Get().(*[]byte)
+	key := oi.compressInto((*bufPtr)[:0], v)
+	equal := bytes.Equal(obj, key)
+
+	// keep the grown buffer for the next call
+	*bufPtr = key
+	keyBufPool.Put(bufPtr)
+
+	return equal, nil
+}
+
+// GetBytesInto writes the object stored at objAddr into dst and returns the used slice and nil.
+// If dst is too small a larger slice is allocated, so callers should keep using the returned
+// slice to reuse the buffer across many reads. On failure it returns dst[:0] and an error.
+// Just like ObjBytes it returns ErrCorruptObject if the stored object is too short to be
+// valid, or SafeMode is turned on and objAddr is not the address of an interned object.
+//
+// Unlike ObjBytes the result never shares memory with the object store. If compression is
+// turned on and the read-through cache is enabled, the decompressed object is taken from the
+// cache, so reading an object repeatedly does not allocate. Otherwise the object still needs
+// to be decompressed before it is copied into dst.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) GetBytesInto(objAddr uintptr, dst []byte) ([]byte, error) {
+	dst = dst[:0]
+
 	oi.RLock()
-	for _, p := range ptrs {
+	defer oi.RUnlock()
 
-		_, err := oi.store.Get(p)
+	b, err := oi.store.Get(objAddr)
+	if err != nil {
+		return dst, err
+	}
+	if err := oi.checkStored(objAddr, b); err != nil {
+		return dst, err
+	}
+
+	if oi.conf.Compression != None {
+		if oi.cache != nil {
+			b, err = oi.cachedDecompress(objAddr, b)
+		} else {
+			// remove leading bytes for reference count and decompress
+			b, err = oi.decompress(b[oi.refCntSize:])
+		}
 		if err != nil {
-			continue
+			return dst, err
 		}
-
-		// increment reference count by 1
-		atomic.AddUint32((*uint32)(unsafe.Pointer(p)), 1)
-
+		return append(dst, b...), nil
 	}
-	oi.RUnlock()
-}
 
-// IncRefCntBatchUnsafe increments the reference count of objects interned in the store.
-// Since these operations are atomic we don't need to acquire any read locks, but it is
-// up to the caller to ensure the objects actually exist. If you are not sure, use the safer method.
-func (oi *ObjectIntern) IncRefCntBatchUnsafe(ptrs []uintptr) {
-	for _, p := range ptrs {
-		// increment reference count by 1
-		atomic.AddUint32((*uint32)(unsafe.Pointer(p)), 1)
-	}
+	// remove leading bytes for reference count
+	return append(dst, b[oi.refCntSize:]...), nil
 }
 
-// ObjBytes returns a []byte and nil on success.
-// On failure it returns nil and an error.
+// ObjBytesBatch returns the objects stored at addrs as a [][]byte and nil. Its indexes
+// match the indexes of addrs. The read lock is only acquired once. The result is written
+// into dst, which is grown if it is too small, so the slice returned by the previous call
+// can be passed in to reuse it.
+// Upon failure it returns nil and an error identifying the first address which could not
+// be resolved.
 //
-// WARNING: This can be dangerous. You are able to directly modify the values stored
-// in the object store after you retrieve an uncompressed []byte
+// If compression is turned off the returned slices reference the interned data, just like
+// those returned by ObjBytes. Otherwise all objects are decompressed into a single buffer,
+// which is reused by the next call that is given the result as dst. So the returned slices
+// are only valid until then, and they must not be appended to.
 //
-// If compression is turned off, this will return a []byte slice with the backing array
-// set to the interned data, otherwise it will return a new decompressed []byte
-func (oi *ObjectIntern) ObjBytes(objAddr uintptr) ([]byte, error) {
-	var err error
+// This method does not increase the reference count of the interned objects.
+func (oi *ObjectIntern) ObjBytesBatch(addrs []uintptr, dst [][]byte) ([][]byte, error) {
+	if cap(dst) < len(addrs) {
+		dst = make([][]byte, len(addrs))
+	}
+	dst = dst[:len(addrs)]
+
+	// take over the buffer of the previous call, its first object starts at the beginning of it
+	var scratch []byte
+	if oi.conf.Compression != None && len(dst) > 0 {
+		scratch = dst[0][:0]
+	}
 
 	oi.RLock()
 	defer oi.RUnlock()
 
-	b, err := oi.store.Get(objAddr)
-	if err != nil {
-		return nil, err
+	for idx, addr := range addrs {
+		b, err := oi.store.Get(addr)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get object %d at %d: %s", idx, addr, err)
+		}
+
+		if oi.conf.Compression == None {
+			// remove leading bytes for reference count
+			dst[idx] = b[oi.refCntSize:]
+			continue
+		}
+
+		start := len(scratch)
+		scratch, err = oi.decompressInto(scratch, b[oi.refCntSize:])
+		if err != nil {
+			return nil, fmt.Errorf("Could not get object %d at %d: %s", idx, addr, err)
+		}
+		dst[idx] = scratch[start:]
 	}
 
-	if oi.conf.Compression != None {
-		// remove 4 leading bytes for reference count and decompress
-		b, err = oi.decompress(b[4:])
-		return b, err
+	if oi.conf.Compression != None && len(dst) > 0 {
+		// the buffer may have been reallocated while it grew, but the objects are stored back to
+		// back, so point all of them into the final buffer
+		var start int
+		for idx := range dst {
+			end := start + len(dst[idx])
+			dst[idx] = scratch[start:end:end]
+			start = end
+		}
+		// keep the capacity of the whole buffer, so the next call can reuse it
+		dst[0] = scratch[:len(dst[0])]
 	}
 
-	// remove 4 leading bytes for reference count
-	return b[4:], nil
+	return dst, nil
 }
 
 // ObjString returns a string and nil on success.
-// On failure it returns an empty string and an error.
+// On failure it returns an empty string and an error. It checks the object just like ObjBytes.
 //
 // This method does not use the interned data to create a string,
 // instead it allocates a new string.
@@ -931,23 +2750,27 @@ func (oi *ObjectIntern) ObjString(objAddr uintptr) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if err := oi.checkStored(objAddr, b); err != nil {
+		return "", err
+	}
 
 	if oi.conf.Compression != None {
-		// remove 4 leading bytes for reference count and decompress
-		b, err := oi.decompress(b[4:])
+		// remove leading bytes for reference count and decompress
+		b, err := oi.decompress(b[oi.refCntSize:])
 		if err != nil {
 			return "", err
 		}
 		return string(b), nil
 	}
 
-	return string(b[4:]), nil
+	return string(b[oi.refCntSize:]), nil
 }
 
 // Len takes a slice of object addresses, it assumes that compression is turned off.
 // Upon success it returns a slice of the lengths of all of the interned objects - the 4 trailing bytes for reference count, and true.
 // The returned slice indexes match the indexes of the slice of uintptrs.
-// On failure it returns a possibly partial slice of the lengths, and false.
+// On failure, including an object which doesn't hold any data after the reference count,
+// it returns a possibly partial slice of the lengths, and false.
 func (oi *ObjectIntern) Len(ptrs []uintptr) (retLn []int, all bool) {
 	retLn = make([]int, len(ptrs))
 	all = true
@@ -957,17 +2780,38 @@ func (oi *ObjectIntern) Len(ptrs []uintptr) (retLn []int, all bool) {
 
 	for idx, ptr := range ptrs {
 		b, err := oi.store.Get(ptr)
-		if err != nil {
+		if err != nil || len(b) <= int(oi.refCntSize) {
 			return retLn, false
 		}
-		// remove 4 leading bytes of reference count
-		retLn[idx] = len(b) - 4
+		// remove leading bytes of reference count
+		retLn[idx] = len(b) - int(oi.refCntSize)
 	}
 	return
 }
 
+// TotalLen takes a slice of object addresses, it assumes that compression is turned off.
+// Upon success it returns the sum of the lengths of all of the interned objects - the 4 trailing bytes for reference count, and true.
+// It is the same as summing the result of Len, without allocating the intermediate slice.
+// On failure, including an object which doesn't hold any data after the reference count,
+// it returns the sum of the lengths found so far, and false.
+func (oi *ObjectIntern) TotalLen(ptrs []uintptr) (total int, all bool) {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	for _, ptr := range ptrs {
+		b, err := oi.store.Get(ptr)
+		if err != nil || len(b) <= int(oi.refCntSize) {
+			return total, false
+		}
+		// remove leading bytes of reference count
+		total += len(b) - int(oi.refCntSize)
+	}
+	return total, true
+}
+
 // JoinStrings takes a slice of uintptr and returns a reconstructed string using sep
-// as the separator.
+// as the separator. If the length of the joined string would overflow an int it
+// returns an empty string and ErrJoinTooLarge.
 func (oi *ObjectIntern) JoinStrings(nodes []uintptr, sep string) (string, error) {
 	if oi.conf.Compression != None {
 		return oi.joinStringsCompressed(nodes, sep)
@@ -976,6 +2820,27 @@ func (oi *ObjectIntern) JoinStrings(nodes []uintptr, sep string) (string, error)
 	return oi.joinStringsUncompressed(nodes, sep)
 }
 
+// maxJoinSize is the maximum length of a string built by JoinStrings
+const maxJoinSize = int(^uint(0) >> 1)
+
+// joinSize returns size + n and nil. If the sum would exceed maxJoinSize it returns
+// 0 and ErrJoinTooLarge instead of overflowing.
+func joinSize(size, n int) (int, error) {
+	if n > maxJoinSize-size {
+		return 0, ErrJoinTooLarge
+	}
+	return size + n, nil
+}
+
+// joinBufPool holds the buffers used to build the strings returned by JoinStrings
+// and the objects interned by AddOrGetConcat
+var joinBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 256)
+		return &buf
+	},
+}
+
 func (oi *ObjectIntern) joinStringsCompressed(nodes []uintptr, sep string) (string, error) {
 	switch len(nodes) {
 	case 0:
@@ -985,24 +2850,54 @@ func (oi *ObjectIntern) joinStringsCompressed(nodes []uintptr, sep string) (stri
 		return single, err
 	}
 
-	var bld strings.Builder
+	bufPtr := joinBufPool.Get().(*[]byte)
+	buf := (*bufPtr)[:0]
+	defer func() {
+		// keep the grown buffer for the next call
+		*bufPtr = buf
+		joinBufPool.Put(bufPtr)
+	}()
 
-	first, err := oi.GetStringFromPtr(nodes[0])
-	if err != nil {
-		return "", err
-	}
-	bld.WriteString(first)
+	// guards against overflowing the length of the joined string
+	var size int
+
+	locked := oi.readLock()
+	for idx, nodePtr := range nodes {
+		b, err := oi.store.Get(nodePtr)
+		if err != nil {
+			oi.readUnlock(locked)
+			return "", err
+		}
 
-	for _, nodePtr := range nodes[1:] {
-		tmpString, err := oi.GetStringFromPtr(nodePtr)
+		if oi.cache != nil {
+			b, err = oi.cachedDecompress(nodePtr, b)
+		} else {
+			// remove leading bytes for reference count and decompress
+			b, err = oi.decompress(b[oi.refCntSize:])
+		}
+		if err != nil {
+			oi.readUnlock(locked)
+			return "", err
+		}
+
+		if idx > 0 {
+			size, err = joinSize(size, len(sep))
+			if err != nil {
+				oi.readUnlock(locked)
+				return "", err
+			}
+			buf = append(buf, sep...)
+		}
+		size, err = joinSize(size, len(b))
 		if err != nil {
+			oi.readUnlock(locked)
 			return "", err
 		}
-		bld.WriteString(sep)
-		bld.WriteString(tmpString)
+		buf = append(buf, b...)
 	}
+	oi.readUnlock(locked)
 
-	return bld.String(), nil
+	return string(buf), nil
 }
 
 func (oi *ObjectIntern) joinStringsUncompressed(nodes []uintptr, sep string) (string, error) {
@@ -1014,36 +2909,45 @@ func (oi *ObjectIntern) joinStringsUncompressed(nodes []uintptr, sep string) (st
 		return single, err
 	}
 
-	lengths, complete := oi.Len(nodes)
-	if !complete {
-		return "", fmt.Errorf("Could not find object in store")
-	}
-
-	oi.RLock()
-	totalSize := len(sep) * (len(nodes) - 1)
-	for _, length := range lengths {
-		totalSize += length
-	}
-
-	var tmpString string
-	var bld strings.Builder
-	bld.Grow(totalSize)
+	bufPtr := joinBufPool.Get().(*[]byte)
+	buf := (*bufPtr)[:0]
+	defer func() {
+		// keep the grown buffer for the next call
+		*bufPtr = buf
+		joinBufPool.Put(bufPtr)
+	}()
 
-	stringHeader := (*reflect.StringHeader)(unsafe.Pointer(&tmpString))
+	// guards against overflowing the length of the joined string
+	var size int
 
-	stringHeader.Data = nodes[0] + 4
-	stringHeader.Len = lengths[0]
-	bld.WriteString(tmpString)
+	locked := oi.readLock()
+	for idx, nodePtr := range nodes {
+		b, err := oi.store.Get(nodePtr)
+		if err != nil {
+			oi.readUnlock(locked)
+			return "", fmt.Errorf("Could not find object in store")
+		}
 
-	for idx, nodePtr := range nodes[1:] {
-		stringHeader.Data = nodePtr + 4
-		stringHeader.Len = lengths[idx+1]
-		bld.WriteString(sep)
-		bld.WriteString(tmpString)
+		if idx > 0 {
+			size, err = joinSize(size, len(sep))
+			if err != nil {
+				oi.readUnlock(locked)
+				return "", err
+			}
+			buf = append(buf, sep...)
+		}
+		// remove leading bytes for reference count
+		b = b[oi.refCntSize:]
+		size, err = joinSize(size, len(b))
+		if err != nil {
+			oi.readUnlock(locked)
+			return "", err
+		}
+		buf = append(buf, b...)
 	}
+	oi.readUnlock(locked)
 
-	oi.RUnlock()
-	return bld.String(), nil
+	return string(buf), nil
 }
 
 // Reset empties the object store and index and re-initializes them.
@@ -1052,28 +2956,221 @@ func (oi *ObjectIntern) joinStringsUncompressed(nodes []uintptr, sep string) (st
 // previously interned object.
 // Returns nil on success and an error on failure.
 func (oi *ObjectIntern) Reset() error {
-	var err error
+	if oi.Frozen() {
+		return ErrFrozen
+	}
+
 	oi.Lock()
-	for obj, addr := range oi.objIndex {
-		// delete object from index first
-		// If you delete all of the objects in the slab then the slab will be deleted
-		// When this happens the memory that the slab was using is MUnmapped, which is
-		// the same memory pointed to by the key stored in the ObjIndex. When you try to
-		// access the key to delete it from the ObjIndex you will get a SEGFAULT
-		delete(oi.objIndex, obj)
 
-		// delete object from object store
-		err = oi.store.Delete(addr)
+	if oi.closed() {
+		oi.Unlock()
+		return ErrClosed
+	}
+
+	if oi.conf.Logger != nil {
+		oi.log(EventReset, map[string]interface{}{"count": oi.indexLen() + len(oi.unindexed)})
+	}
+
+	err := oi.clear()
+	if err != nil {
+		oi.Unlock()
+		return err
+	}
+
+	oi.store = oi.conf.NewStore(oi.conf.SlabSize)
+	oi.fault = nil
+	err = oi.prewarm()
+
+	oi.Unlock()
+	return err
+}
+
+// DeleteAll deletes every object from the object store regardless of its reference count
+// and returns the number of objects removed and nil. Unlike Reset it keeps the object store
+// and the maps of the index, so references to them stay valid.
+// On failure it returns the number of objects removed so far and an error. The index is
+// empty either way, objects which could not be deleted remain in the object store.
+//
+// Just like Reset this should only be used if no one is going to try to reference a
+// previously interned object.
+func (oi *ObjectIntern) DeleteAll() (int, error) {
+	if oi.Frozen() {
+		return 0, ErrFrozen
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	if oi.closed() {
+		return 0, ErrClosed
+	}
+
+	addrs := make([]uintptr, 0, oi.indexLen()+len(oi.unindexed))
+	oi.indexRange(func(addr uintptr) bool {
+		addrs = append(addrs, addr)
+		return true
+	})
+
+	// empty the whole index first
+	// If you delete all of the objects in the slab then the slab will be deleted
+	// When this happens the memory that the slab was using is MUnmapped, which is
+	// the same memory pointed to by the keys stored in the ObjIndex. When you try to
+	// access those keys to delete them from the ObjIndex you will get a SEGFAULT
+	oi.emptyIndex()
+
+	oi.cache.reset()
+	oi.strCache.reset()
+	oi.lastStr.reset()
+	oi.handles.reset()
+
+	for idx, addr := range addrs {
+		// the object store is kept, so zero the memory before handing it back, see remove
+		obj, err := oi.store.Get(addr)
 		if err != nil {
-			return err
+			return idx, err
+		}
+		for i := range obj {
+			obj[i] = 0
 		}
+
+		// delete object from object store
+		if _, err := oi.storeDelete(addr, uint8(len(obj))); err != nil {
+			return idx, err
+		}
+	}
+
+	return len(addrs), nil
+}
+
+// ResetAndTrim does the same thing as Reset, but also releases the memory that was used
+// by the table back to the operating system, so the RSS of a process drops after a spike.
+// If the store implements Trimmer it is trimmed and kept, otherwise it is replaced by a
+// fresh store just like Reset does. Just like Reset the pools in PrewarmSizes are prewarmed
+// again. Afterwards the Go runtime is asked to return freed memory to the operating system,
+// which forces a garbage collection.
+// Returns nil on success and an error on failure.
+func (oi *ObjectIntern) ResetAndTrim() error {
+	if oi.Frozen() {
+		return ErrFrozen
+	}
+
+	oi.Lock()
+
+	if oi.closed() {
+		oi.Unlock()
+		return ErrClosed
+	}
+
+	if oi.conf.Logger != nil {
+		oi.log(EventReset, map[string]interface{}{"count": oi.indexLen() + len(oi.unindexed)})
+	}
+
+	err := oi.clear()
+	if err != nil {
+		oi.Unlock()
+		return err
 	}
 
-	oi.store = gos.NewObjectStore(oi.conf.SlabSize)
-	oi.objIndex = make(map[string]uintptr)
+	// every object has been deleted successfully
+	oi.fault = nil
+
+	if t, ok := oi.store.(Trimmer); ok {
+		err = t.Trim()
+	} else {
+		oi.store = oi.conf.NewStore(oi.conf.SlabSize)
+	}
+	if err == nil {
+		err = oi.prewarm()
+	}
 
 	oi.Unlock()
-	return nil
+
+	// the index, caches and replaced store are garbage now, so this is
+	// done after unlocking to not block other callers during the collection
+	debug.FreeOSMemory()
+
+	return err
+}
+
+// Close deletes all objects from the object store and releases all of its memory.
+// Afterwards the ObjectIntern is unusable and its methods return ErrClosed.
+// It returns nil on success. On failure it returns an error, but the ObjectIntern
+// is closed regardless. Calling Close more than once returns ErrClosed.
+// If AutoCompact is turned on, Close first waits for the background compaction to stop.
+// If oi is frozen, it also waits for the reads which don't hold the read lock, see Freeze.
+//
+// All addresses, strings and handles previously handed out become invalid.
+// Methods that do not validate addresses, such as DeleteUnsafe or
+// GetStringFromPtrUnsafe, must not be called after Close.
+func (oi *ObjectIntern) Close() error {
+	// the background compaction needs the lock, so it has to be stopped first
+	oi.compactor.stop()
+
+	oi.Lock()
+
+	if oi.closed() {
+		oi.Unlock()
+		return ErrClosed
+	}
+
+	// readers of a frozen ObjectIntern don't hold the read lock
+	oi.waitReaders()
+
+	err := oi.clear()
+	oi.store = closedStore{}
+
+	oi.Unlock()
+	return err
+}
+
+// closed returns true if Close has been called.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) closed() bool {
+	_, ok := oi.store.(closedStore)
+	return ok
+}
+
+// clear removes all objects from the index, the caches and the object store.
+// It returns nil on success and the first error encountered on failure.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) clear() error {
+	addrs := make([]uintptr, 0, oi.indexLen())
+	oi.indexRange(func(addr uintptr) bool {
+		addrs = append(addrs, addr)
+		return true
+	})
+
+	// drop the whole index first
+	// If you delete all of the objects in the slab then the slab will be deleted
+	// When this happens the memory that the slab was using is MUnmapped, which is
+	// the same memory pointed to by the keys stored in the ObjIndex. When you try to
+	// access those keys to delete them from the ObjIndex you will get a SEGFAULT
+	oi.newIndex()
+
+	oi.cache.reset()
+	oi.strCache.reset()
+	oi.lastStr.reset()
+	oi.handles.reset()
+
+	for _, addr := range addrs {
+		obj, err := oi.store.Get(addr)
+		if err != nil {
+			return err
+		}
+		// ResetAndTrim may keep the object store, so zero the memory before handing it back, see remove
+		for i := range obj {
+			obj[i] = 0
+		}
+
+		// delete object from object store
+		if _, err := oi.storeDelete(addr, uint8(len(obj))); err != nil {
+			return err
+		}
+	}
+
+	return oi.freePlaceholders()
 }
 
 func (oi *ObjectIntern) FragStatsByObjSize(objSize uint8) (float32, error) {
@@ -1094,12 +3191,195 @@ func (oi *ObjectIntern) FragStatsTotal() (float32, error) {
 	return oi.store.FragStatsTotal()
 }
 
+// FragAdvice describes the fragmentation of a single object size pool
+// and whether compacting it is recommended.
+type FragAdvice struct {
+	ObjSize       uint8
+	Fragmentation float32
+	Objects       int
+	Recommended   bool
+}
+
+// FragReport returns a FragAdvice for every pool in the object store, sorted by object size.
+// Fragmentation is the ratio of unused object slots in the pool's slabs, it is derived from
+// FragStatsByObjSize which reports the ratio of used object slots. Recommended is set when
+// Fragmentation exceeds the configured FragThreshold.
+//
+// This method is read-only, it does not modify the store in any way.
+func (oi *ObjectIntern) FragReport() []FragAdvice {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	objects := oi.objectsPerPool()
+
+	stats := oi.store.FragStatsPerPool()
+	report := make([]FragAdvice, 0, len(stats))
+	for _, stat := range stats {
+		used, err := oi.store.FragStatsByObjSize(stat.ObjSize)
+		if err != nil {
+			continue
+		}
+		frag := 1 - used
+		report = append(report, FragAdvice{
+			ObjSize:       stat.ObjSize,
+			Fragmentation: frag,
+			Objects:       objects[stat.ObjSize],
+			Recommended:   frag > oi.conf.FragThreshold,
+		})
+	}
+
+	sort.Slice(report, func(i, j int) bool { return report[i].ObjSize < report[j].ObjSize })
+
+	return report
+}
+
+// SizeOf returns the number of bytes obj would occupy in the object store if it was interned,
+// without interning it. This is the size of the stored form of obj, after normalization
+// and compression, including the reference count. It is also the object size of the pool
+// obj would be stored in. For empty objects it returns 0.
+//
+// Each slab of the object store has a small header in addition to its objects, which is
+// not included. SizeOf also does not check whether obj is already interned, in which case
+// interning it again does not use any additional memory.
+func (oi *ObjectIntern) SizeOf(obj []byte) int {
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return 0
+	}
+	if oi.conf.Compression != None {
+		obj = oi.compress(obj)
+	}
+	return len(obj) + int(oi.refCntSize)
+}
+
+// PoolForLen returns the object size of the pool in which the object store keeps an object
+// whose stored form is length bytes long, which is length plus the reference count. The
+// object store has a pool for every object size, so objects of different lengths never
+// share a slab. The stored form is compressed if compression is turned on, see SizeOf to
+// get it for a given object. If no object of that length can be stored, because it is
+// empty or too long for the object store, it returns 0.
+func (oi *ObjectIntern) PoolForLen(length int) uint8 {
+	size := length + int(oi.refCntSize)
+	if length <= 0 || size > math.MaxUint8 {
+		return 0
+	}
+	return uint8(size)
+}
+
+// ActivePoolSizes returns the object sizes of all pools which hold at least one
+// interned object, sorted in ascending order. The sizes include the reference count,
+// so they can be passed to FragStatsByObjSize and MemStatsByObjSize.
+func (oi *ObjectIntern) ActivePoolSizes() []uint8 {
+	oi.RLock()
+	objects := oi.objectsPerPool()
+	oi.RUnlock()
+
+	sizes := make([]uint8, 0, len(objects))
+	for size := range objects {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+
+	return sizes
+}
+
+// ObjectsInPool returns the addresses of all interned objects stored in the pool of the
+// given object size, sorted in ascending order, and nil. Just like for FragStatsByObjSize
+// the size includes the reference count, see SizeOf and ActivePoolSizes.
+// If the object store has no pool of that size it returns nil and an error.
+//
+// This method iterates over every object in the index while holding the read lock, so its
+// cost grows with the number of interned objects. It is meant for debugging.
+func (oi *ObjectIntern) ObjectsInPool(objSize uint8) ([]uintptr, error) {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	if _, err := oi.store.MemStatsByObjSize(objSize); err != nil {
+		return nil, err
+	}
+
+	addrs := make([]uintptr, 0)
+	oi.indexRange(func(addr uintptr) bool {
+		b, err := oi.store.Get(addr)
+		if err == nil && len(b) == int(objSize) {
+			addrs = append(addrs, addr)
+		}
+		return true
+	})
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	return addrs, nil
+}
+
+// RefCntHistogram counts the interned objects by reference count. buckets are the upper
+// bounds of the buckets and must be sorted in ascending order. The returned slice has one
+// more element than buckets: the element at index i counts the objects whose reference
+// count is larger than buckets[i-1] and at most buckets[i], the last one counts the objects
+// whose reference count is larger than the last bound.
+//
+// For example buckets of []uint32{1, 10} separate objects which are only referenced once
+// from those referenced up to 10 times and those referenced more often.
+//
+// If reference counting is turned off every object is counted as referenced once.
+//
+// This method iterates over every object in the index while holding the read lock, so its
+// cost is O(n) in the number of interned objects. It should not be used in a hot path.
+func (oi *ObjectIntern) RefCntHistogram(buckets []uint32) []int {
+	hist := make([]int, len(buckets)+1)
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	oi.indexRange(func(addr uintptr) bool {
+		refCnt := uint32(1)
+		if !oi.conf.DisableRefCounting {
+			refCnt = atomic.LoadUint32((*uint32)(unsafe.Pointer(addr)))
+		}
+		hist[sort.Search(len(buckets), func(i int) bool { return buckets[i] >= refCnt })]++
+		return true
+	})
+
+	return hist
+}
+
+// objectsPerPool counts the interned objects per object size.
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) objectsPerPool() map[uint8]int {
+	objects := make(map[uint8]int)
+	oi.indexRange(func(addr uintptr) bool {
+		b, err := oi.store.Get(addr)
+		if err == nil {
+			objects[uint8(len(b))]++
+		}
+		return true
+	})
+	return objects
+}
+
 func (oi *ObjectIntern) MemStatsByObjSize(objSize uint8) (uint64, error) {
 	oi.RLock()
 	defer oi.RUnlock()
 	return oi.store.MemStatsByObjSize(objSize)
 }
 
+// MemStatsForSizes returns the memory used by the pools of each of the requested object sizes.
+// The returned slices have the same length as sizes. If a pool could not be found its memory
+// usage is 0 and the error at the same index is set, otherwise the error is nil.
+// All of the stats are gathered while holding the read lock once, so they are consistent.
+func (oi *ObjectIntern) MemStatsForSizes(sizes []uint8) ([]uint64, []error) {
+	mem := make([]uint64, len(sizes))
+	errs := make([]error, len(sizes))
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	for idx, size := range sizes {
+		mem[idx], errs[idx] = oi.store.MemStatsByObjSize(size)
+	}
+	return mem, errs
+}
+
 func (oi *ObjectIntern) MemStatsPerPool() []gos.MemStat {
 	oi.RLock()
 	defer oi.RUnlock()
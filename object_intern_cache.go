@@ -0,0 +1,291 @@
+package goi
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// decompressionCache is a bounded read-through cache of decompressed objects,
+// keyed by the address of the object in the object store.
+//
+// Cached slices are never modified once they have been added, so strings that
+// alias them remain valid after the entry is evicted; the garbage collector
+// keeps the backing array alive for as long as something references it.
+type decompressionCache struct {
+	sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[uintptr]*list.Element
+}
+
+type cacheEntry struct {
+	addr uintptr
+	data []byte
+}
+
+// newDecompressionCache returns a cache holding at most size entries.
+// If size is less than 1 it returns nil, which is a valid disabled cache.
+func newDecompressionCache(size int) *decompressionCache {
+	if size < 1 {
+		return nil
+	}
+	return &decompressionCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[uintptr]*list.Element, size),
+	}
+}
+
+// get returns the decompressed object stored at addr and true on a cache hit.
+// On a miss it returns nil and false.
+func (c *decompressionCache) get(addr uintptr) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.entries[addr]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).data, true
+}
+
+// add inserts the decompressed object data for addr, evicting the least
+// recently used entry if the cache is full.
+// The caller must not modify data after handing it to the cache.
+func (c *decompressionCache) add(addr uintptr, data []byte) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.entries[addr]; ok {
+		elem.Value.(*cacheEntry).data = data
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).addr)
+	}
+
+	c.entries[addr] = c.lru.PushFront(&cacheEntry{addr: addr, data: data})
+}
+
+// remove drops the entry for addr, if any. This must be called whenever
+// the object at addr is removed from the object store, since the address
+// may be reused for a different object afterwards.
+func (c *decompressionCache) remove(addr uintptr) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	if elem, ok := c.entries[addr]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, addr)
+	}
+	c.Unlock()
+}
+
+// reset drops all entries
+func (c *decompressionCache) reset() {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	c.lru.Init()
+	c.entries = make(map[uintptr]*list.Element, c.size)
+	c.Unlock()
+}
+
+// stringCache is a bounded cache of the strings returned by GetStringFromPtr,
+// keyed by the address of the object in the object store. Entries expire after
+// ttl, after which the string is recomputed from the object store.
+type stringCache struct {
+	sync.Mutex
+	size    int
+	ttl     time.Duration
+	now     func() time.Time
+	lru     *list.List
+	entries map[uintptr]*list.Element
+}
+
+type stringCacheEntry struct {
+	addr    uintptr
+	str     string
+	expires time.Time
+}
+
+// newStringCache returns a cache holding at most size entries for ttl each.
+// If size or ttl is less than 1 it returns nil, which is a valid disabled cache.
+func newStringCache(size int, ttl time.Duration) *stringCache {
+	if size < 1 || ttl < 1 {
+		return nil
+	}
+	return &stringCache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[uintptr]*list.Element, size),
+	}
+}
+
+// get returns the string for the object stored at addr and true on a cache hit.
+// On a miss, or if the entry has expired, it returns an empty string and false.
+func (c *stringCache) get(addr uintptr) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	elem, ok := c.entries[addr]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*stringCacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, addr)
+		return "", false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.str, true
+}
+
+// add inserts the string for addr, evicting the least recently used entry if the cache is full
+func (c *stringCache) add(addr uintptr, str string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	expires := c.now().Add(c.ttl)
+	if elem, ok := c.entries[addr]; ok {
+		entry := elem.Value.(*stringCacheEntry)
+		entry.str = str
+		entry.expires = expires
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	if c.lru.Len() >= c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*stringCacheEntry).addr)
+	}
+
+	c.entries[addr] = c.lru.PushFront(&stringCacheEntry{addr: addr, str: str, expires: expires})
+}
+
+// remove drops the entry for addr, if any. This must be called whenever
+// the object at addr is removed from the object store.
+func (c *stringCache) remove(addr uintptr) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	if elem, ok := c.entries[addr]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, addr)
+	}
+	c.Unlock()
+}
+
+// reset drops all entries
+func (c *stringCache) reset() {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	c.lru.Init()
+	c.entries = make(map[uintptr]*list.Element, c.size)
+	c.Unlock()
+}
+
+// lastString remembers the string most recently built by GetStringFromPtr, so reading the
+// same address again right away doesn't need to decompress the object. It is much cheaper
+// than the stringCache, but only helps if the same object is read in bursts. It is only
+// used if the stringCache is disabled.
+type lastString struct {
+	sync.Mutex
+	addr uintptr
+	str  string
+}
+
+// newLastString returns an empty lastString
+func newLastString() *lastString {
+	return &lastString{}
+}
+
+// get returns the remembered string and true if it belongs to the object stored at addr.
+// Otherwise it returns an empty string and false.
+func (l *lastString) get(addr uintptr) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if addr == 0 || l.addr != addr {
+		return "", false
+	}
+	return l.str, true
+}
+
+// set remembers str as the string of the object stored at addr
+func (l *lastString) set(addr uintptr, str string) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	l.addr = addr
+	l.str = str
+	l.Unlock()
+}
+
+// remove forgets the remembered string if it belongs to addr. This must be called
+// whenever the object at addr is removed from the object store or modified.
+func (l *lastString) remove(addr uintptr) {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	if l.addr == addr {
+		l.addr = 0
+		l.str = ""
+	}
+	l.Unlock()
+}
+
+// reset forgets the remembered string
+func (l *lastString) reset() {
+	if l == nil {
+		return
+	}
+
+	l.Lock()
+	l.addr = 0
+	l.str = ""
+	l.Unlock()
+}
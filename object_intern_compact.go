@@ -0,0 +1,186 @@
+package goi
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// compactor controls the background compaction started by AutoCompact
+type compactor struct {
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newCompactor() *compactor {
+	return &compactor{
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// stop stops the background compaction and waits for it to return.
+// It is safe to call stop more than once, or on a nil compactor.
+func (c *compactor) stop() {
+	if c == nil {
+		return
+	}
+
+	c.stopOnce.Do(func() {
+		close(c.quit)
+		<-c.done
+	})
+}
+
+// compactLoop runs autoCompact every CompactInterval until c is stopped
+func (oi *ObjectIntern) compactLoop(c *compactor) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(oi.conf.CompactInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+			oi.autoCompact()
+		}
+	}
+}
+
+// autoCompact compacts every pool whose fragmentation, the ratio of unused object slots,
+// exceeds CompactFragThreshold. If the fragmentation of the whole object store doesn't
+// exceed it, no pool is compacted and the write lock is never acquired.
+func (oi *ObjectIntern) autoCompact() {
+	if oi.Frozen() {
+		return
+	}
+
+	oi.RLock()
+	used, err := oi.store.FragStatsTotal()
+	oi.RUnlock()
+	if err != nil || 1-used <= oi.conf.CompactFragThreshold {
+		return
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	oi.relocations = make(map[uintptr]uintptr)
+	for _, stat := range oi.store.FragStatsPerPool() {
+		if 1-stat.FragPercent > oi.conf.CompactFragThreshold {
+			// errors are not fatal, the pool is simply compacted again on the next tick
+			oi.compact(stat.ObjSize)
+		}
+	}
+}
+
+// Compact moves the objects of the pool of the given object size into as few slabs as
+// possible, so the object store can release the slabs which are no longer used. It
+// returns the number of moved objects and nil. Just like for FragStatsByObjSize the size
+// includes the reference count. On failure it returns the number of objects moved so far
+// and an error, every object remains valid either way.
+//
+// WARNING: Every moved object gets a new address. Handles returned by AddOrGetHandle are
+// updated, but addresses and strings previously handed out for the moved objects become
+// invalid. Only use this if objects of this pool are exclusively referenced through handles,
+// see HandlesOnly, or if every address held by the caller is updated with RelocationMap
+// before it is used again.
+//
+// Compact relies on the object store filling the slots at the highest addresses first,
+// like the default store does. It holds the write lock until the whole pool is compacted.
+func (oi *ObjectIntern) Compact(objSize uint8) (int, error) {
+	if oi.Frozen() {
+		return 0, ErrFrozen
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	oi.relocations = make(map[uintptr]uintptr)
+	return oi.compact(objSize)
+}
+
+// RelocationMap returns the old and new address of every object moved by the most recent
+// compaction, either a call to Compact or a run of the background compaction started by
+// AutoCompact, keyed by the old address. It is a copy, so it can be used to update maps
+// of addresses held by the caller in a single pass once the compaction has returned. If no
+// compaction has run yet it returns an empty map.
+//
+// Addresses which aren't in the map have not been moved. Since every compaction replaces the
+// map, callers must apply it before compacting again.
+func (oi *ObjectIntern) RelocationMap() map[uintptr]uintptr {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	relocations := make(map[uintptr]uintptr, len(oi.relocations))
+	for oldAddr, newAddr := range oi.relocations {
+		relocations[oldAddr] = newAddr
+	}
+	return relocations
+}
+
+// compact does the work of Compact.
+//
+// The caller is responsible for holding the write lock.
+func (oi *ObjectIntern) compact(objSize uint8) (int, error) {
+	addrs := make([]uintptr, 0)
+	oi.indexRange(func(addr uintptr) bool {
+		// pinned objects keep their addresses
+		if oi.isPinned(addr) {
+			return true
+		}
+		b, err := oi.store.Get(addr)
+		if err == nil && len(b) == int(objSize) {
+			addrs = append(addrs, addr)
+		}
+		return true
+	})
+	// move the objects at the lowest addresses first, they are the furthest away from the free slots
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	var moved int
+	for _, addr := range addrs {
+		obj, err := oi.store.Get(addr)
+		if err != nil {
+			return moved, err
+		}
+
+		// the store copies obj, including its reference count
+		newAddr, err := oi.storeAdd(obj)
+		if err != nil {
+			return moved, err
+		}
+
+		if newAddr < addr {
+			// every slot above addr is in use, moving the remaining objects would not free any slab.
+			// The object store is kept, so zero the memory before handing it back, see remove
+			newObj, err := oi.store.Get(newAddr)
+			if err != nil {
+				return moved, err
+			}
+			for i := range newObj {
+				newObj[i] = 0
+			}
+			_, err = oi.storeDelete(newAddr, uint8(len(newObj)))
+			return moved, err
+		}
+
+		// the index still references the old copy, so it needs to be updated before the old copy is deleted
+		oi.relocate(obj[oi.refCntSize:], addr, newAddr)
+		oi.relocations[addr] = newAddr
+
+		// zero the old copy before handing it back, see remove
+		for i := range obj {
+			obj[i] = 0
+		}
+		if _, err := oi.storeDelete(addr, uint8(len(obj))); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,304 @@
+package goi
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fragment interns n objects of the same size with a handle each, then deletes every other
+// one. It returns the handles of the remaining objects by value, and the size of their pool.
+func fragment(t *testing.T, oi *ObjectIntern, n int) (map[string]Handle, uint8) {
+	all := make([]Handle, n)
+	var size uint8
+	for i := range all {
+		obj := []byte(fmt.Sprintf("%08d", i))
+		h, err := oi.AddOrGetHandle(obj, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGetHandle: ", obj)
+		}
+		all[i] = h
+		size = uint8(oi.SizeOf(obj))
+	}
+
+	handles := make(map[string]Handle, n/2)
+	for i, h := range all {
+		if i%2 == 0 {
+			handles[fmt.Sprintf("%08d", i)] = h
+			continue
+		}
+		addr, _ := oi.ResolveHandle(h)
+		if _, err := oi.Delete(addr); err != nil {
+			t.Fatal("Failed to Delete: ", err)
+		}
+	}
+	return handles, size
+}
+
+// checkHandles verifies that every handle still resolves to its object
+func checkHandles(t *testing.T, oi *ObjectIntern, handles map[string]Handle) {
+	for obj, h := range handles {
+		addr, ok := oi.ResolveHandle(h)
+		if !ok {
+			t.Fatalf("Handle of %q does not resolve anymore", obj)
+		}
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != obj {
+			t.Fatalf("Expected %q, got %q: %v", obj, str, err)
+		}
+		ptr, err := oi.GetPtrFromByte([]byte(obj))
+		if err != nil || ptr != addr {
+			t.Fatalf("Expected the index to find %q at %d, got %d: %v", obj, addr, ptr, err)
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	testCompact(t, false)
+}
+
+func TestCompactCompressed(t *testing.T) {
+	testCompact(t, true)
+}
+
+func testCompact(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	handles, size := fragment(t, oi, 1000)
+
+	// reference counts need to move along with the objects
+	h := handles["00000000"]
+	addr, _ := oi.ResolveHandle(h)
+	if _, err := oi.IncRefCnt(addr); err != nil {
+		t.Fatal("Failed to IncRefCnt: ", err)
+	}
+
+	usedBefore, err := oi.FragStatsByObjSize(size)
+	if err != nil {
+		t.Fatal("Failed to get FragStatsByObjSize: ", err)
+	}
+	memBefore, err := oi.MemStatsByObjSize(size)
+	if err != nil {
+		t.Fatal("Failed to get MemStatsByObjSize: ", err)
+	}
+
+	moved, err := oi.Compact(size)
+	if err != nil {
+		t.Fatal("Failed to Compact: ", err)
+	}
+	if moved == 0 {
+		t.Fatal("Compact should have moved objects")
+	}
+
+	usedAfter, err := oi.FragStatsByObjSize(size)
+	if err != nil {
+		t.Fatal("Failed to get FragStatsByObjSize: ", err)
+	}
+	memAfter, err := oi.MemStatsByObjSize(size)
+	if err != nil {
+		t.Fatal("Failed to get MemStatsByObjSize: ", err)
+	}
+	if usedAfter <= usedBefore || memAfter >= memBefore {
+		t.Fatalf("Expected compaction to use fewer slabs, used ratio went from %f to %f and memory from %d to %d",
+			usedBefore, usedAfter, memBefore, memAfter)
+	}
+
+	checkHandles(t, oi, handles)
+
+	addr, _ = oi.ResolveHandle(h)
+	if refCnt, err := oi.RefCnt(addr); err != nil || refCnt != 2 {
+		t.Fatalf("Expected reference count 2 after compaction, got %d: %v", refCnt, err)
+	}
+
+	// objects added after the compaction must not be corrupted by the slots freed by it
+	for i := 1000; i < 1500; i++ {
+		obj := []byte(fmt.Sprintf("%08d", i))
+		if handles[string(obj)], err = oi.AddOrGetHandle(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGetHandle: ", obj)
+		}
+	}
+	checkHandles(t, oi, handles)
+
+	// a compacted pool has nothing left to move
+	if moved, err := oi.Compact(size); err != nil || moved != 0 {
+		t.Fatalf("Expected nothing to move, got %d: %v", moved, err)
+	}
+}
+
+func TestRelocationMap(t *testing.T) {
+	testRelocationMap(t, false)
+}
+
+func TestRelocationMapCompressed(t *testing.T) {
+	testRelocationMap(t, true)
+}
+
+func testRelocationMap(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	if relocations := oi.RelocationMap(); len(relocations) != 0 {
+		t.Fatalf("Expected no relocations before compacting, got %d", len(relocations))
+	}
+
+	// the caller keeps its own metadata by raw address
+	external := make(map[uintptr]string)
+	addrs := make([]uintptr, 1000)
+	var size uint8
+	for i := range addrs {
+		obj := []byte(fmt.Sprintf("%08d", i))
+		addr, err := oi.AddOrGet(obj, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs[i] = addr
+		size = uint8(oi.SizeOf(obj))
+	}
+	// delete every other object to fragment the pool
+	for i, addr := range addrs {
+		if i%2 == 0 {
+			external[addr] = fmt.Sprintf("%08d", i)
+		} else if _, err := oi.Delete(addr); err != nil {
+			t.Fatal("Failed to Delete: ", err)
+		}
+	}
+
+	moved, err := oi.Compact(size)
+	if err != nil || moved == 0 {
+		t.Fatalf("Expected Compact to move objects, got %d: %v", moved, err)
+	}
+
+	relocations := oi.RelocationMap()
+	if len(relocations) != moved {
+		t.Fatalf("Expected %d relocations, got %d", moved, len(relocations))
+	}
+
+	// remap the external map in a single pass
+	remapped := make(map[uintptr]string, len(external))
+	for addr, obj := range external {
+		if newAddr, ok := relocations[addr]; ok {
+			addr = newAddr
+		}
+		if _, ok := remapped[addr]; ok {
+			t.Fatalf("Two objects were remapped to %d", addr)
+		}
+		remapped[addr] = obj
+	}
+
+	for addr, obj := range remapped {
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != obj {
+			t.Fatalf("Expected %q at %d, got %q: %v", obj, addr, str, err)
+		}
+		ptr, err := oi.GetPtrFromByte([]byte(obj))
+		if err != nil || ptr != addr {
+			t.Fatalf("Expected the index to find %q at %d, got %d: %v", obj, addr, ptr, err)
+		}
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Failed to Verify: ", err)
+	}
+
+	// the map is a copy, and the next compaction replaces it
+	relocations[0] = 1
+	if _, err := oi.Compact(size); err != nil {
+		t.Fatal("Failed to Compact: ", err)
+	}
+	if relocations := oi.RelocationMap(); len(relocations) != 0 {
+		t.Fatalf("Expected no relocations for a compacted pool, got %d", len(relocations))
+	}
+}
+
+func TestAutoCompact(t *testing.T) {
+	c := NewConfig()
+	c.HandlesOnly = true
+	c.AutoCompact = true
+	c.CompactInterval = 10 * time.Millisecond
+	c.CompactFragThreshold = 0.25
+	oi := NewObjectIntern(c)
+
+	// without compaction half of the slots of the pool would stay unused
+	handles, size := fragment(t, oi, 1000)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		used, err := oi.FragStatsByObjSize(size)
+		if err != nil {
+			t.Fatal("Failed to get FragStatsByObjSize: ", err)
+		}
+		if 1-used <= c.CompactFragThreshold {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Pool was not compacted, %f of its slots are unused", 1-used)
+		}
+		time.Sleep(c.CompactInterval)
+	}
+
+	checkHandles(t, oi, handles)
+
+	if err := oi.Close(); err != nil {
+		t.Fatal("Failed to Close: ", err)
+	}
+	select {
+	case <-oi.compactor.done:
+	default:
+		t.Fatal("Close did not stop the background compaction")
+	}
+}
+
+// TestCompactDuringAddOrGetHandle compacts the pool right after AddOrGetHandle has added a
+// new object. Logger is called once the write lock has been released, so it runs at the same
+// point as a background compaction could.
+func TestCompactDuringAddOrGetHandle(t *testing.T) {
+	c := NewConfig()
+	c.HandlesOnly = true
+	c.SlabSize = 4
+	var oi *ObjectIntern
+	var compacting bool
+	var first, added uintptr
+	c.Logger = func(event string, fields map[string]interface{}) {
+		if event != EventCreated || !compacting {
+			return
+		}
+		compacting = false
+		added = fields["addr"].(uintptr)
+
+		// the new object is the only one in the second slab, free a slot in the first one
+		// so the new object is moved into it
+		if _, err := oi.Delete(first); err != nil {
+			t.Error("Failed to Delete: ", err)
+		}
+		if _, err := oi.Compact(uint8(oi.SizeOf([]byte("00000000")))); err != nil {
+			t.Error("Failed to Compact: ", err)
+		}
+	}
+	oi = NewObjectIntern(c)
+
+	handles := make(map[string]Handle)
+	for i := 0; i <= int(c.SlabSize); i++ {
+		obj := fmt.Sprintf("%08d", i)
+		compacting = i == int(c.SlabSize)
+		h, err := oi.AddOrGetHandle([]byte(obj), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGetHandle: ", err)
+		}
+		if i == 0 {
+			first, _ = oi.ResolveHandle(h)
+			continue
+		}
+		handles[obj] = h
+	}
+
+	if _, ok := oi.RelocationMap()[added]; !ok {
+		t.Fatal("Expected the new object to be moved")
+	}
+	checkHandles(t, oi, handles)
+}
@@ -0,0 +1,749 @@
+package goi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/tmthrgd/shoco"
+)
+
+// shocoCompressInto compresses in with the model m the same way shoco.Compress does,
+// but appends the result to dst instead of allocating a new slice. It returns the
+// extended slice, which is only reallocated if dst is too small.
+//
+// shoco does not offer a way to compress into an existing buffer, so this mirrors the
+// non-proposed encoding of its compress function. The output must stay byte for byte
+// identical to shoco.Compress, since objects compressed either way share the index.
+func shocoCompressInto(m *shoco.Model, dst, in []byte) []byte {
+	var indices [8]int16
+
+	for len(in) != 0 {
+		// find the longest string of known successors
+		indices[0] = int16(m.ChrIdsByChr[in[0]])
+
+		if lastChrIndex := indices[0]; lastChrIndex >= 0 {
+			nConsecutive := 1
+			for ; nConsecutive <= m.MaxSuccessorN && nConsecutive < len(in); nConsecutive++ {
+				currentIndex := m.ChrIdsByChr[in[nConsecutive]]
+				if currentIndex < 0 { // '\0' is always -1
+					break
+				}
+
+				successorIndex := m.SuccessorIDsByChrIDAndChrID[lastChrIndex][currentIndex]
+				if successorIndex < 0 {
+					break
+				}
+
+				indices[nConsecutive] = int16(successorIndex)
+				lastChrIndex = int16(currentIndex)
+			}
+
+			if nConsecutive >= 2 {
+				if packN := shocoBestEncoding(m, &indices, nConsecutive); packN >= 0 {
+					pack := &m.Packs[packN]
+					code := pack.Word
+					for i := 0; i < pack.BytesUnpacked; i++ {
+						code |= uint32(indices[i]) << pack.Offsets[i]
+					}
+
+					var codeBuf [4]byte
+					binary.BigEndian.PutUint32(codeBuf[:], code)
+					dst = append(dst, codeBuf[:pack.BytesPacked]...)
+
+					in = in[pack.BytesUnpacked:]
+					continue
+				}
+			}
+		}
+
+		if in[0]&0x80 != 0 || in[0] == 0x00 { // non-ascii case or NUL char
+			// put in a sentinel byte
+			dst = append(dst, 0x00)
+		}
+
+		dst = append(dst, in[0])
+		in = in[1:]
+	}
+
+	return dst
+}
+
+// shocoBestEncoding returns the index of the largest pack of m which can encode the
+// first nConsecutive indices, or -1 if there is none
+func shocoBestEncoding(m *shoco.Model, indices *[8]int16, nConsecutive int) int {
+	for p := len(m.Packs) - 1; p >= 0; p-- {
+		pack := &m.Packs[p]
+		if nConsecutive < pack.BytesUnpacked {
+			continue
+		}
+
+		fits := true
+		for i := 0; i < pack.BytesUnpacked; i++ {
+			if indices[i] > pack.Masks[i] {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return p
+		}
+	}
+
+	return -1
+}
+
+// shocoDecompressInto decompresses in with the model m the same way shoco.Decompress does,
+// but appends the result to dst instead of allocating a new slice. It returns the extended
+// slice and nil, or dst and shoco.ErrInvalid if in is malformed.
+//
+// Just like shocoCompressInto this mirrors the non-proposed encoding of shoco.
+func shocoDecompressInto(m *shoco.Model, dst, in []byte) ([]byte, error) {
+	start := len(dst)
+
+	for len(in) != 0 {
+		mark := -1
+		for val := in[0]; val&0x80 != 0; val <<= 1 {
+			mark++
+		}
+
+		if mark < 0 {
+			if in[0] == 0x00 { // ignore the sentinel value for non-ascii chars
+				if len(in) < 2 {
+					return dst[:start], shoco.ErrInvalid
+				}
+
+				dst = append(dst, in[1])
+				in = in[2:]
+			} else {
+				dst = append(dst, in[0])
+				in = in[1:]
+			}
+
+			continue
+		}
+
+		if mark >= len(m.Packs) || m.Packs[mark].BytesPacked > len(in) {
+			return dst[:start], shoco.ErrInvalid
+		}
+		pack := &m.Packs[mark]
+
+		var codeBuf [4]byte
+		copy(codeBuf[:], in[:pack.BytesPacked])
+		code := binary.BigEndian.Uint32(codeBuf[:])
+
+		idx := (code >> pack.Offsets[0]) & uint32(pack.Masks[0])
+		if int(idx) >= len(m.ChrsByChrID) {
+			return dst[:start], shoco.ErrInvalid
+		}
+
+		lastChr := m.ChrsByChrID[idx]
+		dst = append(dst, lastChr)
+
+		for i := 1; i < pack.BytesUnpacked; i++ {
+			idx0, idx1 := lastChr-m.MinChr, (code>>pack.Offsets[i])&uint32(pack.Masks[i])
+			if int(idx0) >= len(m.ChrsByChrAndSuccessorID) ||
+				int(idx1) >= len(m.ChrsByChrAndSuccessorID[idx0]) {
+				return dst[:start], shoco.ErrInvalid
+			}
+
+			lastChr = m.ChrsByChrAndSuccessorID[idx0][idx1]
+			dst = append(dst, lastChr)
+		}
+
+		in = in[pack.BytesPacked:]
+	}
+
+	return dst, nil
+}
+
+// shocoTokenLen returns the length of the first token of in, which must be encoded with the
+// model m: an ASCII byte, a non-ASCII byte preceded by its sentinel, or a pack. It returns 0
+// if the token is malformed.
+func shocoTokenLen(m *shoco.Model, in []byte) int {
+	mark := -1
+	for val := in[0]; val&0x80 != 0; val <<= 1 {
+		mark++
+	}
+
+	var n int
+	switch {
+	case mark < 0 && in[0] == 0x00:
+		n = 2
+	case mark < 0:
+		n = 1
+	case mark < len(m.Packs):
+		n = m.Packs[mark].BytesPacked
+	}
+	if n > len(in) {
+		return 0
+	}
+	return n
+}
+
+// shocoDecompressTo decompresses in with the model m the same way shocoDecompressInto does,
+// but writes the result to w in chunks of at most 64 bytes, so the decompressed object is
+// never held in memory as a whole. It returns the number of bytes written and nil, or the
+// number of bytes written so far and an error. If in is malformed the error is shoco.ErrInvalid,
+// everything up to the malformed token has been written by then.
+func shocoDecompressTo(m *shoco.Model, w io.Writer, in []byte) (int64, error) {
+	var maxUnpacked int
+	for _, pack := range m.Packs {
+		if pack.BytesUnpacked > maxUnpacked {
+			maxUnpacked = pack.BytesUnpacked
+		}
+	}
+
+	var buf [64]byte
+	var written int64
+	for len(in) != 0 {
+		var err error
+
+		// decompress whole tokens as long as the chunk is guaranteed to have room for them
+		chunk := buf[:0]
+		for len(in) != 0 && len(chunk)+maxUnpacked <= len(buf) {
+			n := shocoTokenLen(m, in)
+			if n == 0 {
+				err = shoco.ErrInvalid
+				break
+			}
+			if chunk, err = shocoDecompressInto(m, chunk, in[:n]); err != nil {
+				break
+			}
+			in = in[n:]
+		}
+
+		if len(chunk) > 0 {
+			n, werr := w.Write(chunk)
+			written += int64(n)
+			if werr != nil {
+				return written, werr
+			}
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// DecompressStreamFromPtr writes the decompressed object stored at objAddr to w, in chunks
+// of at most 64 bytes, without ever holding the whole decompressed object in memory.
+// It returns the number of bytes written and nil. On failure it returns the number of bytes
+// written so far and an error. If compression is turned off the object is written as is.
+//
+// The stored object is copied while holding the read lock, so the lock is not held while
+// writing to w.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) DecompressStreamFromPtr(objAddr uintptr, w io.Writer) (int64, error) {
+	// stored objects are at most 255 bytes
+	var buf [255]byte
+
+	oi.RLock()
+	b, err := oi.store.Get(objAddr)
+	if err != nil {
+		oi.RUnlock()
+		return 0, err
+	}
+	// remove leading bytes for reference count
+	obj := append(buf[:0], b[oi.refCntSize:]...)
+	oi.RUnlock()
+
+	if oi.conf.Compression == None {
+		n, err := w.Write(obj)
+		return int64(n), err
+	}
+	return shocoDecompressTo(oi.model, w, obj)
+}
+
+// shocoCompressVerified does the same thing as shocoCompressInto, but checks that the
+// compressed object decompresses to in. If it doesn't, in is appended in the literal
+// encoding instead, which always decompresses correctly, so no object is ever corrupted
+// by an edge case of the model.
+func shocoCompressVerified(m *shoco.Model, dst, in []byte) []byte {
+	out := shocoCompressInto(m, dst, in)
+
+	// objects are at most 255 bytes, so this usually stays on the stack
+	var buf [512]byte
+	restored, err := shocoDecompressInto(m, buf[:0], out[len(dst):])
+	if err == nil && bytes.Equal(restored, in) {
+		return out
+	}
+	return appendShocoLiteral(out[:len(dst)], in)
+}
+
+// appendShocoLiteral appends in to dst in shoco's literal encoding, without compressing it,
+// and returns the extended slice. shoco.Decompress decodes it back to in. ASCII bytes other
+// than NUL are their own encoding, every other byte is preceded by a 0x00 sentinel byte, so
+// for ASCII input the literal encoding is the input itself.
+func appendShocoLiteral(dst, in []byte) []byte {
+	for _, c := range in {
+		if c&0x80 != 0 || c == 0x00 {
+			dst = append(dst, 0x00)
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// LikelyCompressible is the default CompressibilityFn. It is a cheap estimate of whether
+// shoco, whose model is trained on English words, can compress obj. It returns true if at
+// least half of the bytes of obj are lowercase ASCII letters and at least a quarter are
+// lowercase letters which are not hex digits. High entropy input like hex encoded hashes,
+// UUIDs or base64 fails the test, while words and typical metric names pass it.
+func LikelyCompressible(obj []byte) bool {
+	var lower, nonHex int
+	for _, c := range obj {
+		if c >= 'a' && c <= 'z' {
+			lower++
+			if c > 'f' {
+				nonHex++
+			}
+		}
+	}
+	return lower*2 >= len(obj) && nonHex*4 >= len(obj)
+}
+
+// AddOrGetBuf does the same thing as AddOrGet, but compresses obj into scratch instead of
+// allocating a new slice for every call. It returns the address of the object, the buffer
+// holding the compressed object and nil on success. The returned buffer may have been
+// regrown and should be passed in as scratch on the next call, so a tight loop of inserts
+// only allocates when an object is larger than every object before it.
+// On failure it returns 0, the buffer and an error.
+//
+// The object store and the index never reference scratch, so the caller is free to reuse
+// it as soon as this method returns. If compression is turned off this is the same as
+// AddOrGet and scratch is returned unused.
+func (oi *ObjectIntern) AddOrGetBuf(obj, scratch []byte, safe bool) (uintptr, []byte, error) {
+	if oi.conf.Compression == None {
+		addr, err := oi.AddOrGet(obj, safe)
+		return addr, scratch, err
+	}
+
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return 0, scratch, ErrEmptyObject
+	}
+
+	// obj is only read from, so there is no need to create a copy even if safe is set to true
+	scratch = oi.compressInto(scratch[:0], obj)
+
+	// acquire lock
+	oi.RLock()
+
+	addr, ok := oi.getAndIncrement(scratch)
+	if ok {
+		oi.RUnlock()
+		return addr, scratch, nil
+	}
+
+	oi.RUnlock()
+
+	oi.lock()
+
+	// re-check everything
+	addr, ok = oi.getAndIncrement(scratch)
+	if ok {
+		oi.Unlock()
+		return addr, scratch, nil
+	}
+
+	// add copies scratch into the object store
+	addr, err := oi.add(scratch)
+	if err != nil {
+		oi.Unlock()
+		return 0, scratch, err
+	}
+
+	oi.Unlock()
+	return addr, scratch, nil
+}
+
+// CompressionEstimate is the projected size of a set of objects when they are stored
+// with one type of compression
+type CompressionEstimate struct {
+	StoredBytes    int     // total length of the stored objects, without reference counts
+	Ratio          float64 // average ratio of stored length to original length
+	Incompressible int     // number of objects which are not stored in fewer bytes than their original length
+}
+
+// EstimateCompression compresses samples with every implemented type of compression, in
+// the same way as an ObjectIntern created with the default configuration and that type
+// of compression would, and returns the projected size of the stored objects per type.
+// It doesn't need an ObjectIntern, so it can be used to choose a configuration.
+//
+// Every sample is counted, including duplicates, which an ObjectIntern would only store
+// once. Empty samples are skipped, since they can't be interned.
+func EstimateCompression(samples [][]byte) map[Compression]CompressionEstimate {
+	estimates := make(map[Compression]CompressionEstimate, 2)
+
+	var none, sh CompressionEstimate
+	var buf []byte
+	var n int
+	for _, obj := range samples {
+		if len(obj) == 0 {
+			continue
+		}
+		n++
+
+		none.StoredBytes += len(obj)
+		none.Ratio++
+		none.Incompressible++
+
+		if LikelyCompressible(obj) {
+			buf = shocoCompressVerified(shoco.DefaultModel, buf[:0], obj)
+		} else {
+			buf = appendShocoLiteral(buf[:0], obj)
+		}
+		sh.StoredBytes += len(buf)
+		sh.Ratio += float64(len(buf)) / float64(len(obj))
+		if len(buf) >= len(obj) {
+			sh.Incompressible++
+		}
+	}
+
+	if n > 0 {
+		none.Ratio /= float64(n)
+		sh.Ratio /= float64(n)
+	}
+	estimates[None] = none
+	estimates[Shoco] = sh
+
+	return estimates
+}
+
+// Recompress switches oi to the compression newMode. Under the write lock it decompresses
+// every interned object, stores it again compressed with newMode, and moves its reference
+// count, namespace, deadline and handles over to the new copy, before it removes the old
+// one. It returns nil on success, including when newMode is already in use. If newMode is
+// not recognized or not implemented yet it returns an error and nothing is changed. While
+// any object is pinned by InternConstants it returns ErrPinned and nothing is changed.
+//
+// Every object moves to a new address, so all addresses and strings previously handed out
+// become invalid, just like after Reset. Handles stay valid. So do functions previously
+// returned by CompressionFunc or DecompressionFunc, but they keep using the old compression.
+//
+// This must not be called concurrently with other methods of oi, since many of them compress
+// their arguments before they acquire a lock. If storing an object fails, it returns the
+// error and oi is left with a partially converted index, so it should be Reset.
+func (oi *ObjectIntern) Recompress(newMode Compression) error {
+	if oi.Frozen() {
+		return ErrFrozen
+	}
+
+	if err := checkCompression(newMode); err != nil {
+		return err
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	if oi.closed() {
+		return ErrClosed
+	}
+	if newMode == oi.conf.Compression {
+		return nil
+	}
+	// pinned objects must keep their addresses, see InternConstants
+	if len(oi.pinned) > 0 {
+		return ErrPinned
+	}
+
+	type record struct {
+		addr     uintptr
+		ns       Namespace
+		refCnt   uint32
+		deadline time.Time
+		obj      []byte // decompressed copy
+	}
+
+	var err error
+	records := make([]record, 0, oi.indexLen()+len(oi.unindexed))
+	oi.indexRange(func(addr uintptr) bool {
+		var b []byte
+		b, err = oi.store.Get(addr)
+		if err != nil {
+			return false
+		}
+
+		r := record{
+			addr:     addr,
+			ns:       oi.namespaceOf(addr),
+			deadline: oi.deadlines[addr],
+		}
+		if !oi.conf.DisableRefCounting {
+			r.refCnt = atomic.LoadUint32((*uint32)(unsafe.Pointer(addr)))
+		}
+		r.obj, err = oi.decompressInto(nil, b[oi.refCntSize:])
+		if err != nil {
+			return false
+		}
+
+		records = append(records, r)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// the keys of the index alias the old objects, so the index has to be emptied
+	// BEFORE they are deleted
+	oi.emptyIndex()
+	oi.conf.Compression = newMode
+	oi.setCompression()
+
+	// every old object stays in the object store until all new copies have been added,
+	// so no new copy can reuse the address of an old object which still has handles
+	for _, r := range records {
+		obj := oi.compress(r.obj)
+
+		// copies of the same object which were not deduplicated due to MaxInternLen
+		// are merged if the new stored form is short enough to be indexed
+		if existing, ok := oi.indexGetNS(r.ns, obj); ok {
+			if !oi.conf.DisableRefCounting {
+				atomic.AddUint32((*uint32)(unsafe.Pointer(existing)), r.refCnt)
+			}
+			oi.handles.relocate(r.addr, existing)
+			continue
+		}
+
+		// add copies obj into the object store
+		addr, err := oi.addNS(r.ns, obj)
+		if err != nil {
+			return err
+		}
+		if !oi.conf.DisableRefCounting {
+			atomic.StoreUint32((*uint32)(unsafe.Pointer(addr)), r.refCnt)
+		}
+		if !r.deadline.IsZero() {
+			oi.deadlines[addr] = r.deadline
+		}
+		oi.handles.relocate(r.addr, addr)
+	}
+
+	for _, r := range records {
+		old, err := oi.store.Get(r.addr)
+		if err != nil {
+			return err
+		}
+		// zero the memory before handing it back, see remove
+		for i := range old {
+			old[i] = 0
+		}
+		if _, err := oi.storeDelete(r.addr, uint8(len(old))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CompressStrings returns a compressed version of every string in ins. Its indexes match the
+// indexes of ins, and every result is the same as the one returned by CompressString.
+//
+// Just like CompressBatch all strings are compressed into a single buffer, and the results
+// share a single string allocated from it, instead of allocating once per string. If
+// compression is turned off the returned strings are the ones in ins.
+func (oi *ObjectIntern) CompressStrings(ins []string) []string {
+	outs := make([]string, len(ins))
+	if oi.conf.Compression == None {
+		copy(outs, ins)
+		return outs
+	}
+
+	var size int
+	for _, in := range ins {
+		size += len(in)
+	}
+	buf := make([]byte, 0, size)
+	ends := make([]int, len(ins))
+	var scratch []byte
+	for idx, in := range ins {
+		// CompressibilityFn may keep or modify its input, so it never gets the string's memory
+		scratch = append(scratch[:0], in...)
+		buf = oi.compressInto(buf, scratch)
+		ends[idx] = len(buf)
+	}
+
+	return splitString(outs, string(buf), ends)
+}
+
+// DecompressStrings returns a decompressed version of every string in ins and nil. Its
+// indexes match the indexes of ins, and every result is the same as the one returned by
+// DecompressString. Upon failure it returns nil and an error identifying the first string
+// which could not be decompressed.
+//
+// Just like CompressStrings all strings are decompressed into a single buffer, and the
+// results share a single string allocated from it.
+func (oi *ObjectIntern) DecompressStrings(ins []string) ([]string, error) {
+	outs := make([]string, len(ins))
+	if oi.conf.Compression == None {
+		copy(outs, ins)
+		return outs, nil
+	}
+
+	var size int
+	for _, in := range ins {
+		size += len(in)
+	}
+	// compressed objects are usually smaller than the original ones
+	buf := make([]byte, 0, size*2)
+	ends := make([]int, len(ins))
+	var err error
+	for idx, in := range ins {
+		// decompressing only reads its input
+		buf, err = oi.decompressInto(buf, readOnlyBytes(in))
+		if err != nil {
+			return nil, fmt.Errorf("Could not decompress string %d: %s", idx, err)
+		}
+		ends[idx] = len(buf)
+	}
+
+	return splitString(outs, string(buf), ends), nil
+}
+
+// splitString sets outs[idx] to the part of s which ends at ends[idx] and starts at the end
+// of the previous part, and returns outs.
+func splitString(outs []string, s string, ends []int) []string {
+	var start int
+	for idx, end := range ends {
+		outs[idx] = s[start:end]
+		start = end
+	}
+	return outs
+}
+
+// WriteCompressedStrings compresses every string in ins the same way as CompressStrings and
+// writes it to w, preceded by its length as a uvarint, see binary.PutUvarint. This builds
+// dictionaries of compressed values offline, which ReadCompressedStrings loads again. The
+// format has no header, so they must be read with the same compression and ShocoModel.
+// It returns the number of bytes written and nil on success.
+// On failure it returns the number of bytes written so far and an error.
+func (oi *ObjectIntern) WriteCompressedStrings(w io.Writer, ins []string) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	var buf, scratch []byte
+	for _, in := range ins {
+		scratch = append(scratch[:0], in...)
+		buf = oi.compressInto(buf[:0], scratch)
+
+		n := binary.PutUvarint(lenBuf[:], uint64(len(buf)))
+		if _, err := bw.Write(lenBuf[:n]); err != nil {
+			return cw.n, err
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return cw.n, err
+		}
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ReadCompressedStrings reads strings written by WriteCompressedStrings from r until EOF,
+// and returns them decompressed and nil. Upon failure it returns the strings read so far
+// and an error. If r ends in the middle of a string the error is io.ErrUnexpectedEOF.
+func (oi *ObjectIntern) ReadCompressedStrings(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+
+	var outs []string
+	var buf bytes.Buffer
+	var out []byte
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return outs, nil
+		}
+		if err != nil {
+			return outs, err
+		}
+		if size > uint64(maxJoinSize) {
+			return outs, fmt.Errorf("String %d is too large: %d bytes", len(outs), size)
+		}
+		// the buffer only grows as data arrives, so a corrupted length can't allocate all memory
+		buf.Reset()
+		if _, err := io.CopyN(&buf, br, int64(size)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return outs, err
+		}
+
+		out, err = oi.decompressInto(out[:0], buf.Bytes())
+		if err != nil {
+			return outs, fmt.Errorf("Could not decompress string %d: %s", len(outs), err)
+		}
+		outs = append(outs, string(out))
+	}
+}
+
+// MarshalShocoModel serializes the shoco model m, for example one generated from the
+// caller's own objects, so it can be passed to NewObjectIntern as ShocoModel.
+func MarshalShocoModel(m *shoco.Model) ([]byte, error) {
+	if err := checkShocoModel(m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// shocoModelSum returns a hash of the shoco model m, which is the same for all models
+// which marshal to the same bytes.
+func shocoModelSum(m *shoco.Model) uint64 {
+	// every model which passed checkShocoModel can be marshaled
+	b, _ := json.Marshal(m)
+	return hashBytes(0, b)
+}
+
+// parseShocoModel returns the shoco model serialized by MarshalShocoModel in b and nil,
+// or nil and an error if b is not a valid model.
+func parseShocoModel(b []byte) (*shoco.Model, error) {
+	m := &shoco.Model{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("Invalid ShocoModel: %s", err)
+	}
+	if err := checkShocoModel(m); err != nil {
+		return nil, fmt.Errorf("Invalid ShocoModel: %s", err)
+	}
+	return m, nil
+}
+
+// checkShocoModel returns nil if m can be used to compress and decompress objects, otherwise
+// an error. It does the same checks as shoco, which panics on an invalid model, and also
+// checks that every character id is in range, so compressing can't index out of bounds.
+func checkShocoModel(m *shoco.Model) error {
+	if len(m.ChrsByChrID) == 0 || len(m.ChrsByChrAndSuccessorID) == 0 || len(m.Packs) == 0 {
+		return fmt.Errorf("model has no characters or packs")
+	}
+	if m.MaxSuccessorN > 7 {
+		return fmt.Errorf("MaxSuccessorN %d is larger than 7", m.MaxSuccessorN)
+	}
+	if len(m.SuccessorIDsByChrIDAndChrID) != len(m.ChrsByChrID) {
+		return fmt.Errorf("model has %d successor rows for %d characters", len(m.SuccessorIDsByChrIDAndChrID), len(m.ChrsByChrID))
+	}
+	for _, s := range m.SuccessorIDsByChrIDAndChrID {
+		if len(s) != len(m.ChrsByChrID) {
+			return fmt.Errorf("model has a successor row of %d ids for %d characters", len(s), len(m.ChrsByChrID))
+		}
+	}
+	for c, id := range m.ChrIdsByChr {
+		if int(id) >= len(m.ChrsByChrID) {
+			return fmt.Errorf("character %d has id %d out of range", c, id)
+		}
+	}
+	for i, p := range m.Packs {
+		if p.BytesPacked == 0 || p.BytesPacked > 4 || p.BytesPacked&(p.BytesPacked-1) != 0 ||
+			p.BytesUnpacked == 0 || p.BytesUnpacked > 8 || p.BytesUnpacked&(p.BytesUnpacked-1) != 0 {
+			return fmt.Errorf("pack %d packs %d bytes into %d", i, p.BytesUnpacked, p.BytesPacked)
+		}
+	}
+	return nil
+}
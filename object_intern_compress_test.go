@@ -0,0 +1,768 @@
+package goi
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/tmthrgd/shoco"
+)
+
+func TestShocoCompressInto(t *testing.T) {
+	inputs := append([][]byte{}, testBytes...)
+	inputs = append(inputs,
+		[]byte("embedded\x00nul"),
+		[]byte("\x00"),
+		[]byte("non-utf8 \xff\xfe\xc3"),
+		[]byte("ünïcödé"),
+		[]byte("the quick brown fox jumps over the lazy dog"),
+	)
+	for i := 0; i < 100; i++ {
+		inputs = append(inputs, []byte(randStringBytesMaskImprSrc(i)))
+	}
+
+	var buf []byte
+	for _, in := range inputs {
+		buf = shocoCompressInto(shoco.DefaultModel, buf[:0], in)
+		expected := shoco.Compress(in)
+		if !bytes.Equal(buf, expected) {
+			t.Fatalf("Compressing %q: expected %v, got %v", in, expected, buf)
+		}
+	}
+
+	// the result is appended to dst
+	buf = shocoCompressInto(shoco.DefaultModel, []byte("prefix"), testBytes[0])
+	if !bytes.Equal(buf, append([]byte("prefix"), shoco.Compress(testBytes[0])...)) {
+		t.Fatalf("Expected the compressed object to be appended to dst, got %v", buf)
+	}
+}
+
+func TestAddOrGetBuf(t *testing.T) {
+	testAddOrGetBuf(t, false)
+}
+
+func TestAddOrGetBufCompressed(t *testing.T) {
+	testAddOrGetBuf(t, true)
+}
+
+func testAddOrGetBuf(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	var scratch []byte
+	addrs := make([]uintptr, 0, len(testBytes))
+	for _, obj := range testBytes {
+		var addr uintptr
+		var err error
+		addr, scratch, err = oi.AddOrGetBuf(obj, scratch, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGetBuf: ", err)
+		}
+		addrs = append(addrs, addr)
+
+		// reusing the scratch buffer must not affect objects added before
+		for i := range scratch {
+			scratch[i] = 'X'
+		}
+	}
+
+	for idx, addr := range addrs {
+		str, err := oi.ObjString(addr)
+		if err != nil {
+			t.Fatal("Failed to get ObjString: ", err)
+		}
+		if str != testStrings[idx] {
+			t.Fatalf("Expected %q, got %q", testStrings[idx], str)
+		}
+
+		// objects added with AddOrGetBuf and AddOrGet must be the same
+		addr2, err := oi.AddOrGet(testBytes[idx], true)
+		if err != nil || addr2 != addr {
+			t.Fatalf("Expected AddOrGet to find %q at %d, got %d: %v", testBytes[idx], addr, addr2, err)
+		}
+		refCnt, err := oi.RefCnt(addr)
+		if err != nil || refCnt != 2 {
+			t.Fatalf("Expected reference count 2 for %q, got %d: %v", testBytes[idx], refCnt, err)
+		}
+	}
+
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Verify failed: ", err)
+	}
+
+	if _, _, err := oi.AddOrGetBuf(nil, scratch, true); err != ErrEmptyObject {
+		t.Fatalf("Expected ErrEmptyObject, got %v", err)
+	}
+}
+
+func BenchmarkAddOrGetBuf(b *testing.B) {
+	benchmarks := []struct {
+		name string
+		buf  bool
+	}{
+		{"AddOrGet", false},
+		{"AddOrGetBuf", true},
+	}
+	data := generateTestData(10000, 0.5)
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			cnf := NewConfig()
+			cnf.Compression = Shoco
+			oi := NewObjectIntern(cnf)
+
+			// intern everything once, so the benchmark measures the lookup of existing objects
+			for _, obj := range data {
+				if _, err := oi.AddOrGet(obj, true); err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
+				}
+			}
+
+			var scratch []byte
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				obj := data[i%len(data)]
+				if bm.buf {
+					globalPtr, scratch, _ = oi.AddOrGetBuf(obj, scratch, true)
+				} else {
+					globalPtr, _ = oi.AddOrGet(obj, true)
+				}
+			}
+		})
+	}
+}
+
+func TestLikelyCompressible(t *testing.T) {
+	for _, tc := range []struct {
+		obj      string
+		expected bool
+	}{
+		{"the quick brown fox jumps over the lazy dog", true},
+		{"servername1234", true},
+		{"metric", true},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", false},
+		{"d41d8cd98f00b204e9800998ecf8427e", false},
+		{"U29tZVJhbmRvbUJhc2U2NA==", false},
+		{"ünïcödé", false},
+	} {
+		if actual := LikelyCompressible([]byte(tc.obj)); actual != tc.expected {
+			t.Errorf("Expected LikelyCompressible(%q) to be %t", tc.obj, tc.expected)
+		}
+	}
+}
+
+func TestCompressibilityFn(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	oi := NewObjectIntern(cnf)
+
+	uuid := []byte("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	english := []byte("the quick brown fox jumps over the lazy dog")
+	binary := []byte("\x00\xff\xfe 6ba7b810")
+
+	for _, tc := range []struct {
+		obj        []byte
+		compressed bool
+	}{
+		{uuid, false},
+		{english, true},
+		{binary, false},
+	} {
+		addr, err := oi.AddOrGet(tc.obj, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+
+		stored, err := oi.store.Get(addr)
+		if err != nil {
+			t.Fatal("Failed to get object from store: ", err)
+		}
+		stored = stored[oi.refCntSize:]
+		if tc.compressed {
+			if !bytes.Equal(stored, shoco.Compress(tc.obj)) || len(stored) >= len(tc.obj) {
+				t.Fatalf("Expected %q to be compressed, stored %v", tc.obj, stored)
+			}
+		} else if !bytes.Equal(stored, appendShocoLiteral(nil, tc.obj)) {
+			t.Fatalf("Expected compression of %q to be skipped, stored %v", tc.obj, stored)
+		}
+
+		// objects are found and read the same way whether they have been compressed or not
+		found, err := oi.GetPtrFromByte(tc.obj)
+		if err != nil || found != addr {
+			t.Fatalf("Expected to find %q at %d, got %d: %v", tc.obj, addr, found, err)
+		}
+		b, err := oi.ObjBytes(addr)
+		if err != nil || !bytes.Equal(b, tc.obj) {
+			t.Fatalf("Expected %q, got %q: %v", tc.obj, b, err)
+		}
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != string(tc.obj) {
+			t.Fatalf("Expected %q, got %q: %v", tc.obj, str, err)
+		}
+	}
+
+	// the literal encoding of ASCII objects is the object itself
+	if !bytes.Equal(appendShocoLiteral(nil, uuid), uuid) {
+		t.Fatal("Literal encoding of an ASCII object differs from the object")
+	}
+
+	// without a CompressibilityFn everything is compressed
+	cnf.CompressibilityFn = nil
+	oi = NewObjectIntern(cnf)
+	if !bytes.Equal(oi.Compress(uuid), shoco.Compress(uuid)) {
+		t.Fatal("Expected every object to be compressed without a CompressibilityFn")
+	}
+}
+
+// brokenModel returns a copy of the default shoco model which decodes every successor
+// to 'x', so packed encodings don't decompress to their original input anymore
+func brokenModel() *shoco.Model {
+	m := shoco.DefaultModel
+	broken := &shoco.Model{
+		ChrsByChrID:                 m.ChrsByChrID,
+		ChrIdsByChr:                 m.ChrIdsByChr,
+		SuccessorIDsByChrIDAndChrID: m.SuccessorIDsByChrIDAndChrID,
+		Packs:                       m.Packs,
+		MinChr:                      m.MinChr,
+		MaxSuccessorN:               m.MaxSuccessorN,
+	}
+	for _, successors := range m.ChrsByChrAndSuccessorID {
+		broken.ChrsByChrAndSuccessorID = append(broken.ChrsByChrAndSuccessorID, bytes.Repeat([]byte("x"), len(successors)))
+	}
+	return broken
+}
+
+func TestShocoDecompressInto(t *testing.T) {
+	inputs := append([][]byte{}, testBytes...)
+	inputs = append(inputs, []byte("embedded\x00nul"), []byte("ünïcödé"))
+
+	var buf []byte
+	for _, in := range inputs {
+		var err error
+		buf, err = shocoDecompressInto(shoco.DefaultModel, buf[:0], shoco.Compress(in))
+		if err != nil || !bytes.Equal(buf, in) {
+			t.Fatalf("Expected %q, got %q: %v", in, buf, err)
+		}
+	}
+
+	for _, invalid := range [][]byte{{0x00}, {0xff}, {0xc0}} {
+		if _, err := shocoDecompressInto(shoco.DefaultModel, nil, invalid); err != shoco.ErrInvalid {
+			t.Fatalf("Expected ErrInvalid for %v, got %v", invalid, err)
+		}
+	}
+}
+
+// TestShocoDifferential compares shocoCompressInto and shocoDecompressInto against the
+// functions of the shoco package, using random input of up to 255 bytes
+func TestShocoDifferential(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1088))
+	alphabets := []string{
+		"abcdefghijklmnopqrstuvwxyz",
+		"abcdefghijklmnopqrstuvwxyz ._-0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+		"aeiouthnrs\x00\x7f\x80\xc3\xa9\xff",
+	}
+	random := func() []byte {
+		in := make([]byte, rnd.Intn(256))
+		if len(in) > 0 && rnd.Intn(4) == 0 {
+			in = make([]byte, 255)
+		}
+		switch alphabet := rnd.Intn(len(alphabets) + 1); alphabet {
+		case len(alphabets):
+			// any byte, including non-ASCII ones
+			rnd.Read(in)
+		default:
+			for i := range in {
+				in[i] = alphabets[alphabet][rnd.Intn(len(alphabets[alphabet]))]
+			}
+		}
+		return in
+	}
+
+	for _, m := range []*shoco.Model{shoco.WordsEnModel, shoco.FilePathModel} {
+		var comp, decomp []byte
+		for i := 0; i < 5000; i++ {
+			in := random()
+
+			expected := m.Compress(in)
+			comp = shocoCompressInto(m, comp[:0], in)
+			if !bytes.Equal(comp, expected) {
+				t.Fatalf("Compressing %q: expected %v, got %v", in, expected, comp)
+			}
+
+			var err error
+			decomp, err = shocoDecompressInto(m, decomp[:0], comp)
+			if err != nil || !bytes.Equal(decomp, in) {
+				t.Fatalf("Expected %q, got %q: %v", in, decomp, err)
+			}
+
+			// random input is mostly invalid, both have to agree on it
+			expected, expectedErr := m.Decompress(in)
+			decomp, err = shocoDecompressInto(m, decomp[:0], in)
+			if err != expectedErr || (err == nil && !bytes.Equal(decomp, expected)) {
+				t.Fatalf("Decompressing %v: expected %q and %v, got %q and %v", in, expected, expectedErr, decomp, err)
+			}
+		}
+	}
+}
+
+func TestShocoCompressVerified(t *testing.T) {
+	english := []byte("the quick brown fox jumps over the lazy dog")
+
+	// with a working model the result is the same as without verification
+	out := shocoCompressVerified(shoco.DefaultModel, nil, english)
+	if !bytes.Equal(out, shoco.Compress(english)) {
+		t.Fatalf("Expected the compressed object, got %v", out)
+	}
+
+	broken := brokenModel()
+	comp := shocoCompressInto(broken, nil, english)
+	if restored, err := shocoDecompressInto(broken, nil, comp); err == nil && bytes.Equal(restored, english) {
+		t.Fatal("The broken model should not round trip")
+	}
+
+	out = shocoCompressVerified(broken, []byte("prefix"), english)
+	if !bytes.Equal(out, append([]byte("prefix"), appendShocoLiteral(nil, english)...)) {
+		t.Fatalf("Expected a fallback to the literal encoding, got %v", out)
+	}
+	restored, err := shocoDecompressInto(broken, nil, out[len("prefix"):])
+	if err != nil || !bytes.Equal(restored, english) {
+		t.Fatalf("Expected %q, got %q: %v", english, restored, err)
+	}
+}
+
+func TestVerifyCompression(t *testing.T) {
+	defaultModel := shoco.DefaultModel
+	shoco.DefaultModel = brokenModel()
+	defer func() { shoco.DefaultModel = defaultModel }()
+
+	english := []byte("the quick brown fox jumps over the lazy dog")
+
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	oi := NewObjectIntern(cnf)
+
+	addr, err := oi.AddOrGet(english, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	str, err := oi.GetStringFromPtr(addr)
+	if err != nil || str != string(english) {
+		t.Fatalf("Expected %q, got %q: %v", english, str, err)
+	}
+	found, err := oi.GetPtrFromByte(english)
+	if err != nil || found != addr {
+		t.Fatalf("Expected to find %q at %d, got %d: %v", english, addr, found, err)
+	}
+
+	// without verification the object is silently corrupted
+	cnf.VerifyCompression = false
+	oi = NewObjectIntern(cnf)
+	addr, err = oi.AddOrGet(english, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if str, _ := oi.GetStringFromPtr(addr); str == string(english) {
+		t.Fatal("Expected the broken model to corrupt the object without verification")
+	}
+}
+
+func TestShocoModel(t *testing.T) {
+	paths := [][]byte{
+		[]byte("/usr/local/lib/python3.6/site-packages/setuptools/__init__.py"),
+		[]byte("/home/user/src/github.com/robert-milan/go-object-interning/object_intern.go"),
+		[]byte("/var/lib/docker/overlay2/l/6f3c/diff/etc/nginx/conf.d/default.conf"),
+		[]byte("/etc/systemd/system/multi-user.target.wants/sshd.service"),
+		[]byte("/usr/share/doc/libc6-dev/changelog.Debian.gz"),
+	}
+
+	model, err := MarshalShocoModel(shoco.FilePathModel)
+	if err != nil {
+		t.Fatal("Failed to MarshalShocoModel: ", err)
+	}
+
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	cnf.CompressibilityFn = nil
+	def := NewObjectIntern(cnf)
+	cnf.ShocoModel = model
+	oi := NewObjectIntern(cnf)
+
+	var defSize, size int
+	for _, path := range paths {
+		defSize += len(def.Compress(path))
+		size += len(oi.Compress(path))
+		if !bytes.Equal(oi.Compress(path), shoco.FilePathModel.Compress(path)) {
+			t.Fatalf("Expected %q to be compressed with the configured model", path)
+		}
+
+		addr, err := oi.AddOrGet(path, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != string(path) {
+			t.Fatalf("Expected %q, got %q: %v", path, str, err)
+		}
+		var w bytes.Buffer
+		if _, err := oi.DecompressStreamFromPtr(addr, &w); err != nil || !bytes.Equal(w.Bytes(), path) {
+			t.Fatalf("Expected to stream %q, got %q: %v", path, w.Bytes(), err)
+		}
+	}
+	if size >= defSize {
+		t.Fatalf("Expected the file path model to compress better than the default model, got %d and %d bytes", size, defSize)
+	}
+
+	invalid := [][]byte{
+		[]byte("not a model"),
+		[]byte("{}"),
+		[]byte(`{"ChrsByChrID":"YWI=","SuccessorIDsByChrIDAndChrID":[[0,1]],"ChrsByChrAndSuccessorID":["YQ=="],"Packs":[{"BytesPacked":1,"BytesUnpacked":2}]}`),
+	}
+	for _, b := range invalid {
+		cnf.ShocoModel = b
+		if _, err := NewObjectInternE(cnf); err == nil {
+			t.Fatalf("Expected an error for the invalid model %q", b)
+		}
+	}
+}
+
+// chunkWriter records the size of every write, and fails once it has received failAfter bytes
+type chunkWriter struct {
+	bytes.Buffer
+	chunks    []int
+	failAfter int
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if w.failAfter > 0 && w.Len()+len(p) > w.failAfter {
+		return 0, io.ErrShortWrite
+	}
+	w.chunks = append(w.chunks, len(p))
+	return w.Buffer.Write(p)
+}
+
+func TestDecompressStreamFromPtr(t *testing.T) {
+	testDecompressStreamFromPtr(t, false)
+}
+
+func TestDecompressStreamFromPtrCompressed(t *testing.T) {
+	testDecompressStreamFromPtr(t, true)
+}
+
+func testDecompressStreamFromPtr(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	long := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 5))
+	objs := append([][]byte{long, []byte("ünïcödé")}, testBytes...)
+	for _, obj := range objs {
+		addr, err := oi.AddOrGet(obj, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", obj)
+		}
+
+		var w chunkWriter
+		n, err := oi.DecompressStreamFromPtr(addr, &w)
+		if err != nil || n != int64(len(obj)) || !bytes.Equal(w.Bytes(), obj) {
+			t.Fatalf("Expected %q (%d bytes), got %q (%d bytes): %v", obj, len(obj), w.Bytes(), n, err)
+		}
+		if compress {
+			for _, chunk := range w.chunks {
+				if chunk > 64 {
+					t.Fatalf("Expected chunks of at most 64 bytes, got %v", w.chunks)
+				}
+			}
+		}
+	}
+
+	addr, err := oi.AddOrGet(long, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	w := chunkWriter{failAfter: 10}
+	if n, err := oi.DecompressStreamFromPtr(addr, &w); err != io.ErrShortWrite || n != int64(w.Len()) {
+		t.Fatalf("Expected io.ErrShortWrite after %d bytes, got %d bytes: %v", w.Len(), n, err)
+	}
+
+	if _, err := oi.DecompressStreamFromPtr(0, &w); err == nil {
+		t.Fatal("Expected an error for an address which is not in the store")
+	}
+}
+
+func TestShocoDecompressTo(t *testing.T) {
+	var w chunkWriter
+	if _, err := shocoDecompressTo(shoco.DefaultModel, &w, []byte{'a', 'b', 0x00}); err != shoco.ErrInvalid {
+		t.Fatalf("Expected ErrInvalid for a truncated sentinel, got %v", err)
+	}
+	if w.String() != "ab" {
+		t.Fatalf("Expected everything up to the malformed token to be written, got %q", w.String())
+	}
+
+	w.Reset()
+	if _, err := shocoDecompressTo(shoco.DefaultModel, &w, []byte{0xff}); err != shoco.ErrInvalid {
+		t.Fatalf("Expected ErrInvalid for an unknown pack, got %v", err)
+	}
+}
+
+func TestEstimateCompression(t *testing.T) {
+	estimates := EstimateCompression(testBytes)
+
+	none, ok := estimates[None]
+	if !ok {
+		t.Fatal("Expected an estimate for None")
+	}
+	sh, ok := estimates[Shoco]
+	if !ok {
+		t.Fatal("Expected an estimate for Shoco")
+	}
+
+	var total int
+	for _, b := range testBytes {
+		total += len(b)
+	}
+	if none.StoredBytes != total || none.Ratio != 1 || none.Incompressible != len(testBytes) {
+		t.Fatalf("Expected %d bytes, a ratio of 1 and %d incompressible objects without compression, got %+v", total, len(testBytes), none)
+	}
+
+	if sh.StoredBytes >= none.StoredBytes || sh.Ratio >= none.Ratio {
+		t.Fatalf("Expected shoco to beat no compression, got %+v and %+v", sh, none)
+	}
+
+	// the estimate must match what an ObjectIntern actually stores
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	oi := NewObjectIntern(cnf)
+	var stored int
+	for _, b := range testBytes {
+		stored += len(oi.Compress(b))
+	}
+	if sh.StoredBytes != stored {
+		t.Fatalf("Expected %d bytes with shoco, got %d", stored, sh.StoredBytes)
+	}
+
+	if estimates := EstimateCompression(nil); estimates[Shoco] != (CompressionEstimate{}) {
+		t.Fatalf("Expected an empty estimate for no samples, got %+v", estimates[Shoco])
+	}
+}
+
+func TestRecompress(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	if err := oi.Recompress(ShocoDict); err == nil {
+		t.Fatal("Expected an error for an unimplemented compression")
+	}
+
+	// every object is interned once, every other one twice
+	handles := make(map[string]Handle, len(testStrings))
+	for i, s := range testStrings {
+		h, err := oi.AddOrGetHandle([]byte(s), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGetHandle: ", err)
+		}
+		handles[s] = h
+		if i%2 == 0 {
+			if _, err := oi.AddOrGet([]byte(s), true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", err)
+			}
+		}
+	}
+	if _, err := oi.AddOrGetNS(1, []byte(testStrings[0]), true); err != nil {
+		t.Fatal("Failed to AddOrGetNS: ", err)
+	}
+	refCnts := make(map[string]uint32, len(testStrings))
+	for _, s := range testStrings {
+		addr, _ := oi.ResolveHandle(handles[s])
+		refCnts[s], _ = oi.RefCnt(addr)
+	}
+	count := oi.Count()
+
+	for _, mode := range []Compression{Shoco, Shoco, None} {
+		if err := oi.Recompress(mode); err != nil {
+			t.Fatalf("Failed to Recompress to %d: %v", mode, err)
+		}
+		if oi.Compression() != mode {
+			t.Fatalf("Expected compression %d, got %d", mode, oi.Compression())
+		}
+		if oi.Count() != count {
+			t.Fatalf("Expected %d objects, got %d", count, oi.Count())
+		}
+
+		for _, s := range testStrings {
+			addr, ok := oi.ResolveHandle(handles[s])
+			if !ok {
+				t.Fatalf("Expected the handle of %s to stay valid", s)
+			}
+			if str, err := oi.GetStringFromPtr(addr); err != nil || str != s {
+				t.Fatalf("Expected %s, got %s: %v", s, str, err)
+			}
+			if found, err := oi.GetPtrFromByte([]byte(s)); err != nil || found != addr {
+				t.Fatalf("Expected to find %s at %d, got %d: %v", s, addr, found, err)
+			}
+			if cnt, err := oi.RefCnt(addr); err != nil || cnt != refCnts[s] {
+				t.Fatalf("Expected a reference count of %d for %s, got %d: %v", refCnts[s], s, cnt, err)
+			}
+		}
+		if _, err := oi.GetPtrFromByteNS(1, []byte(testStrings[0])); err != nil {
+			t.Fatal("Expected to find the object in its namespace: ", err)
+		}
+	}
+
+	// the freed memory must be reusable without corrupting new objects
+	addr, err := oi.AddOrGet([]byte("recompressed"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if str, err := oi.GetStringFromPtr(addr); err != nil || str != "recompressed" {
+		t.Fatalf("Expected recompressed, got %s: %v", str, err)
+	}
+}
+
+func TestCompressBatch(t *testing.T) {
+	testCompressBatch(t, false)
+}
+
+func TestCompressBatchCompressed(t *testing.T) {
+	testCompressBatch(t, true)
+}
+
+func testCompressBatch(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	ins := append([][]byte{[]byte("0123456789abcdef"), []byte("caf\xc3\xa9")}, testBytes...)
+	compressed := oi.CompressBatch(ins)
+	if len(compressed) != len(ins) {
+		t.Fatalf("Expected %d compressed objects, got %d", len(ins), len(compressed))
+	}
+	for idx, in := range ins {
+		if expected := oi.Compress(in); !bytes.Equal(compressed[idx], expected) {
+			t.Fatalf("Expected %q for %q, got %q", expected, in, compressed[idx])
+		}
+	}
+
+	// appending to one of the results must not overwrite the next one
+	expected := append([]byte(nil), compressed[1]...)
+	_ = append(compressed[0], 'x')
+	if !bytes.Equal(compressed[1], expected) {
+		t.Fatalf("Expected %q, got %q", expected, compressed[1])
+	}
+
+	decompressed, errs := oi.DecompressBatch(compressed)
+	if len(decompressed) != len(ins) || len(errs) != len(ins) {
+		t.Fatalf("Expected %d decompressed objects and errors, got %d and %d", len(ins), len(decompressed), len(errs))
+	}
+	for idx, in := range ins {
+		if errs[idx] != nil || !bytes.Equal(decompressed[idx], in) {
+			t.Fatalf("Expected %q, got %q: %v", in, decompressed[idx], errs[idx])
+		}
+	}
+
+	if !compress {
+		return
+	}
+
+	// a truncated sentinel is invalid, the other objects must still be decompressed
+	decompressed, errs = oi.DecompressBatch([][]byte{compressed[0], {0x00}, compressed[2]})
+	if _, err := oi.Decompress([]byte{0x00}); errs[1] != err || decompressed[1] != nil {
+		t.Fatalf("Expected %v for the invalid object, got %q: %v", err, decompressed[1], errs[1])
+	}
+	for _, idx := range []int{0, 2} {
+		if errs[idx] != nil || !bytes.Equal(decompressed[idx], ins[idx]) {
+			t.Fatalf("Expected %q, got %q: %v", ins[idx], decompressed[idx], errs[idx])
+		}
+	}
+}
+
+func TestCompressStrings(t *testing.T) {
+	testCompressStrings(t, false)
+}
+
+func TestCompressStringsCompressed(t *testing.T) {
+	testCompressStrings(t, true)
+}
+
+func testCompressStrings(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	ins := append([]string{""}, testStrings...)
+	compressed := oi.CompressStrings(ins)
+	if len(compressed) != len(ins) {
+		t.Fatalf("Expected %d compressed strings, got %d", len(ins), len(compressed))
+	}
+	for idx, in := range ins {
+		if expected := oi.CompressString(in); compressed[idx] != expected {
+			t.Fatalf("Expected %q, got %q", expected, compressed[idx])
+		}
+	}
+
+	decompressed, err := oi.DecompressStrings(compressed)
+	if err != nil {
+		t.Fatal("Failed to DecompressStrings: ", err)
+	}
+	for idx, in := range ins {
+		if decompressed[idx] != in {
+			t.Fatalf("Expected %q, got %q", in, decompressed[idx])
+		}
+	}
+
+	// the streamed format reloads into the same strings
+	var buf bytes.Buffer
+	n, err := oi.WriteCompressedStrings(&buf, ins)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("Expected %d bytes written, got %d: %v", buf.Len(), n, err)
+	}
+	stream := buf.Bytes()
+	reloaded, err := oi.ReadCompressedStrings(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal("Failed to ReadCompressedStrings: ", err)
+	}
+	if len(reloaded) != len(ins) {
+		t.Fatalf("Expected %d strings, got %d", len(ins), len(reloaded))
+	}
+	for idx, in := range ins {
+		if reloaded[idx] != in {
+			t.Fatalf("Expected %q, got %q", in, reloaded[idx])
+		}
+	}
+
+	// a failing writer stops the stream, the count only includes the bytes it accepted
+	large := make([]string, 0, 1000)
+	for i := 0; i < cap(large); i++ {
+		large = append(large, ins[i%len(ins)])
+	}
+	w := &chunkWriter{failAfter: 1}
+	if n, err := oi.WriteCompressedStrings(w, large); err != io.ErrShortWrite || n != int64(w.Len()) {
+		t.Fatalf("Expected io.ErrShortWrite after %d bytes, got %d: %v", w.Len(), n, err)
+	}
+
+	// a stream ending in the middle of a string keeps the strings read before it
+	reloaded, err = oi.ReadCompressedStrings(bytes.NewReader(stream[:len(stream)-1]))
+	if err != io.ErrUnexpectedEOF || len(reloaded) != len(ins)-1 {
+		t.Fatalf("Expected %d strings and io.ErrUnexpectedEOF, got %d: %v", len(ins)-1, len(reloaded), err)
+	}
+
+	if !compress {
+		return
+	}
+
+	// a truncated sentinel is invalid
+	if _, err := oi.DecompressStrings([]string{compressed[1], "\x00"}); err == nil {
+		t.Fatal("Expected an error decompressing an invalid string")
+	}
+}
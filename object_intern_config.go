@@ -1,5 +1,9 @@
 package goi
 
+import (
+	"time"
+)
+
 type Compression uint8
 
 // Types of compression
@@ -14,11 +18,159 @@ var Config = NewConfig()
 
 // ObjectInternConfig holds a configuration to use when creating a new ObjectIntern.
 // Currently, Index and MaxIndexSize don't do anything.
+//
+// CacheSize is the number of decompressed objects to keep in a read-through
+// cache when compression is turned on. A value of 0 disables the cache.
+//
+// StringCacheSize is the number of strings returned by GetStringFromPtr to keep
+// in a cache when compression is turned on, each of them for CacheTTL. The cache
+// is only enabled if CacheTTL is larger than 0.
+//
+// NewStore is called with SlabSize to create the backend store, both on
+// creation and on Reset. If it is nil the default NewGosStore is used.
+//
+// FragThreshold is the ratio of unused object slots in a pool above which
+// FragReport recommends compacting that pool.
+//
+// Normalize, if set, is applied to every object before it is added or looked up,
+// so objects which normalize to the same value share a single interned object.
+// It must not modify its input in place.
+//
+// TrimSpace removes leading and trailing white space from every object before it is
+// added or looked up, after Normalize has been applied, so "server " and "server" share
+// a single interned object which is stored without the white space.
+//
+// HashedIndex replaces the default index, whose string keys alias the interned
+// data, with an index keyed by a 64 bit hash of every object. It does not
+// reference memory of the object store, but every lookup needs to compare the
+// stored object to resolve hash collisions.
+//
+// ReverseIndex keeps a copy of the index key of every indexed object, mapped by its address.
+// Deleting an object then looks up its key by address instead of deriving it from the object
+// store, and the check that an object is still interned is a single lookup. It costs a copy
+// of every indexed object.
+//
+// DisableRefCounting stores objects without the 4 byte reference count in front of
+// them, which saves memory for sets of objects that are never deleted. All methods
+// which modify or read reference counts, including every Delete method, then return
+// ErrNoRefCounting or do nothing. It is turned off by default, so reference counting
+// is kept by configs which don't set it, including ones which don't use NewConfig.
+//
+// LockStats records how long AddOrGet and the Delete methods wait for the write
+// lock, see the LockStats method. It adds a call to time.Now before and after
+// acquiring the write lock, if it is turned off the overhead is a single check.
+//
+// MaxInternLen, if larger than 0, is the maximum length of the stored form of an
+// object (compressed if compression is turned on, without the reference count) to
+// deduplicate. Longer objects are still stored, but they are not indexed. Adding
+// the same long object twice stores it twice, and every copy is removed on its
+// first delete. Lookups by value, such as GetPtrFromByte, DeleteByByte or
+// IncRefCntByString, never find them. Their addresses work like any other.
+//
+// CompressibilityFn, if set, is called with every object before it is compressed,
+// including objects which are only looked up. If it returns false compressing the
+// object is skipped, and the object is stored in shoco's literal encoding instead,
+// which for ASCII objects is the object itself. It must be deterministic, otherwise
+// the same object may be stored twice. The default is LikelyCompressible.
+//
+// VerifyCompression checks that every compressed object decompresses to the
+// original object. If it doesn't, the object is stored in shoco's literal
+// encoding instead, just like objects rejected by CompressibilityFn, so an edge
+// case of the compression model can never corrupt an object. It costs one
+// decompression per compression, but no allocations.
+//
+// OnEvict, if set, is called whenever one of the Delete methods drops the final
+// reference of an object and removes it, or Expire removes an expired object,
+// exactly once per removed object. It gets
+// the former address of the object and a decompressed copy of its data. It is
+// called after the write lock has been released, so it may use the ObjectIntern,
+// but by then the address may already have been reused for a new object. It is
+// not called for objects removed by Reset, ResetAndTrim, DeleteAll, Close or ReplaceValue.
+//
+// InitialIndexCapacity is the number of objects the index has room for when it is
+// created, both on creation and on Reset. Setting it to the expected number of
+// objects avoids growing the index over and over while it is filled up.
+//
+// HandlesOnly declares that interned objects are only ever referenced through the
+// Handles returned by AddOrGetHandle, never by their address. It doesn't change the
+// behavior of any method, but it is required by AutoCompact.
+//
+// AutoCompact starts a goroutine which checks the fragmentation of the object store
+// every CompactInterval, and calls Compact for every pool in which the ratio of unused
+// object slots exceeds CompactFragThreshold. Compaction moves objects to different
+// addresses, so it requires HandlesOnly. The goroutine is stopped by Close.
+//
+// IgnoreMissingOnDelete makes Delete, DeleteByByte and DeleteByString return false
+// and nil instead of an error when the object to delete can't be found, so objects
+// can be deleted speculatively. Other errors, such as ErrNoRefCounting, are still returned.
+//
+// SafeMode makes ObjBytes, ObjString and GetStringFromPtrChecked look up every object they
+// read in the index, and return ErrCorruptObject if there is no interned object at its
+// address. This catches stale addresses of removed objects as long as their memory is still
+// mapped and hasn't been reused for a new object. It costs one index lookup per read.
+//
+// Logger, if set, is called with structured events about objects being created and freed,
+// slabs being deleted and Reset being called, see EventCreated and the other events. Events
+// are collected while the write lock is held and passed to Logger once it has been released,
+// so Logger may use the ObjectIntern. If it is nil logging costs a single check.
+//
+// HashSeed is mixed into the hash of every object used by the HashedIndex, so keys which
+// are crafted to collide for one seed don't collide for another. If it is 0, a random seed
+// is chosen when the ObjectIntern is created. It is not a cryptographic hash, but it keeps
+// colliding keys from being precomputed.
+//
+// PrewarmSizes lists object sizes whose pools are filled with PrewarmSlabs slabs when the
+// ObjectIntern is created and on Reset, so the first objects of these sizes don't pay for
+// mapping a slab. A size is the length of the stored form of an object including its
+// reference count, as reported by ObjectsInPool. Every prewarmed slab keeps one slot taken
+// by a placeholder, which is not counted by Count, until Reset, ResetAndTrim or Close.
+//
+// GrowSlabs, if set, is called whenever the object store allocates a slab because the pool of
+// objects of objSize bytes is full. slabs is the number of slabs of the pool, including the new
+// one. It returns how many additional slabs to map right away, so a burst of inserts doesn't
+// hit a full pool over and over, see GrowDoubling. Just like prewarmed slabs, the new slab and
+// the additional slabs keep a placeholder until Reset, ResetAndTrim or Close, so they stay
+// mapped while the pool drains and are reused by the next burst instead of being mapped again.
+// This keeps every pool at its peak size.
+//
+// ShocoModel, if set, is a shoco model serialized by MarshalShocoModel, which is used instead
+// of shoco's default model, trained on English words, to compress and decompress objects if
+// Compression is Shoco. A model trained on the caller's own objects usually compresses them
+// much better. Objects compressed with one model can't be read with another, so it must stay
+// the same for the lifetime of the ObjectIntern, including snapshots it restores.
 type ObjectInternConfig struct {
-	Compression  Compression
-	Index        bool
-	MaxIndexSize uint32
-	SlabSize     uint
+	Compression           Compression
+	Index                 bool
+	MaxIndexSize          uint32
+	SlabSize              uint
+	CacheSize             int
+	StringCacheSize       int
+	CacheTTL              time.Duration
+	NewStore              func(slabSize uint) Store
+	FragThreshold         float32
+	Normalize             func(obj []byte) []byte
+	TrimSpace             bool
+	HashedIndex           bool
+	ReverseIndex          bool
+	DisableRefCounting    bool
+	LockStats             bool
+	MaxInternLen          int
+	CompressibilityFn     func(obj []byte) bool
+	VerifyCompression     bool
+	OnEvict               func(addr uintptr, data []byte)
+	InitialIndexCapacity  int
+	HandlesOnly           bool
+	AutoCompact           bool
+	CompactInterval       time.Duration
+	CompactFragThreshold  float32
+	IgnoreMissingOnDelete bool
+	SafeMode              bool
+	Logger                func(event string, fields map[string]interface{})
+	HashSeed              uint64
+	PrewarmSizes          []uint8
+	PrewarmSlabs          int
+	GrowSlabs             func(objSize uint8, slabs int) int
+	ShocoModel            []byte
 }
 
 // NewConfig returns a new configuration with default settings
@@ -26,11 +178,65 @@ type ObjectInternConfig struct {
 // Compression: 	None,
 // Index:			true,
 // MaxCacheSize: 	157286400,
+// CacheSize:		0,
+// StringCacheSize:	1024,
+// CacheTTL:		0,
+// NewStore:		NewGosStore,
+// FragThreshold:	0.5,
+// TrimSpace:		false,
+// HashedIndex:		false,
+// ReverseIndex:	false,
+// DisableRefCounting:	false,
+// LockStats:		false,
+// MaxInternLen:	0,
+// CompressibilityFn:	LikelyCompressible,
+// VerifyCompression:	true,
+// OnEvict:		nil,
+// InitialIndexCapacity:	0,
+// HandlesOnly:		false,
+// AutoCompact:		false,
+// CompactInterval:	time.Minute,
+// CompactFragThreshold:	0.5,
+// IgnoreMissingOnDelete:	false,
+// SafeMode:		false,
+// Logger:		nil,
+// HashSeed:		0,
+// PrewarmSizes:	nil,
+// PrewarmSlabs:	1,
+// GrowSlabs:		nil,
+// ShocoModel:		nil,
 func NewConfig() ObjectInternConfig {
 	return ObjectInternConfig{
-		Compression:  None,
-		Index:        true,
-		MaxIndexSize: 157286400, // 150 MiB
-		SlabSize:     100,
+		Compression:           None,
+		Index:                 true,
+		MaxIndexSize:          157286400, // 150 MiB
+		SlabSize:              100,
+		CacheSize:             0,
+		StringCacheSize:       1024,
+		CacheTTL:              0,
+		NewStore:              NewGosStore,
+		FragThreshold:         0.5,
+		TrimSpace:             false,
+		HashedIndex:           false,
+		ReverseIndex:          false,
+		DisableRefCounting:    false,
+		LockStats:             false,
+		MaxInternLen:          0,
+		CompressibilityFn:     LikelyCompressible,
+		VerifyCompression:     true,
+		OnEvict:               nil,
+		InitialIndexCapacity:  0,
+		HandlesOnly:           false,
+		AutoCompact:           false,
+		CompactInterval:       time.Minute,
+		CompactFragThreshold:  0.5,
+		IgnoreMissingOnDelete: false,
+		SafeMode:              false,
+		Logger:                nil,
+		HashSeed:              0,
+		PrewarmSizes:          nil,
+		PrewarmSlabs:          1,
+		GrowSlabs:             nil,
+		ShocoModel:            nil,
 	}
 }
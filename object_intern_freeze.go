@@ -0,0 +1,72 @@
+package goi
+
+import (
+	"errors"
+	"runtime"
+	"sync/atomic"
+)
+
+// ErrFrozen is returned by methods which would modify an ObjectIntern after Freeze has been called.
+var ErrFrozen = errors.New("ObjectIntern is frozen")
+
+// Freeze makes oi read-only, for serving a dictionary which has been built up front. Afterwards
+// every method which would add, delete or move objects, or change reference counts, returns
+// ErrFrozen instead, including AddOrGet and its variants, the Delete methods, IncRefCnt,
+// DecRefCntBy, SwapRefCnt, ReplaceValue, Compact, Recompress, Reset, ResetAndTrim, DeleteAll,
+// ReadFrom and Merge. Methods without an error result, such as DeleteBatch, IncRefCntBatch,
+// Expire or Reserve, do nothing. AutoCompact stops compacting. A frozen ObjectIntern can't be
+// unfrozen.
+//
+// Since the objects can't change anymore, GetStringFromPtr, ObjBytes, RefCnt and JoinStrings
+// read them without acquiring the read lock. Close waits for these reads to finish before it
+// releases the object store, reads which start afterwards return ErrClosed.
+//
+// Freeze waits for the write lock, but mutations must be finished before it is called. A
+// mutation running concurrently with Freeze may or may not be rejected.
+func (oi *ObjectIntern) Freeze() {
+	oi.Lock()
+	atomic.StoreUint32(&oi.frozen, 1)
+	oi.Unlock()
+}
+
+// Frozen returns true if Freeze has been called.
+func (oi *ObjectIntern) Frozen() bool {
+	return atomic.LoadUint32(&oi.frozen) == 1
+}
+
+// readLock acquires the read lock unless oi is frozen, and returns true if it did. Otherwise
+// the reader is counted in frozenReaders, so Close can wait for it, see waitReaders.
+// The result must be passed to readUnlock.
+func (oi *ObjectIntern) readLock() bool {
+	if oi.Frozen() {
+		atomic.AddInt64(&oi.frozenReaders, 1)
+		if atomic.LoadUint32(&oi.closing) == 0 {
+			return false
+		}
+		// Close is waiting for the readers to finish, wait for Close with the read lock instead
+		atomic.AddInt64(&oi.frozenReaders, -1)
+	}
+	oi.RLock()
+	return true
+}
+
+// readUnlock releases the read lock if locked, as returned by readLock, is true.
+// Otherwise it stops counting the reader.
+func (oi *ObjectIntern) readUnlock(locked bool) {
+	if locked {
+		oi.RUnlock()
+		return
+	}
+	atomic.AddInt64(&oi.frozenReaders, -1)
+}
+
+// waitReaders makes every following readLock acquire the read lock, and waits for the
+// readers which read without it to finish. Afterwards the object store can be released.
+//
+// The caller is responsible for holding the write lock.
+func (oi *ObjectIntern) waitReaders() {
+	atomic.StoreUint32(&oi.closing, 1)
+	for atomic.LoadInt64(&oi.frozenReaders) != 0 {
+		runtime.Gosched()
+	}
+}
@@ -0,0 +1,196 @@
+package goi
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFreeze(t *testing.T) {
+	testFreeze(t, false)
+}
+
+func TestFreezeCompressed(t *testing.T) {
+	testFreeze(t, true)
+}
+
+func testFreeze(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	addrs := make([]uintptr, 0, len(testBytes))
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	joined, err := oi.JoinStrings(addrs, ".")
+	if err != nil {
+		t.Fatal("Failed to JoinStrings: ", err)
+	}
+
+	if oi.Frozen() {
+		t.Fatal("Expected a new ObjectIntern not to be frozen")
+	}
+	oi.Freeze()
+	if !oi.Frozen() {
+		t.Fatal("Expected the ObjectIntern to be frozen")
+	}
+
+	// adding, even objects which are already interned, and deleting fails
+	if _, err := oi.AddOrGet(testBytes[0], true); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from AddOrGet, got %v", err)
+	}
+	if _, err := oi.AddOrGet([]byte("not interned"), true); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from AddOrGet, got %v", err)
+	}
+	if _, err := oi.AddOrGetFromString(testStrings[0], true); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from AddOrGetFromString, got %v", err)
+	}
+	if _, err := oi.AddOrGetNS(Namespace(1), testBytes[0], true); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from AddOrGetNS, got %v", err)
+	}
+	if _, err := oi.AddOrGetWithTTL(testBytes[0], time.Minute, true); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from AddOrGetWithTTL, got %v", err)
+	}
+	if _, err := oi.Delete(addrs[0]); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from Delete, got %v", err)
+	}
+	if _, err := oi.DeleteByByte(testBytes[0]); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from DeleteByByte, got %v", err)
+	}
+	if _, err := oi.DeleteByString(testStrings[0]); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from DeleteByString, got %v", err)
+	}
+	if _, err := oi.IncRefCnt(addrs[0]); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from IncRefCnt, got %v", err)
+	}
+	if _, err := oi.DecRefCntBy(addrs[0], 1); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from DecRefCntBy, got %v", err)
+	}
+	if err := oi.SwapRefCnt(addrs[0], addrs[1]); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from SwapRefCnt, got %v", err)
+	}
+	if _, err := oi.ReplaceValue(addrs[0], []byte("replaced")); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from ReplaceValue, got %v", err)
+	}
+	if _, err := oi.Compact(uint8(oi.SizeOf(testBytes[0]))); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from Compact, got %v", err)
+	}
+	if err := oi.Reset(); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from Reset, got %v", err)
+	}
+	if err := oi.ResetAndTrim(); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from ResetAndTrim, got %v", err)
+	}
+	if _, err := oi.DeleteAll(); err != ErrFrozen {
+		t.Fatalf("Expected ErrFrozen from DeleteAll, got %v", err)
+	}
+	// methods without an error do nothing
+	oi.DeleteBatch(append([]uintptr(nil), addrs...))
+	oi.IncRefCntBatch(addrs)
+
+	// reads still work and nothing has changed
+	if oi.Count() != len(testBytes) {
+		t.Fatalf("Expected %d objects, got %d", len(testBytes), oi.Count())
+	}
+	for i, addr := range addrs {
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != testStrings[i] {
+			t.Fatalf("Expected %q, got %q: %v", testStrings[i], str, err)
+		}
+		b, err := oi.ObjBytes(addr)
+		if err != nil || !bytes.Equal(b, testBytes[i]) {
+			t.Fatalf("Expected %q, got %q: %v", testBytes[i], b, err)
+		}
+		if cnt, err := oi.RefCnt(addr); err != nil || cnt != 1 {
+			t.Fatalf("Expected a reference count of 1, got %d: %v", cnt, err)
+		}
+		if found, err := oi.GetPtrFromByte(testBytes[i]); err != nil || found != addr {
+			t.Fatalf("Expected to find %q at %d, got %d: %v", testBytes[i], addr, found, err)
+		}
+	}
+	if str, err := oi.JoinStrings(addrs, "."); err != nil || str != joined {
+		t.Fatalf("Expected %q, got %q: %v", joined, str, err)
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Failed to Verify: ", err)
+	}
+
+	// reads don't take the lock, so they don't wait for a writer holding it
+	oi.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j, addr := range addrs {
+				if str, err := oi.GetStringFromPtr(addr); err != nil || str != testStrings[j] {
+					t.Errorf("Expected %q, got %q: %v", testStrings[j], str, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	oi.Unlock()
+
+	// a frozen ObjectIntern can still be closed
+	if err := oi.Close(); err != nil {
+		t.Fatal("Failed to Close: ", err)
+	}
+}
+
+// TestFreezeClose closes a frozen ObjectIntern while readers, which don't hold the read lock,
+// are still running. Every read must either return the object or ErrClosed.
+func TestFreezeClose(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	addrs := make([]uintptr, 0, len(testBytes))
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	oi.Freeze()
+
+	var wg sync.WaitGroup
+	started := make(chan struct{}, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			started <- struct{}{}
+			for {
+				for j, addr := range addrs {
+					str, err := oi.GetStringFromPtr(addr)
+					if err == ErrClosed {
+						return
+					}
+					if err != nil || str != testStrings[j] {
+						t.Errorf("Expected %q, got %q: %v", testStrings[j], str, err)
+						return
+					}
+					if _, err := oi.ObjBytes(addr); err == ErrClosed {
+						return
+					}
+				}
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		<-started
+	}
+
+	if err := oi.Close(); err != nil {
+		t.Fatal("Failed to Close: ", err)
+	}
+	wg.Wait()
+}
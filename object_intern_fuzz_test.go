@@ -0,0 +1,138 @@
+//go:build go1.18
+// +build go1.18
+
+package goi
+
+import (
+	"bytes"
+	"testing"
+)
+
+// maxFuzzObjSize is the largest object the object store accepts, minus 4 bytes for the reference count
+const maxFuzzObjSize = 255 - 4
+
+func addFuzzSeeds(f *testing.F) {
+	for _, b := range testBytes {
+		f.Add(b)
+	}
+	f.Add([]byte("embedded\x00nul"))
+	f.Add([]byte("\x00"))
+	f.Add([]byte("non-utf8 \xff\xfe\xc3"))
+	f.Add([]byte("ünïcödé"))
+}
+
+func FuzzCompressRoundTrip(f *testing.F) {
+	addFuzzSeeds(f)
+
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	oi := NewObjectIntern(cnf)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		in := append([]byte{}, data...)
+
+		comp := oi.Compress(data)
+		if !bytes.Equal(data, in) {
+			t.Fatal("Compress modified its input")
+		}
+
+		out, err := oi.Decompress(comp)
+		if err != nil {
+			t.Fatalf("Failed to decompress %q: %v", data, err)
+		}
+		if !bytes.Equal(out, data) {
+			t.Fatalf("Round trip of %q returned %q", data, out)
+		}
+
+		str, err := oi.DecompressString(oi.CompressString(string(data)))
+		if err != nil {
+			t.Fatalf("Failed to decompress string %q: %v", data, err)
+		}
+		if str != string(data) {
+			t.Fatalf("String round trip of %q returned %q", data, str)
+		}
+	})
+}
+
+func FuzzCompressInto(f *testing.F) {
+	addFuzzSeeds(f)
+
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	oi := NewObjectIntern(cnf)
+
+	var buf []byte
+	f.Fuzz(func(t *testing.T, data []byte) {
+		buf = oi.compressInto(buf[:0], data)
+		if expected := oi.Compress(data); !bytes.Equal(buf, expected) {
+			t.Fatalf("Compressing %q into a buffer returned %v instead of %v", data, buf, expected)
+		}
+	})
+}
+
+func FuzzAddOrGetDelete(f *testing.F) {
+	addFuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		fuzzAddOrGetDelete(t, data, false)
+		fuzzAddOrGetDelete(t, data, true)
+	})
+}
+
+func fuzzAddOrGetDelete(t *testing.T, data []byte, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	if len(data) == 0 {
+		if _, err := oi.AddOrGet(data, true); err != ErrEmptyObject {
+			t.Fatalf("Expected ErrEmptyObject for an empty object, instead got %v", err)
+		}
+		return
+	}
+
+	// the object store only accepts objects of a limited size
+	if len(oi.Compress(data)) > maxFuzzObjSize {
+		t.Skip()
+	}
+
+	addr, err := oi.AddOrGet(data, true)
+	if err != nil {
+		t.Fatalf("Failed to AddOrGet %q: %v", data, err)
+	}
+	addr2, err := oi.AddOrGet(data, true)
+	if err != nil {
+		t.Fatalf("Failed to AddOrGet %q: %v", data, err)
+	}
+	if addr != addr2 {
+		t.Fatalf("Expected the same address for %q, got %d and %d", data, addr, addr2)
+	}
+
+	cnt, err := oi.RefCnt(addr)
+	if err != nil || cnt != 2 {
+		t.Fatalf("Expected reference count 2 for %q, instead got %d and %v", data, cnt, err)
+	}
+
+	str, err := oi.GetStringFromPtr(addr)
+	if err != nil {
+		t.Fatalf("Failed to GetStringFromPtr %q: %v", data, err)
+	}
+	if str != string(data) {
+		t.Fatalf("Expected %q, instead got %q", data, str)
+	}
+
+	deleted, err := oi.Delete(addr)
+	if err != nil || deleted {
+		t.Fatalf("Expected %q to be decremented, instead got %t and %v", data, deleted, err)
+	}
+	deleted, err = oi.Delete(addr)
+	if err != nil || !deleted {
+		t.Fatalf("Expected %q to be removed, instead got %t and %v", data, deleted, err)
+	}
+
+	if _, err = oi.GetPtrFromByte(data); err == nil {
+		t.Fatalf("Removed object %q is still in the index", data)
+	}
+}
@@ -0,0 +1,213 @@
+package goi
+
+// Handle is an opaque, stable reference to an interned object.
+//
+// Unlike the address returned by AddOrGet, a Handle stays valid when the object is
+// moved to a different address inside the object store. Use ResolveHandle to get the
+// current address of the object. The zero Handle never resolves.
+//
+// The lower 32 bits hold the slot in the handle table plus 1, the upper 32 bits hold
+// the generation of the slot. The generation is bumped whenever an object is removed,
+// so handles of removed objects never resolve to objects that reuse their slot.
+type Handle uint64
+
+func newHandle(slot, gen uint32) Handle {
+	return Handle(uint64(gen)<<32 | uint64(slot+1))
+}
+
+func (h Handle) slot() (uint32, bool) {
+	s := uint32(h)
+	if s == 0 {
+		return 0, false
+	}
+	return s - 1, true
+}
+
+func (h Handle) gen() uint32 {
+	return uint32(h >> 32)
+}
+
+type handleSlot struct {
+	addr uintptr
+	gen  uint32
+}
+
+// handleTable maps handles to the current address of their object.
+//
+// All of these methods expect the caller to hold the appropriate lock of the ObjectIntern.
+type handleTable struct {
+	slots  []handleSlot
+	free   []uint32
+	byAddr map[uintptr]uint32
+}
+
+func newHandleTable() *handleTable {
+	return &handleTable{
+		byAddr: make(map[uintptr]uint32),
+	}
+}
+
+// get returns the handle of the object stored at addr and true if it has one.
+// Otherwise it returns 0 and false.
+func (ht *handleTable) get(addr uintptr) (Handle, bool) {
+	slot, ok := ht.byAddr[addr]
+	if !ok {
+		return 0, false
+	}
+	return newHandle(slot, ht.slots[slot].gen), true
+}
+
+// add returns a new handle for the object stored at addr
+func (ht *handleTable) add(addr uintptr) Handle {
+	var slot uint32
+	if len(ht.free) > 0 {
+		slot = ht.free[len(ht.free)-1]
+		ht.free = ht.free[:len(ht.free)-1]
+		ht.slots[slot].addr = addr
+	} else {
+		slot = uint32(len(ht.slots))
+		ht.slots = append(ht.slots, handleSlot{addr: addr})
+	}
+	ht.byAddr[addr] = slot
+	return newHandle(slot, ht.slots[slot].gen)
+}
+
+// resolve returns the current address of the object referenced by h and true.
+// If h is not valid anymore it returns 0 and false.
+func (ht *handleTable) resolve(h Handle) (uintptr, bool) {
+	slot, ok := h.slot()
+	if !ok || slot >= uint32(len(ht.slots)) {
+		return 0, false
+	}
+	s := ht.slots[slot]
+	if s.gen != h.gen() || s.addr == 0 {
+		return 0, false
+	}
+	return s.addr, true
+}
+
+// release invalidates the handle of the object stored at addr, if any.
+// This must be called whenever the object at addr is removed from the object store.
+func (ht *handleTable) release(addr uintptr) {
+	slot, ok := ht.byAddr[addr]
+	if !ok {
+		return
+	}
+	delete(ht.byAddr, addr)
+	ht.slots[slot].addr = 0
+	ht.slots[slot].gen++
+	ht.free = append(ht.free, slot)
+}
+
+// relocate points the handle of the object stored at oldAddr to newAddr, if it has one.
+// If the object at newAddr already has a handle, the handle of oldAddr is released instead.
+func (ht *handleTable) relocate(oldAddr, newAddr uintptr) {
+	slot, ok := ht.byAddr[oldAddr]
+	if !ok {
+		return
+	}
+	if _, ok := ht.byAddr[newAddr]; ok {
+		ht.release(oldAddr)
+		return
+	}
+	delete(ht.byAddr, oldAddr)
+	ht.byAddr[newAddr] = slot
+	ht.slots[slot].addr = newAddr
+}
+
+// reset invalidates all handles. Generations are kept so that
+// handles handed out before the reset never resolve again.
+func (ht *handleTable) reset() {
+	ht.byAddr = make(map[uintptr]uint32)
+	ht.free = ht.free[:0]
+	for i := range ht.slots {
+		if ht.slots[i].addr != 0 {
+			ht.slots[i].addr = 0
+			ht.slots[i].gen++
+		}
+		ht.free = append(ht.free, uint32(i))
+	}
+}
+
+// AddOrGetHandle is the same as AddOrGet, but returns a Handle to the object instead of its address.
+// All handles returned for the same object are equal. On failure it returns 0 and an error.
+//
+// The handle does not hold a reference of its own, the object is removed as soon as its
+// reference count reaches 0, just like with AddOrGet. Afterwards the handle does not resolve anymore.
+//
+// The object is interned and its handle is created while holding the write lock once, so a
+// compaction can't move the object before its handle exists.
+func (oi *ObjectIntern) AddOrGetHandle(obj []byte, safe bool) (Handle, error) {
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return 0, ErrEmptyObject
+	}
+
+	if oi.conf.Compression != None {
+		// this returns a new byte slice, so we don't need to check for safe
+		obj = oi.compress(obj)
+	} else if safe {
+		// create a copy so we don't modify the original []byte
+		// we add 4 bytes to the capacity in case we need to append a reference count
+		objCopy := make([]byte, len(obj), len(obj)+4)
+		copy(objCopy, obj)
+		obj = objCopy
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	addr, ok := oi.getAndIncrement(obj)
+	if !ok {
+		var err error
+		addr, err = oi.add(obj)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if h, ok := oi.handles.get(addr); ok {
+		return h, nil
+	}
+	return oi.handles.add(addr), nil
+}
+
+// ResolveHandle returns the current address of the object referenced by h and true.
+// If the object has been removed from the store it returns 0 and false.
+//
+// The returned address is only valid until the object is moved or removed, so callers
+// that keep it around should resolve the handle again instead.
+func (oi *ObjectIntern) ResolveHandle(h Handle) (uintptr, bool) {
+	oi.RLock()
+	addr, ok := oi.handles.resolve(h)
+	oi.RUnlock()
+	return addr, ok
+}
+
+// relocate points every reference oi holds to the object stored at oldAddr to its copy
+// stored at newAddr. obj must be the stored form of the object.
+//
+// This must happen BEFORE the object at oldAddr is deleted from the object store,
+// since the index may still reference its memory.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) relocate(obj []byte, oldAddr, newAddr uintptr) {
+	if _, ok := oi.unindexed[oldAddr]; ok {
+		delete(oi.unindexed, oldAddr)
+		oi.unindexed[newAddr] = struct{}{}
+		oi.untrack(oldAddr)
+		oi.track(newAddr)
+	} else {
+		ns := oi.namespaceOf(oldAddr)
+		oi.indexDelete(obj, oldAddr)
+		oi.indexAddNS(ns, obj, newAddr)
+	}
+	if deadline, ok := oi.deadlines[oldAddr]; ok {
+		delete(oi.deadlines, oldAddr)
+		oi.deadlines[newAddr] = deadline
+	}
+	oi.cache.remove(oldAddr)
+	oi.strCache.remove(oldAddr)
+	oi.lastStr.remove(oldAddr)
+	oi.handles.relocate(oldAddr, newAddr)
+}
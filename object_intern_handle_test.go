@@ -0,0 +1,137 @@
+package goi
+
+import (
+	"testing"
+)
+
+func TestHandle(t *testing.T) {
+	testHandle(t, false)
+}
+
+func TestHandleCompressed(t *testing.T) {
+	testHandle(t, true)
+}
+
+func testHandle(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	h, err := oi.AddOrGetHandle([]byte("handled"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetHandle: ", err)
+	}
+	h2, err := oi.AddOrGetHandle([]byte("handled"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetHandle: ", err)
+	}
+	if h != h2 {
+		t.Fatalf("Expected the same handle for the same object, got %d and %d", h, h2)
+	}
+
+	addr, ok := oi.ResolveHandle(h)
+	if !ok {
+		t.Fatal("Failed to resolve handle")
+	}
+	ptr, err := oi.GetPtrFromByte([]byte("handled"))
+	if err != nil || ptr != addr {
+		t.Fatalf("Handle resolved to %d, but object is stored at %d", addr, ptr)
+	}
+
+	// simulate the object being moved to a different address
+	oi.Lock()
+	b, err := oi.store.Get(addr)
+	if err != nil {
+		oi.Unlock()
+		t.Fatal("Failed to get object from store: ", err)
+	}
+	newAddr, err := oi.store.Add(append([]byte{}, b...))
+	if err != nil {
+		oi.Unlock()
+		t.Fatal("Failed to add object to store: ", err)
+	}
+	oi.relocate(b[4:], addr, newAddr)
+	err = oi.store.Delete(addr)
+	oi.Unlock()
+	if err != nil {
+		t.Fatal("Failed to delete object from store: ", err)
+	}
+
+	moved, ok := oi.ResolveHandle(h)
+	if !ok {
+		t.Fatal("Failed to resolve handle after the object moved")
+	}
+	if moved != newAddr {
+		t.Fatalf("Expected handle to resolve to %d, instead got %d", newAddr, moved)
+	}
+	ptr, err = oi.GetPtrFromByte([]byte("handled"))
+	if err != nil || ptr != newAddr {
+		t.Fatalf("Expected index to point to %d, instead got %d", newAddr, ptr)
+	}
+	str, err := oi.GetStringFromPtr(moved)
+	if err != nil || str != "handled" {
+		t.Fatalf("Expected to find \"handled\" at the new address, instead found %q", str)
+	}
+
+	// remove both references
+	for i := 0; i < 2; i++ {
+		if _, err = oi.Delete(moved); err != nil {
+			t.Fatal("Failed to delete object: ", err)
+		}
+	}
+	if _, ok = oi.ResolveHandle(h); ok {
+		t.Fatal("Handle of a deleted object should not resolve")
+	}
+
+	// the slot of the released handle gets reused with a new generation
+	h3, err := oi.AddOrGetHandle([]byte("other"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetHandle: ", err)
+	}
+	if h3 == h {
+		t.Fatal("Handle of a deleted object was handed out again")
+	}
+	if _, ok = oi.ResolveHandle(h); ok {
+		t.Fatal("Handle of a deleted object should not resolve after its slot was reused")
+	}
+
+	if err = oi.Reset(); err != nil {
+		t.Fatal("Failed to reset: ", err)
+	}
+	if _, ok = oi.ResolveHandle(h3); ok {
+		t.Fatal("Handles should not resolve after a reset")
+	}
+	if _, ok = oi.ResolveHandle(0); ok {
+		t.Fatal("Zero handle should never resolve")
+	}
+}
+
+func TestHandleHashed(t *testing.T) {
+	cnf := NewConfig()
+	cnf.HashedIndex = true
+	oi := NewObjectIntern(cnf)
+
+	h, err := oi.AddOrGetHandle([]byte("handled"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetHandle: ", err)
+	}
+	addr, _ := oi.ResolveHandle(h)
+
+	oi.Lock()
+	b, _ := oi.store.Get(addr)
+	newAddr, _ := oi.store.Add(append([]byte{}, b...))
+	oi.relocate(b[4:], addr, newAddr)
+	oi.store.Delete(addr)
+	oi.Unlock()
+
+	moved, ok := oi.ResolveHandle(h)
+	if !ok || moved != newAddr {
+		t.Fatalf("Expected handle to resolve to %d, instead got %d", newAddr, moved)
+	}
+	ptr, err := oi.GetPtrFromByte([]byte("handled"))
+	if err != nil || ptr != newAddr {
+		t.Fatalf("Expected index to point to %d, instead got %d", newAddr, ptr)
+	}
+}
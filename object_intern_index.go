@@ -0,0 +1,699 @@
+package goi
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// The index maps the stored form of every object (compressed if compression is
+// turned on, and without the reference count) to its address in the object store.
+//
+// By default the index is a map[string]uintptr whose keys alias the interned data
+// inside the object store. If HashedIndex is turned on, the index is keyed by a
+// 64 bit hash of the stored form instead. Lookups then verify the stored bytes
+// to resolve hash collisions, and the index never references store memory.
+//
+// Objects in namespaces other than DefaultNamespace are kept in a separate map,
+// regardless of HashedIndex. Its keys are the namespace followed by the stored form,
+// they are copies and never reference store memory.
+//
+// If ReverseIndex is turned on, keyOf maps the address of every indexed object to a
+// copy of its key in the index it is stored in: the key of the namespaced index, or the
+// stored form otherwise. Objects are then removed from the index by that key, without
+// reading the object store.
+//
+// All of these methods expect the caller to hold the appropriate lock.
+
+// FNV-1a constants
+const (
+	fnvOffset64 uint64 = 14695981039346656037
+	fnvPrime64  uint64 = 1099511628211
+)
+
+// hashBytes returns the 64 bit FNV-1a hash of b. The offset basis is XORed with seed, so
+// keys which collide for one seed are unlikely to collide for another.
+func hashBytes(seed uint64, b []byte) uint64 {
+	h := fnvOffset64 ^ seed
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// hashString returns the same hash as hashBytes for s
+func hashString(seed uint64, s string) uint64 {
+	h := fnvOffset64 ^ seed
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// hashed returns true if the hashed index is in use
+func (oi *ObjectIntern) hashed() bool {
+	return oi.hashIndex != nil
+}
+
+// newIndex initializes an empty index
+func (oi *ObjectIntern) newIndex() {
+	oi.unindexed = make(map[uintptr]struct{})
+	oi.deadlines = make(map[uintptr]time.Time)
+	oi.pinned = make(map[uintptr]struct{})
+	oi.nsIndex = make(map[string]uintptr)
+	oi.nsOf = make(map[uintptr]Namespace)
+	oi.keyOf = nil
+	// addresses handed out before may already point into unmapped slabs
+	oi.live = nil
+	if oi.slabFrees > 0 {
+		oi.live = make(map[uintptr]struct{})
+	}
+	if oi.conf.ReverseIndex {
+		oi.keyOf = make(map[uintptr]string, oi.conf.InitialIndexCapacity)
+	}
+	if oi.conf.HashedIndex {
+		oi.hashIndex = make(map[uint64]uintptr, oi.conf.InitialIndexCapacity)
+		oi.hashCollisions = make(map[uint64][]uintptr)
+		return
+	}
+	oi.objIndex = make(map[string]uintptr, oi.conf.InitialIndexCapacity)
+}
+
+// Reserve prepares oi for adding n more objects whose stored form is avgSize bytes long on
+// average, compressed if compression is turned on. The index is grown to hold n more objects
+// without growing while they are added. If the object store implements Reserver, it is asked
+// to make room for n objects of that size as well. Otherwise, as with the default object
+// store, slabs are mapped until there is room for n objects of that size, and just like the
+// slabs mapped by PrewarmSizes they stay mapped until oi is reset.
+//
+// Reserve is only a hint, it never fails. Growing the index copies it while holding the
+// write lock, so it should be called once before a bulk insert rather than repeatedly.
+func (oi *ObjectIntern) Reserve(n int, avgSize int) {
+	if oi.Frozen() {
+		return
+	}
+
+	if n <= 0 {
+		return
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	if oi.closed() {
+		return
+	}
+
+	oi.reserveIndex(n)
+
+	if avgSize <= 0 {
+		return
+	}
+	objSize := avgSize + int(oi.refCntSize)
+	if objSize > math.MaxUint8 {
+		objSize = math.MaxUint8
+	}
+
+	if r, ok := oi.store.(Reserver); ok {
+		// it is only a hint, objects are added regardless
+		r.Reserve(uint8(objSize), n)
+		return
+	}
+	oi.reserveSlabs(uint8(objSize), n)
+}
+
+// reserveIndex replaces the maps of the index keyed by the stored form of the objects with
+// maps which have room for n more objects. The keys of the default index are copied as they
+// are, so they still alias the interned data.
+func (oi *ObjectIntern) reserveIndex(n int) {
+	if oi.keyOf != nil {
+		keyOf := make(map[uintptr]string, len(oi.keyOf)+n)
+		for addr, key := range oi.keyOf {
+			keyOf[addr] = key
+		}
+		oi.keyOf = keyOf
+	}
+
+	if !oi.hashed() {
+		objIndex := make(map[string]uintptr, len(oi.objIndex)+n)
+		for key, addr := range oi.objIndex {
+			objIndex[key] = addr
+		}
+		oi.objIndex = objIndex
+		return
+	}
+
+	hashIndex := make(map[uint64]uintptr, len(oi.hashIndex)+n)
+	for h, addr := range oi.hashIndex {
+		hashIndex[h] = addr
+	}
+	oi.hashIndex = hashIndex
+}
+
+// emptyIndex removes every object from the index, but keeps the maps of the index.
+//
+// With the default index this must happen BEFORE the objects are deleted from the object store,
+// since deleting a key from the map reads the memory it aliases.
+func (oi *ObjectIntern) emptyIndex() {
+	for addr := range oi.unindexed {
+		delete(oi.unindexed, addr)
+	}
+	for addr := range oi.deadlines {
+		delete(oi.deadlines, addr)
+	}
+	for addr := range oi.pinned {
+		delete(oi.pinned, addr)
+	}
+	for key := range oi.nsIndex {
+		delete(oi.nsIndex, key)
+	}
+	for addr := range oi.nsOf {
+		delete(oi.nsOf, addr)
+	}
+	for addr := range oi.keyOf {
+		delete(oi.keyOf, addr)
+	}
+	for addr := range oi.live {
+		delete(oi.live, addr)
+	}
+	if !oi.hashed() {
+		for key := range oi.objIndex {
+			delete(oi.objIndex, key)
+		}
+		return
+	}
+	for h := range oi.hashIndex {
+		delete(oi.hashIndex, h)
+	}
+	for h := range oi.hashCollisions {
+		delete(oi.hashCollisions, h)
+	}
+}
+
+// storedEquals returns true if the object stored at addr equals obj
+func (oi *ObjectIntern) storedEquals(addr uintptr, obj []byte) bool {
+	b, err := oi.store.Get(addr)
+	if err != nil {
+		return false
+	}
+	// remove leading bytes for reference count
+	return bytes.Equal(b[oi.refCntSize:], obj)
+}
+
+// indexGet looks up the address of obj, which must be in its stored form.
+// It returns the address and true if obj is indexed, otherwise 0 and false.
+func (oi *ObjectIntern) indexGet(obj []byte) (uintptr, bool) {
+	if !oi.hashed() {
+		addr, ok := oi.objIndex[string(obj)]
+		return addr, ok
+	}
+
+	h := hashBytes(oi.conf.HashSeed, obj)
+	if addr, ok := oi.hashIndex[h]; ok && oi.storedEquals(addr, obj) {
+		return addr, true
+	}
+	for _, addr := range oi.hashCollisions[h] {
+		if oi.storedEquals(addr, obj) {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+// indexGetString is the same as indexGet, but takes a string
+func (oi *ObjectIntern) indexGetString(obj string) (uintptr, bool) {
+	if !oi.hashed() {
+		addr, ok := oi.objIndex[obj]
+		return addr, ok
+	}
+
+	h := hashString(oi.conf.HashSeed, obj)
+	b := readOnlyBytes(obj)
+
+	if addr, ok := oi.hashIndex[h]; ok && oi.storedEquals(addr, b) {
+		return addr, true
+	}
+	for _, addr := range oi.hashCollisions[h] {
+		if oi.storedEquals(addr, b) {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+// readOnlyBytes returns a []byte which shares the data of s. It must only be read from.
+func readOnlyBytes(s string) []byte {
+	var b []byte
+	sliceHeader := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	sliceHeader.Data = (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+	sliceHeader.Len = len(s)
+	sliceHeader.Cap = len(s)
+	return b
+}
+
+// indexGetNS is the same as indexGet, but looks up obj in the namespace ns
+func (oi *ObjectIntern) indexGetNS(ns Namespace, obj []byte) (uintptr, bool) {
+	if ns == DefaultNamespace {
+		return oi.indexGet(obj)
+	}
+
+	addr, ok := oi.nsIndex[string(nsKey(ns, obj))]
+	return addr, ok
+}
+
+// indexAddNS is the same as indexAdd, but adds the object to the namespace ns
+func (oi *ObjectIntern) indexAddNS(ns Namespace, obj []byte, addr uintptr) {
+	oi.track(addr)
+
+	if ns == DefaultNamespace {
+		oi.indexAdd(obj, addr)
+		if oi.keyOf != nil {
+			oi.keyOf[addr] = string(obj)
+		}
+		return
+	}
+
+	key := string(nsKey(ns, obj))
+	oi.nsIndex[key] = addr
+	oi.nsOf[addr] = ns
+	if oi.keyOf != nil {
+		oi.keyOf[addr] = key
+	}
+}
+
+// mapped returns true if the memory at addr may be read. The object store doesn't check this:
+// once a slab has been freed and unmapped, Get still returns the memory at an address inside of
+// it without an error, and reading it crashes the process.
+//
+// As long as the object store has never freed a slab, every address handed out is mapped. Once
+// it frees one, live starts tracking the addresses of all interned objects, and from then on
+// only those are treated as mapped. Addresses of removed objects are rejected even if their
+// slab is still mapped, which every caller treats as not finding the object anyway.
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) mapped(addr uintptr) bool {
+	if oi.live == nil {
+		return true
+	}
+	_, ok := oi.live[addr]
+	return ok
+}
+
+// collectLive starts tracking the addresses of all interned objects in live, see mapped.
+// It is called when the object store frees a slab for the first time.
+func (oi *ObjectIntern) collectLive() {
+	oi.live = make(map[uintptr]struct{}, oi.indexLen()+len(oi.unindexed))
+	oi.indexRange(func(addr uintptr) bool {
+		oi.live[addr] = struct{}{}
+		return true
+	})
+}
+
+// track adds addr to live, if it is being tracked
+func (oi *ObjectIntern) track(addr uintptr) {
+	if oi.live != nil {
+		oi.live[addr] = struct{}{}
+	}
+}
+
+// untrack removes addr from live, if it is being tracked
+func (oi *ObjectIntern) untrack(addr uintptr) {
+	if oi.live != nil {
+		delete(oi.live, addr)
+	}
+}
+
+// namespaceOf returns the namespace of the object stored at addr
+func (oi *ObjectIntern) namespaceOf(addr uintptr) Namespace {
+	return oi.nsOf[addr]
+}
+
+// indexAdd adds the object stored at addr to the index. obj must be its stored form.
+func (oi *ObjectIntern) indexAdd(obj []byte, addr uintptr) {
+	if !oi.hashed() {
+		objString := string(obj)
+
+		// set objString data to the object inside the object store
+		// we need to add 4 at the beginning for the reference count
+		((*reflect.StringHeader)(unsafe.Pointer(&objString))).Data = addr + oi.refCntSize
+
+		oi.objIndex[objString] = addr
+		return
+	}
+
+	h := hashBytes(oi.conf.HashSeed, obj)
+	if _, ok := oi.hashIndex[h]; ok {
+		oi.hashCollisions[h] = append(oi.hashCollisions[h], addr)
+		return
+	}
+	oi.hashIndex[h] = addr
+}
+
+// indexDelete removes the object stored at addr from the index. obj must be its stored form.
+// If the reverse index is in use, the object is removed by the key stored in it instead.
+//
+// With the default index this must happen BEFORE the object is deleted from the object store.
+// If you delete all of the objects in the slab then the slab will be deleted
+// When this happens the memory that the slab was using is MUnmapped, which is
+// the same memory pointed to by the key stored in the ObjIndex. When you try to
+// access the key to delete it from the ObjIndex you will get a SEGFAULT
+func (oi *ObjectIntern) indexDelete(obj []byte, addr uintptr) {
+	oi.untrack(addr)
+
+	key, reversed := oi.keyOf[addr]
+	if reversed {
+		delete(oi.keyOf, addr)
+	}
+
+	if ns, ok := oi.nsOf[addr]; ok {
+		if reversed {
+			delete(oi.nsIndex, key)
+		} else {
+			delete(oi.nsIndex, string(nsKey(ns, obj)))
+		}
+		delete(oi.nsOf, addr)
+		return
+	}
+
+	if !oi.hashed() {
+		if reversed {
+			delete(oi.objIndex, key)
+		} else {
+			delete(oi.objIndex, string(obj))
+		}
+		return
+	}
+
+	var h uint64
+	if reversed {
+		h = hashString(oi.conf.HashSeed, key)
+	} else {
+		h = hashBytes(oi.conf.HashSeed, obj)
+	}
+	collisions := oi.hashCollisions[h]
+	if oi.hashIndex[h] == addr {
+		if len(collisions) == 0 {
+			delete(oi.hashIndex, h)
+			return
+		}
+		// promote the last colliding address
+		oi.hashIndex[h] = collisions[len(collisions)-1]
+		collisions = collisions[:len(collisions)-1]
+	} else {
+		for i, a := range collisions {
+			if a == addr {
+				collisions[i] = collisions[len(collisions)-1]
+				collisions = collisions[:len(collisions)-1]
+				break
+			}
+		}
+	}
+
+	if len(collisions) == 0 {
+		delete(oi.hashCollisions, h)
+		return
+	}
+	oi.hashCollisions[h] = collisions
+}
+
+// indexLen returns the number of indexed objects
+func (oi *ObjectIntern) indexLen() int {
+	if !oi.hashed() {
+		return len(oi.objIndex) + len(oi.nsIndex)
+	}
+
+	n := len(oi.hashIndex) + len(oi.nsIndex)
+	for _, collisions := range oi.hashCollisions {
+		n += len(collisions)
+	}
+	return n
+}
+
+// indexRange calls fn for the address of every indexed object until fn returns false.
+// This includes objects which are not indexed because they are longer than MaxInternLen.
+// fn must not modify the index.
+func (oi *ObjectIntern) indexRange(fn func(addr uintptr) bool) {
+	for addr := range oi.unindexed {
+		if !fn(addr) {
+			return
+		}
+	}
+	for _, addr := range oi.nsIndex {
+		if !fn(addr) {
+			return
+		}
+	}
+
+	if !oi.hashed() {
+		for _, addr := range oi.objIndex {
+			if !fn(addr) {
+				return
+			}
+		}
+		return
+	}
+
+	for _, addr := range oi.hashIndex {
+		if !fn(addr) {
+			return
+		}
+	}
+	for _, collisions := range oi.hashCollisions {
+		for _, addr := range collisions {
+			if !fn(addr) {
+				return
+			}
+		}
+	}
+}
+
+// mapOverhead estimates the memory used by the slots of a map with n entries whose keys and
+// values together take entrySize bytes. Every slot also needs a control byte, and maps grow
+// before they are full, so at most 7 out of 8 slots are used.
+func mapOverhead(n int, entrySize uintptr) uint64 {
+	return uint64(n) * uint64(entrySize+1) * 8 / 7
+}
+
+// IndexOverheadBytes returns an estimate of the memory used by the index, in addition to the
+// memory used by the object store. Together with MemStatsTotal this gives the memory used by
+// the whole table.
+//
+// The keys of the default index alias the interned data, so only their string headers are
+// counted. The keys of namespaced objects and the keys of the reverse index, if ReverseIndex
+// is turned on, are copies, so their data is counted as well. The estimate assumes the maps are as full as they get before growing, so the actual
+// memory use can be up to twice as large, for example after many objects have been deleted.
+func (oi *ObjectIntern) IndexOverheadBytes() uint64 {
+	const (
+		ptrSize    = unsafe.Sizeof(uintptr(0))
+		strSize    = unsafe.Sizeof("")
+		sliceSize  = unsafe.Sizeof([]uintptr(nil))
+		hashSize   = unsafe.Sizeof(uint64(0))
+		structSize = unsafe.Sizeof(struct{}{})
+		nsSize     = unsafe.Sizeof(Namespace(0))
+	)
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	overhead := mapOverhead(len(oi.unindexed), ptrSize+structSize)
+	overhead += mapOverhead(len(oi.nsIndex), strSize+ptrSize)
+	overhead += mapOverhead(len(oi.nsOf), ptrSize+nsSize)
+	for key := range oi.nsIndex {
+		overhead += uint64(len(key))
+	}
+	overhead += mapOverhead(len(oi.keyOf), ptrSize+strSize)
+	for _, key := range oi.keyOf {
+		overhead += uint64(len(key))
+	}
+
+	if !oi.hashed() {
+		return overhead + mapOverhead(len(oi.objIndex), strSize+ptrSize)
+	}
+
+	overhead += mapOverhead(len(oi.hashIndex), hashSize+ptrSize)
+	overhead += mapOverhead(len(oi.hashCollisions), hashSize+sliceSize)
+	for _, collisions := range oi.hashCollisions {
+		overhead += uint64(cap(collisions)) * uint64(ptrSize)
+	}
+	return overhead
+}
+
+// Verify checks the consistency of the index and the object store. It is meant as a
+// debugging aid, for example after the Unsafe methods might have been misused.
+// It returns nil if everything is consistent, otherwise it returns an error describing
+// the first inconsistency found.
+//
+// For every indexed object it checks that the object exists in the object store, that
+// the stored object matches its key in the index and that its reference count is not 0.
+// If ReverseIndex is turned on it also checks that the reverse index matches the index,
+// and once the object store has freed a slab that every interned object is tracked as mapped.
+// The object store does not reliably detect addresses of deleted objects, but their
+// memory is zeroed when they are removed, so they fail the other checks instead.
+//
+// The write lock is held while checking all objects, so this should not be used in a hot path.
+func (oi *ObjectIntern) Verify() error {
+	oi.Lock()
+	defer oi.Unlock()
+
+	check := func(addr uintptr) ([]byte, error) {
+		b, err := oi.store.Get(addr)
+		if err != nil {
+			return nil, fmt.Errorf("Indexed object at %d not found in store: %s", addr, err)
+		}
+		if !oi.conf.DisableRefCounting && atomic.LoadUint32((*uint32)(unsafe.Pointer(addr))) == 0 {
+			return nil, fmt.Errorf("Indexed object at %d has a reference count of 0", addr)
+		}
+		// remove leading bytes for reference count
+		return b[oi.refCntSize:], nil
+	}
+
+	for key, addr := range oi.nsIndex {
+		obj, err := check(addr)
+		if err != nil {
+			return err
+		}
+		if ns, ok := oi.nsOf[addr]; !ok || key != string(nsKey(ns, obj)) {
+			return fmt.Errorf("Namespaced index key of object at %d does not match the stored object", addr)
+		}
+	}
+
+	if oi.keyOf != nil {
+		if len(oi.keyOf) != oi.indexLen() {
+			return fmt.Errorf("Reverse index holds %d objects, the index holds %d", len(oi.keyOf), oi.indexLen())
+		}
+		for addr, key := range oi.keyOf {
+			var found uintptr
+			var ok bool
+			if _, namespaced := oi.nsOf[addr]; namespaced {
+				found, ok = oi.nsIndex[key]
+			} else {
+				found, ok = oi.indexGetString(key)
+			}
+			if !ok || found != addr {
+				return fmt.Errorf("Reverse index key of object at %d does not match the index", addr)
+			}
+		}
+	}
+
+	if oi.live != nil {
+		if len(oi.live) != oi.indexLen()+len(oi.unindexed) {
+			return fmt.Errorf("%d objects are tracked as mapped, %d are interned", len(oi.live), oi.indexLen()+len(oi.unindexed))
+		}
+		var err error
+		oi.indexRange(func(addr uintptr) bool {
+			if _, ok := oi.live[addr]; !ok {
+				err = fmt.Errorf("Interned object at %d is not tracked as mapped", addr)
+			}
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !oi.hashed() {
+		for key, addr := range oi.objIndex {
+			obj, err := check(addr)
+			if err != nil {
+				return err
+			}
+			// the key aliases the stored object, so if the stored object has been modified the
+			// key changes as well, but the map can't find it anymore
+			if (*reflect.StringHeader)(unsafe.Pointer(&key)).Data != addr+oi.refCntSize {
+				return fmt.Errorf("Index key of object at %d does not point to the stored object", addr)
+			}
+			if found, ok := oi.objIndex[string(obj)]; !ok || found != addr {
+				return fmt.Errorf("Index key of object at %d does not match the stored object", addr)
+			}
+		}
+		return nil
+	}
+
+	verifyHashed := func(h uint64, addr uintptr) error {
+		obj, err := check(addr)
+		if err != nil {
+			return err
+		}
+		if hashBytes(oi.conf.HashSeed, obj) != h {
+			return fmt.Errorf("Index hash of object at %d does not match the stored object", addr)
+		}
+		return nil
+	}
+
+	for h, addr := range oi.hashIndex {
+		if err := verifyHashed(h, addr); err != nil {
+			return err
+		}
+	}
+	for h, collisions := range oi.hashCollisions {
+		if _, ok := oi.hashIndex[h]; !ok {
+			return fmt.Errorf("Colliding objects of hash %d have no primary entry in the index", h)
+		}
+		for _, addr := range collisions {
+			if err := verifyHashed(h, addr); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// FindLeaks returns the sorted addresses of all indexed objects whose reference count is 0.
+// This never happens unless the Unsafe methods have been misused, such objects can't be
+// removed by any of the Delete methods anymore. If reference counting is turned off it
+// returns nil. Like Verify it is meant as a diagnostic aid, see RemoveLeaks.
+func (oi *ObjectIntern) FindLeaks() []uintptr {
+	if oi.conf.DisableRefCounting {
+		return nil
+	}
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	return oi.findLeaks()
+}
+
+// RemoveLeaks removes every object reported by FindLeaks from the index and the object
+// store, and returns the number of objects removed. OnEvict is not called for them.
+func (oi *ObjectIntern) RemoveLeaks() int {
+	if oi.conf.DisableRefCounting || oi.Frozen() {
+		return 0
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	var removed int
+	for _, addr := range oi.findLeaks() {
+		obj, err := oi.store.Get(addr)
+		if err != nil {
+			continue
+		}
+		if oi.remove(obj, addr) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// findLeaks does the work of FindLeaks.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) findLeaks() []uintptr {
+	var leaks []uintptr
+	oi.indexRange(func(addr uintptr) bool {
+		if atomic.LoadUint32((*uint32)(unsafe.Pointer(addr))) == 0 {
+			leaks = append(leaks, addr)
+		}
+		return true
+	})
+	sort.Slice(leaks, func(i, j int) bool { return leaks[i] < leaks[j] })
+	return leaks
+}
@@ -0,0 +1,669 @@
+package goi
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+)
+
+func TestAddOrGetAndDeleteHashed25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	cnf.HashedIndex = true
+	testAddOrGetAndDelete(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteHashedNoCprsn250(t *testing.T) {
+	cnf := NewConfig()
+	cnf.HashedIndex = true
+	testAddOrGetAndDelete(t, 250, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByValHashed25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	cnf.HashedIndex = true
+	testAddOrGetAndDeleteByVal(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByValSzHashedNoCprsn25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.HashedIndex = true
+	testAddOrGetAndDeleteByValSz(t, 25, 501, cnf)
+}
+
+func TestBatchDeleteHashed(t *testing.T) {
+	cnf := NewConfig()
+	cnf.HashedIndex = true
+	testBatchDelete(t, 30, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteReverse25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	cnf.ReverseIndex = true
+	testAddOrGetAndDelete(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByValReverseHashed25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.HashedIndex = true
+	cnf.ReverseIndex = true
+	testAddOrGetAndDeleteByVal(t, 25, 501, cnf)
+}
+
+func TestBatchDeleteReverse(t *testing.T) {
+	cnf := NewConfig()
+	cnf.ReverseIndex = true
+	testBatchDelete(t, 30, 501, cnf)
+}
+
+func TestReverseIndex(t *testing.T) {
+	testReverseIndex(t, false)
+}
+
+func TestReverseIndexHashed(t *testing.T) {
+	testReverseIndex(t, true)
+}
+
+func testReverseIndex(t *testing.T, hashed bool) {
+	cnf := NewConfig()
+	cnf.HashedIndex = hashed
+	cnf.ReverseIndex = true
+	oi := NewObjectIntern(cnf)
+
+	// keep the slab mapped while all other objects come and go, see TestDeleteRace
+	if _, err := oi.AddOrGet([]byte("pinned00"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+
+	for cycle := 0; cycle < 20; cycle++ {
+		addrs := make([]uintptr, 0, 50)
+		for i := 0; i < 50; i++ {
+			var addr uintptr
+			var err error
+			if i%5 == 0 {
+				addr, err = oi.AddOrGetNS(Namespace(1), []byte(fmt.Sprintf("rev%05d", i)), true)
+			} else {
+				addr, err = oi.AddOrGet([]byte(fmt.Sprintf("rev%05d", i)), true)
+			}
+			if err != nil {
+				t.Fatal("Failed to AddOrGet: ", err)
+			}
+			addrs = append(addrs, addr)
+		}
+		if len(oi.keyOf) != oi.Count() {
+			t.Fatalf("Reverse index should contain %d objects, instead found %d", oi.Count(), len(oi.keyOf))
+		}
+		if err := oi.Verify(); err != nil {
+			t.Fatal("Verify failed: ", err)
+		}
+
+		// delete a different half of the objects every cycle, the rest is deleted by value
+		for i, addr := range addrs {
+			if (i+cycle)%2 == 0 {
+				continue
+			}
+			if ok, err := oi.Delete(addr); !ok || err != nil {
+				t.Fatalf("Expected the object to be removed, got %t: %v", ok, err)
+			}
+			if ok, err := oi.Delete(addr); ok || err == nil {
+				t.Fatalf("Expected an error for the removed object, got %t: %v", ok, err)
+			}
+		}
+		for i := range addrs {
+			if (i+cycle)%2 != 0 {
+				continue
+			}
+			var ok bool
+			var err error
+			if i%5 == 0 {
+				ok, err = oi.DeleteByByteNS(Namespace(1), []byte(fmt.Sprintf("rev%05d", i)))
+			} else {
+				ok, err = oi.DeleteByString(fmt.Sprintf("rev%05d", i))
+			}
+			if !ok || err != nil {
+				t.Fatalf("Expected the object to be removed, got %t: %v", ok, err)
+			}
+		}
+
+		if oi.Count() != 1 || len(oi.keyOf) != 1 {
+			t.Fatalf("Expected only the pinned object, got %d objects and %d reverse entries", oi.Count(), len(oi.keyOf))
+		}
+		if err := oi.Verify(); err != nil {
+			t.Fatal("Verify failed: ", err)
+		}
+	}
+
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	if len(oi.keyOf) != 0 {
+		t.Fatalf("Reverse index should be empty after Reset, instead found %d objects", len(oi.keyOf))
+	}
+}
+
+// reservingStore records the calls to Reserve of the default store
+type reservingStore struct {
+	Store
+	objSize uint8
+	n       int
+}
+
+func (r *reservingStore) Reserve(objSize uint8, n int) error {
+	r.objSize, r.n = objSize, n
+	return nil
+}
+
+func TestReserve(t *testing.T) {
+	testReserve(t, false)
+}
+
+func TestReserveHashed(t *testing.T) {
+	testReserve(t, true)
+}
+
+func testReserve(t *testing.T, hashed bool) {
+	n := 5000
+	objs := make([][]byte, n)
+	for i := range objs {
+		objs[i] = []byte(fmt.Sprintf("reserve%08d", i))
+	}
+
+	// bulkAllocs returns the number of allocations made while interning objs, growing the
+	// index allocates new maps, so a reserved index must need fewer allocations
+	bulkAllocs := func(reserve bool) uint64 {
+		cnf := NewConfig()
+		cnf.HashedIndex = hashed
+		cnf.ReverseIndex = true
+		cnf.NewStore = func(slabSize uint) Store {
+			return &reservingStore{Store: NewGosStore(slabSize)}
+		}
+		oi := NewObjectIntern(cnf)
+		defer oi.Close()
+
+		if reserve {
+			oi.Reserve(n, len(objs[0]))
+			store := oi.store.(*reservingStore)
+			if store.n != n || store.objSize != uint8(len(objs[0])+4) {
+				t.Fatalf("Expected the store to reserve %d objects of %d bytes, got %d of %d", n, len(objs[0])+4, store.n, store.objSize)
+			}
+		}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		for _, obj := range objs {
+			if _, err := oi.AddOrGet(obj, false); err != nil {
+				t.Fatal("Failed to AddOrGet: ", err)
+			}
+		}
+		runtime.ReadMemStats(&after)
+
+		if oi.Count() != n {
+			t.Fatalf("Expected %d objects, got %d", n, oi.Count())
+		}
+		if err := oi.Verify(); err != nil {
+			t.Fatal("Verify failed: ", err)
+		}
+		return after.Mallocs - before.Mallocs
+	}
+
+	grown, reserved := bulkAllocs(false), bulkAllocs(true)
+	if reserved >= grown {
+		t.Fatalf("Expected fewer allocations after Reserve, got %d instead of %d", reserved, grown)
+	}
+}
+
+func TestReserveSlabs(t *testing.T) {
+	cnf := NewConfig()
+	cnf.SlabSize = 100
+	oi := NewObjectIntern(cnf)
+	defer oi.Close()
+
+	n := 1000
+	objs := make([][]byte, n)
+	for i := range objs {
+		objs[i] = []byte(fmt.Sprintf("slab%08d", i))
+	}
+	objSize := uint8(len(objs[0]) + int(oi.refCntSize))
+
+	// the default store doesn't implement Reserver, so the slabs are mapped up front
+	oi.Reserve(n, len(objs[0]))
+	if mem := oi.poolMem(objSize); mem == 0 {
+		t.Fatal("Expected Reserve to map slabs")
+	}
+	allocs := oi.slabAllocs
+	for _, obj := range objs {
+		if _, err := oi.AddOrGet(obj, false); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+	}
+	if oi.slabAllocs != allocs {
+		t.Fatalf("Expected no slab allocations after Reserve, got %d", oi.slabAllocs-allocs)
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Verify failed: ", err)
+	}
+
+	// the reserved slabs are released by Reset
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	if mem := oi.poolMem(objSize); mem != 0 {
+		t.Fatalf("Expected Reset to unmap the reserved slabs, got %d bytes", mem)
+	}
+}
+
+func TestHashedIndex(t *testing.T) {
+	cnf := NewConfig()
+	cnf.HashedIndex = true
+	oi := NewObjectIntern(cnf)
+
+	if oi.objIndex != nil {
+		t.Fatal("The string index should not be used with a hashed index")
+	}
+
+	addrs := make([]uintptr, 0)
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	if oi.indexLen() != len(testBytes) {
+		t.Fatalf("Index should contain %d objects, instead found %d", len(testBytes), oi.indexLen())
+	}
+
+	for idx, b := range testBytes {
+		addr, err := oi.GetPtrFromByte(b)
+		if err != nil || addr != addrs[idx] {
+			t.Fatal("Failed to GetPtrFromByte: ", string(b))
+		}
+		if _, err := oi.IncRefCntByString(testStrings[idx]); err != nil {
+			t.Fatal("Failed to IncRefCntByString: ", testStrings[idx])
+		}
+	}
+
+	if _, err := oi.GetPtrFromByte([]byte("notInterned")); err == nil {
+		t.Fatal("GetPtrFromByte should fail for an object which is not interned")
+	}
+
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Reset returned an error: ", err)
+	}
+	if oi.indexLen() != 0 {
+		t.Fatalf("Index should be empty after Reset, instead found %d objects", oi.indexLen())
+	}
+}
+
+func TestHashedIndexCollisions(t *testing.T) {
+	cnf := NewConfig()
+	cnf.HashedIndex = true
+	oi := NewObjectIntern(cnf)
+
+	first, second := testBytes[0], testBytes[1]
+	addr, err := oi.AddOrGet(first, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", first)
+	}
+	addr2, err := oi.AddOrGet(second, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", second)
+	}
+
+	// pretend that the second object collides with the first one
+	h, h2 := hashBytes(oi.conf.HashSeed, first), hashBytes(oi.conf.HashSeed, second)
+	delete(oi.hashIndex, h2)
+	oi.hashCollisions[h] = append(oi.hashCollisions[h], addr2)
+
+	// lookups must verify the stored object and not just trust the hash
+	if found, ok := oi.indexGet(first); !ok || found != addr {
+		t.Fatal("Failed to find the first object")
+	}
+	if oi.indexLen() != 2 {
+		t.Fatalf("Index should contain 2 objects, instead found %d", oi.indexLen())
+	}
+
+	// deleting the first object should promote the colliding one
+	if ok, err := oi.Delete(addr); err != nil || !ok {
+		t.Fatal("Failed to Delete: ", addr)
+	}
+	if oi.hashIndex[h] != addr2 || len(oi.hashCollisions) != 0 {
+		t.Fatal("Colliding object should have been promoted")
+	}
+	if _, ok := oi.indexGet(first); ok {
+		t.Fatal("Deleted object should not be found")
+	}
+	if oi.indexLen() != 1 {
+		t.Fatalf("Index should contain 1 object, instead found %d", oi.indexLen())
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	hashes := func(seed uint64) map[uint64]bool {
+		cnf := NewConfig()
+		cnf.HashedIndex = true
+		cnf.HashSeed = seed
+		oi := NewObjectIntern(cnf)
+		for _, b := range testBytes {
+			if _, err := oi.AddOrGet(b, true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", b)
+			}
+		}
+		for _, b := range testBytes {
+			if _, err := oi.GetPtrFromByte(b); err != nil {
+				t.Fatal("Failed to GetPtrFromByte: ", b)
+			}
+		}
+
+		keys := make(map[uint64]bool, len(oi.hashIndex))
+		for h := range oi.hashIndex {
+			keys[h] = true
+		}
+		return keys
+	}
+
+	// the same seed always distributes the keys the same way
+	first := hashes(1)
+	if !reflect.DeepEqual(first, hashes(1)) {
+		t.Fatal("Expected the same hashes for the same seed")
+	}
+
+	// with a different seed none of the keys should hash to the same value
+	for h := range hashes(2) {
+		if first[h] {
+			t.Fatalf("Expected different hashes for different seeds, both contain %d", h)
+		}
+	}
+
+	// a random seed is chosen for every ObjectIntern if none is set
+	oi, oi2 := NewObjectIntern(NewConfig()), NewObjectIntern(NewConfig())
+	if oi.conf.HashSeed == 0 || oi.conf.HashSeed == oi2.conf.HashSeed {
+		t.Fatalf("Expected two different random seeds, got %d and %d", oi.conf.HashSeed, oi2.conf.HashSeed)
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	benchmarks := []struct {
+		name   string
+		num    int
+		hashed bool
+		lookup bool
+	}{
+		{"StringMapInsert-10000", 10000, false, false},
+		{"HashedInsert-10000", 10000, true, false},
+		{"StringMapLookup-10000", 10000, false, true},
+		{"HashedLookup-10000", 10000, true, true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c := NewConfig()
+			c.HashedIndex = bm.hashed
+
+			data := make([][]byte, 0, bm.num)
+			for i := 0; i < bm.num; i++ {
+				data = append(data, []byte(fmt.Sprintf("words%d", i)))
+			}
+
+			oi := NewObjectIntern(c)
+			if bm.lookup {
+				for _, obj := range data {
+					oi.AddOrGet(obj, true)
+				}
+			}
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if bm.lookup {
+					for _, obj := range data {
+						globalPtr, _ = oi.GetPtrFromByte(obj)
+					}
+					continue
+				}
+
+				b.StopTimer()
+				oi.Reset()
+				b.StartTimer()
+				for _, obj := range data {
+					globalPtr, _ = oi.AddOrGet(obj, true)
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	testVerify(t, false, false)
+}
+
+func TestVerifyCompressed(t *testing.T) {
+	testVerify(t, true, false)
+}
+
+func TestVerifyHashed(t *testing.T) {
+	testVerify(t, false, true)
+}
+
+func testVerify(t *testing.T, compress bool, hashed bool) {
+	newOI := func() (*ObjectIntern, []uintptr) {
+		cnf := NewConfig()
+		cnf.HashedIndex = hashed
+		if compress {
+			cnf.Compression = Shoco
+		}
+		oi := NewObjectIntern(cnf)
+
+		// all objects have the same length, so they share a slab which is
+		// not unmapped when a single object is removed from the store
+		addrs := make([]uintptr, 0, 10)
+		for i := 0; i < 10; i++ {
+			addr, err := oi.AddOrGet([]byte(fmt.Sprintf("verify%d", i)), true)
+			if err != nil {
+				t.Fatal("Failed to AddOrGet: ", err)
+			}
+			addrs = append(addrs, addr)
+		}
+		if err := oi.Verify(); err != nil {
+			t.Fatal("Verify failed on a consistent ObjectIntern: ", err)
+		}
+		return oi, addrs
+	}
+
+	// freeing an object in the store without removing it from the index
+	oi, addrs := newOI()
+	b, err := oi.store.Get(addrs[3])
+	if err != nil {
+		t.Fatal("Failed to get from store: ", err)
+	}
+	for i := range b {
+		b[i] = 0
+	}
+	if err := oi.store.Delete(addrs[3]); err != nil {
+		t.Fatal("Failed to delete from store: ", err)
+	}
+	if err := oi.Verify(); err == nil {
+		t.Fatal("Verify did not detect a missing object")
+	}
+
+	// a reference count that dropped to 0 without removing the object
+	oi, addrs = newOI()
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(addrs[5])), 0)
+	if err := oi.Verify(); err == nil {
+		t.Fatal("Verify did not detect a reference count of 0")
+	}
+
+	// a stored object that does not match its key anymore
+	oi, addrs = newOI()
+	b, err = oi.store.Get(addrs[7])
+	if err != nil {
+		t.Fatal("Failed to get from store: ", err)
+	}
+	b[len(b)-1]++
+	if err := oi.Verify(); err == nil {
+		t.Fatal("Verify did not detect a modified object")
+	}
+}
+
+func TestFindLeaks(t *testing.T) {
+	testFindLeaks(t, false)
+}
+
+func TestFindLeaksHashed(t *testing.T) {
+	testFindLeaks(t, true)
+}
+
+func testFindLeaks(t *testing.T, hashed bool) {
+	cnf := NewConfig()
+	cnf.HashedIndex = hashed
+	oi := NewObjectIntern(cnf)
+
+	addrs := make([]uintptr, 0, 10)
+	for i := 0; i < 10; i++ {
+		addr, err := oi.AddOrGet([]byte(fmt.Sprintf("leak%d", i)), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if leaks := oi.FindLeaks(); len(leaks) != 0 {
+		t.Fatalf("Expected no leaks, got %v", leaks)
+	}
+
+	// reference counts that dropped to 0 without removing the objects
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(addrs[2])), 0)
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(addrs[6])), 0)
+	expected := []uintptr{addrs[2], addrs[6]}
+	if expected[0] > expected[1] {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if leaks := oi.FindLeaks(); !reflect.DeepEqual(leaks, expected) {
+		t.Fatalf("Expected leaks %v, got %v", expected, leaks)
+	}
+
+	if removed := oi.RemoveLeaks(); removed != 2 {
+		t.Fatalf("Expected 2 leaks to be removed, got %d", removed)
+	}
+	if leaks := oi.FindLeaks(); len(leaks) != 0 {
+		t.Fatalf("Expected no leaks after RemoveLeaks, got %v", leaks)
+	}
+	if oi.Count() != 8 {
+		t.Fatalf("Expected 8 objects to remain, got %d", oi.Count())
+	}
+	if _, err := oi.GetPtrFromByte([]byte("leak2")); err == nil {
+		t.Fatal("Expected the leaked object to be removed from the index")
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Verify failed after RemoveLeaks: ", err)
+	}
+}
+
+func TestIndexOverheadBytes(t *testing.T) {
+	testIndexOverheadBytes(t, false)
+}
+
+func TestIndexOverheadBytesHashed(t *testing.T) {
+	testIndexOverheadBytes(t, true)
+}
+
+func testIndexOverheadBytes(t *testing.T, hashed bool) {
+	cnf := NewConfig()
+	cnf.HashedIndex = hashed
+	oi := NewObjectIntern(cnf)
+
+	if overhead := oi.IndexOverheadBytes(); overhead != 0 {
+		t.Fatalf("Expected no overhead for an empty index, got %d", overhead)
+	}
+
+	var last uint64
+	for i := 0; i < 1000; i++ {
+		if _, err := oi.AddOrGet([]byte(fmt.Sprintf("overhead%d", i)), true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		if i%100 != 99 {
+			continue
+		}
+
+		overhead := oi.IndexOverheadBytes()
+		if overhead <= last {
+			t.Fatalf("Expected overhead to grow with Count %d, got %d after %d", oi.Count(), overhead, last)
+		}
+		// every entry needs at least its key and value
+		if min := uint64(oi.Count()) * 16; overhead < min {
+			t.Fatalf("Expected overhead of at least %d for %d objects, got %d", min, oi.Count(), overhead)
+		}
+		last = overhead
+	}
+
+	// namespaced objects add to the overhead
+	if _, err := oi.AddOrGetNS(1, []byte("overhead0"), true); err != nil {
+		t.Fatal("Failed to AddOrGetNS: ", err)
+	}
+	if overhead := oi.IndexOverheadBytes(); overhead <= last {
+		t.Fatalf("Expected overhead to grow with a namespaced object, got %d after %d", overhead, last)
+	}
+
+	// the reverse index stores a copy of every key
+	cnf.ReverseIndex = true
+	reverse := NewObjectIntern(cnf)
+	var keys uint64
+	for i := 0; i < 1000; i++ {
+		obj := []byte(fmt.Sprintf("overhead%d", i))
+		if _, err := reverse.AddOrGet(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		keys += uint64(len(obj))
+	}
+	// every reverse entry needs at least its address, its string header and the key itself
+	if min := last + uint64(reverse.Count())*24 + keys; reverse.IndexOverheadBytes() < min {
+		t.Fatalf("Expected overhead of at least %d with ReverseIndex, got %d", min, reverse.IndexOverheadBytes())
+	}
+}
+
+func BenchmarkWarmup(b *testing.B) {
+	benchmarks := []struct {
+		name     string
+		num      int
+		capacity int
+		hashed   bool
+		short    bool
+	}{
+		{"NoHint-100000", 100000, 0, false, false},
+		{"Hint-100000", 100000, 100000, false, false},
+		{"HashedNoHint-100000", 100000, 0, true, false},
+		{"HashedHint-100000", 100000, 100000, true, false},
+		// skip short
+		{"NoHint-1000000", 1000000, 0, false, true},
+		{"Hint-1000000", 1000000, 1000000, false, true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			if testing.Short() && bm.short {
+				b.Skip()
+			}
+
+			c := NewConfig()
+			c.InitialIndexCapacity = bm.capacity
+			c.HashedIndex = bm.hashed
+			data := generateTestData(bm.num, 0)
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				oi := NewObjectIntern(c)
+				for _, obj := range data {
+					if _, err := oi.AddOrGet(obj, false); err != nil {
+						b.Fatal("Failed to AddOrGet: ", err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package goi
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// InternIterator is a pull-style iterator over the objects interned when it was created,
+// see Iterator. It is not safe for concurrent use by multiple goroutines.
+type InternIterator struct {
+	oi     *ObjectIntern
+	addrs  []uintptr
+	pos    int
+	frees  uint64               // slabs freed by the object store when live was last updated
+	live   map[uintptr]struct{} // objects interned when live was last updated, nil until a slab is freed
+	addr   uintptr
+	refCnt uint32
+	value  []byte
+	err    error
+}
+
+// Iterator returns an iterator over every interned object, in no particular order.
+// Call Next to advance it to the first object and every following one.
+//
+// The addresses of all interned objects are copied while holding the read lock once, the
+// lock is not held between calls, so iterating can be interleaved with other work and other
+// calls to oi. Objects deleted after the iterator was created are skipped by Next, but an
+// object may still be deleted between Next and Value, in which case Value returns nil. If an
+// address is reused for a new object in the meantime, the new object is returned instead.
+// Whenever the object store has freed a slab since the previous call, Next and Value collect
+// the addresses of all interned objects again before reading one, since the memory of a freed
+// slab can't be read anymore.
+func (oi *ObjectIntern) Iterator() *InternIterator {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	addrs := make([]uintptr, 0, oi.indexLen()+len(oi.unindexed))
+	oi.indexRange(func(addr uintptr) bool {
+		addrs = append(addrs, addr)
+		return true
+	})
+
+	return &InternIterator{oi: oi, addrs: addrs, frees: oi.slabFrees}
+}
+
+// mapped returns true if the memory at addr can be read. Once the object store frees a slab,
+// the addresses of the objects it held must not be read anymore, so every time a slab has
+// been freed since the last call the addresses of all interned objects are collected again.
+//
+// The caller is responsible for holding at least a read lock.
+func (it *InternIterator) mapped(addr uintptr) bool {
+	oi := it.oi
+	if it.live == nil && it.frees == oi.slabFrees {
+		return true
+	}
+	if it.live == nil || it.frees != oi.slabFrees {
+		it.live = make(map[uintptr]struct{}, oi.indexLen()+len(oi.unindexed))
+		oi.indexRange(func(addr uintptr) bool {
+			it.live[addr] = struct{}{}
+			return true
+		})
+		it.frees = oi.slabFrees
+	}
+	_, ok := it.live[addr]
+	return ok
+}
+
+// Next advances the iterator to the next object which is still interned and returns true.
+// It returns false once there are no objects left or an error has occurred, see Err.
+func (it *InternIterator) Next() bool {
+	it.addr, it.refCnt, it.value = 0, 0, nil
+	if it.err != nil {
+		return false
+	}
+
+	oi := it.oi
+	oi.RLock()
+	defer oi.RUnlock()
+
+	if oi.closed() {
+		it.err = ErrClosed
+		return false
+	}
+
+	for it.pos < len(it.addrs) {
+		addr := it.addrs[it.pos]
+		it.pos++
+		if !it.mapped(addr) {
+			continue
+		}
+
+		b, err := oi.store.Get(addr)
+		// the object has been deleted since the iterator was created
+		if err != nil || !oi.interned(b, addr) {
+			continue
+		}
+
+		it.addr = addr
+		// if reference counting is turned off every object is treated as referenced once
+		it.refCnt = 1
+		if !oi.conf.DisableRefCounting {
+			it.refCnt = atomic.LoadUint32((*uint32)(unsafe.Pointer(addr)))
+		}
+		return true
+	}
+
+	return false
+}
+
+// Addr returns the address of the current object.
+func (it *InternIterator) Addr() uintptr {
+	return it.addr
+}
+
+// RefCnt returns the reference count of the current object when Next advanced to it.
+func (it *InternIterator) RefCnt() uint32 {
+	return it.refCnt
+}
+
+// Value returns a decompressed copy of the current object, which does not reference the object
+// store. It is only read on the first call for every object. If the object has been deleted
+// since Next advanced to it Value returns nil. If reading it fails Value returns nil, and
+// the error is returned by Err.
+func (it *InternIterator) Value() []byte {
+	if it.value != nil || it.addr == 0 || it.err != nil {
+		return it.value
+	}
+
+	oi := it.oi
+	oi.RLock()
+	defer oi.RUnlock()
+
+	if oi.closed() {
+		it.err = ErrClosed
+		return nil
+	}
+	if !it.mapped(it.addr) {
+		return nil
+	}
+	b, err := oi.store.Get(it.addr)
+	if err != nil || !oi.interned(b, it.addr) {
+		return nil
+	}
+
+	b, err = oi.decompress(b[oi.refCntSize:])
+	if err != nil {
+		it.err = err
+		return nil
+	}
+	it.value = append([]byte(nil), b...)
+	return it.value
+}
+
+// Err returns the first error which occurred while iterating, or nil.
+func (it *InternIterator) Err() error {
+	return it.err
+}
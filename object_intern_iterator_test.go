@@ -0,0 +1,98 @@
+package goi
+
+import (
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	testIterator(t, false)
+}
+
+func TestIteratorCompressed(t *testing.T) {
+	testIterator(t, true)
+}
+
+func testIterator(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	addrs := make(map[string]uintptr, len(testStrings))
+	for _, s := range testStrings {
+		addr, err := oi.AddOrGetFromString(s, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGetFromString: ", err)
+		}
+		addrs[s] = addr
+	}
+	// the first object is referenced twice
+	if _, err := oi.AddOrGetFromString(testStrings[0], true); err != nil {
+		t.Fatal("Failed to AddOrGetFromString: ", err)
+	}
+
+	seen := make(map[string]bool, len(addrs))
+	it := oi.Iterator()
+	for it.Next() {
+		v := string(it.Value())
+		if addr, ok := addrs[v]; !ok || addr != it.Addr() {
+			t.Fatalf("Unexpected object %q at %d", v, it.Addr())
+		}
+		if seen[v] {
+			t.Fatalf("Object %q returned twice", v)
+		}
+		seen[v] = true
+
+		expected := uint32(1)
+		if v == testStrings[0] {
+			expected = 2
+		}
+		if it.RefCnt() != expected {
+			t.Fatalf("Expected a reference count of %d for %q, got %d", expected, v, it.RefCnt())
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal("Failed to iterate: ", it.Err())
+	}
+	if len(seen) != len(addrs) {
+		t.Fatalf("Expected %d objects, got %d", len(addrs), len(seen))
+	}
+
+	// objects deleted after the iterator was created are skipped, the others are still returned
+	it = oi.Iterator()
+	deleted := testStrings[1]
+	removed, err := oi.Delete(addrs[deleted])
+	if err != nil || !removed {
+		t.Fatal("Failed to Delete: ", err)
+	}
+	var n int
+	for it.Next() {
+		if it.Addr() == addrs[deleted] {
+			t.Fatalf("Expected %d to be skipped", it.Addr())
+		}
+		n++
+	}
+	if n != len(addrs)-1 || it.Err() != nil {
+		t.Fatalf("Expected %d objects, got %d: %v", len(addrs)-1, n, it.Err())
+	}
+
+	// an object deleted between Next and Value has no value
+	it = oi.Iterator()
+	if !it.Next() {
+		t.Fatal("Expected an object")
+	}
+	for removed := false; !removed; {
+		if removed, err = oi.Delete(it.Addr()); err != nil {
+			t.Fatal("Failed to Delete: ", err)
+		}
+	}
+	if v := it.Value(); v != nil {
+		t.Fatalf("Expected no value for a deleted object, got %q", v)
+	}
+
+	oi.Close()
+	if it.Next() || it.Err() != ErrClosed {
+		t.Fatalf("Expected ErrClosed, got %v", it.Err())
+	}
+}
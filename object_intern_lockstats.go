@@ -0,0 +1,108 @@
+package goi
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// lockStatsBuckets is the number of histogram buckets. Bucket i counts waits
+// shorter than 2^i nanoseconds, the last bucket counts all longer waits.
+const lockStatsBuckets = 40
+
+// lockStats is a histogram of the time spent waiting for the write lock
+type lockStats struct {
+	buckets [lockStatsBuckets]uint64
+	count   uint64
+	total   int64
+	max     int64
+}
+
+// record adds a single wait of duration d to the histogram
+func (ls *lockStats) record(d time.Duration) {
+	ns := int64(d)
+	bucket := 0
+	for bucket < lockStatsBuckets-1 && ns >= int64(1)<<uint(bucket) {
+		bucket++
+	}
+
+	atomic.AddUint64(&ls.buckets[bucket], 1)
+	atomic.AddUint64(&ls.count, 1)
+	atomic.AddInt64(&ls.total, ns)
+	for {
+		max := atomic.LoadInt64(&ls.max)
+		if ns <= max || atomic.CompareAndSwapInt64(&ls.max, max, ns) {
+			return
+		}
+	}
+}
+
+// LockStats describes how long AddOrGet and the Delete methods had to wait for the write lock.
+// The percentiles are upper bounds, they are taken from a histogram with buckets of
+// exponentially increasing size and capped at Max.
+type LockStats struct {
+	Count uint64
+	Total time.Duration
+	Max   time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+}
+
+// lock acquires the write lock. If LockStats is turned on it also records how long it had to wait.
+func (oi *ObjectIntern) lock() {
+	if oi.lockStats == nil {
+		oi.Lock()
+		return
+	}
+
+	start := time.Now()
+	oi.Lock()
+	oi.lockStats.record(time.Since(start))
+}
+
+// LockStats returns statistics about the time spent waiting for the write lock when adding
+// or deleting objects. If LockStats is turned off in the config it returns an empty LockStats.
+func (oi *ObjectIntern) LockStats() LockStats {
+	ls := oi.lockStats
+	if ls == nil {
+		return LockStats{}
+	}
+
+	var buckets [lockStatsBuckets]uint64
+	var count uint64
+	for i := range buckets {
+		buckets[i] = atomic.LoadUint64(&ls.buckets[i])
+		count += buckets[i]
+	}
+
+	stats := LockStats{
+		Count: count,
+		Total: time.Duration(atomic.LoadInt64(&ls.total)),
+		Max:   time.Duration(atomic.LoadInt64(&ls.max)),
+	}
+	if count == 0 {
+		return stats
+	}
+
+	percentile := func(p uint64) time.Duration {
+		// rank of the percentile, rounded up
+		rank := (count*p + 99) / 100
+		var seen uint64
+		for i, n := range buckets {
+			seen += n
+			if seen >= rank {
+				bound := time.Duration(int64(1) << uint(i))
+				if i == lockStatsBuckets-1 || bound > stats.Max {
+					return stats.Max
+				}
+				return bound
+			}
+		}
+		return stats.Max
+	}
+
+	stats.P50 = percentile(50)
+	stats.P90 = percentile(90)
+	stats.P99 = percentile(99)
+	return stats
+}
@@ -0,0 +1,61 @@
+package goi
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestLockStats(t *testing.T) {
+	cnf := NewConfig()
+	cnf.LockStats = true
+	oi := NewObjectIntern(cnf)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				addr, err := oi.AddOrGet([]byte(fmt.Sprintf("lock%d-%d", g, i)), true)
+				if err != nil {
+					t.Error("Failed to AddOrGet: ", err)
+					return
+				}
+				if _, err = oi.Delete(addr); err != nil {
+					t.Error("Failed to Delete: ", err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	stats := oi.LockStats()
+	// every new object takes the write lock once to be added and once to be deleted
+	if stats.Count != 8*100*2 {
+		t.Fatalf("Expected %d lock acquisitions, instead got %d", 8*100*2, stats.Count)
+	}
+	if stats.P50 > stats.P90 || stats.P90 > stats.P99 || stats.P99 > stats.Max {
+		t.Fatalf("Percentiles are not ordered: %+v", stats)
+	}
+	if stats.Total < stats.Max {
+		t.Fatalf("Total wait time is smaller than the longest wait: %+v", stats)
+	}
+}
+
+func TestLockStatsDisabled(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	addr, err := oi.AddOrGet([]byte("unlocked"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if _, err = oi.Delete(addr); err != nil {
+		t.Fatal("Failed to Delete: ", err)
+	}
+
+	if stats := oi.LockStats(); stats != (LockStats{}) {
+		t.Fatalf("Expected empty LockStats, instead got %+v", stats)
+	}
+}
@@ -0,0 +1,49 @@
+package goi
+
+// Events passed to the Logger of an ObjectIntern
+const (
+	// EventCreated is logged when a new object is added to the object store. Its fields
+	// are "addr", the address of the object, and "len", the length of its stored form.
+	EventCreated = "created"
+	// EventFreed is logged when an object is removed from the object store, unless it is
+	// removed by Reset, ResetAndTrim, DeleteAll or Close. Its only field is "addr".
+	EventFreed = "freed"
+	// EventReset is logged by Reset and ResetAndTrim. Its only field is "count", the number
+	// of objects which have been removed.
+	EventReset = "reset"
+	// EventSlabDeleted is logged when freeing an object leaves its slab empty, so the object
+	// store deletes the slab. Its fields are "objSize", the size of the object slots in the
+	// slab, and "bytes", the amount of memory released.
+	EventSlabDeleted = "slab deleted"
+)
+
+// logEntry is an event waiting to be passed to the Logger
+type logEntry struct {
+	event  string
+	fields map[string]interface{}
+}
+
+// log queues event to be passed to the Logger once the write lock is released. Callers
+// should check that the Logger is set before building fields, to keep logging free
+// when it is turned off.
+//
+// The caller must hold the write lock.
+func (oi *ObjectIntern) log(event string, fields map[string]interface{}) {
+	if oi.conf.Logger == nil {
+		return
+	}
+	oi.logQueue = append(oi.logQueue, logEntry{event: event, fields: fields})
+}
+
+// Unlock releases the write lock, and then passes all events logged while it was held
+// to the Logger. The Logger is never called while a lock is held, so it may use the
+// ObjectIntern.
+func (oi *ObjectIntern) Unlock() {
+	queue := oi.logQueue
+	oi.logQueue = nil
+	oi.RWMutex.Unlock()
+
+	for _, entry := range queue {
+		oi.conf.Logger(entry.event, entry.fields)
+	}
+}
@@ -0,0 +1,66 @@
+package goi
+
+import (
+	"testing"
+)
+
+type logged struct {
+	event  string
+	fields map[string]interface{}
+}
+
+func TestLogger(t *testing.T) {
+	var events []logged
+	var oi *ObjectIntern
+
+	cnf := NewConfig()
+	cnf.Logger = func(event string, fields map[string]interface{}) {
+		// the logger is called without holding a lock, so it may use the ObjectIntern
+		oi.Count()
+		events = append(events, logged{event: event, fields: fields})
+	}
+	oi = NewObjectIntern(cnf)
+
+	addr, err := oi.AddOrGet([]byte("lifecycle"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if len(events) != 1 || events[0].event != EventCreated || events[0].fields["addr"] != addr || events[0].fields["len"] != len("lifecycle") {
+		t.Fatalf("Expected a created event for %d, got %v", addr, events)
+	}
+
+	// finding the object again doesn't create it
+	if _, err := oi.AddOrGet([]byte("lifecycle"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if _, err := oi.Delete(addr); err != nil {
+		t.Fatal("Failed to Delete: ", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected no events until the last reference is dropped, got %v", events)
+	}
+
+	// the object is the only one in its slab, so the slab is deleted as well
+	if ok, err := oi.Delete(addr); !ok || err != nil {
+		t.Fatalf("Expected the object to be removed, got %t: %v", ok, err)
+	}
+	if len(events) != 3 || events[1].event != EventFreed || events[1].fields["addr"] != addr || events[2].event != EventSlabDeleted {
+		t.Fatalf("Expected a freed and a slab deleted event for %d, got %v", addr, events[1:])
+	}
+	if objSize := events[2].fields["objSize"]; objSize != uint8(len("lifecycle")+4) {
+		t.Fatalf("Expected an object size of %d, got %v", len("lifecycle")+4, objSize)
+	}
+
+	events = nil
+	for _, b := range testBytes[:3] {
+		if _, err := oi.AddOrGet(b, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+	}
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	if last := events[len(events)-1]; last.event != EventReset || last.fields["count"] != 3 {
+		t.Fatalf("Expected a reset event for 3 objects, got %v", last)
+	}
+}
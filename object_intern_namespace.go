@@ -0,0 +1,133 @@
+package goi
+
+import (
+	"fmt"
+)
+
+// Namespace separates objects from different domains, such as metric names and tag
+// values, within a single ObjectIntern. Identical objects in different namespaces are
+// distinct interned objects with their own address and reference count.
+//
+// The namespace is only part of the index key, it is not stored with the object, so
+// an object uses the same amount of memory in every namespace. Once interned, objects
+// are identified by their address alone, so Delete, RefCnt, GetStringFromPtr and all
+// other methods taking an address work for every namespace.
+//
+// Methods that look up objects by value without taking a Namespace, such as AddOrGet,
+// GetPtrFromByte or DeleteByByte, use DefaultNamespace. Snapshots do not record
+// namespaces, ReadFrom loads every object into DefaultNamespace.
+type Namespace uint8
+
+// DefaultNamespace is the namespace used by all methods that don't take a Namespace
+const DefaultNamespace Namespace = 0
+
+// nsKey returns the key of obj, which must be in its stored form, in the namespace index
+func nsKey(ns Namespace, obj []byte) []byte {
+	key := make([]byte, len(obj)+1)
+	key[0] = byte(ns)
+	copy(key[1:], obj)
+	return key
+}
+
+// AddOrGetNS is the same as AddOrGet, but finds or adds obj in the namespace ns.
+// On failure it returns 0 and an error
+//
+// If the object is found in the namespace its reference count is increased by 1.
+// If the object is added to the namespace its reference count is set to 1.
+func (oi *ObjectIntern) AddOrGetNS(ns Namespace, obj []byte, safe bool) (uintptr, error) {
+	if ns == DefaultNamespace {
+		return oi.AddOrGet(obj, safe)
+	}
+
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return 0, ErrEmptyObject
+	}
+
+	// obj is never modified, it is only copied into the index key and the object store,
+	// so there is no need to create a copy even if safe is set to true
+	if oi.conf.Compression != None {
+		obj = oi.compress(obj)
+	}
+
+	// acquire lock
+	oi.RLock()
+
+	addr, ok := oi.getAndIncrementNS(ns, obj)
+	if ok {
+		oi.RUnlock()
+		return addr, nil
+	}
+
+	oi.RUnlock()
+
+	oi.lock()
+
+	// re-check everything
+	addr, ok = oi.getAndIncrementNS(ns, obj)
+	if ok {
+		oi.Unlock()
+		return addr, nil
+	}
+
+	addr, err := oi.addNS(ns, obj)
+	if err != nil {
+		oi.Unlock()
+		return 0, err
+	}
+	oi.misses++
+
+	oi.Unlock()
+	return addr, nil
+}
+
+// GetPtrFromByteNS is the same as GetPtrFromByte, but finds obj in the namespace ns.
+// Upon failure it returns 0 and an error.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) GetPtrFromByteNS(ns Namespace, obj []byte) (uintptr, error) {
+	if ns == DefaultNamespace {
+		return oi.GetPtrFromByte(obj)
+	}
+
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return 0, ErrEmptyObject
+	}
+
+	key := obj
+	if oi.conf.Compression != None {
+		key = oi.compress(obj)
+	}
+
+	oi.RLock()
+	// try to find the object in the index
+	addr, ok := oi.indexGetNS(ns, key)
+	oi.RUnlock()
+
+	if !ok {
+		return 0, fmt.Errorf("Could not find object in namespace %d: %s", ns, string(obj))
+	}
+	return addr, nil
+}
+
+// DeleteByByteNS is the same as DeleteByByte, but finds obj in the namespace ns.
+// Possible return values are as follows:
+//
+// true, nil - reference count reached 0 and the object was removed from both the index
+// and the object store.
+//
+// false, nil - reference count was decremented by 1 and no further action was taken.
+//
+// false, error - the object was not found in the namespace or could not be deleted
+func (oi *ObjectIntern) DeleteByByteNS(ns Namespace, obj []byte) (bool, error) {
+	if oi.Frozen() {
+		return false, ErrFrozen
+	}
+
+	addr, err := oi.GetPtrFromByteNS(ns, obj)
+	if err != nil {
+		return false, err
+	}
+	return oi.Delete(addr)
+}
@@ -0,0 +1,150 @@
+package goi
+
+import (
+	"testing"
+)
+
+func TestNamespace(t *testing.T) {
+	testNamespace(t, false, false)
+}
+
+func TestNamespaceCompressed(t *testing.T) {
+	testNamespace(t, true, false)
+}
+
+func TestNamespaceHashed(t *testing.T) {
+	testNamespace(t, false, true)
+}
+
+func testNamespace(t *testing.T, compress bool, hashed bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	cnf.HashedIndex = hashed
+	oi := NewObjectIntern(cnf)
+
+	const names, values Namespace = 1, 2
+	obj := []byte("servername1234")
+
+	nameAddr, err := oi.AddOrGetNS(names, obj, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetNS: ", err)
+	}
+	valueAddr, err := oi.AddOrGetNS(values, obj, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetNS: ", err)
+	}
+	defaultAddr, err := oi.AddOrGet(obj, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if nameAddr == valueAddr || nameAddr == defaultAddr || valueAddr == defaultAddr {
+		t.Fatalf("Expected distinct addresses in every namespace, got %d, %d and %d", nameAddr, valueAddr, defaultAddr)
+	}
+
+	// increase the reference count in one namespace only
+	addr, err := oi.AddOrGetNS(names, obj, false)
+	if err != nil || addr != nameAddr {
+		t.Fatalf("Expected address %d for existing object, got %d: %v", nameAddr, addr, err)
+	}
+
+	for _, expected := range []struct {
+		addr   uintptr
+		refCnt uint32
+	}{
+		{nameAddr, 2},
+		{valueAddr, 1},
+		{defaultAddr, 1},
+	} {
+		refCnt, err := oi.RefCnt(expected.addr)
+		if err != nil {
+			t.Fatal("Failed to get RefCnt: ", err)
+		}
+		if refCnt != expected.refCnt {
+			t.Fatalf("Expected reference count %d for object at %d, got %d", expected.refCnt, expected.addr, refCnt)
+		}
+
+		str, err := oi.GetStringFromPtr(expected.addr)
+		if err != nil || str != string(obj) {
+			t.Fatalf("Expected %q at %d, got %q: %v", obj, expected.addr, str, err)
+		}
+	}
+
+	for ns, expected := range map[Namespace]uintptr{names: nameAddr, values: valueAddr, DefaultNamespace: defaultAddr} {
+		addr, err := oi.GetPtrFromByteNS(ns, obj)
+		if err != nil || addr != expected {
+			t.Fatalf("Expected address %d in namespace %d, got %d: %v", expected, ns, addr, err)
+		}
+	}
+	if _, err := oi.GetPtrFromByteNS(3, obj); err == nil {
+		t.Fatal("GetPtrFromByteNS should fail for a namespace the object was never added to")
+	}
+
+	// removing the object from one namespace must not affect the others
+	deleted, err := oi.DeleteByByteNS(values, obj)
+	if err != nil || !deleted {
+		t.Fatalf("Expected object to be removed from namespace %d: %v", values, err)
+	}
+	if _, err := oi.GetPtrFromByteNS(values, obj); err == nil {
+		t.Fatal("Object should have been removed from its namespace")
+	}
+	if addr, err := oi.GetPtrFromByte(obj); err != nil || addr != defaultAddr {
+		t.Fatalf("Expected object to remain in the default namespace at %d, got %d: %v", defaultAddr, addr, err)
+	}
+
+	deleted, err = oi.Delete(nameAddr)
+	if err != nil || deleted {
+		t.Fatalf("Expected reference count of object at %d to be decremented: %v", nameAddr, err)
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Verify failed: ", err)
+	}
+
+	deleted, err = oi.Delete(nameAddr)
+	if err != nil || !deleted {
+		t.Fatalf("Expected object at %d to be removed: %v", nameAddr, err)
+	}
+	if _, err := oi.GetPtrFromByteNS(names, obj); err == nil {
+		t.Fatal("Object should have been removed from its namespace")
+	}
+	if addr, err := oi.GetPtrFromByte(obj); err != nil || addr != defaultAddr {
+		t.Fatalf("Expected object to remain in the default namespace at %d, got %d: %v", defaultAddr, addr, err)
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Verify failed: ", err)
+	}
+}
+
+func TestNamespaceReplaceValue(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	const ns Namespace = 1
+
+	defaultAddr, err := oi.AddOrGet([]byte("after"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	addr, err := oi.AddOrGetNS(ns, []byte("before"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetNS: ", err)
+	}
+
+	// the new value exists in the default namespace, but the object must stay in its own
+	newAddr, err := oi.ReplaceValue(addr, []byte("after"))
+	if err != nil {
+		t.Fatal("Failed to ReplaceValue: ", err)
+	}
+	if newAddr == defaultAddr {
+		t.Fatal("ReplaceValue merged the object into a different namespace")
+	}
+	if found, err := oi.GetPtrFromByteNS(ns, []byte("after")); err != nil || found != newAddr {
+		t.Fatalf("Expected replaced object at %d in its namespace, got %d: %v", newAddr, found, err)
+	}
+	if _, err := oi.GetPtrFromByteNS(ns, []byte("before")); err == nil {
+		t.Fatal("Old value should have been removed from its namespace")
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Verify failed: ", err)
+	}
+}
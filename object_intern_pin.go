@@ -0,0 +1,74 @@
+package goi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPinned is returned when an object pinned by InternConstants would be removed or
+// modified, for example by deleting its final reference.
+var ErrPinned = errors.New("Object is pinned")
+
+// InternConstants interns every object in consts, pins it and returns the addresses in the
+// same order as consts and nil. Pinned objects are never removed by the Delete methods, Expire
+// or FreeDeadBatch, and never moved by Compact, ReplaceValue or Recompress, so their addresses
+// stay the same and AddOrGet of the same bytes always returns them. This allows comparing
+// interned objects against well-known constants by address. Recompress returns ErrPinned
+// while any object is pinned.
+//
+// Every constant takes one reference of its own. Deleting the final reference of a pinned
+// object leaves it interned and returns ErrPinned. Reset, ResetAndTrim, DeleteAll and Close
+// still remove pinned objects.
+//
+// On failure it returns nil and an error, constants interned before the failing one stay pinned.
+func (oi *ObjectIntern) InternConstants(consts [][]byte) ([]uintptr, error) {
+	if oi.Frozen() {
+		return nil, ErrFrozen
+	}
+
+	objs := make([][]byte, len(consts))
+	for idx, obj := range consts {
+		obj = oi.normalize(obj)
+		if len(obj) == 0 {
+			return nil, ErrEmptyObject
+		}
+		if oi.conf.Compression != None {
+			obj = oi.compress(obj)
+		}
+		// objects which are not indexed can't be found by AddOrGet
+		if oi.conf.MaxInternLen > 0 && len(obj) > oi.conf.MaxInternLen {
+			return nil, fmt.Errorf("Constant %d is longer than MaxInternLen", idx)
+		}
+		objs[idx] = obj
+	}
+
+	oi.lock()
+	defer oi.Unlock()
+
+	addrs := make([]uintptr, len(objs))
+	for idx, obj := range objs {
+		addr, ok := oi.getAndIncrement(obj)
+		if !ok {
+			var err error
+			// add copies obj into the object store
+			addr, err = oi.add(obj)
+			if err != nil {
+				return nil, fmt.Errorf("Could not intern constant %d: %s", idx, err)
+			}
+		}
+
+		oi.pinned[addr] = struct{}{}
+		// pinned objects never expire
+		delete(oi.deadlines, addr)
+		addrs[idx] = addr
+	}
+	return addrs, nil
+}
+
+// isPinned returns true if the object at objAddr has been pinned by InternConstants.
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) isPinned(objAddr uintptr) bool {
+	_, ok := oi.pinned[objAddr]
+	return ok
+}
@@ -0,0 +1,99 @@
+package goi
+
+import (
+	"testing"
+)
+
+func TestInternConstants(t *testing.T) {
+	testInternConstants(t, false)
+}
+
+func TestInternConstantsCompressed(t *testing.T) {
+	testInternConstants(t, true)
+}
+
+func testInternConstants(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	// one of the constants is already interned
+	existing, err := oi.AddOrGet([]byte("status"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+
+	consts := [][]byte{[]byte("host"), []byte("status"), []byte("region")}
+	addrs, err := oi.InternConstants(consts)
+	if err != nil {
+		t.Fatal("Failed to InternConstants: ", err)
+	}
+	if len(addrs) != len(consts) || addrs[1] != existing {
+		t.Fatalf("Expected the existing object at %d to be pinned, got %v", existing, addrs)
+	}
+
+	for idx, c := range consts {
+		for i := 0; i < 3; i++ {
+			addr, err := oi.AddOrGet(c, true)
+			if err != nil || addr != addrs[idx] {
+				t.Fatalf("Expected %s at %d, got %d: %v", c, addrs[idx], addr, err)
+			}
+		}
+
+		// drop far more references than have been taken
+		for i := 0; i < 10; i++ {
+			if _, err := oi.Delete(addrs[idx]); err != nil && err != ErrPinned {
+				t.Fatal("Failed to Delete: ", err)
+			}
+		}
+		if ok, err := oi.DeleteByByte(c); ok || err != ErrPinned {
+			t.Fatalf("Expected ErrPinned for the final reference of %s, got %t: %v", c, ok, err)
+		}
+
+		str, err := oi.GetStringFromPtr(addrs[idx])
+		if err != nil || str != string(c) {
+			t.Fatalf("Expected %s to stay interned, got %s: %v", c, str, err)
+		}
+		addr, err := oi.AddOrGet(c, true)
+		if err != nil || addr != addrs[idx] {
+			t.Fatalf("Expected %s at %d after deleting it, got %d: %v", c, addrs[idx], addr, err)
+		}
+	}
+
+	oi.DeleteBatch(addrs)
+	if oi.FreeDeadBatch(addrs) != 0 {
+		t.Fatal("FreeDeadBatch should not remove pinned objects")
+	}
+	if _, err := oi.ReplaceValue(addrs[0], []byte("other")); err != ErrPinned {
+		t.Fatalf("Expected ErrPinned from ReplaceValue, got %v", err)
+	}
+	mode := Shoco
+	if compress {
+		mode = None
+	}
+	if err := oi.Recompress(mode); err != ErrPinned {
+		t.Fatalf("Expected ErrPinned from Recompress, got %v", err)
+	}
+	for idx, c := range consts {
+		addr, err := oi.GetPtrFromByte(c)
+		if err != nil || addr != addrs[idx] {
+			t.Fatalf("Expected %s at %d after Recompress, got %d: %v", c, addrs[idx], addr, err)
+		}
+	}
+	if oi.Count() != len(consts) {
+		t.Fatalf("Expected %d objects, got %d", len(consts), oi.Count())
+	}
+
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	if len(oi.pinned) != 0 {
+		t.Fatalf("Expected Reset to remove the pinned objects, got %d", len(oi.pinned))
+	}
+
+	if _, err := oi.InternConstants([][]byte{[]byte("ok"), nil}); err != ErrEmptyObject {
+		t.Fatalf("Expected ErrEmptyObject, got %v", err)
+	}
+}
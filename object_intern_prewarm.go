@@ -0,0 +1,136 @@
+package goi
+
+// prewarm maps PrewarmSlabs slabs for every object size in PrewarmSizes. The object store
+// unmaps a slab as soon as its last object is deleted, so a single placeholder object is
+// kept in every slab and all other slots are freed again. The placeholders are never
+// indexed, they are only deleted by clear.
+//
+// The default object store fills its slabs one after another, so every SlabSize
+// consecutive objects share a slab.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) prewarm() error {
+	perSlab := int(oi.conf.SlabSize)
+	for _, size := range oi.conf.PrewarmSizes {
+		// the placeholders are all zeroes, just like a freed slot
+		obj := make([]byte, size)
+
+		addrs := make([]uintptr, 0, perSlab*oi.conf.PrewarmSlabs)
+		for i := 0; i < cap(addrs); i++ {
+			addr, err := oi.storeAdd(obj)
+			if err != nil {
+				return err
+			}
+			addrs = append(addrs, addr)
+		}
+
+		for i, addr := range addrs {
+			if i%perSlab == 0 {
+				oi.placeholders = append(oi.placeholders, addr)
+				continue
+			}
+			if _, err := oi.storeDelete(addr, size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// grow maps the additional slabs GrowSlabs asks for after the pool of objects of objSize
+// bytes has grown from before to after bytes by allocating a slab. Just like prewarm, it
+// keeps a placeholder object in every additional slab, and in the slab which has just been
+// allocated, so none of them is unmapped when the pool drains. All other slots of these
+// slabs are freed again.
+//
+// Growing is only a hint, so if the object store fails to add an object it stops growing.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) grow(objSize uint8, before, after uint64) {
+	slabs := int(after / (after - before))
+	n := oi.conf.GrowSlabs(objSize, slabs)
+
+	obj := make([]byte, objSize)
+	var fillers []uintptr
+	// the first object shares the slab which has just been allocated, unless it is full
+	placeholder := true
+	for grown := 0; placeholder || grown < n; {
+		mem := oi.poolMem(objSize)
+		addr, err := oi.store.Add(obj)
+		if err != nil {
+			break
+		}
+		if oi.poolMem(objSize) > mem {
+			oi.slabAllocs++
+			grown++
+			placeholder = true
+		}
+
+		if placeholder {
+			oi.placeholders = append(oi.placeholders, addr)
+			placeholder = false
+			continue
+		}
+		fillers = append(fillers, addr)
+	}
+
+	// every filler shares its slab with a placeholder
+	for _, addr := range fillers {
+		oi.store.Delete(addr)
+	}
+}
+
+// reserveSlabs makes room for n more objects of objSize bytes in an object store which
+// doesn't implement Reserver. It adds objects until n of them have been stored without
+// allocating a slab. Just like grow, it keeps a placeholder object in every slab allocated
+// meanwhile, so it isn't unmapped again, and frees all other objects. Free slots which
+// already existed count towards n.
+//
+// Reserving is only a hint, so if the object store fails to add an object it stops.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) reserveSlabs(objSize uint8, n int) {
+	obj := make([]byte, objSize)
+	fillers := make([]uintptr, 0, n)
+	for len(fillers) < n {
+		mem := oi.poolMem(objSize)
+		addr, err := oi.store.Add(obj)
+		if err != nil {
+			break
+		}
+		if oi.poolMem(objSize) > mem {
+			oi.slabAllocs++
+			oi.placeholders = append(oi.placeholders, addr)
+			continue
+		}
+		fillers = append(fillers, addr)
+	}
+
+	for _, addr := range fillers {
+		oi.store.Delete(addr)
+	}
+}
+
+// GrowDoubling is a GrowSlabs func which doubles the number of slabs of a pool whenever it is full.
+func GrowDoubling(objSize uint8, slabs int) int {
+	return slabs
+}
+
+// freePlaceholders deletes the placeholder objects added by prewarm, grow and reserveSlabs from
+// the object store.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) freePlaceholders() error {
+	for len(oi.placeholders) > 0 {
+		obj, err := oi.store.Get(oi.placeholders[0])
+		if err != nil {
+			return err
+		}
+		if _, err := oi.storeDelete(oi.placeholders[0], uint8(len(obj))); err != nil {
+			return err
+		}
+		oi.placeholders = oi.placeholders[1:]
+	}
+	oi.placeholders = nil
+	return nil
+}
@@ -0,0 +1,179 @@
+package goi
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPrewarm(t *testing.T) {
+	cnf := NewConfig()
+	cnf.SlabSize = 10
+	cnf.PrewarmSizes = []uint8{8, 20}
+	cnf.PrewarmSlabs = 2
+	oi := NewObjectIntern(cnf)
+
+	mem := make(map[uint8]uint64)
+	for _, size := range cnf.PrewarmSizes {
+		m, err := oi.MemStatsByObjSize(size)
+		if err != nil || m == 0 {
+			t.Fatalf("Expected the pool of size %d to be prewarmed, got %d bytes: %v", size, m, err)
+		}
+		mem[size] = m
+	}
+	if oi.Count() != 0 {
+		t.Fatalf("Expected the placeholders not to be counted, got %d objects", oi.Count())
+	}
+
+	// 4 bytes of reference count + 4 bytes of data fit the pool of size 8, filling both
+	// prewarmed slabs except for their placeholders must not map another slab
+	var addrs []uintptr
+	for i := 0; i < 2*(int(cnf.SlabSize)-1); i++ {
+		addr, err := oi.AddOrGet([]byte{'a', 'b', 'c', byte('a' + i)}, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if m, _ := oi.MemStatsByObjSize(8); m != mem[8] {
+		t.Fatalf("Expected the prewarmed slabs to be used, memory grew from %d to %d", mem[8], m)
+	}
+
+	// deleting every object keeps the slabs mapped
+	for _, addr := range addrs {
+		if _, err := oi.Delete(addr); err != nil {
+			t.Fatal("Failed to Delete: ", err)
+		}
+	}
+	if m, _ := oi.MemStatsByObjSize(8); m != mem[8] {
+		t.Fatalf("Expected the prewarmed slabs to stay mapped, got %d bytes instead of %d", m, mem[8])
+	}
+
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	for _, size := range cnf.PrewarmSizes {
+		if m, err := oi.MemStatsByObjSize(size); err != nil || m != mem[size] {
+			t.Fatalf("Expected the pool of size %d to be prewarmed after Reset, got %d bytes: %v", size, m, err)
+		}
+	}
+
+	if err := oi.ResetAndTrim(); err != nil {
+		t.Fatal("Failed to ResetAndTrim: ", err)
+	}
+	for _, size := range cnf.PrewarmSizes {
+		if m, err := oi.MemStatsByObjSize(size); err != nil || m != mem[size] {
+			t.Fatalf("Expected the pool of size %d to be prewarmed after ResetAndTrim, got %d bytes: %v", size, m, err)
+		}
+	}
+
+	if err := oi.Close(); err != nil {
+		t.Fatal("Failed to Close: ", err)
+	}
+}
+
+func TestPrewarmInvalid(t *testing.T) {
+	cnf := NewConfig()
+	cnf.PrewarmSizes = []uint8{8, 0}
+	if _, err := NewObjectInternE(cnf); err == nil {
+		t.Fatal("Expected an error for a prewarm size of 0")
+	}
+
+	cnf = NewConfig()
+	cnf.PrewarmSlabs = -1
+	if _, err := NewObjectInternE(cnf); err == nil {
+		t.Fatal("Expected an error for a negative number of prewarmed slabs")
+	}
+}
+
+func TestGrowSlabs(t *testing.T) {
+	cnf := NewConfig()
+	cnf.SlabSize = 10
+	cnf.GrowSlabs = GrowDoubling
+	oi := NewObjectIntern(cnf)
+
+	burst := func() []uintptr {
+		addrs := make([]uintptr, 0, 11)
+		for i := 0; i < cap(addrs); i++ {
+			addr, err := oi.AddOrGet([]byte(fmt.Sprintf("grow%02d", i)), true)
+			if err != nil {
+				t.Fatal("Failed to AddOrGet: ", err)
+			}
+			addrs = append(addrs, addr)
+		}
+		return addrs
+	}
+
+	// the first object allocates a slab, which is doubled to 2 slabs
+	addrs := burst()
+	if allocs, _ := oi.SlabChurn(); allocs != 2 {
+		t.Fatalf("Expected 2 slabs to be allocated, got %d", allocs)
+	}
+	for i, addr := range addrs {
+		if str, err := oi.GetStringFromPtr(addr); err != nil || str != fmt.Sprintf("grow%02d", i) {
+			t.Fatalf("Expected grow%02d, got %s: %v", i, str, err)
+		}
+	}
+	if oi.Count() != len(addrs) {
+		t.Fatalf("Expected the placeholders not to be counted, got %d objects", oi.Count())
+	}
+	mem, err := oi.MemStatsByObjSize(uint8(len("grow00") + 4))
+	if err != nil {
+		t.Fatal("Failed to MemStatsByObjSize: ", err)
+	}
+
+	for _, addr := range addrs {
+		if _, err := oi.Delete(addr); err != nil {
+			t.Fatal("Failed to Delete: ", err)
+		}
+	}
+	if m, _ := oi.MemStatsByObjSize(uint8(len("grow00") + 4)); m != mem {
+		t.Fatalf("Expected the pool to stay at %d bytes, got %d", mem, m)
+	}
+
+	// the slabs stay mapped and take the next burst
+	burst()
+	if allocs, frees := oi.SlabChurn(); allocs != 2 || frees != 0 {
+		t.Fatalf("Expected the next burst to reuse the slabs, got %d allocs and %d frees", allocs, frees)
+	}
+
+	if err := oi.Close(); err != nil {
+		t.Fatal("Failed to Close: ", err)
+	}
+}
+
+func BenchmarkBurstInsert(b *testing.B) {
+	benchmarks := []struct {
+		name      string
+		growSlabs func(objSize uint8, slabs int) int
+	}{
+		{"Default", nil},
+		{"Doubling", GrowDoubling},
+	}
+	data := make([][]byte, 1000)
+	for i := range data {
+		data[i] = []byte(fmt.Sprintf("burst%06d", i))
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			cnf := NewConfig()
+			cnf.GrowSlabs = bm.growSlabs
+			oi := NewObjectIntern(cnf)
+
+			addrs := make([]uintptr, len(data))
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			// every iteration is a burst of inserts which is drained again
+			for i := 0; i < b.N; i++ {
+				for idx, obj := range data {
+					addrs[idx], _ = oi.AddOrGet(obj, false)
+				}
+				oi.DeleteBatch(addrs)
+			}
+
+			allocs, _ := oi.SlabChurn()
+			b.ReportMetric(float64(allocs)/float64(b.N), "slabs/op")
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package goi
+
+import (
+	"container/heap"
+	"sync/atomic"
+	"unsafe"
+)
+
+// InternedObject is a copy of an interned object together with its address and reference count
+type InternedObject struct {
+	Addr   uintptr
+	Value  []byte // decompressed copy, it does not reference the object store
+	RefCnt uint32
+}
+
+// refCntHeap is a heap of objects ordered by reference count. If top is set the object
+// with the lowest reference count is at the root, so it is the first to be replaced when
+// collecting the objects with the highest reference counts, and vice versa.
+type refCntHeap struct {
+	objs []InternedObject
+	top  bool
+}
+
+func (h *refCntHeap) Len() int { return len(h.objs) }
+
+func (h *refCntHeap) Less(i, j int) bool {
+	if h.top {
+		return h.objs[i].RefCnt < h.objs[j].RefCnt
+	}
+	return h.objs[i].RefCnt > h.objs[j].RefCnt
+}
+
+func (h *refCntHeap) Swap(i, j int) { h.objs[i], h.objs[j] = h.objs[j], h.objs[i] }
+
+func (h *refCntHeap) Push(x interface{}) { h.objs = append(h.objs, x.(InternedObject)) }
+
+func (h *refCntHeap) Pop() interface{} {
+	obj := h.objs[len(h.objs)-1]
+	h.objs = h.objs[:len(h.objs)-1]
+	return obj
+}
+
+// TopByRefCnt returns the n interned objects with the highest reference counts, ordered
+// from the highest to the lowest reference count. If fewer than n objects are interned
+// all of them are returned. Objects with equal reference counts are returned in no
+// particular order.
+//
+// If reference counting is turned off every object is treated as referenced once.
+//
+// This method iterates over every object in the index while holding the read lock, but only
+// keeps the n objects found so far in a heap, so its cost is O(count * log n). Only the values
+// of the returned objects are copied.
+func (oi *ObjectIntern) TopByRefCnt(n int) []InternedObject {
+	return oi.byRefCnt(n, true)
+}
+
+// BottomByRefCnt is the same as TopByRefCnt, but returns the n interned objects with the
+// lowest reference counts, ordered from the lowest to the highest reference count.
+func (oi *ObjectIntern) BottomByRefCnt(n int) []InternedObject {
+	return oi.byRefCnt(n, false)
+}
+
+// byRefCnt does the work of TopByRefCnt and BottomByRefCnt
+func (oi *ObjectIntern) byRefCnt(n int, top bool) []InternedObject {
+	if n <= 0 {
+		return nil
+	}
+
+	oi.RLock()
+	defer oi.RUnlock()
+
+	h := &refCntHeap{top: top}
+	oi.indexRange(func(addr uintptr) bool {
+		refCnt := uint32(1)
+		if !oi.conf.DisableRefCounting {
+			refCnt = atomic.LoadUint32((*uint32)(unsafe.Pointer(addr)))
+		}
+
+		obj := InternedObject{Addr: addr, RefCnt: refCnt}
+		if h.Len() < n {
+			heap.Push(h, obj)
+		} else if top && refCnt > h.objs[0].RefCnt || !top && refCnt < h.objs[0].RefCnt {
+			h.objs[0] = obj
+			heap.Fix(h, 0)
+		}
+		return true
+	})
+
+	// the root is the last object to be returned, so fill the result from the back
+	objs := make([]InternedObject, h.Len())
+	for i := len(objs) - 1; i >= 0; i-- {
+		obj := heap.Pop(h).(InternedObject)
+
+		b, err := oi.store.Get(obj.Addr)
+		if err == nil {
+			b, err = oi.decompress(b[oi.refCntSize:])
+		}
+		if err == nil {
+			obj.Value = append([]byte(nil), b...)
+		}
+		objs[i] = obj
+	}
+
+	return objs
+}
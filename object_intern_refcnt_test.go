@@ -0,0 +1,80 @@
+package goi
+
+import (
+	"testing"
+)
+
+func TestByRefCnt(t *testing.T) {
+	testByRefCnt(t, false)
+}
+
+func TestByRefCntCompressed(t *testing.T) {
+	testByRefCnt(t, true)
+}
+
+func testByRefCnt(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	if objs := oi.TopByRefCnt(3); len(objs) != 0 {
+		t.Fatalf("Expected no objects, got %v", objs)
+	}
+
+	// object i is referenced i+1 times
+	for i, b := range testBytes {
+		for n := 0; n <= i; n++ {
+			if _, err := oi.AddOrGet(b, true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", b)
+			}
+		}
+	}
+
+	top := oi.TopByRefCnt(3)
+	if len(top) != 3 {
+		t.Fatalf("Expected 3 objects, got %d", len(top))
+	}
+	for i, obj := range top {
+		idx := len(testBytes) - 1 - i
+		if obj.RefCnt != uint32(idx+1) || string(obj.Value) != testStrings[idx] {
+			t.Fatalf("Expected %q with reference count %d at position %d, got %q with %d", testStrings[idx], idx+1, i, obj.Value, obj.RefCnt)
+		}
+		if addr, err := oi.GetPtrFromByte(testBytes[idx]); err != nil || addr != obj.Addr {
+			t.Fatalf("Expected address %d for %q, got %d: %v", addr, obj.Value, obj.Addr, err)
+		}
+	}
+
+	bottom := oi.BottomByRefCnt(4)
+	if len(bottom) != 4 {
+		t.Fatalf("Expected 4 objects, got %d", len(bottom))
+	}
+	for i, obj := range bottom {
+		if obj.RefCnt != uint32(i+1) || string(obj.Value) != testStrings[i] {
+			t.Fatalf("Expected %q with reference count %d at position %d, got %q with %d", testStrings[i], i+1, i, obj.Value, obj.RefCnt)
+		}
+	}
+
+	// asking for more objects than there are returns all of them
+	all := oi.TopByRefCnt(100)
+	if len(all) != len(testBytes) {
+		t.Fatalf("Expected %d objects, got %d", len(testBytes), len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i-1].RefCnt < all[i].RefCnt {
+			t.Fatalf("Objects are not ordered by reference count: %d before %d", all[i-1].RefCnt, all[i].RefCnt)
+		}
+	}
+
+	// the values are copies
+	all[0].Value[0] = 'X'
+	str, err := oi.GetStringFromPtr(all[0].Addr)
+	if err != nil || str != testStrings[len(testStrings)-1] {
+		t.Fatalf("Modifying a returned value changed the interned object to %q: %v", str, err)
+	}
+
+	if objs := oi.BottomByRefCnt(0); objs != nil {
+		t.Fatalf("Expected nil for n = 0, got %v", objs)
+	}
+}
@@ -0,0 +1,288 @@
+package goi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"unsafe"
+)
+
+// snapshotMagic identifies the beginning of a snapshot written by WriteTo or WriteHot
+var snapshotMagic = [4]byte{'G', 'O', 'I', 'S'}
+
+// snapshotVersion is the version of the snapshot format
+const snapshotVersion uint8 = 2
+
+// A snapshot consists of a header followed by any number of records until EOF.
+//
+// The header is made of the 4 magic bytes, 1 byte for the format version, 1 byte for
+// the compression the objects have been stored with and the 8 byte little endian hash
+// of the shoco model they have been compressed with, see shocoModelSum.
+//
+// Every record consists of the 4 byte little endian reference count, 1 byte for the
+// length of the object and the object itself, exactly as it is stored in the object
+// store (compressed if compression is turned on), excluding the reference count.
+
+// WriteTo writes a snapshot of all interned objects and their reference counts to w.
+// It returns the number of bytes written and nil on success.
+// On failure it returns the number of bytes written so far and an error.
+//
+// The read lock is held while writing to w.
+func (oi *ObjectIntern) WriteTo(w io.Writer) (int64, error) {
+	return oi.WriteHot(w, 0)
+}
+
+// WriteHot is the same as WriteTo, but it only writes objects whose reference count is
+// at least minRefCnt. This keeps snapshots small when only frequently referenced
+// objects are of interest, for example to warm up a new process.
+// The resulting snapshot can be loaded with ReadFrom.
+func (oi *ObjectIntern) WriteHot(w io.Writer, minRefCnt uint32) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	bw.Write(snapshotMagic[:])
+	bw.WriteByte(snapshotVersion)
+	bw.WriteByte(byte(oi.conf.Compression))
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], oi.modelSum)
+	bw.Write(sum[:])
+
+	var err error
+
+	oi.RLock()
+	oi.indexRange(func(addr uintptr) bool {
+		var b []byte
+		b, err = oi.store.Get(addr)
+		if err != nil {
+			return false
+		}
+
+		// without reference counting every object is treated as referenced once
+		refCnt := uint32(1)
+		if !oi.conf.DisableRefCounting {
+			refCnt = atomic.LoadUint32((*uint32)(unsafe.Pointer(addr)))
+		}
+		if refCnt < minRefCnt {
+			return true
+		}
+
+		// the first 4 bytes may already be the reference count, but we read it atomically above
+		var record [5]byte
+		binary.LittleEndian.PutUint32(record[:4], refCnt)
+		record[4] = uint8(len(b) - int(oi.refCntSize))
+		bw.Write(record[:])
+		_, err = bw.Write(b[oi.refCntSize:])
+		return err == nil
+	})
+	oi.RUnlock()
+
+	if err != nil {
+		return cw.n, err
+	}
+
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// ReadFrom loads a snapshot written by WriteTo or WriteHot from r.
+// Objects which are not interned yet are added with the reference count recorded
+// in the snapshot, the recorded reference count of objects which already exist is
+// added to their current reference count.
+// It returns the number of bytes read and nil on success.
+// On failure it returns the number of bytes read so far and an error, objects
+// which have been loaded up to that point remain interned. A record of an empty
+// object is invalid, it fails with ErrEmptyObject.
+//
+// The snapshot must have been written by a table using the same compression and, if
+// compression is turned on, the same ShocoModel. Otherwise ErrCompressionMismatch is
+// returned before any objects are loaded.
+func (oi *ObjectIntern) ReadFrom(r io.Reader) (int64, error) {
+	if oi.Frozen() {
+		return 0, ErrFrozen
+	}
+
+	cr := &countingReader{r: bufio.NewReader(r)}
+
+	var header [14]byte
+	if _, err := io.ReadFull(cr, header[:]); err != nil {
+		return cr.n, err
+	}
+	if [4]byte{header[0], header[1], header[2], header[3]} != snapshotMagic {
+		return cr.n, fmt.Errorf("Snapshot header not recognized")
+	}
+	if header[4] != snapshotVersion {
+		return cr.n, fmt.Errorf("Snapshot version %d not supported", header[4])
+	}
+	if !oi.sameCompression(Compression(header[5]), binary.LittleEndian.Uint64(header[6:])) {
+		return cr.n, ErrCompressionMismatch
+	}
+
+	var record [5]byte
+	obj := make([]byte, 0, 255)
+	for {
+		_, err := io.ReadFull(cr, record[:])
+		if err == io.EOF {
+			return cr.n, nil
+		}
+		if err != nil {
+			return cr.n, err
+		}
+
+		refCnt := binary.LittleEndian.Uint32(record[:4])
+		obj = obj[:record[4]]
+		if _, err := io.ReadFull(cr, obj); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return cr.n, err
+		}
+
+		if err := oi.load(obj, refCnt); err != nil {
+			return cr.n, err
+		}
+	}
+}
+
+// Merge interns every object of other into oi. Objects which are not interned in oi yet
+// are added with their reference count in other, the reference count of objects which
+// already exist is increased by their reference count in other. Every object stays in its
+// namespace. It returns nil on success.
+// On failure it returns an error, objects which have been merged up to that point remain
+// interned.
+//
+// Both tables must use the same compression and, if compression is turned on, the same
+// ShocoModel. Otherwise ErrCompressionMismatch is returned before any objects are merged. other is not modified, but its addresses are not valid
+// in oi, use GetPtrFromByte to look up the merged objects.
+//
+// The objects of other are copied while holding its read lock, then merged into oi
+// without holding it, so two tables can be merged into each other concurrently.
+func (oi *ObjectIntern) Merge(other *ObjectIntern) error {
+	if oi.Frozen() {
+		return ErrFrozen
+	}
+
+	if other == oi {
+		return fmt.Errorf("Cannot merge an ObjectIntern into itself")
+	}
+	if !oi.sameCompression(other.conf.Compression, other.modelSum) {
+		return ErrCompressionMismatch
+	}
+
+	type record struct {
+		ns     Namespace
+		refCnt uint32
+		obj    []byte
+	}
+
+	var err error
+
+	other.RLock()
+	records := make([]record, 0, other.indexLen()+len(other.unindexed))
+	other.indexRange(func(addr uintptr) bool {
+		var b []byte
+		b, err = other.store.Get(addr)
+		if err != nil {
+			return false
+		}
+
+		// without reference counting every object is treated as referenced once
+		refCnt := uint32(1)
+		if !other.conf.DisableRefCounting {
+			refCnt = atomic.LoadUint32((*uint32)(unsafe.Pointer(addr)))
+		}
+
+		records = append(records, record{
+			ns:     other.namespaceOf(addr),
+			refCnt: refCnt,
+			obj:    append([]byte(nil), b[other.refCntSize:]...),
+		})
+		return true
+	})
+	other.RUnlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		if err := oi.loadNS(r.ns, r.obj, r.refCnt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameCompression returns true if objects stored with compression c by a shoco model
+// whose hash is modelSum can be used by oi as they are.
+func (oi *ObjectIntern) sameCompression(c Compression, modelSum uint64) bool {
+	if c != oi.conf.Compression {
+		return false
+	}
+	// without compression the model is never used
+	return c == None || modelSum == oi.modelSum
+}
+
+// load interns obj, which is already in its stored form, and adds refCnt to its reference count.
+// If reference counting is turned off refCnt is only used to skip unreferenced objects.
+func (oi *ObjectIntern) load(obj []byte, refCnt uint32) error {
+	return oi.loadNS(DefaultNamespace, obj, refCnt)
+}
+
+// loadNS is the same as load, but interns obj in the namespace ns
+func (oi *ObjectIntern) loadNS(ns Namespace, obj []byte, refCnt uint32) error {
+	// empty objects are never interned
+	if len(obj) == 0 {
+		return ErrEmptyObject
+	}
+	if refCnt == 0 {
+		return nil
+	}
+
+	oi.Lock()
+	defer oi.Unlock()
+
+	if addr, ok := oi.indexGetNS(ns, obj); ok {
+		if oi.conf.DisableRefCounting {
+			return nil
+		}
+		atomic.AddUint32((*uint32)(unsafe.Pointer(addr)), refCnt)
+		return nil
+	}
+
+	// add copies obj, so it is safe to reuse it afterwards
+	addr, err := oi.addNS(ns, obj)
+	if err != nil {
+		return err
+	}
+	if oi.conf.DisableRefCounting {
+		return nil
+	}
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(addr)), refCnt)
+	return nil
+}
+
+// countingWriter counts the number of bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the number of bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,362 @@
+package goi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tmthrgd/shoco"
+)
+
+func TestSnapshot(t *testing.T) {
+	testSnapshot(t, false)
+}
+
+func TestSnapshotCompressed(t *testing.T) {
+	testSnapshot(t, true)
+}
+
+func testSnapshot(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	// object at index i gets a reference count of i+1
+	for idx, b := range testBytes {
+		for i := 0; i <= idx; i++ {
+			if _, err := oi.AddOrGet(b, true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", b)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := oi.WriteTo(&buf)
+	if err != nil {
+		t.Fatal("Failed to WriteTo: ", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo reported %d bytes, but wrote %d", n, buf.Len())
+	}
+
+	written := n
+	oi2 := NewObjectIntern(c)
+	n, err = oi2.ReadFrom(&buf)
+	if err != nil {
+		t.Fatal("Failed to ReadFrom: ", err)
+	}
+	if n != written {
+		t.Fatalf("ReadFrom read %d bytes, but %d were written", n, written)
+	}
+
+	for idx, b := range testBytes {
+		addr, err := oi2.GetPtrFromByte(b)
+		if err != nil {
+			t.Fatal("Object missing after loading snapshot: ", string(b))
+		}
+		sz, err := oi2.GetStringFromPtr(addr)
+		if err != nil || sz != testStrings[idx] {
+			t.Fatalf("Expected: %s\nActual: %s\n", testStrings[idx], sz)
+		}
+		rc, err := oi2.RefCnt(addr)
+		if err != nil || rc != uint32(idx+1) {
+			t.Fatalf("Reference count should be %d, instead found %d", idx+1, rc)
+		}
+	}
+}
+
+func TestWriteHot(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	// object at index i gets a reference count of i+1
+	for idx, b := range testBytes {
+		for i := 0; i <= idx; i++ {
+			if _, err := oi.AddOrGet(b, true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", b)
+			}
+		}
+	}
+
+	var full, hot bytes.Buffer
+	if _, err := oi.WriteTo(&full); err != nil {
+		t.Fatal("Failed to WriteTo: ", err)
+	}
+	const minRefCnt = 5
+	if _, err := oi.WriteHot(&hot, minRefCnt); err != nil {
+		t.Fatal("Failed to WriteHot: ", err)
+	}
+	if hot.Len() >= full.Len() {
+		t.Fatalf("Hot snapshot (%d bytes) should be smaller than full snapshot (%d bytes)", hot.Len(), full.Len())
+	}
+
+	oi2 := NewObjectIntern(NewConfig())
+	if _, err := oi2.ReadFrom(&hot); err != nil {
+		t.Fatal("Failed to ReadFrom: ", err)
+	}
+
+	if len(oi2.objIndex) != len(testBytes)-minRefCnt+1 {
+		t.Fatalf("Expected %d objects, instead found %d", len(testBytes)-minRefCnt+1, len(oi2.objIndex))
+	}
+	for idx, b := range testBytes {
+		_, err := oi2.GetPtrFromByte(b)
+		if idx+1 < minRefCnt && err == nil {
+			t.Fatal("Object below the threshold should have been excluded: ", string(b))
+		}
+		if idx+1 >= minRefCnt && err != nil {
+			t.Fatal("Object above the threshold should have been included: ", string(b))
+		}
+	}
+}
+
+func TestReadFromInvalid(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	if _, err := oi.ReadFrom(bytes.NewReader([]byte("nope!"))); err == nil {
+		t.Fatal("ReadFrom should fail for an unknown header")
+	}
+
+	var buf bytes.Buffer
+	oi.AddOrGet(testBytes[0], true)
+	oi.WriteTo(&buf)
+	truncated := buf.Bytes()[:buf.Len()-1]
+
+	oi2 := NewObjectIntern(NewConfig())
+	if _, err := oi2.ReadFrom(bytes.NewReader(truncated)); err == nil {
+		t.Fatal("ReadFrom should fail for a truncated snapshot")
+	}
+}
+
+func TestReadFromEmptyObject(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+	var buf bytes.Buffer
+	if _, err := oi.WriteTo(&buf); err != nil {
+		t.Fatal("Failed to WriteTo: ", err)
+	}
+	// a reference count of 1 followed by a length of 0
+	buf.Write([]byte{1, 0, 0, 0, 0})
+
+	oi2 := NewObjectIntern(NewConfig())
+	if _, err := oi2.ReadFrom(&buf); err != ErrEmptyObject {
+		t.Fatal("ReadFrom should return ErrEmptyObject, instead got: ", err)
+	}
+	if count := oi2.Count(); count != 0 {
+		t.Fatalf("No objects should have been loaded, instead found %d", count)
+	}
+}
+
+func TestReadFromCompressionMismatch(t *testing.T) {
+	compressed := NewConfig()
+	compressed.Compression = Shoco
+	uncompressed := NewConfig()
+
+	for _, cnfs := range [][2]ObjectInternConfig{{compressed, uncompressed}, {uncompressed, compressed}} {
+		oi := NewObjectIntern(cnfs[0])
+		for _, b := range testBytes {
+			if _, err := oi.AddOrGet(b, true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", b)
+			}
+		}
+
+		var buf bytes.Buffer
+		if _, err := oi.WriteTo(&buf); err != nil {
+			t.Fatal("Failed to WriteTo: ", err)
+		}
+
+		oi2 := NewObjectIntern(cnfs[1])
+		if _, err := oi2.ReadFrom(&buf); err != ErrCompressionMismatch {
+			t.Fatal("ReadFrom should return ErrCompressionMismatch, instead got: ", err)
+		}
+		if len(oi2.objIndex) != 0 {
+			t.Fatalf("No objects should have been loaded, instead found %d", len(oi2.objIndex))
+		}
+	}
+}
+
+func TestReadFromModelMismatch(t *testing.T) {
+	model, err := MarshalShocoModel(shoco.FilePathModel)
+	if err != nil {
+		t.Fatal("Failed to MarshalShocoModel: ", err)
+	}
+	paths := NewConfig()
+	paths.Compression = Shoco
+	paths.ShocoModel = model
+	words := NewConfig()
+	words.Compression = Shoco
+
+	oi := NewObjectIntern(paths)
+	if _, err := oi.AddOrGet([]byte("/usr/local/lib/python/site-packages"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	var buf bytes.Buffer
+	if _, err := oi.WriteTo(&buf); err != nil {
+		t.Fatal("Failed to WriteTo: ", err)
+	}
+	snapshot := buf.Bytes()
+
+	oi2 := NewObjectIntern(words)
+	if _, err := oi2.ReadFrom(bytes.NewReader(snapshot)); err != ErrCompressionMismatch {
+		t.Fatal("ReadFrom should return ErrCompressionMismatch, instead got: ", err)
+	}
+	if count := oi2.Count(); count != 0 {
+		t.Fatalf("No objects should have been loaded, instead found %d", count)
+	}
+
+	// a table parsing the same model reads the snapshot
+	oi3 := NewObjectIntern(paths)
+	if _, err := oi3.ReadFrom(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal("Failed to ReadFrom: ", err)
+	}
+	if _, err := oi3.GetPtrFromByte([]byte("/usr/local/lib/python/site-packages")); err != nil {
+		t.Fatal("Expected the object to be loaded: ", err)
+	}
+
+	// without compression the model doesn't matter
+	paths.Compression = None
+	words.Compression = None
+	buf.Reset()
+	if _, err := NewObjectIntern(paths).WriteTo(&buf); err != nil {
+		t.Fatal("Failed to WriteTo: ", err)
+	}
+	if _, err := NewObjectIntern(words).ReadFrom(&buf); err != nil {
+		t.Fatal("Failed to ReadFrom: ", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	testMerge(t, false)
+}
+
+func TestMergeCompressed(t *testing.T) {
+	testMerge(t, true)
+}
+
+func testMerge(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+	other := NewObjectIntern(c)
+
+	// oi references the first 6 objects once, other references the last 7 objects twice
+	expected := make(map[string]uint32)
+	for _, b := range testBytes[:6] {
+		if _, err := oi.AddOrGet(b, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		expected[string(b)]++
+	}
+	for _, b := range testBytes[3:] {
+		for i := 0; i < 2; i++ {
+			if _, err := other.AddOrGet(b, true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", b)
+			}
+			expected[string(b)]++
+		}
+	}
+
+	const ns Namespace = 1
+	if _, err := other.AddOrGetNS(ns, []byte("server"), true); err != nil {
+		t.Fatal("Failed to AddOrGetNS: ", err)
+	}
+
+	if err := oi.Merge(other); err != nil {
+		t.Fatal("Failed to Merge: ", err)
+	}
+
+	for obj, refCnt := range expected {
+		addr, err := oi.GetPtrFromByte([]byte(obj))
+		if err != nil {
+			t.Fatalf("Object %q missing after Merge: %v", obj, err)
+		}
+		if rc, err := oi.RefCnt(addr); err != nil || rc != refCnt {
+			t.Fatalf("Expected reference count %d for %q, got %d: %v", refCnt, obj, rc, err)
+		}
+	}
+	if count := oi.Count(); count != len(expected)+1 {
+		t.Fatalf("Expected %d objects after Merge, got %d", len(expected)+1, count)
+	}
+
+	// the namespaced object is merged into its namespace, not into the default one
+	addr, err := oi.GetPtrFromByteNS(ns, []byte("server"))
+	if err != nil {
+		t.Fatal("Namespaced object missing after Merge: ", err)
+	}
+	if defaultAddr, _ := oi.GetPtrFromByte([]byte("server")); defaultAddr == addr {
+		t.Fatal("Namespaced object was merged into the default namespace")
+	}
+
+	// other is left as it was
+	for _, b := range testBytes[3:] {
+		addr, err := other.GetPtrFromByte(b)
+		if err != nil {
+			t.Fatalf("Object %q missing from the merged table: %v", b, err)
+		}
+		if rc, err := other.RefCnt(addr); err != nil || rc != 2 {
+			t.Fatalf("Expected reference count 2 for %q in the merged table, got %d: %v", b, rc, err)
+		}
+	}
+
+	if err := oi.Merge(oi); err == nil {
+		t.Fatal("Merging an ObjectIntern into itself should fail")
+	}
+}
+
+func TestMergeModelMismatch(t *testing.T) {
+	model, err := MarshalShocoModel(shoco.FilePathModel)
+	if err != nil {
+		t.Fatal("Failed to MarshalShocoModel: ", err)
+	}
+	paths := NewConfig()
+	paths.Compression = Shoco
+	paths.ShocoModel = model
+	words := NewConfig()
+	words.Compression = Shoco
+
+	other := NewObjectIntern(paths)
+	if _, err := other.AddOrGet([]byte("/usr/local/lib/python/site-packages"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+
+	oi := NewObjectIntern(words)
+	if err := oi.Merge(other); err != ErrCompressionMismatch {
+		t.Fatal("Merge should return ErrCompressionMismatch, instead got: ", err)
+	}
+	if count := oi.Count(); count != 0 {
+		t.Fatalf("Expected no objects to be merged, got %d", count)
+	}
+
+	oi = NewObjectIntern(paths)
+	if err := oi.Merge(other); err != nil {
+		t.Fatal("Failed to Merge: ", err)
+	}
+	addr, err := oi.GetPtrFromByte([]byte("/usr/local/lib/python/site-packages"))
+	if err != nil {
+		t.Fatal("Expected the object to be merged: ", err)
+	}
+	if str, err := oi.GetStringFromPtr(addr); err != nil || str != "/usr/local/lib/python/site-packages" {
+		t.Fatalf("Expected the merged object, got %q: %v", str, err)
+	}
+}
+
+func TestMergeCompressionMismatch(t *testing.T) {
+	compressed := NewConfig()
+	compressed.Compression = Shoco
+	uncompressed := NewConfig()
+
+	oi := NewObjectIntern(compressed)
+	other := NewObjectIntern(uncompressed)
+	for _, b := range testBytes {
+		if _, err := other.AddOrGet(b, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+	}
+
+	if err := oi.Merge(other); err != ErrCompressionMismatch {
+		t.Fatal("Merge should return ErrCompressionMismatch, instead got: ", err)
+	}
+	if count := oi.Count(); count != 0 {
+		t.Fatalf("Expected no objects to be merged, got %d", count)
+	}
+}
@@ -0,0 +1,205 @@
+package goi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// MemStatJSON is the JSON representation of the memory used by a single pool of the object store
+type MemStatJSON struct {
+	ObjSize uint8  `json:"obj_size"`
+	MemUsed uint64 `json:"mem_used"`
+}
+
+// FragStatJSON is the JSON representation of the fragmentation of a single pool of the object store
+type FragStatJSON struct {
+	ObjSize     uint8   `json:"obj_size"`
+	ObjsPerSlab uint    `json:"objs_per_slab"`
+	FragPercent float32 `json:"frag_percent"`
+}
+
+// StatsJSON aggregates the stats of an ObjectIntern, it is what MarshalStatsJSON encodes.
+// The pools are sorted by object size. FragTotal is 0 if the object store has no slabs.
+type StatsJSON struct {
+	Count       int            `json:"count"`
+	MemTotal    uint64         `json:"mem_total"`
+	FragTotal   float32        `json:"frag_total"`
+	MemPerPool  []MemStatJSON  `json:"mem_per_pool"`
+	FragPerPool []FragStatJSON `json:"frag_per_pool"`
+}
+
+// Count returns the number of objects in the object store
+func (oi *ObjectIntern) Count() int {
+	oi.RLock()
+	defer oi.RUnlock()
+	return oi.indexLen() + len(oi.unindexed)
+}
+
+// SlabChurn returns the number of slabs the object store has allocated and freed since the
+// ObjectIntern was created, including the slabs of the stores replaced by Reset. High numbers
+// compared to the memory in use mean that objects are added and deleted in a pattern which
+// keeps mapping and unmapping slabs.
+//
+// The object store doesn't report slab allocations, so they are derived from the memory of the
+// pool an object is added to or deleted from. A single add or delete changes at most one slab.
+func (oi *ObjectIntern) SlabChurn() (allocs uint64, frees uint64) {
+	oi.RLock()
+	defer oi.RUnlock()
+	return oi.slabAllocs, oi.slabFrees
+}
+
+// HitStats returns how many times AddOrGet and its variants found an object which was already
+// interned, and how many times they added a new one, since the ObjectIntern was created. A high
+// ratio of hits means interning saves a lot of memory, a low one means most objects are unique
+// and interning them is mostly overhead.
+func (oi *ObjectIntern) HitStats() (hits uint64, misses uint64) {
+	oi.RLock()
+	defer oi.RUnlock()
+	return atomic.LoadUint64(&oi.hits), oi.misses
+}
+
+// Health returns nil if oi is in a usable state. If an operation of the object store has
+// failed unexpectedly, for example deleting an object which is known to be stored, oi may be
+// left in an inconsistent state and it returns an error describing the first such failure.
+// The failure is recorded until Reset or ResetAndTrim succeed. If oi has been closed it
+// returns ErrClosed.
+//
+// Failures caused by the objects themselves, such as adding an object which is too long
+// for the object store, are returned to the caller and not recorded.
+func (oi *ObjectIntern) Health() error {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	if oi.closed() {
+		return ErrClosed
+	}
+	if oi.fault != nil {
+		return fmt.Errorf("Object store failed: %s", oi.fault)
+	}
+	return nil
+}
+
+// recordFault records err as the first unexpected failure of the object store, see Health.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) recordFault(err error) {
+	if oi.fault == nil && err != ErrClosed {
+		oi.fault = err
+	}
+}
+
+// poolMem returns the memory used by the pool of objects of objSize bytes, or 0 if there is no such pool
+//
+// The caller is responsible for holding at least a read lock.
+func (oi *ObjectIntern) poolMem(objSize uint8) uint64 {
+	mem, _ := oi.store.MemStatsByObjSize(objSize)
+	return mem
+}
+
+// storeAdd adds obj to the object store and counts the slab it allocates, if any. If it
+// allocates a slab and GrowSlabs is set, the pool grows by the slabs GrowSlabs asks for.
+// It returns the address of the stored object and nil, or 0 and an error.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) storeAdd(obj []byte) (uintptr, error) {
+	before := oi.poolMem(uint8(len(obj)))
+	addr, err := oi.store.Add(obj)
+	if err != nil {
+		// objects of any length the object store supports should always be added
+		if len(obj) > 0 && len(obj) <= math.MaxUint8 {
+			oi.recordFault(err)
+		}
+		return 0, err
+	}
+	if after := oi.poolMem(uint8(len(obj))); after > before {
+		oi.slabAllocs++
+		if oi.conf.GrowSlabs != nil {
+			oi.grow(uint8(len(obj)), before, after)
+		}
+	}
+	return addr, nil
+}
+
+// storeDelete deletes the object of objSize bytes at objAddr from the object store and counts
+// the slab it frees, if any. It returns the number of bytes unmapped and nil, or 0 and an error.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) storeDelete(objAddr uintptr, objSize uint8) (uint64, error) {
+	before := oi.poolMem(objSize)
+	// objects are only deleted once they have been found in the object store
+	if err := oi.store.Delete(objAddr); err != nil {
+		oi.recordFault(err)
+		return 0, err
+	}
+	after := oi.poolMem(objSize)
+	if after >= before {
+		return 0, nil
+	}
+	oi.slabFrees++
+	if oi.live == nil {
+		oi.collectLive()
+	}
+	return before - after, nil
+}
+
+// Stats returns the count, memory and fragmentation stats of the ObjectIntern and nil.
+// All of the stats are gathered while holding the read lock once, so they are consistent.
+// On failure it returns an empty StatsJSON and an error.
+func (oi *ObjectIntern) Stats() (StatsJSON, error) {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	if oi.closed() {
+		return StatsJSON{}, ErrClosed
+	}
+
+	memTotal, err := oi.store.MemStatsTotal()
+	if err != nil {
+		return StatsJSON{}, err
+	}
+	// the only error is that there are no slabs at all
+	fragTotal, err := oi.store.FragStatsTotal()
+	if err != nil {
+		fragTotal = 0
+	}
+
+	stats := StatsJSON{
+		Count:       oi.indexLen() + len(oi.unindexed),
+		MemTotal:    memTotal,
+		FragTotal:   fragTotal,
+		MemPerPool:  []MemStatJSON{},
+		FragPerPool: []FragStatJSON{},
+	}
+	for _, stat := range oi.store.MemStatsPerPool() {
+		stats.MemPerPool = append(stats.MemPerPool, MemStatJSON{
+			ObjSize: stat.ObjSize,
+			MemUsed: stat.MemUsed,
+		})
+	}
+	for _, stat := range oi.store.FragStatsPerPool() {
+		stats.FragPerPool = append(stats.FragPerPool, FragStatJSON{
+			ObjSize:     stat.ObjSize,
+			ObjsPerSlab: stat.ObjsPerSlab,
+			FragPercent: stat.FragPercent,
+		})
+	}
+
+	sort.Slice(stats.MemPerPool, func(i, j int) bool { return stats.MemPerPool[i].ObjSize < stats.MemPerPool[j].ObjSize })
+	sort.Slice(stats.FragPerPool, func(i, j int) bool { return stats.FragPerPool[i].ObjSize < stats.FragPerPool[j].ObjSize })
+
+	return stats, nil
+}
+
+// MarshalStatsJSON returns the stats returned by Stats encoded as JSON and nil.
+// This is meant to be served by a debug endpoint.
+// On failure it returns nil and an error.
+func (oi *ObjectIntern) MarshalStatsJSON() ([]byte, error) {
+	stats, err := oi.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stats)
+}
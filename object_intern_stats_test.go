@@ -0,0 +1,173 @@
+package goi
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestMarshalStatsJSON(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	for _, obj := range testBytes {
+		if _, err := oi.AddOrGet(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+	}
+	if cnt := oi.Count(); cnt != len(testBytes) {
+		t.Fatalf("Expected Count %d, got %d", len(testBytes), cnt)
+	}
+
+	data, err := oi.MarshalStatsJSON()
+	if err != nil {
+		t.Fatal("Failed to MarshalStatsJSON: ", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal("Failed to unmarshal stats: ", err)
+	}
+	for _, field := range []string{"count", "mem_total", "frag_total", "mem_per_pool", "frag_per_pool"} {
+		if _, ok := fields[field]; !ok {
+			t.Fatalf("Field %q missing from %s", field, data)
+		}
+	}
+
+	var stats StatsJSON
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatal("Failed to unmarshal stats: ", err)
+	}
+	if stats.Count != len(testBytes) {
+		t.Fatalf("Expected count %d, got %d", len(testBytes), stats.Count)
+	}
+	memTotal, _ := oi.MemStatsTotal()
+	if stats.MemTotal == 0 || stats.MemTotal != memTotal {
+		t.Fatalf("Expected mem_total %d, got %d", memTotal, stats.MemTotal)
+	}
+	if len(stats.MemPerPool) != len(oi.MemStatsPerPool()) || len(stats.FragPerPool) != len(oi.FragStatsPerPool()) {
+		t.Fatalf("Expected one entry per pool, got %d and %d", len(stats.MemPerPool), len(stats.FragPerPool))
+	}
+	for i := 1; i < len(stats.MemPerPool); i++ {
+		if stats.MemPerPool[i-1].ObjSize >= stats.MemPerPool[i].ObjSize {
+			t.Fatalf("Pools are not sorted by object size: %v", stats.MemPerPool)
+		}
+	}
+
+	// an empty table still has valid stats
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	data, err = oi.MarshalStatsJSON()
+	if err != nil {
+		t.Fatal("Failed to MarshalStatsJSON of an empty table: ", err)
+	}
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatal("Failed to unmarshal stats: ", err)
+	}
+	if stats.Count != 0 || len(stats.MemPerPool) != 0 {
+		t.Fatalf("Expected empty stats, got %s", data)
+	}
+
+	oi.Close()
+	if _, err := oi.MarshalStatsJSON(); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestSlabChurn(t *testing.T) {
+	cnf := NewConfig()
+	cnf.SlabSize = 10
+	oi := NewObjectIntern(cnf)
+
+	if allocs, frees := oi.SlabChurn(); allocs != 0 || frees != 0 {
+		t.Fatalf("Expected no churn for a new ObjectIntern, got %d allocs and %d frees", allocs, frees)
+	}
+
+	// every cycle fills 3 slabs and frees them again
+	cycles := 5
+	for cycle := 0; cycle < cycles; cycle++ {
+		addrs := make([]uintptr, 0, 3*cnf.SlabSize)
+		for i := 0; i < cap(addrs); i++ {
+			addr, err := oi.AddOrGet([]byte(fmt.Sprintf("churn%03d", i)), false)
+			if err != nil {
+				t.Fatal("Failed to AddOrGet: ", err)
+			}
+			addrs = append(addrs, addr)
+		}
+		for _, addr := range addrs {
+			if _, err := oi.Delete(addr); err != nil {
+				t.Fatal("Failed to Delete: ", err)
+			}
+		}
+	}
+
+	allocs, frees := oi.SlabChurn()
+	if allocs != uint64(3*cycles) || frees != uint64(3*cycles) {
+		t.Fatalf("Expected %d allocs and frees, got %d allocs and %d frees", 3*cycles, allocs, frees)
+	}
+
+	// Reset frees the slabs of the remaining objects
+	if _, err := oi.AddOrGet([]byte("churn"), false); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	allocs, frees = oi.SlabChurn()
+	if allocs != uint64(3*cycles+1) || frees != uint64(3*cycles+1) {
+		t.Fatalf("Expected %d allocs and frees, got %d allocs and %d frees", 3*cycles+1, allocs, frees)
+	}
+}
+
+func TestHitStats(t *testing.T) {
+	testHitStats(t, false)
+}
+
+func TestHitStatsCompressed(t *testing.T) {
+	testHitStats(t, true)
+}
+
+func testHitStats(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	if hits, misses := oi.HitStats(); hits != 0 || misses != 0 {
+		t.Fatalf("Expected no hits or misses, got %d and %d", hits, misses)
+	}
+
+	// every value is interned three times, once as a string
+	const unique = 50
+	for i := 0; i < unique; i++ {
+		obj := []byte(fmt.Sprintf("hitstats%d", i))
+		if _, err := oi.AddOrGet(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		if _, err := oi.AddOrGet(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		if _, err := oi.AddOrGetFromString(string(obj), true); err != nil {
+			t.Fatal("Failed to AddOrGetFromString: ", err)
+		}
+	}
+	// failed calls are neither hits nor misses
+	if _, err := oi.AddOrGet(nil, true); err != ErrEmptyObject {
+		t.Fatalf("Expected ErrEmptyObject, got %v", err)
+	}
+
+	hits, misses := oi.HitStats()
+	if hits != 2*unique || misses != unique {
+		t.Fatalf("Expected %d hits and %d misses, got %d and %d", 2*unique, unique, hits, misses)
+	}
+
+	// deleting objects doesn't change the counters
+	if _, err := oi.DeleteByString("hitstats0"); err != nil {
+		t.Fatal("Failed to DeleteByString: ", err)
+	}
+	hits, misses = oi.HitStats()
+	if hits != 2*unique || misses != unique {
+		t.Fatalf("Expected %d hits and %d misses, got %d and %d", 2*unique, unique, hits, misses)
+	}
+}
@@ -0,0 +1,55 @@
+package goi
+
+import (
+	gos "github.com/grafana/go-generic-object-store"
+)
+
+// Store is the backend an ObjectIntern keeps its interned objects in.
+//
+// Addresses returned by Add must stay valid and must not move until the object
+// is deleted, because the reference count of every object is read and modified
+// directly through its address.
+type Store interface {
+	Add(obj []byte) (uintptr, error)
+	Get(objAddr uintptr) ([]byte, error)
+	Delete(objAddr uintptr) error
+	FragStatsByObjSize(objSize uint8) (float32, error)
+	FragStatsPerPool() []gos.FragStat
+	FragStatsTotal() (float32, error)
+	MemStatsByObjSize(objSize uint8) (uint64, error)
+	MemStatsPerPool() []gos.MemStat
+	MemStatsTotal() (uint64, error)
+}
+
+// Trimmer can optionally be implemented by a Store which is able to release memory
+// it no longer uses back to the operating system. ResetAndTrim calls Trim on an empty
+// store instead of replacing it.
+type Trimmer interface {
+	Trim() error
+}
+
+// Reserver can optionally be implemented by a Store which is able to allocate room for n
+// objects of objSize bytes in advance. Reserve is only a hint, the Store may ignore it.
+type Reserver interface {
+	Reserve(objSize uint8, n int) error
+}
+
+// NewGosStore returns the default Store, which is backed by go-generic-object-store.
+// slabSize is the number of objects per slab.
+func NewGosStore(slabSize uint) Store {
+	store := gos.NewObjectStore(slabSize)
+	return &store
+}
+
+// closedStore replaces the Store of a closed ObjectIntern, every operation fails with ErrClosed
+type closedStore struct{}
+
+func (closedStore) Add(obj []byte) (uintptr, error)                   { return 0, ErrClosed }
+func (closedStore) Get(objAddr uintptr) ([]byte, error)               { return nil, ErrClosed }
+func (closedStore) Delete(objAddr uintptr) error                      { return ErrClosed }
+func (closedStore) FragStatsByObjSize(objSize uint8) (float32, error) { return 0, ErrClosed }
+func (closedStore) FragStatsPerPool() []gos.FragStat                  { return nil }
+func (closedStore) FragStatsTotal() (float32, error)                  { return 0, ErrClosed }
+func (closedStore) MemStatsByObjSize(objSize uint8) (uint64, error)   { return 0, ErrClosed }
+func (closedStore) MemStatsPerPool() []gos.MemStat                    { return nil }
+func (closedStore) MemStatsTotal() (uint64, error)                    { return 0, ErrClosed }
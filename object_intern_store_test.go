@@ -0,0 +1,437 @@
+package goi
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"unsafe"
+
+	gos "github.com/grafana/go-generic-object-store"
+)
+
+// mapStore is a simple Store backed by a map of heap allocated objects
+type mapStore struct {
+	objs map[uintptr][]byte
+}
+
+func newMapStore(slabSize uint) Store {
+	return &mapStore{objs: make(map[uintptr][]byte)}
+}
+
+func (m *mapStore) Add(obj []byte) (uintptr, error) {
+	if len(obj) == 0 || len(obj) > 255 {
+		return 0, fmt.Errorf("mapStore: size of object (%d) is outside limits", len(obj))
+	}
+	b := make([]byte, len(obj))
+	copy(b, obj)
+	addr := uintptr(unsafe.Pointer(&b[0]))
+	m.objs[addr] = b
+	return addr, nil
+}
+
+func (m *mapStore) Get(objAddr uintptr) ([]byte, error) {
+	b, ok := m.objs[objAddr]
+	if !ok {
+		return nil, fmt.Errorf("mapStore: object %d not found", objAddr)
+	}
+	return b, nil
+}
+
+func (m *mapStore) Delete(objAddr uintptr) error {
+	if _, ok := m.objs[objAddr]; !ok {
+		return fmt.Errorf("mapStore: object %d not found", objAddr)
+	}
+	delete(m.objs, objAddr)
+	return nil
+}
+
+func (m *mapStore) FragStatsByObjSize(objSize uint8) (float32, error) { return 0, nil }
+func (m *mapStore) FragStatsPerPool() []gos.FragStat                  { return nil }
+func (m *mapStore) FragStatsTotal() (float32, error)                  { return 0, nil }
+func (m *mapStore) MemStatsByObjSize(objSize uint8) (uint64, error)   { return 0, nil }
+func (m *mapStore) MemStatsPerPool() []gos.MemStat                    { return nil }
+
+func (m *mapStore) MemStatsTotal() (uint64, error) {
+	var total uint64
+	for _, b := range m.objs {
+		total += uint64(len(b))
+	}
+	return total, nil
+}
+
+func TestMapStore(t *testing.T) {
+	testMapStore(t, false)
+}
+
+func TestMapStoreCompressed(t *testing.T) {
+	testMapStore(t, true)
+}
+
+func testMapStore(t *testing.T, compress bool) {
+	c := NewConfig()
+	c.NewStore = newMapStore
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+	store := oi.store.(*mapStore)
+
+	addrs := make([]uintptr, 0)
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		if _, ok := store.objs[addr]; !ok {
+			t.Fatal("Object was not added to the configured store: ", b)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	// reference count should be 2 after this finishes
+	for idx, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		if addr != addrs[idx] {
+			t.Fatal("Address mismatch for: ", b)
+		}
+	}
+
+	for idx, addr := range addrs {
+		sz, err := oi.GetStringFromPtr(addr)
+		if err != nil {
+			t.Fatal("Failed to GetStringFromPtr: ", addr)
+		}
+		if sz != testStrings[idx] {
+			t.Fatalf("Expected: %s\nActual: %s\n", testStrings[idx], sz)
+		}
+
+		ok, err := oi.Delete(addr)
+		if err != nil || ok {
+			t.Fatal("Delete should only decrement the reference count: ", addr)
+		}
+		ok, err = oi.Delete(addr)
+		if err != nil || !ok {
+			t.Fatal("Delete should have removed the object: ", addr)
+		}
+	}
+
+	if len(store.objs) != 0 || len(oi.objIndex) != 0 {
+		t.Fatalf("Store and index should be empty, instead found %d and %d objects", len(store.objs), len(oi.objIndex))
+	}
+
+	// Reset should create a new store with the configured constructor
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Reset returned an error: ", err)
+	}
+	if _, ok := oi.store.(*mapStore); !ok {
+		t.Fatal("Reset did not use the configured store")
+	}
+}
+
+// trimStore is a mapStore which records calls to Trim
+type trimStore struct {
+	mapStore
+	trimmed int
+}
+
+func newTrimStore(slabSize uint) Store {
+	return &trimStore{mapStore: mapStore{objs: make(map[uintptr][]byte)}}
+}
+
+func (t *trimStore) Trim() error {
+	t.trimmed++
+	return nil
+}
+
+func TestResetAndTrim(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	addrs := make([]uintptr, 0, 10000)
+	for i := 0; i < 10000; i++ {
+		addr, err := oi.AddOrGet([]byte(fmt.Sprintf("spike%d", i)), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if mem, _ := oi.MemStatsTotal(); mem == 0 {
+		t.Fatal("Expected memory to be used before trimming")
+	}
+
+	if err := oi.ResetAndTrim(); err != nil {
+		t.Fatal("ResetAndTrim returned an error: ", err)
+	}
+
+	mem, err := oi.MemStatsTotal()
+	if err != nil || mem != 0 {
+		t.Fatalf("Expected no memory to be used after trimming, got %d: %v", mem, err)
+	}
+	if len(oi.MemStatsPerPool()) != 0 {
+		t.Fatalf("Expected no pools after trimming, got %v", oi.MemStatsPerPool())
+	}
+	if cnt := oi.Count(); cnt != 0 {
+		t.Fatalf("Expected no objects after trimming, got %d", cnt)
+	}
+	// addresses of the old slabs must not resolve anymore
+	if _, err := oi.GetStringFromPtr(addrs[0]); err == nil {
+		t.Fatal("Object of the old store is still accessible")
+	}
+
+	if _, err := oi.AddOrGet([]byte("afterwards"), true); err != nil {
+		t.Fatal("Failed to AddOrGet after trimming: ", err)
+	}
+}
+
+func TestResetAndTrimTrimmer(t *testing.T) {
+	c := NewConfig()
+	c.NewStore = newTrimStore
+	oi := NewObjectIntern(c)
+	store := oi.store.(*trimStore)
+
+	for _, b := range testBytes {
+		if _, err := oi.AddOrGet(b, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+	}
+	objs := make([][]byte, 0, len(store.objs))
+	for _, obj := range store.objs {
+		objs = append(objs, obj)
+	}
+
+	if err := oi.ResetAndTrim(); err != nil {
+		t.Fatal("ResetAndTrim returned an error: ", err)
+	}
+	if oi.store != Store(store) {
+		t.Fatal("ResetAndTrim replaced a store which implements Trimmer")
+	}
+	if store.trimmed != 1 || len(store.objs) != 0 {
+		t.Fatalf("Expected an empty store trimmed once, got %d objects trimmed %d times", len(store.objs), store.trimmed)
+	}
+	// the store is kept, so the memory it got back must be zeroed
+	for _, obj := range objs {
+		if !bytes.Equal(obj, make([]byte, len(obj))) {
+			t.Fatalf("Expected the memory of a deleted object to be zeroed, got %v", obj)
+		}
+	}
+}
+
+func TestGetStringFromPtrChecked(t *testing.T) {
+	testGetStringFromPtrChecked(t, false)
+}
+
+func TestGetStringFromPtrCheckedCompressed(t *testing.T) {
+	testGetStringFromPtrChecked(t, true)
+}
+
+func testGetStringFromPtrChecked(t *testing.T, compress bool) {
+	c := NewConfig()
+	c.NewStore = newMapStore
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+	store := oi.store.(*mapStore)
+
+	addr, err := oi.AddOrGet([]byte("servername1234"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+
+	str, err := oi.GetStringFromPtrChecked(addr)
+	if err != nil || str != "servername1234" {
+		t.Fatalf("Expected servername1234, got %q: %v", str, err)
+	}
+
+	// truncate the stored object so it doesn't even hold the whole reference count
+	store.objs[addr] = store.objs[addr][:2]
+	if str, err := oi.GetStringFromPtrChecked(addr); err != ErrCorruptObject || str != "" {
+		t.Fatalf("Expected ErrCorruptObject, got %q: %v", str, err)
+	}
+
+	// a reference count without any data is not a valid object either
+	store.objs[addr] = store.objs[addr][:4]
+	if _, err := oi.GetStringFromPtrChecked(addr); err != ErrCorruptObject {
+		t.Fatalf("Expected ErrCorruptObject, got %v", err)
+	}
+
+	if _, err := oi.GetStringFromPtrChecked(0); err == nil {
+		t.Fatal("Expected an error for an address which is not in the store")
+	}
+
+	// the unchecked methods catch a reference count without any data as well
+	if str, err := oi.GetStringFromPtr(addr); err != ErrCorruptObject || str != "" {
+		t.Fatalf("Expected ErrCorruptObject, got %q: %v", str, err)
+	}
+	if _, all := oi.Len([]uintptr{addr}); all {
+		t.Fatal("Expected Len to fail for an object without data")
+	}
+	if _, all := oi.TotalLen([]uintptr{addr}); all {
+		t.Fatal("Expected TotalLen to fail for an object without data")
+	}
+	if _, err := oi.Delete(addr); err == nil {
+		t.Fatal("Expected Delete to fail for an object without data")
+	}
+}
+
+func TestObjBytesChecked(t *testing.T) {
+	c := NewConfig()
+	c.NewStore = newMapStore
+	oi := NewObjectIntern(c)
+	store := oi.store.(*mapStore)
+
+	addr, err := oi.AddOrGet([]byte("servername1234"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+
+	// a reference count without any data is not a valid object
+	store.objs[addr] = store.objs[addr][:4]
+	if b, err := oi.ObjBytes(addr); err != ErrCorruptObject || b != nil {
+		t.Fatalf("Expected ErrCorruptObject, got %q: %v", b, err)
+	}
+	if str, err := oi.ObjString(addr); err != ErrCorruptObject || str != "" {
+		t.Fatalf("Expected ErrCorruptObject, got %q: %v", str, err)
+	}
+	if b, err := oi.GetBytesInto(addr, make([]byte, 8)); err != ErrCorruptObject || len(b) != 0 {
+		t.Fatalf("Expected ErrCorruptObject, got %q: %v", b, err)
+	}
+}
+
+func TestSafeMode(t *testing.T) {
+	testSafeMode(t, false)
+}
+
+func TestSafeModeCompressed(t *testing.T) {
+	testSafeMode(t, true)
+}
+
+func testSafeMode(t *testing.T, compress bool) {
+	c := NewConfig()
+	c.SafeMode = true
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	// keep the slab mapped, so the object store still returns the freed slot
+	if _, err := oi.AddOrGet([]byte("server1"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	addr, err := oi.AddOrGet([]byte("server2"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if str, err := oi.ObjString(addr); err != nil || str != "server2" {
+		t.Fatalf("Expected server2, got %q: %v", str, err)
+	}
+
+	if _, err := oi.Delete(addr); err != nil {
+		t.Fatal("Failed to Delete: ", err)
+	}
+	if b, err := oi.ObjBytes(addr); err != ErrCorruptObject {
+		t.Fatalf("Expected ErrCorruptObject for a freed address, got %q: %v", b, err)
+	}
+	if str, err := oi.ObjString(addr); err != ErrCorruptObject {
+		t.Fatalf("Expected ErrCorruptObject for a freed address, got %q: %v", str, err)
+	}
+	if str, err := oi.GetStringFromPtrChecked(addr); err != ErrCorruptObject {
+		t.Fatalf("Expected ErrCorruptObject for a freed address, got %q: %v", str, err)
+	}
+	if b, err := oi.GetBytesInto(addr, nil); err != ErrCorruptObject {
+		t.Fatalf("Expected ErrCorruptObject for a freed address, got %q: %v", b, err)
+	}
+
+	// once the slot is reused the address is valid again, it belongs to the new object
+	reused, err := oi.AddOrGet([]byte("server3"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if reused != addr {
+		t.Skip("The object store did not reuse the address of the deleted object")
+	}
+	if str, err := oi.ObjString(addr); err != nil || str != "server3" {
+		t.Fatalf("Expected server3, got %q: %v", str, err)
+	}
+}
+
+// faultyStore wraps a Store and fails every Add or Delete once told to
+type faultyStore struct {
+	Store
+	failAdd    bool
+	failDelete bool
+}
+
+func (f *faultyStore) Add(obj []byte) (uintptr, error) {
+	if f.failAdd {
+		return 0, fmt.Errorf("faultyStore: Add failed")
+	}
+	return f.Store.Add(obj)
+}
+
+func (f *faultyStore) Delete(objAddr uintptr) error {
+	if f.failDelete {
+		return fmt.Errorf("faultyStore: Delete failed")
+	}
+	return f.Store.Delete(objAddr)
+}
+
+func TestHealth(t *testing.T) {
+	cnf := NewConfig()
+	cnf.NewStore = func(slabSize uint) Store {
+		return &faultyStore{Store: NewGosStore(slabSize)}
+	}
+	oi := NewObjectIntern(cnf)
+	store := oi.store.(*faultyStore)
+
+	addr, err := oi.AddOrGet([]byte("healthy"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if err := oi.Health(); err != nil {
+		t.Fatal("Expected a healthy ObjectIntern, got ", err)
+	}
+
+	// an object which is too long is the caller's fault
+	if _, err := oi.AddOrGet(make([]byte, 300), true); err == nil {
+		t.Fatal("Expected an error for an object which is too long")
+	}
+	if err := oi.Health(); err != nil {
+		t.Fatal("Expected a healthy ObjectIntern after a rejected object, got ", err)
+	}
+
+	store.failDelete = true
+	if _, err := oi.Delete(addr); err == nil {
+		t.Fatal("Expected Delete to fail")
+	}
+	store.failDelete = false
+	if err := oi.Health(); err == nil || !strings.Contains(err.Error(), "Delete failed") {
+		t.Fatal("Expected Health to report the failed Delete, got ", err)
+	}
+
+	// the first failure is kept
+	store.failAdd = true
+	if _, err := oi.AddOrGet([]byte("unhealthy"), true); err == nil {
+		t.Fatal("Expected AddOrGet to fail")
+	}
+	if err := oi.Health(); err == nil || !strings.Contains(err.Error(), "Delete failed") {
+		t.Fatal("Expected Health to report the failed Delete, got ", err)
+	}
+
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	if err := oi.Health(); err != nil {
+		t.Fatal("Expected a healthy ObjectIntern after Reset, got ", err)
+	}
+
+	if err := oi.Close(); err != nil {
+		t.Fatal("Failed to Close: ", err)
+	}
+	if err := oi.Health(); err != ErrClosed {
+		t.Fatal("Expected ErrClosed, got ", err)
+	}
+}
@@ -0,0 +1,202 @@
+package goi
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestStress(t *testing.T) {
+	testStress(t, NewConfig())
+}
+
+func TestStressCompressed(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	cnf.CacheSize = 16
+	testStress(t, cnf)
+}
+
+func TestStressHashed(t *testing.T) {
+	cnf := NewConfig()
+	cnf.HashedIndex = true
+	testStress(t, cnf)
+}
+
+// testStress hammers AddOrGet, IncRefCnt and Delete on a small set of overlapping keys from
+// many goroutines. Every goroutine only drops the references it acquired itself, so once all
+// of them are done every object must have been removed. Run it with -race, on Go 1.14 and
+// newer also with -gcflags=all=-d=checkptr=0 because the object store converts unaligned pointers.
+func testStress(t *testing.T, cnf ObjectInternConfig) {
+	goroutines := 16
+	iterations := 2000
+	if testing.Short() {
+		iterations = 200
+	}
+
+	keys := make([][]byte, 8)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("stress%d", i))
+	}
+
+	oi := NewObjectIntern(cnf)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+
+			for i := 0; i < iterations; i++ {
+				key := keys[rnd.Intn(len(keys))]
+
+				addr, err := oi.AddOrGet(key, true)
+				if err != nil {
+					errs <- err
+					return
+				}
+				refs := 1
+
+				if rnd.Intn(2) == 0 {
+					if _, err = oi.IncRefCnt(addr); err != nil {
+						errs <- err
+						return
+					}
+					refs++
+				}
+
+				str, err := oi.GetStringFromPtr(addr)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if str != string(key) {
+					errs <- fmt.Errorf("Expected %s, instead got %s", key, str)
+					return
+				}
+
+				for ; refs > 0; refs-- {
+					if _, err = oi.Delete(addr); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for _, key := range keys {
+		if addr, err := oi.GetPtrFromByte(key); err == nil {
+			cnt, _ := oi.RefCnt(addr)
+			t.Fatalf("Expected %s to be removed, but it is still interned with reference count %d", key, cnt)
+		}
+	}
+}
+
+// TestDeleteRace has two goroutines race to drop the final reference of the same object.
+// Exactly one of them may remove it, the other one must report that it wasn't found.
+// Run it with -race, see testStress.
+func TestDeleteRace(t *testing.T) {
+	iterations := 1000
+	if testing.Short() {
+		iterations = 100
+	}
+
+	oi := NewObjectIntern(NewConfig())
+
+	// every removed object is the only one in its slab, so the slab is unmapped while the
+	// other goroutine may still be trying to delete it. The object store only finds the
+	// slab of an address if there is a slab at or below it, so a neighbour of a different
+	// size is stored in a slab mapped after it.
+	for i := 0; i < iterations; i++ {
+		addr, err := oi.AddOrGet([]byte(fmt.Sprintf("race%02d", i%100)), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		neighbour, err := oi.AddOrGet([]byte("neighbouring"), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+
+		start := make(chan struct{})
+		removed := make(chan bool, 2)
+		var wg sync.WaitGroup
+		for g := 0; g < 2; g++ {
+			wg.Add(1)
+			go func(batch bool) {
+				defer wg.Done()
+				<-start
+				if batch {
+					removed <- oi.DeleteBatchResult([]uintptr{addr})[0] == Removed
+					return
+				}
+				ok, _ := oi.Delete(addr)
+				removed <- ok
+			}(g == 1)
+		}
+		close(start)
+		wg.Wait()
+
+		if first, second := <-removed, <-removed; first == second {
+			t.Fatalf("Expected exactly one delete to remove the object, got %t and %t", first, second)
+		}
+		if ok, err := oi.Delete(neighbour); !ok || err != nil {
+			t.Fatalf("Expected the neighbour to be removed, got %t: %v", ok, err)
+		}
+		if oi.Count() != 0 {
+			t.Fatalf("Expected no objects, got %d objects", oi.Count())
+		}
+	}
+
+	// deleting the same object twice must not remove it twice either
+	neighbour, err := oi.AddOrGet([]byte("neighbouring"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	addr, err := oi.AddOrGet([]byte("racing"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if ok, err := oi.Delete(addr); !ok || err != nil {
+		t.Fatalf("Expected the object to be removed, got %t: %v", ok, err)
+	}
+	if ok, err := oi.Delete(addr); ok || err == nil {
+		t.Fatalf("Expected an error for the removed object, got %t: %v", ok, err)
+	}
+
+	// neither does listing it twice in a batch, the first entry unmaps its slab
+	addr, err = oi.AddOrGet([]byte("racing"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	results := oi.DeleteBatchResult([]uintptr{addr, addr})
+	if results[0] != Removed || results[1] != NotFound {
+		t.Fatalf("Expected Removed and NotFound, got %v", results)
+	}
+	addr, err = oi.AddOrGet([]byte("racing"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	oi.DeleteBatch([]uintptr{addr, addr})
+	if oi.Count() != 1 {
+		t.Fatalf("Expected only the neighbour, got %d objects", oi.Count())
+	}
+	if _, err := oi.Delete(neighbour); err != nil {
+		t.Fatal("Failed to Delete: ", err)
+	}
+	if oi.Count() != 0 {
+		t.Fatalf("Expected no objects, got %d objects", oi.Count())
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Failed to Verify: ", err)
+	}
+}
@@ -3,8 +3,11 @@ package goi
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"math/rand"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 	"unsafe"
@@ -63,6 +66,70 @@ func randStringBytesMaskImprSrc(n int) string {
 	return string(b)
 }
 
+// generateTestData returns n objects of the form "words%d". dupeRate is the share of
+// objects which are duplicates of an earlier object. The data is the same for every call
+// with the same arguments, so benchmarks using it are comparable.
+func generateTestData(n int, dupeRate float64) [][]byte {
+	rnd := rand.New(rand.NewSource(int64(n)))
+
+	data := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		if i > 0 && rnd.Float64() < dupeRate {
+			// copy, so objects never share their backing array
+			data = append(data, append([]byte(nil), data[rnd.Intn(i)]...))
+			continue
+		}
+		data = append(data, []byte(fmt.Sprintf("words%d", i)))
+	}
+	return data
+}
+
+func TestNewObjectInternE(t *testing.T) {
+	oi, err := NewObjectInternE(NewConfig())
+	if err != nil || oi == nil {
+		t.Fatal("Failed to create ObjectIntern with the default config: ", err)
+	}
+
+	shocoDict := NewConfig()
+	shocoDict.Compression = ShocoDict
+	unknown := NewConfig()
+	unknown.Compression = 42
+	noSlabs := NewConfig()
+	noSlabs.SlabSize = 0
+	negCapacity := NewConfig()
+	negCapacity.InitialIndexCapacity = -1
+	noHandles := NewConfig()
+	noHandles.AutoCompact = true
+	noInterval := NewConfig()
+	noInterval.HandlesOnly = true
+	noInterval.AutoCompact = true
+	noInterval.CompactInterval = 0
+
+	for name, c := range map[string]ObjectInternConfig{
+		"ShocoDict":                    shocoDict,
+		"UnknownCompression":           unknown,
+		"ZeroSlabSize":                 noSlabs,
+		"NegativeInitialIndexCapacity": negCapacity,
+		"AutoCompactWithoutHandles":    noHandles,
+		"AutoCompactWithoutInterval":   noInterval,
+	} {
+		oi, err := NewObjectInternE(c)
+		if err == nil || oi != nil {
+			t.Errorf("%s: expected an error, got %v", name, oi)
+			continue
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected NewObjectIntern to panic", name)
+				}
+			}()
+			NewObjectIntern(c)
+		}()
+	}
+}
+
 func TestAddOrGet(t *testing.T) {
 	testAddOrGet(t, true, false)
 }
@@ -413,727 +480,4037 @@ func TestIncRefCntBatch(t *testing.T) {
 	}
 }
 
-func TestAddOrGetAndDelete25(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = Shoco
-	testAddOrGetAndDelete(t, 25, 501, cnf)
-}
-
-func TestAddOrGetAndDelete250(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = Shoco
-	testAddOrGetAndDelete(t, 250, 501, cnf)
-}
-
-func TestAddOrGetAndDeleteNoCprsn25(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = None
-	testAddOrGetAndDelete(t, 25, 501, cnf)
-}
-
-func TestAddOrGetAndDeleteNoCprsn250(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = None
-	testAddOrGetAndDelete(t, 250, 501, cnf)
-}
-
-func testAddOrGetAndDelete(t *testing.T, keySize int, numKeys int, cnf ObjectInternConfig) {
-	oi := NewObjectIntern(cnf)
+func TestGetStringFromPtrCached(t *testing.T) {
+	c := NewConfig()
+	c.Compression = Shoco
+	c.CacheSize = 5
+	oi := NewObjectIntern(c)
 
-	// slice to store addresses
 	addrs := make([]uintptr, 0)
-	// generate numKeys random strings of keySize length
-	originalSzs := make([]string, 0)
-	for i := 0; i < numKeys; i++ {
-		sz := randStringBytesMaskImprSrc(keySize)
-		originalSzs = append(originalSzs, sz)
-	}
-
-	// reference count should be 1 after this finishes
-	for _, sz := range originalSzs {
-		addr, err := oi.AddOrGet([]byte(sz), true)
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
 		if err != nil {
-			t.Error("Failed to AddOrGet: ", []byte(sz))
-			return
+			t.Fatal("Failed to AddOrGet: ", b)
 		}
-		// add addr to addrs
 		addrs = append(addrs, addr)
 	}
 
-	// reference count should be 2 after this finishes
-	for _, sz := range originalSzs {
-		_, err := oi.AddOrGet([]byte(sz), true)
+	for idx, addr := range addrs {
+		first, err := oi.GetStringFromPtr(addr)
 		if err != nil {
-			t.Error("Failed to AddOrGet: ", []byte(sz))
-			return
+			t.Fatal("Failed to GetStringFromPtr: ", addr)
 		}
-	}
-
-	// decrease reference count by 1, it should now be 1 again
-	for _, addr := range addrs {
-		ok, err := oi.Delete(addr)
+		second, err := oi.GetStringFromPtr(addr)
 		if err != nil {
-			t.Error("Failed to delete object (possibly not found in the object store): ", addr)
-			return
+			t.Fatal("Failed to GetStringFromPtr: ", addr)
 		}
-		if ok {
-			t.Error("Ok should be false since reference count is at 1 now")
-			return
+		if first != testStrings[idx] || second != testStrings[idx] {
+			t.Fatalf("Expected: %s\nActual: %s, %s\n", testStrings[idx], first, second)
 		}
-	}
 
-	// decrease reference count by 1, now objects should be deleted (slabs are deleted as well)
-	for _, addr := range addrs {
-		ok, err := oi.Delete(addr)
+		// a cache hit should alias the same data as the previous call
+		dataPointer := (*reflect.StringHeader)(unsafe.Pointer(&first)).Data
+		dataPointer2 := (*reflect.StringHeader)(unsafe.Pointer(&second)).Data
+		if dataPointer != dataPointer2 {
+			t.Fatal("Cached strings should share the same data pointer: ", testStrings[idx])
+		}
+
+		copied, err := oi.GetStringFromPtrCopy(addr)
 		if err != nil {
-			t.Error("Failed to delete object (possibly not found in the object store): ", addr)
-			return
+			t.Fatal("Failed to GetStringFromPtrCopy: ", addr)
 		}
-		if !ok {
-			t.Error("Ok should be true since object should have been deleted")
-			return
+		if copied != testStrings[idx] {
+			t.Fatalf("Expected: %s\nActual: %s\n", testStrings[idx], copied)
+		}
+		if (*reflect.StringHeader)(unsafe.Pointer(&copied)).Data == dataPointer {
+			t.Fatal("Copied string should not share memory with the cache: ", testStrings[idx])
 		}
 	}
 
-}
+	// the cache must never grow beyond its configured size
+	if len(oi.cache.entries) != c.CacheSize {
+		t.Fatalf("Cache should hold %d entries, instead found %d", c.CacheSize, len(oi.cache.entries))
+	}
 
-func TestBatchDelete501(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = None
-	testBatchDelete(t, 30, 501, cnf)
+	// deleting the last reference must drop the cached entry
+	last := addrs[len(addrs)-1]
+	if _, ok := oi.cache.get(last); !ok {
+		t.Fatal("Most recently read object should be cached")
+	}
+	if _, err := oi.Delete(last); err != nil {
+		t.Fatal("Failed to Delete: ", last)
+	}
+	if _, ok := oi.cache.get(last); ok {
+		t.Fatal("Deleted object should not be cached anymore")
+	}
 }
 
-func TestBatchDeleteNoCprsn(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = Shoco
-	testBatchDelete(t, 30, 501, cnf)
-}
+func TestGetStringFromPtrTTL(t *testing.T) {
+	c := NewConfig()
+	c.Compression = Shoco
+	c.CacheTTL = time.Minute
+	oi := NewObjectIntern(c)
 
-func testBatchDelete(t *testing.T, keySize int, numKeys int, cnf ObjectInternConfig) {
-	oi := NewObjectIntern(cnf)
+	// count decompressions and control the time seen by the cache
+	var decompressed int
+	decompress := oi.decompress
+	oi.decompress = func(in []byte) ([]byte, error) {
+		decompressed++
+		return decompress(in)
+	}
+	now := time.Now()
+	oi.strCache.now = func() time.Time { return now }
 
-	// slice to store addresses
-	addrs := make([]uintptr, 0)
-	// generate numKeys random strings of keySize length
-	originalSzs := make([]string, 0)
-	for i := 0; i < numKeys; i++ {
-		sz := randStringBytesMaskImprSrc(keySize)
-		originalSzs = append(originalSzs, sz)
+	addr, err := oi.AddOrGet([]byte("cachedstring"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
 	}
 
-	// reference count should be 1 after this finishes
-	for _, sz := range originalSzs {
-		addr, err := oi.AddOrGet([]byte(sz), true)
+	for i := 0; i < 3; i++ {
+		str, err := oi.GetStringFromPtr(addr)
 		if err != nil {
-			t.Error("Failed to AddOrGet: ", []byte(sz))
-			return
+			t.Fatal("Failed to GetStringFromPtr: ", err)
+		}
+		if str != "cachedstring" {
+			t.Fatalf("Expected cachedstring, instead got %s", str)
 		}
-		// add addr to addrs
-		addrs = append(addrs, addr)
+	}
+	if decompressed != 1 {
+		t.Fatalf("Expected 1 decompression within the TTL, instead got %d", decompressed)
 	}
 
-	// reference count should be 10 after this finishes
-	for i := 0; i < 9; i++ {
-		for _, sz := range originalSzs {
-			_, err := oi.AddOrGet([]byte(sz), true)
-			if err != nil {
-				t.Error("Failed to AddOrGet: ", []byte(sz))
-				return
-			}
-		}
+	// after the TTL expired the string needs to be recomputed
+	now = now.Add(c.CacheTTL)
+	str, err := oi.GetStringFromPtr(addr)
+	if err != nil {
+		t.Fatal("Failed to GetStringFromPtr: ", err)
+	}
+	if str != "cachedstring" {
+		t.Fatalf("Expected cachedstring, instead got %s", str)
+	}
+	if decompressed != 2 {
+		t.Fatalf("Expected 2 decompressions after the TTL expired, instead got %d", decompressed)
 	}
 
-	// use subslice to decrease and then delete a subslice of the original
-	// added strings.
-	subAddrs := addrs[:numKeys-5]
-	for i := 0; i < 10; i++ {
-		oi.DeleteBatch(subAddrs)
+	// deleting the object must drop the cached string
+	if _, err = oi.Delete(addr); err != nil {
+		t.Fatal("Failed to Delete: ", err)
 	}
+	if _, ok := oi.strCache.get(addr); ok {
+		t.Fatal("Deleted object should not be cached anymore")
+	}
+}
 
-	// check to make sure the last 5 still exist
-	lastFive := addrs[numKeys-5:]
-	for _, ptr := range lastFive {
-		_, err := oi.GetStringFromPtr(ptr)
-		if err != nil {
-			t.Error("Could not find string in object store")
-			return
-		}
+func TestGetStringFromPtrLast(t *testing.T) {
+	c := NewConfig()
+	c.Compression = Shoco
+	oi := NewObjectIntern(c)
+
+	// count decompressions
+	var decompressed int
+	decompress := oi.decompress
+	oi.decompress = func(in []byte) ([]byte, error) {
+		decompressed++
+		return decompress(in)
 	}
 
-	// delete everything
-	for i := 0; i < 10; i++ {
-		oi.DeleteBatch(lastFive)
+	addr, err := oi.AddOrGet([]byte("servername1234"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	other, err := oi.AddOrGet([]byte("metric"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
 	}
 
-	// make sure none of the items exist in the store
-	for _, ptr := range addrs {
-		_, err := oi.GetStringFromPtr(ptr)
-		if err == nil {
-			t.Error("Object should not have been found in the store")
-			return
+	expect := func(addr uintptr, expected string, decompressions int) {
+		t.Helper()
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != expected {
+			t.Fatalf("Expected %q, got %q: %v", expected, str, err)
+		}
+		if decompressed != decompressions {
+			t.Fatalf("Expected %d decompressions, got %d", decompressions, decompressed)
 		}
 	}
-}
 
-func TestMemStatsPerPool(t *testing.T) {
-	oi := NewObjectIntern(NewConfig())
+	// repeated reads of the same address only decompress once
+	expect(addr, "servername1234", 1)
+	expect(addr, "servername1234", 1)
+	expect(other, "metric", 2)
+	expect(addr, "servername1234", 3)
 
-	addrs := make([]uintptr, 0)
-	for _, tmpBytes := range testBytes {
-		addr, err := oi.AddOrGet(tmpBytes, true)
-		if err != nil {
-			t.Error("Failed to add object to object store")
-		}
-		addrs = append(addrs, addr)
+	// modifying the object in place must not return the old string
+	if newAddr, err := oi.ReplaceValue(addr, []byte("servername4321")); err != nil || newAddr != addr {
+		t.Fatalf("Expected the object to be replaced in place, got %d: %v", newAddr, err)
 	}
+	expect(addr, "servername4321", 4)
 
-	m := oi.MemStatsPerPool()
-	for _, s := range m {
-		t.Logf("ObjectSize: %d\nMemUsed: %d\n\n", s.ObjSize, s.MemUsed)
+	// a new object stored at the address of a deleted one must not return the old string
+	if _, err := oi.Delete(addr); err != nil {
+		t.Fatal("Failed to Delete: ", err)
+	}
+	reused, err := oi.AddOrGet([]byte("servername9876"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if reused != addr {
+		t.Skip("The object store did not reuse the address of the deleted object")
+	}
+	expect(addr, "servername9876", 5)
+
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	if str, ok := oi.lastStr.get(addr); ok {
+		t.Fatalf("Expected no string to be remembered after Reset, got %q", str)
 	}
 }
 
-func TestJoinStringsCompressed(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = Shoco
-	testJoinStrings(t, cnf)
+type errReader struct{}
+
+func (errReader) Read(p []byte) (int, error) {
+	return 0, fmt.Errorf("read failed")
 }
 
-func TestJoinStringsUncompressed(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = None
-	testJoinStrings(t, cnf)
+func TestAddOrGetReader(t *testing.T) {
+	testAddOrGetReader(t, false)
 }
 
-func testJoinStrings(t *testing.T, cnf ObjectInternConfig) {
-	oi := NewObjectIntern(cnf)
+func TestAddOrGetReaderCompressed(t *testing.T) {
+	testAddOrGetReader(t, true)
+}
 
-	addrs := make([]uintptr, 0)
-	for _, tmpBytes := range testBytes {
-		addr, err := oi.AddOrGet(tmpBytes, true)
+func testAddOrGetReader(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	for idx, s := range testStrings {
+		addr, err := oi.AddOrGetReader(strings.NewReader(s), len(s))
 		if err != nil {
-			t.Error("Failed to add object to object store")
+			t.Fatal("Failed to AddOrGetReader: ", err)
 		}
-		addrs = append(addrs, addr)
-	}
 
-	expected := "SmallString.LongerString.AnEvenLongerString.metric.root.server.servername1234.servername4321.servername91FFXX.AndTheLongestStringWeDealWithWithEvenASmallAmountOfSpaceMoreToGetUsOverTheGiganticLimitOfStuff"
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil {
+			t.Fatal("Failed to GetStringFromPtr: ", err)
+		}
+		if str != s {
+			t.Fatalf("Expected %s, instead got %s", s, str)
+		}
 
-	joinedString, err := oi.JoinStrings(addrs, ".")
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	if joinedString != expected {
-		t.Errorf("Expected: %s\nActual: %s\n", expected, joinedString)
-		return
+		// the buffer is reused, so make sure earlier objects are still intact
+		for _, prev := range testStrings[:idx+1] {
+			if _, err := oi.GetPtrFromByte([]byte(prev)); err != nil {
+				t.Fatalf("Could not find %s after interning %s", prev, s)
+			}
+		}
 	}
 
-	joinedString, err = oi.JoinStrings([]uintptr{}, ".")
-	if err == nil {
-		t.Error("We should have an error here")
-		return
+	// adding an existing object through a reader increments its reference count
+	addr, err := oi.AddOrGetReader(strings.NewReader(testStrings[0]), 0)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetReader: ", err)
+	}
+	if cnt, err := oi.RefCnt(addr); err != nil || cnt != 2 {
+		t.Fatalf("Expected reference count 2, instead got %d and %v", cnt, err)
 	}
 
-	joinedString, err = oi.JoinStrings([]uintptr{addrs[0]}, ".")
-	if err != nil {
-		t.Error(err)
-		return
+	// nothing should be interned if reading fails
+	reader := io.MultiReader(strings.NewReader("partial"), errReader{})
+	if _, err := oi.AddOrGetReader(reader, 16); err == nil {
+		t.Fatal("Expected AddOrGetReader to fail")
 	}
-	if joinedString != string(testBytes[0]) {
-		t.Errorf("Expected: %s\nActual: %s\n", string(testBytes[0]), joinedString)
-		return
+	if _, err := oi.GetPtrFromByte([]byte("partial")); err == nil {
+		t.Fatal("Partially read object should not be interned")
 	}
 }
 
-func TestReset(t *testing.T) {
-	c := NewConfig()
-	oi := NewObjectIntern(c)
+func TestAddOrGetConcat(t *testing.T) {
+	testAddOrGetConcat(t, false)
+}
 
-	data := make([][]byte, 0, 10000)
-	rand.Seed(time.Now().UnixNano())
-	l := len(testStrings)
+func TestAddOrGetConcatCompressed(t *testing.T) {
+	testAddOrGetConcat(t, true)
+}
 
-	for i := 0; i < 10000; i++ {
-		data = append(data, []byte(fmt.Sprintf(testStrings[rand.Intn(l)]+"%d", i)))
-		oi.AddOrGet(data[i], false)
+func testAddOrGetConcat(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
 	}
+	oi := NewObjectIntern(c)
 
-	if len(oi.objIndex) != 10000 {
-		t.Fatalf("Length of object index should be 10000, instead found: %d", len(oi.objIndex))
+	addr, err := oi.AddOrGetConcat([]byte("a"), []byte("b"))
+	if err != nil {
+		t.Fatal("Failed to AddOrGetConcat: ", err)
 	}
-
-	err := oi.Reset()
+	addr2, err := oi.AddOrGet([]byte("ab"), true)
 	if err != nil {
-		t.Fatalf("Reset returned an error: %s", err)
+		t.Fatal("Failed to AddOrGet: ", err)
 	}
-
-	if len(oi.objIndex) != 0 {
-		t.Fatalf("Length of object index should be 0, instead found: %d", len(oi.objIndex))
+	if addr != addr2 {
+		t.Fatalf("Expected AddOrGetConcat and AddOrGet to return the same address, got %d and %d", addr, addr2)
+	}
+	refCnt, err := oi.RefCnt(addr)
+	if err != nil || refCnt != 2 {
+		t.Fatalf("Expected reference count 2, got %d: %v", refCnt, err)
 	}
-}
 
-func TestAddOrGetAndDeleteByVal25(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = Shoco
-	testAddOrGetAndDeleteByVal(t, 25, 501, cnf)
-}
+	prefix := []byte("servername.")
+	for idx, suffix := range testBytes {
+		addr, err := oi.AddOrGetConcat(prefix, suffix)
+		if err != nil {
+			t.Fatal("Failed to AddOrGetConcat: ", err)
+		}
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != "servername."+testStrings[idx] {
+			t.Fatalf("Expected %q, got %q: %v", "servername."+testStrings[idx], str, err)
+		}
+	}
 
-func TestAddOrGetAndDeleteByVal250(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = Shoco
-	testAddOrGetAndDeleteByVal(t, 250, 501, cnf)
+	// the pooled buffer must not be referenced by interned objects
+	str, err := oi.GetStringFromPtr(addr)
+	if err != nil || str != "ab" {
+		t.Fatalf("Expected %q, got %q: %v", "ab", str, err)
+	}
+
+	if _, err := oi.AddOrGetConcat(); err != ErrEmptyObject {
+		t.Fatalf("Expected ErrEmptyObject without parts, got %v", err)
+	}
+	if _, err := oi.AddOrGetConcat(nil, []byte{}); err != ErrEmptyObject {
+		t.Fatalf("Expected ErrEmptyObject for empty parts, got %v", err)
+	}
 }
 
-func TestAddOrGetAndDeleteByValNoCprsn25(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = None
-	testAddOrGetAndDeleteByVal(t, 25, 501, cnf)
+func TestAddOrGetFromString(t *testing.T) {
+	testAddOrGetFromString(t, false, false)
 }
 
-func TestAddOrGetAndDeleteByValNoCprsn250(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = None
-	testAddOrGetAndDeleteByVal(t, 250, 501, cnf)
+func TestAddOrGetFromStringCompressed(t *testing.T) {
+	testAddOrGetFromString(t, true, false)
 }
 
-func testAddOrGetAndDeleteByVal(t *testing.T, keySize int, numKeys int, cnf ObjectInternConfig) {
-	oi := NewObjectIntern(cnf)
+func TestAddOrGetFromStringHashed(t *testing.T) {
+	testAddOrGetFromString(t, false, true)
+}
 
-	// slice to store addresses
-	addrs := make([]uintptr, 0)
-	// generate numKeys random strings of keySize length
-	originalSzs := make([]string, 0)
-	// also generate compressed versions stored in []byte
-	decompBytes := make([][]byte, 0)
-	for i := 0; i < numKeys; i++ {
-		sz := randStringBytesMaskImprSrc(keySize)
-		originalSzs = append(originalSzs, sz)
-		decompBytes = append(decompBytes, []byte(sz))
+func testAddOrGetFromString(t *testing.T, compress bool, hashed bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
 	}
+	c.HashedIndex = hashed
+	oi := NewObjectIntern(c)
 
-	// reference count should be 1 after this finishes
-	for _, sz := range originalSzs {
-		addr, err := oi.AddOrGet([]byte(sz), true)
+	addrs := make([]uintptr, len(testStrings))
+	for idx, s := range testStrings {
+		addr, err := oi.AddOrGetFromString(s, false)
 		if err != nil {
-			t.Error("Failed to AddOrGet: ", []byte(sz))
-			return
+			t.Fatal("Failed to AddOrGetFromString: ", s)
 		}
-		// add addr to addrs
-		addrs = append(addrs, addr)
+		addrs[idx] = addr
 	}
 
-	// reference count should be 2 after this finishes
-	for _, sz := range originalSzs {
-		_, err := oi.AddOrGet([]byte(sz), true)
-		if err != nil {
-			t.Error("Failed to AddOrGet: ", []byte(sz))
-			return
+	for idx, s := range testStrings {
+		// both a string and a []byte must find the object added from a string
+		addr, err := oi.AddOrGetFromString(s, true)
+		if err != nil || addr != addrs[idx] {
+			t.Fatalf("Expected %q at %d, got %d: %v", s, addrs[idx], addr, err)
+		}
+		addr, err = oi.AddOrGet([]byte(s), true)
+		if err != nil || addr != addrs[idx] {
+			t.Fatalf("Expected %q at %d, got %d: %v", s, addrs[idx], addr, err)
+		}
+		if refCnt, err := oi.RefCnt(addr); err != nil || refCnt != 3 {
+			t.Fatalf("Expected reference count 3 for %q, got %d: %v", s, refCnt, err)
+		}
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != s {
+			t.Fatalf("Expected %q, got %q: %v", s, str, err)
 		}
 	}
 
-	// decrease reference count by 1, it should now be 1 again
-	for _, compObj := range decompBytes {
-		ok, err := oi.DeleteByByte(compObj)
-		if err != nil {
-			t.Error("Failed to delete object (possibly not found in the object store): ", compObj)
-			return
+	if _, err := oi.AddOrGetFromString("", true); err != ErrEmptyObject {
+		t.Fatalf("Expected ErrEmptyObject, got %v", err)
+	}
+}
+
+func TestAddOrGetStringCompressedCached(t *testing.T) {
+	c := NewConfig()
+	c.Compression = Shoco
+	c.CacheSize = len(testStrings)
+	oi := NewObjectIntern(c)
+
+	for _, s := range testStrings {
+		obj := []byte(s)
+		str, err := oi.AddOrGetString(obj, false)
+		if err != nil || str != s {
+			t.Fatalf("Expected %s, got %s: %v", s, str, err)
 		}
-		if ok {
-			t.Error("Ok should be false since reference count is at 1 now")
-			return
+
+		// the returned string must not alias the caller's []byte
+		obj[0]++
+		if str != s {
+			t.Fatalf("Expected %s to be unchanged, got %s", s, str)
 		}
-	}
 
-	// decrease reference count by 1, now objects should be deleted (slabs are deleted as well)
-	for _, compObj := range decompBytes {
-		ok, err := oi.DeleteByByte(compObj)
-		if err != nil {
-			t.Error("Failed to delete object (possibly not found in the object store): ", compObj)
-			return
+		// further calls return the same string
+		str2, err := oi.AddOrGetString([]byte(s), true)
+		if err != nil || str2 != s {
+			t.Fatalf("Expected %s, got %s: %v", s, str2, err)
 		}
-		if !ok {
-			t.Error("Ok should be true since object should have been deleted")
-			return
+		if (*reflect.StringHeader)(unsafe.Pointer(&str)).Data != (*reflect.StringHeader)(unsafe.Pointer(&str2)).Data {
+			t.Fatalf("Expected both strings of %s to alias the cached object", s)
 		}
 	}
-
 }
 
-func TestAddOrGetAndDeleteByValSz25(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = Shoco
-	testAddOrGetAndDeleteByValSz(t, 25, 501, cnf)
-}
+func TestAddOrGetStringLength(t *testing.T) {
+	configs := []struct {
+		name        string
+		compression Compression
+		cacheSize   int
+	}{
+		{"Uncompressed", None, 0},
+		{"Compressed", Shoco, 0},
+		{"CompressedCached", Shoco, 10},
+	}
 
-func TestAddOrGetAndDeleteByValSz250(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = Shoco
-	testAddOrGetAndDeleteByValSz(t, 250, 501, cnf)
-}
+	// these compress to fewer bytes, or expand in shoco's literal encoding
+	strs := []string{"the other thing", "interesting.metrics.then", "\x80\x81\xfe\xff", "z", "qqqqqqqq"}
 
-func TestAddOrGetAndDeleteByValSzNoCprsn25(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = None
-	testAddOrGetAndDeleteByValSz(t, 25, 501, cnf)
-}
+	for _, cnf := range configs {
+		t.Run(cnf.name, func(t *testing.T) {
+			c := NewConfig()
+			c.Compression = cnf.compression
+			c.CacheSize = cnf.cacheSize
+			oi := NewObjectIntern(c)
 
-func TestAddOrGetAndDeleteByValSzNoCprsn250(t *testing.T) {
-	cnf := NewConfig()
-	cnf.Compression = None
-	testAddOrGetAndDeleteByValSz(t, 250, 501, cnf)
-}
+			var differ bool
+			for _, s := range strs {
+				if cnf.compression != None && len(oi.compress([]byte(s))) != len(s) {
+					differ = true
+				}
 
-func testAddOrGetAndDeleteByValSz(t *testing.T, keySize int, numKeys int, cnf ObjectInternConfig) {
-	oi := NewObjectIntern(cnf)
+				// the first call adds the object, the others find it, safe and unsafe
+				for i, safe := range []bool{true, false, true} {
+					str, addr, err := oi.AddOrGetStringAddr([]byte(s), safe)
+					if err != nil {
+						t.Fatal("Failed to AddOrGetStringAddr: ", err)
+					}
+					if str != s {
+						t.Fatalf("Expected %q after %d calls, got %q", s, i+1, str)
+					}
 
-	// slice to store addresses
-	addrs := make([]uintptr, 0)
-	// generate numKeys random strings of keySize length
-	originalSzs := make([]string, 0)
-	for i := 0; i < numKeys; i++ {
-		sz := randStringBytesMaskImprSrc(keySize)
-		originalSzs = append(originalSzs, sz)
-	}
+					str, err = oi.GetStringFromPtr(addr)
+					if err != nil || str != s {
+						t.Fatalf("Expected GetStringFromPtr to return %q, got %q: %v", s, str, err)
+					}
+				}
+			}
 
-	// reference count should be 1 after this finishes
-	for _, sz := range originalSzs {
-		addr, err := oi.AddOrGet([]byte(sz), true)
-		if err != nil {
-			t.Error("Failed to AddOrGet: ", []byte(sz))
-			return
-		}
-		// add addr to addrs
-		addrs = append(addrs, addr)
+			if cnf.compression != None && !differ {
+				t.Fatal("Expected some of the compressed objects to differ in length")
+			}
+		})
 	}
+}
 
-	// reference count should be 2 after this finishes
-	for _, sz := range originalSzs {
-		_, err := oi.AddOrGet([]byte(sz), true)
-		if err != nil {
-			t.Error("Failed to AddOrGet: ", []byte(sz))
-			return
-		}
+func BenchmarkAddOrGetStringRepeated(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		compression Compression
+		cacheSize   int
+	}{
+		{"Uncompressed", None, 0},
+		{"Compressed", Shoco, 0},
+		{"CompressedCached", Shoco, 100},
 	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c := NewConfig()
+			c.Compression = bm.compression
+			c.CacheSize = bm.cacheSize
+			oi := NewObjectIntern(c)
+			obj := []byte("some.metric.name.1234")
 
-	// decrease reference count by 1, it should now be 1 again
-	for _, sz := range originalSzs {
-		ok, err := oi.DeleteByString(sz)
-		if err != nil {
-			t.Error("Failed to delete object (possibly not found in the object store): ", sz)
-			return
-		}
-		if ok {
-			t.Error("Ok should be false since reference count is at 1 now")
-			return
-		}
-	}
+			b.ResetTimer()
+			b.ReportAllocs()
 
-	// decrease reference count by 1, now objects should be deleted (slabs are deleted as well)
-	for _, sz := range originalSzs {
-		ok, err := oi.DeleteByString(sz)
-		if err != nil {
-			t.Error("Failed to delete object (possibly not found in the object store): ", sz)
-			return
-		}
-		if !ok {
-			t.Error("Ok should be true since object should have been deleted")
-			return
-		}
+			for i := 0; i < b.N; i++ {
+				globalStr, _ = oi.AddOrGetString(obj, false)
+			}
+		})
 	}
-
 }
 
-func TestObjBytes(t *testing.T) {
-	testObjBytes(t, false)
+func TestAddOrGetStringAddr(t *testing.T) {
+	testAddOrGetStringAddr(t, true, false)
+	testAddOrGetStringAddr(t, false, false)
 }
 
-func TestObjBytesCompressed(t *testing.T) {
-	testObjBytes(t, true)
+func TestAddOrGetStringAddrCompressed(t *testing.T) {
+	testAddOrGetStringAddr(t, true, true)
+	testAddOrGetStringAddr(t, false, true)
 }
 
-func testObjBytes(t *testing.T, compress bool) {
+func testAddOrGetStringAddr(t *testing.T, safe bool, compress bool) {
 	c := NewConfig()
 	if compress {
 		c.Compression = Shoco
 	}
 	oi := NewObjectIntern(c)
 
-	objAddrs := make([]uintptr, 0)
+	for i := 0; i < 2; i++ {
+		for idx, b := range testBytes {
+			str, addr, err := oi.AddOrGetStringAddr(b, safe)
+			if err != nil {
+				t.Fatal("Failed to AddOrGetStringAddr: ", err)
+			}
+			if str != testStrings[idx] {
+				t.Fatalf("Expected %s, instead got %s", testStrings[idx], str)
+			}
 
-	for _, b := range testBytes {
-		addr, err := oi.AddOrGet(b, true)
-		if err != nil {
-			t.Error("Failed to AddOrGet: ", b)
-			return
+			ptr, err := oi.GetPtrFromByte(b)
+			if err != nil {
+				t.Fatal("Failed to GetPtrFromByte: ", err)
+			}
+			if ptr != addr {
+				t.Fatalf("Expected address %d, instead got %d", ptr, addr)
+			}
+
+			// without compression the string uses the interned data
+			if !compress && (*reflect.StringHeader)(unsafe.Pointer(&str)).Data != addr+4 {
+				t.Fatal("String should point to the interned object: ", str)
+			}
+
+			if cnt, err := oi.RefCnt(addr); err != nil || cnt != uint32(i+1) {
+				t.Fatalf("Expected reference count %d, instead got %d and %v", i+1, cnt, err)
+			}
 		}
-		objAddrs = append(objAddrs, addr)
 	}
+}
 
-	for idx, addr := range objAddrs {
-		valFromStore, err := oi.ObjBytes(addr)
+func TestAddOrGetBoth(t *testing.T) {
+	testAddOrGetBoth(t, false)
+}
+
+func TestAddOrGetBothCompressed(t *testing.T) {
+	testAddOrGetBoth(t, true)
+}
+
+func testAddOrGetBoth(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	for idx, b := range testBytes {
+		addr, str, err := oi.AddOrGetBoth(b, true)
 		if err != nil {
-			t.Error("Failed while getting ObjBytes")
-			return
+			t.Fatal("Failed to AddOrGetBoth: ", err)
 		}
-		if !bytes.Equal(valFromStore, testBytes[idx]) {
-			t.Error("Original and returned values do not match")
-			return
+		if str != testStrings[idx] {
+			t.Fatalf("Expected %s, instead got %s", testStrings[idx], str)
+		}
+		if resolved, err := oi.GetStringFromPtr(addr); err != nil || resolved != str {
+			t.Fatalf("Expected %d to resolve to %s, instead got %s and %v", addr, str, resolved, err)
 		}
 	}
+
+	if addr, str, err := oi.AddOrGetBoth(nil, true); err != ErrEmptyObject || addr != 0 || str != "" {
+		t.Fatalf("Expected ErrEmptyObject, instead got %d, %q and %v", addr, str, err)
+	}
 }
 
-func TestObjString(t *testing.T) {
-	testObjString(t, false)
+func TestReplaceValue(t *testing.T) {
+	testReplaceValue(t, false)
 }
 
-func TestObjStringCompressed(t *testing.T) {
-	testObjString(t, true)
+func TestReplaceValueCompressed(t *testing.T) {
+	testReplaceValue(t, true)
 }
 
-func testObjString(t *testing.T, compress bool) {
+func testReplaceValue(t *testing.T, compress bool) {
 	c := NewConfig()
 	if compress {
 		c.Compression = Shoco
 	}
 	oi := NewObjectIntern(c)
 
-	objAddrs := make([]uintptr, 0)
+	// keep another object of the same size around, so the slab is not unmapped
+	if _, err := oi.AddOrGet([]byte("spelling"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
 
-	for _, b := range testBytes {
-		addr, err := oi.AddOrGet(b, true)
-		if err != nil {
-			t.Error("Failed to AddOrGet: ", b)
-			return
-		}
-		objAddrs = append(objAddrs, addr)
+	h, err := oi.AddOrGetHandle([]byte("speling"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetHandle: ", err)
+	}
+	addr, _ := oi.ResolveHandle(h)
+	if _, err = oi.IncRefCnt(addr); err != nil {
+		t.Fatal("Failed to IncRefCnt: ", err)
 	}
 
-	for idx, addr := range objAddrs {
-		valFromStore, err := oi.ObjString(addr)
-		if err != nil {
-			t.Error("Failed while getting ObjString")
-			return
+	expectValue := func(addr uintptr, value string, oldValue string) {
+		t.Helper()
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != value {
+			t.Fatalf("Expected %s, instead got %s and %v", value, str, err)
 		}
-		if valFromStore != testStrings[idx] {
-			t.Error("Original and returned values do not match")
-			return
+		if ptr, err := oi.GetPtrFromByte([]byte(value)); err != nil || ptr != addr {
+			t.Fatalf("Expected %s to be found at %d, instead got %d and %v", value, addr, ptr, err)
+		}
+		if _, err := oi.GetPtrFromByte([]byte(oldValue)); err == nil {
+			t.Fatalf("Old value %s should not be found anymore", oldValue)
+		}
+		if cnt, err := oi.RefCnt(addr); err != nil || cnt != 2 {
+			t.Fatalf("Expected reference count 2, instead got %d and %v", cnt, err)
+		}
+		if resolved, ok := oi.ResolveHandle(h); !ok || resolved != addr {
+			t.Fatalf("Expected handle to resolve to %d, instead got %d", addr, resolved)
+		}
+		if err := oi.Verify(); err != nil {
+			t.Fatal("Verify failed: ", err)
 		}
 	}
-}
 
-func TestCompressDecompress(t *testing.T) {
+	// same length, the object is replaced in place
+	newAddr, err := oi.ReplaceValue(addr, []byte("spelinG"))
+	if err != nil {
+		t.Fatal("Failed to ReplaceValue: ", err)
+	}
+	if !compress && newAddr != addr {
+		t.Fatalf("Expected object to keep its address %d, instead got %d", addr, newAddr)
+	}
+	expectValue(newAddr, "spelinG", "speling")
+
+	// different length, the object is moved
+	addr = newAddr
+	newAddr, err = oi.ReplaceValue(addr, []byte("corrected spelling"))
+	if err != nil {
+		t.Fatal("Failed to ReplaceValue: ", err)
+	}
+	if newAddr == addr {
+		t.Fatal("Expected object to move to a new address")
+	}
+	expectValue(newAddr, "corrected spelling", "spelinG")
+
+	// an already interned value, the objects are merged
+	existing, err := oi.GetPtrFromByte([]byte("spelling"))
+	if err != nil {
+		t.Fatal("Failed to GetPtrFromByte: ", err)
+	}
+	merged, err := oi.ReplaceValue(newAddr, []byte("spelling"))
+	if err != nil {
+		t.Fatal("Failed to ReplaceValue: ", err)
+	}
+	if merged != existing {
+		t.Fatalf("Expected objects to be merged at %d, instead got %d", existing, merged)
+	}
+	if cnt, err := oi.RefCnt(merged); err != nil || cnt != 3 {
+		t.Fatalf("Expected reference count 3, instead got %d and %v", cnt, err)
+	}
+	if _, ok := oi.ResolveHandle(h); !ok {
+		t.Fatal("Handle should follow the merged object")
+	}
+}
+
+func TestAddOrGetMap(t *testing.T) {
+	testAddOrGetMap(t, false)
+}
+
+func TestAddOrGetMapCompressed(t *testing.T) {
+	testAddOrGetMap(t, true)
+}
+
+func testAddOrGetMap(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	// every test object is passed in three times
+	objs := make([][]byte, 0, len(testBytes)*3)
+	for i := 0; i < 3; i++ {
+		for _, b := range testBytes {
+			objs = append(objs, append([]byte(nil), b...))
+		}
+	}
+
+	addrs, err := oi.AddOrGetMap(objs, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetMap: ", err)
+	}
+	if len(addrs) != len(testStrings) {
+		t.Fatalf("Expected %d entries, instead got %d", len(testStrings), len(addrs))
+	}
+
+	for _, s := range testStrings {
+		addr, ok := addrs[s]
+		if !ok {
+			t.Fatalf("Missing entry for %s", s)
+		}
+		if ptr, err := oi.GetPtrFromByte([]byte(s)); err != nil || ptr != addr {
+			t.Fatalf("Expected %s to be interned at %d, instead got %d and %v", s, addr, ptr, err)
+		}
+		if cnt, err := oi.RefCnt(addr); err != nil || cnt != 1 {
+			t.Fatalf("Expected reference count 1 for %s, instead got %d and %v", s, cnt, err)
+		}
+	}
+
+	// a failing object releases all references acquired so far
+	if _, err = oi.AddOrGetMap([][]byte{[]byte("metric"), []byte("fresh"), nil}, true); err == nil {
+		t.Fatal("Expected AddOrGetMap to fail for an empty object")
+	}
+	if cnt, err := oi.RefCnt(addrs["metric"]); err != nil || cnt != 1 {
+		t.Fatalf("Expected reference count 1 after a failed AddOrGetMap, instead got %d and %v", cnt, err)
+	}
+	if _, err = oi.GetPtrFromByte([]byte("fresh")); err == nil {
+		t.Fatal("Objects of a failed AddOrGetMap should not stay interned")
+	}
+}
+
+func TestAddOrGetAllLines(t *testing.T) {
+	testAddOrGetAllLines(t, false)
+}
+
+func TestAddOrGetAllLinesCompressed(t *testing.T) {
+	testAddOrGetAllLines(t, true)
+}
+
+func testAddOrGetAllLines(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	data := []byte("alpha\nbeta\n\ngamma\nalpha\n")
+	orig := append([]byte(nil), data...)
+
+	addrs, err := oi.AddOrGetAllLines(data, '\n')
+	if err != nil {
+		t.Fatal("Failed to AddOrGetAllLines: ", err)
+	}
+	if !bytes.Equal(data, orig) {
+		t.Fatalf("Expected data to be unmodified, got %q", data)
+	}
+
+	// the empty line and the one after the trailing separator are skipped
+	expected := []string{"alpha", "beta", "gamma", "alpha"}
+	if len(addrs) != len(expected) {
+		t.Fatalf("Expected %d addresses, got %d", len(expected), len(addrs))
+	}
+	for i, s := range expected {
+		if str, err := oi.GetStringFromPtr(addrs[i]); err != nil || str != s {
+			t.Fatalf("Expected %s at index %d, got %s: %v", s, i, str, err)
+		}
+	}
+	if addrs[0] != addrs[3] {
+		t.Fatalf("Expected both alpha lines to be deduplicated, got %d and %d", addrs[0], addrs[3])
+	}
+	if cnt, err := oi.RefCnt(addrs[0]); err != nil || cnt != 2 {
+		t.Fatalf("Expected reference count 2 for alpha, got %d: %v", cnt, err)
+	}
+
+	for _, data := range []string{"", ",", ",,,"} {
+		if addrs, err := oi.AddOrGetAllLines([]byte(data), ','); err != nil || len(addrs) != 0 {
+			t.Fatalf("Expected no addresses for %q, got %v: %v", data, addrs, err)
+		}
+	}
+	if addrs, err := oi.AddOrGetAllLines([]byte("beta"), ','); err != nil || len(addrs) != 1 {
+		t.Fatalf("Expected a single address without a separator, got %v: %v", addrs, err)
+	}
+
+	// a failing line releases all references acquired so far
+	tooLong := strings.Repeat("x", 300)
+	if _, err := oi.AddOrGetAllLines([]byte("gamma,fresh,"+tooLong), ','); err == nil {
+		t.Fatal("Expected AddOrGetAllLines to fail for an object which is too large")
+	}
+	if cnt, err := oi.RefCnt(addrs[2]); err != nil || cnt != 1 {
+		t.Fatalf("Expected reference count 1 after a failed AddOrGetAllLines, got %d: %v", cnt, err)
+	}
+	if _, err := oi.GetPtrFromByte([]byte("fresh")); err == nil {
+		t.Fatal("Objects of a failed AddOrGetAllLines should not stay interned")
+	}
+}
+
+func TestMaxInternLen(t *testing.T) {
+	testMaxInternLen(t, false, false)
+}
+
+func TestMaxInternLenCompressed(t *testing.T) {
+	testMaxInternLen(t, true, false)
+}
+
+func TestMaxInternLenHashed(t *testing.T) {
+	testMaxInternLen(t, false, true)
+}
+
+func testMaxInternLen(t *testing.T, compress bool, hashed bool) {
+	c := NewConfig()
+	c.MaxInternLen = 20
+	c.HashedIndex = hashed
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	long := testBytes[len(testBytes)-1]
+	if len(oi.Compress(long)) <= c.MaxInternLen {
+		t.Fatal("Test object is not long enough")
+	}
+
+	addr, err := oi.AddOrGet(long, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	addr2, err := oi.AddOrGet(long, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if addr == addr2 {
+		t.Fatal("Objects longer than MaxInternLen should not be deduplicated")
+	}
+
+	for _, a := range []uintptr{addr, addr2} {
+		str, err := oi.GetStringFromPtr(a)
+		if err != nil || str != string(long) {
+			t.Fatalf("Expected %s, instead got %s and %v", long, str, err)
+		}
+		if cnt, err := oi.RefCnt(a); err != nil || cnt != 1 {
+			t.Fatalf("Expected reference count 1, instead got %d and %v", cnt, err)
+		}
+	}
+	if _, err = oi.GetPtrFromByte(long); err == nil {
+		t.Fatal("Objects longer than MaxInternLen should not be in the index")
+	}
+
+	// short objects are still deduplicated
+	short, _ := oi.AddOrGet([]byte("metric"), true)
+	short2, _ := oi.AddOrGet([]byte("metric"), true)
+	if short != short2 {
+		t.Fatal("Objects shorter than MaxInternLen should be deduplicated")
+	}
+
+	// a single delete removes the object
+	deleted, err := oi.Delete(addr)
+	if err != nil || !deleted {
+		t.Fatalf("Expected object to be removed, instead got %t and %v", deleted, err)
+	}
+	if _, ok := oi.unindexed[addr]; ok {
+		t.Fatal("Removed object is still tracked")
+	}
+
+	// the remaining long object is still part of the ObjectIntern
+	found := false
+	for _, size := range oi.ActivePoolSizes() {
+		if int(size) == oi.SizeOf(long) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Remaining long object is not part of the active pools")
+	}
+
+	if err = oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	if len(oi.unindexed) != 0 {
+		t.Fatal("Reset should remove all objects longer than MaxInternLen")
+	}
+}
+
+func TestIncDecRefCntBy(t *testing.T) {
 	oi := NewObjectIntern(NewConfig())
-	testResults := make([][]byte, 0)
+	oi2 := NewObjectIntern(NewConfig())
+	const n = 10000
+
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		addr2, err := oi2.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+
+		rc, err := oi.IncRefCntBy(addr, n)
+		if err != nil {
+			t.Fatal("Failed to IncRefCntBy: ", addr)
+		}
+		for i := 0; i < n; i++ {
+			if _, err := oi2.IncRefCnt(addr2); err != nil {
+				t.Fatal("Failed to IncRefCnt: ", addr2)
+			}
+		}
+		rc2, err := oi2.RefCnt(addr2)
+		if err != nil {
+			t.Fatal("Failed to get reference count: ", addr2)
+		}
+		if rc != n+1 || rc != rc2 {
+			t.Fatalf("Reference count should be %d, instead found %d and %d", n+1, rc, rc2)
+		}
+
+		rc, err = oi.DecRefCntBy(addr, n/2)
+		if err != nil {
+			t.Fatal("Failed to DecRefCntBy: ", addr)
+		}
+		for i := 0; i < n/2; i++ {
+			if _, err := oi2.Delete(addr2); err != nil {
+				t.Fatal("Failed to Delete: ", addr2)
+			}
+		}
+		rc2, err = oi2.RefCnt(addr2)
+		if err != nil {
+			t.Fatal("Failed to get reference count: ", addr2)
+		}
+		if rc != n/2+1 || rc != rc2 {
+			t.Fatalf("Reference count should be %d, instead found %d and %d", n/2+1, rc, rc2)
+		}
+
+		// decrementing past the last reference saturates instead of deleting
+		rc, err = oi.DecRefCntBy(addr, n)
+		if err != nil {
+			t.Fatal("Failed to DecRefCntBy: ", addr)
+		}
+		if rc != 1 {
+			t.Fatalf("Reference count should saturate at 1, instead found %d", rc)
+		}
+		if _, err := oi.GetStringFromPtr(addr); err != nil {
+			t.Fatal("Object should still exist after saturating decrement: ", addr)
+		}
+	}
+
+	if _, err := oi.IncRefCntBy(0, 1); err == nil {
+		t.Fatal("IncRefCntBy should fail for an unknown address")
+	}
+	if _, err := oi.DecRefCntBy(0, 1); err == nil {
+		t.Fatal("DecRefCntBy should fail for an unknown address")
+	}
+}
+
+func TestSwapRefCnt(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	a, err := oi.AddOrGet([]byte("hot"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	b, err := oi.AddOrGet([]byte("cold"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if _, err := oi.IncRefCntBy(a, 9); err != nil {
+		t.Fatal("Failed to IncRefCntBy: ", err)
+	}
+	if _, err := oi.IncRefCntBy(b, 2); err != nil {
+		t.Fatal("Failed to IncRefCntBy: ", err)
+	}
+
+	if err := oi.SwapRefCnt(a, b); err != nil {
+		t.Fatal("Failed to SwapRefCnt: ", err)
+	}
+	if rc, _ := oi.RefCnt(a); rc != 3 {
+		t.Fatalf("Reference count of a should be 3, instead found %d", rc)
+	}
+	if rc, _ := oi.RefCnt(b); rc != 10 {
+		t.Fatalf("Reference count of b should be 10, instead found %d", rc)
+	}
+
+	// swapping an object with itself changes nothing
+	if err := oi.SwapRefCnt(b, b); err != nil {
+		t.Fatal("Failed to SwapRefCnt: ", err)
+	}
+	if rc, _ := oi.RefCnt(b); rc != 10 {
+		t.Fatalf("Reference count of b should be 10, instead found %d", rc)
+	}
+
+	// an unknown object fails without modifying the other one
+	if err := oi.SwapRefCnt(a, 0); err == nil {
+		t.Fatal("SwapRefCnt should fail for an unknown address")
+	}
+	if rc, _ := oi.RefCnt(a); rc != 3 {
+		t.Fatalf("Reference count of a should be 3, instead found %d", rc)
+	}
+
+	// an object with a reference count of 0 would leave the other one unreferenced
+	*(*uint32)(unsafe.Pointer(b)) = 0
+	if err := oi.SwapRefCnt(a, b); err == nil {
+		t.Fatal("SwapRefCnt should fail for a reference count of 0")
+	}
+	if rc, _ := oi.RefCnt(a); rc != 3 {
+		t.Fatalf("Reference count of a should be 3, instead found %d", rc)
+	}
+
+	c := NewConfig()
+	c.DisableRefCounting = true
+	if err := NewObjectIntern(c).SwapRefCnt(a, b); err != ErrNoRefCounting {
+		t.Fatalf("Expected ErrNoRefCounting, got %v", err)
+	}
+}
+
+func TestDeleteIfRefCnt(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	// keep the slab mapped once the object has been removed, see TestDeleteRace
+	if _, err := oi.AddOrGet([]byte("pinned"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+
+	addr, err := oi.AddOrGet([]byte("casobj"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if _, err := oi.AddOrGet([]byte("casobj"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+
+	rc, err := oi.RefCnt(addr)
+	if err != nil || rc != 2 {
+		t.Fatalf("Reference count should be 2, instead found %d: %v", rc, err)
+	}
+
+	// another caller increments the reference count after it has been read
+	done := make(chan error)
+	go func() {
+		_, err := oi.IncRefCnt(addr)
+		done <- err
+	}()
+	if err := <-done; err != nil {
+		t.Fatal("Failed to IncRefCnt: ", err)
+	}
+
+	if ok, err := oi.DeleteIfRefCnt(addr, rc); ok || err != nil {
+		t.Fatalf("Expected the stale delete to do nothing, got %t: %v", ok, err)
+	}
+	if rc, _ := oi.RefCnt(addr); rc != 3 {
+		t.Fatalf("Reference count should be 3, instead found %d", rc)
+	}
+
+	for rc := uint32(3); rc > 0; rc-- {
+		if ok, err := oi.DeleteIfRefCnt(addr, rc+1); ok || err != nil {
+			t.Fatalf("Expected the delete with a wrong count to do nothing, got %t: %v", ok, err)
+		}
+		if ok, err := oi.DeleteIfRefCnt(addr, rc); !ok || err != nil {
+			t.Fatalf("Expected the delete of reference %d to proceed, got %t: %v", rc, ok, err)
+		}
+	}
+	if oi.Count() != 1 {
+		t.Fatalf("Expected only the pinned object, got %d objects", oi.Count())
+	}
+	if ok, err := oi.DeleteIfRefCnt(addr, 1); ok || err == nil {
+		t.Fatalf("Expected an error for the removed object, got %t: %v", ok, err)
+	}
+
+	c := NewConfig()
+	c.DisableRefCounting = true
+	if _, err := NewObjectIntern(c).DeleteIfRefCnt(addr, 1); err != ErrNoRefCounting {
+		t.Fatalf("Expected ErrNoRefCounting, got %v", err)
+	}
+}
+
+func TestStringAndRefCnt(t *testing.T) {
+	testStringAndRefCnt(t, false)
+}
+
+func TestStringAndRefCntCompressed(t *testing.T) {
+	testStringAndRefCnt(t, true)
+}
+
+func testStringAndRefCnt(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	// object i is referenced i+1 times
+	addrs := make([]uintptr, len(testStrings))
+	for i, s := range testStrings {
+		for n := 0; n <= i; n++ {
+			addr, err := oi.AddOrGet([]byte(s), true)
+			if err != nil {
+				t.Fatal("Failed to AddOrGet: ", s)
+			}
+			addrs[i] = addr
+		}
+	}
+
+	for i, addr := range addrs {
+		str, rc, err := oi.StringAndRefCnt(addr)
+		if err != nil {
+			t.Fatal("Failed to StringAndRefCnt: ", err)
+		}
+		if str != testStrings[i] {
+			t.Fatalf("Expected: %s\nActual: %s\n", testStrings[i], str)
+		}
+		if rc != uint32(i+1) {
+			t.Fatalf("Reference count of %s should be %d, instead found %d", str, i+1, rc)
+		}
+	}
+
+	if _, _, err := oi.StringAndRefCnt(0); err == nil {
+		t.Fatal("StringAndRefCnt should fail for an unknown address")
+	}
+
+	c.DisableRefCounting = true
+	if _, _, err := NewObjectIntern(c).StringAndRefCnt(addrs[0]); err != ErrNoRefCounting {
+		t.Fatalf("Expected ErrNoRefCounting, got %v", err)
+	}
+}
+
+func TestGetStringFromPtrUnsafe(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+
+		safe, err := oi.GetStringFromPtr(addr)
+		if err != nil {
+			t.Fatal("Failed to GetStringFromPtr: ", addr)
+		}
+		unsafeSz := oi.GetStringFromPtrUnsafe(addr, len(b))
+		if unsafeSz != string(b) || unsafeSz != safe {
+			t.Fatalf("Expected: %s\nActual: %s\n", string(b), unsafeSz)
+		}
+
+		// both strings should point at the interned data
+		if (*reflect.StringHeader)(unsafe.Pointer(&unsafeSz)).Data != (*reflect.StringHeader)(unsafe.Pointer(&safe)).Data {
+			t.Fatal("Uintptr mismatch for: ", string(b))
+		}
+	}
+}
+
+func TestActivePoolSizes(t *testing.T) {
+	testActivePoolSizes(t, false)
+}
+
+func TestActivePoolSizesCompressed(t *testing.T) {
+	testActivePoolSizes(t, true)
+}
+
+func testActivePoolSizes(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	if sizes := oi.ActivePoolSizes(); len(sizes) != 0 {
+		t.Fatalf("Expected no active pools, instead got %v", sizes)
+	}
+
+	expected := make(map[uint8]bool)
+	var last uintptr
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		// 4 bytes are added to every object for the reference count
+		expected[uint8(len(oi.Compress(b))+4)] = true
+		last = addr
+	}
+
+	// a pool whose objects have all been deleted is not active anymore
+	lastSize := uint8(len(oi.Compress(testBytes[len(testBytes)-1])) + 4)
+	if _, err := oi.Delete(last); err != nil {
+		t.Fatal("Failed to Delete: ", err)
+	}
+	delete(expected, lastSize)
+
+	sizes := oi.ActivePoolSizes()
+	if len(sizes) != len(expected) {
+		t.Fatalf("Expected %d active pools, instead got %v", len(expected), sizes)
+	}
+	for i, size := range sizes {
+		if !expected[size] {
+			t.Fatalf("Unexpected active pool %d", size)
+		}
+		if i > 0 && sizes[i-1] >= size {
+			t.Fatalf("Active pools are not sorted: %v", sizes)
+		}
+		if _, err := oi.MemStatsByObjSize(size); err != nil {
+			t.Fatalf("Failed to get MemStatsByObjSize for active pool %d: %v", size, err)
+		}
+	}
+}
+
+func TestObjectsInPool(t *testing.T) {
+	testObjectsInPool(t, false)
+}
+
+func TestObjectsInPoolCompressed(t *testing.T) {
+	testObjectsInPool(t, true)
+}
+
+func testObjectsInPool(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	// 250 objects of the same length span several slabs of the pool
+	expected := make(map[uintptr]bool)
+	var size uint8
+	for i := 0; i < 250; i++ {
+		obj := []byte(fmt.Sprintf("%08d", i))
+		addr, err := oi.AddOrGet(obj, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", obj)
+		}
+		expected[addr] = true
+		size = uint8(oi.SizeOf(obj))
+	}
+	// objects of other sizes are not part of the pool
+	for _, b := range testBytes {
+		if oi.SizeOf(b) == int(size) {
+			continue
+		}
+		if _, err := oi.AddOrGet(b, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+	}
+
+	addrs, err := oi.ObjectsInPool(size)
+	if err != nil {
+		t.Fatal("Failed to ObjectsInPool: ", err)
+	}
+	if len(addrs) != len(expected) {
+		t.Fatalf("Expected %d objects in pool %d, got %d", len(expected), size, len(addrs))
+	}
+	for i, addr := range addrs {
+		if !expected[addr] {
+			t.Fatalf("Unexpected object %d in pool %d", addr, size)
+		}
+		if i > 0 && addrs[i-1] >= addr {
+			t.Fatalf("Addresses are not sorted: %v", addrs)
+		}
+	}
+
+	if addrs, err := oi.ObjectsInPool(size + 100); err == nil {
+		t.Fatalf("Expected an error for a pool which doesn't exist, got %v", addrs)
+	}
+}
+
+func TestRefCntHistogram(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	// object i is referenced i+1 times
+	for i, obj := range testBytes {
+		for n := 0; n <= i; n++ {
+			if _, err := oi.AddOrGet(obj, true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", err)
+			}
+		}
+	}
+
+	hist := oi.RefCntHistogram([]uint32{1, 2, 5})
+	expected := []int{1, 1, 3, 5}
+	if !reflect.DeepEqual(hist, expected) {
+		t.Fatalf("Expected histogram %v, got %v", expected, hist)
+	}
+
+	hist = oi.RefCntHistogram(nil)
+	if !reflect.DeepEqual(hist, []int{len(testBytes)}) {
+		t.Fatalf("Expected all objects in a single bucket, got %v", hist)
+	}
+
+	c := NewConfig()
+	c.DisableRefCounting = true
+	oi = NewObjectIntern(c)
+	for _, obj := range testBytes {
+		if _, err := oi.AddOrGet(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+	}
+	hist = oi.RefCntHistogram([]uint32{1, 2})
+	expected = []int{len(testBytes), 0, 0}
+	if !reflect.DeepEqual(hist, expected) {
+		t.Fatalf("Expected histogram %v without reference counting, got %v", expected, hist)
+	}
+}
+
+func TestEmptyObject(t *testing.T) {
+	testEmptyObject(t, false)
+}
+
+func TestEmptyObjectCompressed(t *testing.T) {
+	testEmptyObject(t, true)
+}
+
+func testEmptyObject(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	for _, obj := range [][]byte{nil, {}} {
+		for _, safe := range []bool{true, false} {
+			if addr, err := oi.AddOrGet(obj, safe); err != ErrEmptyObject || addr != 0 {
+				t.Fatalf("Expected AddOrGet to return 0 and ErrEmptyObject, instead got %d and %v", addr, err)
+			}
+			if str, err := oi.AddOrGetString(obj, safe); err != ErrEmptyObject || str != "" {
+				t.Fatalf("Expected AddOrGetString to return an empty string and ErrEmptyObject, instead got %q and %v", str, err)
+			}
+		}
+		if addr, err := oi.GetPtrFromByte(obj); err != ErrEmptyObject || addr != 0 {
+			t.Fatalf("Expected GetPtrFromByte to return 0 and ErrEmptyObject, instead got %d and %v", addr, err)
+		}
+		if ok, err := oi.DeleteByByte(obj); err != ErrEmptyObject || ok {
+			t.Fatalf("Expected DeleteByByte to return false and ErrEmptyObject, instead got %t and %v", ok, err)
+		}
+	}
+
+	if ok, err := oi.DeleteByString(""); err != ErrEmptyObject || ok {
+		t.Fatalf("Expected DeleteByString to return false and ErrEmptyObject, instead got %t and %v", ok, err)
+	}
+	if ok, err := oi.IncRefCntByString(""); err != ErrEmptyObject || ok {
+		t.Fatalf("Expected IncRefCntByString to return false and ErrEmptyObject, instead got %t and %v", ok, err)
+	}
+
+	// nothing may have been interned
+	if sizes := oi.ActivePoolSizes(); len(sizes) != 0 {
+		t.Fatalf("Expected no interned objects, instead found pools %v", sizes)
+	}
+}
+
+func TestSizeOf(t *testing.T) {
+	testSizeOf(t, false, true)
+	testSizeOf(t, false, false)
+}
+
+func TestSizeOfCompressed(t *testing.T) {
+	testSizeOf(t, true, true)
+}
+
+func testSizeOf(t *testing.T, compress bool, refCounting bool) {
+	c := NewConfig()
+	c.SlabSize = 50
+	c.DisableRefCounting = !refCounting
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	if size := oi.SizeOf(nil); size != 0 {
+		t.Fatalf("Expected size 0 for an empty object, instead got %d", size)
+	}
+
+	// all of these objects have the same length, so they fill exactly one slab
+	objs := make([][]byte, 0, c.SlabSize)
+	for i := 0; i < int(c.SlabSize); i++ {
+		objs = append(objs, []byte(fmt.Sprintf("sizeof%03d", i)))
+	}
+
+	size := oi.SizeOf(objs[0])
+	expected := len(oi.Compress(objs[0]))
+	if refCounting {
+		expected += 4
+	}
+	if size != expected {
+		t.Fatalf("Expected size %d, instead got %d", expected, size)
+	}
+
+	before, _ := oi.MemStatsTotal()
+	for _, obj := range objs {
+		if oi.SizeOf(obj) != size {
+			// compressed objects of the same length may compress to different sizes
+			continue
+		}
+		if _, err := oi.AddOrGet(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+	}
+	after, _ := oi.MemStatsTotal()
+
+	// the objects are stored in the pool for their size
+	pool, err := oi.MemStatsByObjSize(uint8(size))
+	if err != nil {
+		t.Fatal("Failed to MemStatsByObjSize: ", err)
+	}
+	if pool != after-before {
+		t.Fatalf("Expected all memory to be used by pool %d, instead it uses %d of %d", size, pool, after-before)
+	}
+
+	// the slab uses the memory of its objects plus a small header
+	objMem := uint64(size) * uint64(c.SlabSize)
+	if after-before < objMem || after-before-objMem > 64 {
+		t.Fatalf("Expected the slab to use a little more than %d bytes, instead it uses %d", objMem, after-before)
+	}
+}
+
+func TestPoolForLen(t *testing.T) {
+	for _, refCounting := range []bool{true, false} {
+		c := NewConfig()
+		c.DisableRefCounting = !refCounting
+		oi := NewObjectIntern(c)
+
+		overhead := 0
+		if refCounting {
+			overhead = 4
+		}
+
+		if oi.PoolForLen(0) != 0 || oi.PoolForLen(-1) != 0 {
+			t.Fatal("Expected no pool for empty objects")
+		}
+		if oi.PoolForLen(256-overhead) != 0 {
+			t.Fatalf("Expected no pool for objects of %d bytes", 256-overhead)
+		}
+
+		var last uint8
+		for _, length := range []int{1, 2, 3, 10, 50, 100, 200, 255 - overhead} {
+			pool := oi.PoolForLen(length)
+			if int(pool) != length+overhead || pool < last {
+				t.Fatalf("Expected pool %d for length %d after pool %d, got %d", length+overhead, length, last, pool)
+			}
+			last = pool
+
+			// the object really lands in that pool
+			obj := bytes.Repeat([]byte{'p'}, length)
+			if oi.PoolForLen(oi.SizeOf(obj)-overhead) != pool {
+				t.Fatalf("Expected SizeOf to agree with PoolForLen for length %d", length)
+			}
+			addr, err := oi.AddOrGet(obj, true)
+			if err != nil {
+				t.Fatal("Failed to AddOrGet: ", err)
+			}
+			addrs, err := oi.ObjectsInPool(pool)
+			if err != nil || len(addrs) != 1 || addrs[0] != addr {
+				t.Fatalf("Expected %d in pool %d, got %v: %v", addr, pool, addrs, err)
+			}
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	testNormalize(t, false)
+}
+
+func TestNormalizeCompressed(t *testing.T) {
+	testNormalize(t, true)
+}
+
+func testNormalize(t *testing.T, compress bool) {
+	c := NewConfig()
+	c.Normalize = bytes.ToLower
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	variants := []string{"server", "Server", "SERVER", "sErVeR"}
+
+	addr, err := oi.AddOrGet([]byte(variants[0]), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", variants[0])
+	}
+
+	for _, v := range variants[1:] {
+		addr2, err := oi.AddOrGet([]byte(v), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", v)
+		}
+		if addr2 != addr {
+			t.Fatal("Mixed case variants should share one address: ", v)
+		}
+
+		sz, err := oi.AddOrGetString([]byte(v), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGetString: ", v)
+		}
+		if sz != "server" {
+			t.Fatalf("Expected: server\nActual: %s\n", sz)
+		}
+
+		addr3, err := oi.GetPtrFromByte([]byte(v))
+		if err != nil || addr3 != addr {
+			t.Fatal("GetPtrFromByte should find the normalized object: ", v)
+		}
+	}
+
+	if len(oi.objIndex) != 1 {
+		t.Fatalf("Index should contain 1 object, instead found %d", len(oi.objIndex))
+	}
+
+	rc, err := oi.RefCnt(addr)
+	if err != nil {
+		t.Fatal("Failed to get reference count: ", addr)
+	}
+	if rc != uint32(2*len(variants)-1) {
+		t.Fatalf("Reference count should be %d, instead found %d", 2*len(variants)-1, rc)
+	}
+
+	if _, err := oi.IncRefCntByString("SeRvEr"); err != nil {
+		t.Fatal("IncRefCntByString should find the normalized object")
+	}
+	rc++
+
+	for i := uint32(0); i < rc; i++ {
+		var ok bool
+		if i%2 == 0 {
+			ok, err = oi.DeleteByByte([]byte(variants[i%uint32(len(variants))]))
+		} else {
+			ok, err = oi.DeleteByString(variants[i%uint32(len(variants))])
+		}
+		if err != nil {
+			t.Fatal("Failed to delete normalized object: ", err)
+		}
+		if ok != (i == rc-1) {
+			t.Fatalf("Object should only be removed with the last reference, removed: %t at %d", ok, i)
+		}
+	}
+}
+
+func TestTrimSpace(t *testing.T) {
+	testTrimSpace(t, false)
+}
+
+func TestTrimSpaceCompressed(t *testing.T) {
+	testTrimSpace(t, true)
+}
+
+func testTrimSpace(t *testing.T, compress bool) {
+	c := NewConfig()
+	c.TrimSpace = true
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	variants := []string{"server", "server ", " server", "\tserver\n", "  server  "}
+
+	addr, err := oi.AddOrGet([]byte(variants[0]), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", variants[0])
+	}
+
+	for _, v := range variants[1:] {
+		addr2, err := oi.AddOrGet([]byte(v), true)
+		if err != nil {
+			t.Fatalf("Failed to AddOrGet: %q", v)
+		}
+		if addr2 != addr {
+			t.Fatalf("Padded variants should share one address: %q", v)
+		}
+
+		addr3, err := oi.GetPtrFromByte([]byte(v))
+		if err != nil || addr3 != addr {
+			t.Fatalf("GetPtrFromByte should find the trimmed object: %q", v)
+		}
+	}
+
+	sz, err := oi.GetStringFromPtr(addr)
+	if err != nil {
+		t.Fatal("Failed to GetStringFromPtr: ", err)
+	}
+	if sz != "server" {
+		t.Fatalf("Expected the trimmed form to be stored: %q", sz)
+	}
+
+	if oi.Count() != 1 {
+		t.Fatalf("Index should contain 1 object, instead found %d", oi.Count())
+	}
+
+	for i := range variants {
+		var ok bool
+		if i%2 == 0 {
+			ok, err = oi.DeleteByByte([]byte(variants[i]))
+		} else {
+			ok, err = oi.DeleteByString(variants[i])
+		}
+		if err != nil {
+			t.Fatal("Failed to delete trimmed object: ", err)
+		}
+		if ok != (i == len(variants)-1) {
+			t.Fatalf("Object should only be removed with the last reference, removed: %t at %d", ok, i)
+		}
+	}
+}
+
+func TestGetPtrFromByteBatch(t *testing.T) {
+	testGetPtrFromByteBatch(t, false)
+}
+
+func TestGetPtrFromByteBatchCompressed(t *testing.T) {
+	testGetPtrFromByteBatch(t, true)
+}
+
+func testGetPtrFromByteBatch(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	expected := make([]uintptr, 0, len(testBytes))
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		expected = append(expected, addr)
+	}
+
+	addrs, errs := oi.GetPtrFromByteBatch(testBytes)
+	if len(addrs) != len(testBytes) || len(errs) != len(testBytes) {
+		t.Fatalf("Expected %d results, got %d addresses and %d errors", len(testBytes), len(addrs), len(errs))
+	}
+	for idx := range testBytes {
+		if errs[idx] != nil {
+			t.Fatalf("Failed to resolve %q: %v", testBytes[idx], errs[idx])
+		}
+		if addrs[idx] != expected[idx] {
+			t.Fatalf("Expected %q at %d, got %d", testBytes[idx], expected[idx], addrs[idx])
+		}
+	}
+
+	// misses only fail their own index
+	addrs, errs = oi.GetPtrFromByteBatch([][]byte{testBytes[0], []byte("missing"), nil, testBytes[1]})
+	if errs[0] != nil || addrs[0] != expected[0] || errs[3] != nil || addrs[3] != expected[1] {
+		t.Fatalf("Expected hits to resolve, got %v and %v", addrs, errs)
+	}
+	if errs[1] == nil || addrs[1] != 0 {
+		t.Fatalf("Expected a miss for an object which was never added, got %d", addrs[1])
+	}
+	if errs[2] != ErrEmptyObject {
+		t.Fatalf("Expected ErrEmptyObject, got %v", errs[2])
+	}
+
+	// reference counts are not changed
+	refCnt, err := oi.RefCnt(expected[0])
+	if err != nil || refCnt != 1 {
+		t.Fatalf("Expected reference count 1, got %d: %v", refCnt, err)
+	}
+}
+
+func TestGetAllByPrefix(t *testing.T) {
+	testGetAllByPrefix(t, false)
+}
+
+func TestGetAllByPrefixCompressed(t *testing.T) {
+	testGetAllByPrefix(t, true)
+}
+
+func testGetAllByPrefix(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	expected := make(map[uintptr]struct{})
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		if bytes.HasPrefix(b, []byte("server")) {
+			expected[addr] = struct{}{}
+		}
+	}
+
+	matches, err := oi.GetAllByPrefix([]byte("server"))
+	if err != nil {
+		t.Fatal("Failed to GetAllByPrefix: ", err)
+	}
+	if len(matches) != len(expected) {
+		t.Fatalf("Expected %d matches, instead found %d", len(expected), len(matches))
+	}
+	for _, addr := range matches {
+		if _, ok := expected[addr]; !ok {
+			t.Fatal("Unexpected match: ", addr)
+		}
+		rc, err := oi.RefCnt(addr)
+		if err != nil || rc != 1 {
+			t.Fatal("GetAllByPrefix should not change the reference count: ", rc)
+		}
+	}
+
+	matches, err = oi.GetAllByPrefix([]byte("doesNotExist"))
+	if err != nil || len(matches) != 0 {
+		t.Fatal("There should not be any matches")
+	}
+}
+
+func TestRefCntsByPrefix(t *testing.T) {
+	testRefCntsByPrefix(t, false)
+}
+
+func TestRefCntsByPrefixCompressed(t *testing.T) {
+	testRefCntsByPrefix(t, true)
+}
+
+func testRefCntsByPrefix(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	// object i is referenced i+1 times
+	expected := make(map[string]uint32)
+	for i, b := range testBytes {
+		for n := 0; n <= i; n++ {
+			if _, err := oi.AddOrGet(b, true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", b)
+			}
+		}
+		if bytes.HasPrefix(b, []byte("server")) {
+			expected[string(b)] = uint32(i + 1)
+		}
+	}
+
+	refCnts, err := oi.RefCntsByPrefix([]byte("server"))
+	if err != nil {
+		t.Fatal("Failed to RefCntsByPrefix: ", err)
+	}
+	if !reflect.DeepEqual(refCnts, expected) {
+		t.Fatalf("Expected %v, got %v", expected, refCnts)
+	}
+
+	refCnts, err = oi.RefCntsByPrefix([]byte("doesNotExist"))
+	if err != nil || len(refCnts) != 0 {
+		t.Fatalf("There should not be any matches, got %v", refCnts)
+	}
+
+	c.DisableRefCounting = true
+	oi = NewObjectIntern(c)
+	if _, err := oi.RefCntsByPrefix([]byte("server")); err != ErrNoRefCounting {
+		t.Fatalf("Expected ErrNoRefCounting, got %v", err)
+	}
+}
+
+func TestSortedValues(t *testing.T) {
+	testSortedValues(t, false)
+}
+
+func TestSortedValuesCompressed(t *testing.T) {
+	testSortedValues(t, true)
+}
+
+func testSortedValues(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	// every object is added twice, it must only be returned once
+	for i := 0; i < 2; i++ {
+		for _, s := range testStrings {
+			if _, err := oi.AddOrGet([]byte(s), true); err != nil {
+				t.Fatal("Failed to AddOrGet: ", s)
+			}
+		}
+	}
+
+	expected := append([]string(nil), testStrings...)
+	sort.Strings(expected)
+
+	values, err := oi.SortedValues()
+	if err != nil {
+		t.Fatal("Failed to SortedValues: ", err)
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Expected %v, got %v", expected, values)
+	}
+
+	oi = NewObjectIntern(c)
+	if values, err := oi.SortedValues(); err != nil || len(values) != 0 {
+		t.Fatalf("Expected no values, got %v: %v", values, err)
+	}
+}
+
+func TestTotalLen(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	ptrs := make([]uintptr, 0, len(testBytes))
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		ptrs = append(ptrs, addr)
+	}
+
+	lens, all := oi.Len(ptrs)
+	if !all {
+		t.Fatal("Len should have found all objects")
+	}
+	var expected int
+	for _, ln := range lens {
+		expected += ln
+	}
+
+	total, all := oi.TotalLen(ptrs)
+	if !all || total != expected {
+		t.Fatalf("Expected %d and true, got %d and %t", expected, total, all)
+	}
+
+	if total, all := oi.TotalLen(nil); !all || total != 0 {
+		t.Fatalf("Expected 0 and true for no addresses, got %d and %t", total, all)
+	}
+
+	// stops at the first missing address
+	total, all = oi.TotalLen([]uintptr{ptrs[0], 0, ptrs[1]})
+	if all || total != lens[0] {
+		t.Fatalf("Expected %d and false, got %d and %t", lens[0], total, all)
+	}
+}
+
+func TestAddOrGetAndDelete25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	testAddOrGetAndDelete(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDelete250(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	testAddOrGetAndDelete(t, 250, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteNoCprsn25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = None
+	testAddOrGetAndDelete(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteNoCprsn250(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = None
+	testAddOrGetAndDelete(t, 250, 501, cnf)
+}
+
+func testAddOrGetAndDelete(t *testing.T, keySize int, numKeys int, cnf ObjectInternConfig) {
+	oi := NewObjectIntern(cnf)
+
+	// slice to store addresses
+	addrs := make([]uintptr, 0)
+	// generate numKeys random strings of keySize length
+	originalSzs := make([]string, 0)
+	for i := 0; i < numKeys; i++ {
+		sz := randStringBytesMaskImprSrc(keySize)
+		originalSzs = append(originalSzs, sz)
+	}
+
+	// reference count should be 1 after this finishes
+	for _, sz := range originalSzs {
+		addr, err := oi.AddOrGet([]byte(sz), true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", []byte(sz))
+			return
+		}
+		// add addr to addrs
+		addrs = append(addrs, addr)
+	}
+
+	// reference count should be 2 after this finishes
+	for _, sz := range originalSzs {
+		_, err := oi.AddOrGet([]byte(sz), true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", []byte(sz))
+			return
+		}
+	}
+
+	// decrease reference count by 1, it should now be 1 again
+	for _, addr := range addrs {
+		ok, err := oi.Delete(addr)
+		if err != nil {
+			t.Error("Failed to delete object (possibly not found in the object store): ", addr)
+			return
+		}
+		if ok {
+			t.Error("Ok should be false since reference count is at 1 now")
+			return
+		}
+	}
+
+	// decrease reference count by 1, now objects should be deleted (slabs are deleted as well)
+	for _, addr := range addrs {
+		ok, err := oi.Delete(addr)
+		if err != nil {
+			t.Error("Failed to delete object (possibly not found in the object store): ", addr)
+			return
+		}
+		if !ok {
+			t.Error("Ok should be true since object should have been deleted")
+			return
+		}
+	}
+
+}
+
+func TestBatchDelete501(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = None
+	testBatchDelete(t, 30, 501, cnf)
+}
+
+func TestBatchDeleteNoCprsn(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	testBatchDelete(t, 30, 501, cnf)
+}
+
+func testBatchDelete(t *testing.T, keySize int, numKeys int, cnf ObjectInternConfig) {
+	oi := NewObjectIntern(cnf)
+
+	// slice to store addresses
+	addrs := make([]uintptr, 0)
+	// generate numKeys random strings of keySize length
+	originalSzs := make([]string, 0)
+	for i := 0; i < numKeys; i++ {
+		sz := randStringBytesMaskImprSrc(keySize)
+		originalSzs = append(originalSzs, sz)
+	}
+
+	// reference count should be 1 after this finishes
+	for _, sz := range originalSzs {
+		addr, err := oi.AddOrGet([]byte(sz), true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", []byte(sz))
+			return
+		}
+		// add addr to addrs
+		addrs = append(addrs, addr)
+	}
+
+	// reference count should be 10 after this finishes
+	for i := 0; i < 9; i++ {
+		for _, sz := range originalSzs {
+			_, err := oi.AddOrGet([]byte(sz), true)
+			if err != nil {
+				t.Error("Failed to AddOrGet: ", []byte(sz))
+				return
+			}
+		}
+	}
+
+	// use subslice to decrease and then delete a subslice of the original
+	// added strings.
+	subAddrs := addrs[:numKeys-5]
+	for i := 0; i < 10; i++ {
+		oi.DeleteBatch(subAddrs)
+	}
+
+	// check to make sure the last 5 still exist
+	lastFive := addrs[numKeys-5:]
+	for _, ptr := range lastFive {
+		_, err := oi.GetStringFromPtr(ptr)
+		if err != nil {
+			t.Error("Could not find string in object store")
+			return
+		}
+	}
+
+	// delete everything
+	for i := 0; i < 10; i++ {
+		oi.DeleteBatch(lastFive)
+	}
+
+	// make sure none of the items exist in the store
+	for _, ptr := range addrs {
+		_, err := oi.GetStringFromPtr(ptr)
+		if err == nil {
+			t.Error("Object should not have been found in the store")
+			return
+		}
+	}
+}
+
+func TestMemStatsPerPool(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	addrs := make([]uintptr, 0)
+	for _, tmpBytes := range testBytes {
+		addr, err := oi.AddOrGet(tmpBytes, true)
+		if err != nil {
+			t.Error("Failed to add object to object store")
+		}
+		addrs = append(addrs, addr)
+	}
+
+	m := oi.MemStatsPerPool()
+	for _, s := range m {
+		t.Logf("ObjectSize: %d\nMemUsed: %d\n\n", s.ObjSize, s.MemUsed)
+	}
+}
+
+func TestFragReport(t *testing.T) {
+	c := NewConfig()
+	c.SlabSize = 10
+	oi := NewObjectIntern(c)
+
+	// fill 10 slabs of the same object size
+	addrs := make([]uintptr, 0, 100)
+	for i := 0; i < 100; i++ {
+		addr, err := oi.AddOrGet([]byte(fmt.Sprintf("fragmented%03d", i)), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", i)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	// fill a single slab of a different object size
+	for i := 0; i < 10; i++ {
+		if _, err := oi.AddOrGet([]byte(fmt.Sprintf("full%d", i)), true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", i)
+		}
+	}
+
+	// leave only a single object in every slab of the first pool
+	for idx, addr := range addrs {
+		if idx%10 == 0 {
+			continue
+		}
+		if _, err := oi.Delete(addr); err != nil {
+			t.Fatal("Failed to Delete: ", addr)
+		}
+	}
+
+	report := oi.FragReport()
+	if len(report) != 2 {
+		t.Fatalf("Report should contain 2 pools, instead found %d", len(report))
+	}
+
+	// "full0" is shorter than "fragmented000", so it is sorted first
+	full, fragmented := report[0], report[1]
+	if full.ObjSize != uint8(len("full0")+4) || fragmented.ObjSize != uint8(len("fragmented000")+4) {
+		t.Fatalf("Unexpected object sizes: %d, %d", full.ObjSize, fragmented.ObjSize)
+	}
+	if full.Recommended || full.Fragmentation != 0 || full.Objects != 10 {
+		t.Fatalf("Full pool should not be recommended for compaction: %+v", full)
+	}
+	if !fragmented.Recommended || fragmented.Objects != 10 {
+		t.Fatalf("Fragmented pool should be recommended for compaction: %+v", fragmented)
+	}
+}
+
+func TestMemStatsForSizes(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+
+	for _, b := range testBytes {
+		if _, err := oi.AddOrGet(b, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+	}
+
+	// 4 bytes are added to every object for the reference count
+	sizes := []uint8{uint8(len("metric") + 4), 1, uint8(len("root") + 4), 255}
+	mem, errs := oi.MemStatsForSizes(sizes)
+	if len(mem) != len(sizes) || len(errs) != len(sizes) {
+		t.Fatalf("Expected %d results, instead found %d and %d", len(sizes), len(mem), len(errs))
+	}
+
+	for idx, size := range sizes {
+		expected, expectedErr := oi.MemStatsByObjSize(size)
+		if mem[idx] != expected || (errs[idx] == nil) != (expectedErr == nil) {
+			t.Fatalf("Mismatch for size %d: %d, %v", size, mem[idx], errs[idx])
+		}
+	}
+
+	if errs[0] != nil || mem[0] == 0 || errs[2] != nil || mem[2] == 0 {
+		t.Fatal("Valid size classes should report their memory usage")
+	}
+	if errs[1] == nil || errs[3] == nil {
+		t.Fatal("Invalid size classes should report an error")
+	}
+}
+
+func TestDeleteBatchResult(t *testing.T) {
+	testDeleteBatchResult(t, false)
+}
+
+func TestDeleteBatchResultCompressed(t *testing.T) {
+	testDeleteBatchResult(t, true)
+}
+
+func testDeleteBatchResult(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	once, err := oi.AddOrGet([]byte("once"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	twice, err := oi.AddOrGet([]byte("twice"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if _, err = oi.AddOrGet([]byte("twice"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	missing, err := oi.AddOrGet([]byte("missing"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if _, err = oi.Delete(missing); err != nil {
+		t.Fatal("Failed to Delete: ", err)
+	}
+
+	ptrs := []uintptr{once, twice, missing}
+	results := oi.DeleteBatchResult(ptrs)
+	expected := []DeleteResult{Removed, Decremented, NotFound}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, instead got %d", len(expected), len(results))
+	}
+	for i := range expected {
+		if results[i] != expected[i] {
+			t.Errorf("Expected result %d for index %d, instead got %d", expected[i], i, results[i])
+		}
+	}
+	if ptrs[0] != once || ptrs[1] != twice || ptrs[2] != missing {
+		t.Fatal("DeleteBatchResult modified its input")
+	}
+
+	if _, err = oi.GetPtrFromByte([]byte("once")); err == nil {
+		t.Fatal("Removed object is still in the index")
+	}
+	if cnt, err := oi.RefCnt(twice); err != nil || cnt != 1 {
+		t.Fatalf("Expected reference count 1, instead got %d and %v", cnt, err)
+	}
+
+	results = oi.DeleteBatchResult([]uintptr{twice})
+	if results[0] != Removed {
+		t.Fatalf("Expected result %d, instead got %d", Removed, results[0])
+	}
+}
+
+func TestFreeDeadBatch(t *testing.T) {
+	testFreeDeadBatch(t, false)
+}
+
+func TestFreeDeadBatchCompressed(t *testing.T) {
+	testFreeDeadBatch(t, true)
+}
+
+func testFreeDeadBatch(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	var evicted []string
+	cnf.OnEvict = func(addr uintptr, data []byte) {
+		evicted = append(evicted, string(data))
+	}
+	oi := NewObjectIntern(cnf)
+
+	// every other object is referenced three times
+	var ptrs []uintptr
+	var singletons []string
+	for i, s := range testStrings {
+		addr, err := oi.AddOrGet([]byte(s), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		if i%2 == 1 {
+			if _, err := oi.IncRefCntBy(addr, 2); err != nil {
+				t.Fatal("Failed to IncRefCntBy: ", err)
+			}
+		} else {
+			singletons = append(singletons, s)
+		}
+		ptrs = append(ptrs, addr)
+	}
+	// duplicates and unknown addresses are skipped
+	ptrs = append(ptrs, ptrs[0], 0)
+	orig := append([]uintptr(nil), ptrs...)
+
+	if removed := oi.FreeDeadBatch(ptrs); removed != len(singletons) {
+		t.Fatalf("Expected %d objects to be removed, got %d", len(singletons), removed)
+	}
+	if !reflect.DeepEqual(ptrs, orig) {
+		t.Fatal("Expected FreeDeadBatch not to modify ptrs")
+	}
+	sort.Strings(evicted)
+	sort.Strings(singletons)
+	if !reflect.DeepEqual(evicted, singletons) {
+		t.Fatalf("Expected OnEvict to be called for %v, got %v", singletons, evicted)
+	}
+
+	for i, s := range testStrings {
+		addr, err := oi.GetPtrFromByte([]byte(s))
+		if i%2 == 0 {
+			if err == nil {
+				t.Fatalf("Expected %s to be removed", s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Expected %s to remain: %v", s, err)
+		}
+		if cnt, err := oi.RefCnt(addr); err != nil || cnt != 3 {
+			t.Fatalf("Expected reference count 3 for %s, got %d: %v", s, cnt, err)
+		}
+	}
+
+	// the addresses of the removed objects must not be used anymore
+	var shared []uintptr
+	for i := 1; i < len(testStrings); i += 2 {
+		shared = append(shared, orig[i])
+	}
+	if removed := oi.FreeDeadBatch(shared); removed != 0 {
+		t.Fatalf("Expected no objects to be removed, got %d", removed)
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	testOnEvict(t, false)
+}
+
+func TestOnEvictCompressed(t *testing.T) {
+	testOnEvict(t, true)
+}
+
+func testOnEvict(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+
+	var oi *ObjectIntern
+	evicted := make(map[uintptr][]string)
+	c.OnEvict = func(addr uintptr, data []byte) {
+		evicted[addr] = append(evicted[addr], string(data))
+		// the write lock must not be held anymore
+		if _, err := oi.GetPtrFromByte(data); err == nil {
+			t.Errorf("Evicted object %q is still in the index", data)
+		}
+	}
+	oi = NewObjectIntern(c)
+
+	deleteFuncs := []func(addr uintptr){
+		func(addr uintptr) { oi.Delete(addr) },
+		func(addr uintptr) { oi.DeleteUnsafe(addr) },
+		func(addr uintptr) { oi.DeleteBatch([]uintptr{addr}) },
+		func(addr uintptr) { oi.DeleteBatchUnsafe([]uintptr{addr}) },
+		func(addr uintptr) { oi.DeleteBatchResult([]uintptr{addr}) },
+	}
+
+	for idx, obj := range testBytes {
+		del := deleteFuncs[idx%len(deleteFuncs)]
+
+		addr, err := oi.AddOrGet(obj, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		if _, err := oi.AddOrGet(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+
+		del(addr)
+		if len(evicted[addr]) != 0 {
+			t.Fatalf("OnEvict was called for %q before its last reference was dropped", obj)
+		}
+
+		del(addr)
+		if len(evicted[addr]) != 1 || evicted[addr][0] != testStrings[idx] {
+			t.Fatalf("Expected OnEvict to be called once with %q, got %q", testStrings[idx], evicted[addr])
+		}
+		delete(evicted, addr)
+	}
+
+	// Reset does not evict objects one by one
+	if _, err := oi.AddOrGet(testBytes[0], true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if err := oi.Reset(); err != nil {
+		t.Fatal("Failed to Reset: ", err)
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("Expected OnEvict not to be called by Reset, got %v", evicted)
+	}
+}
+
+func TestClose(t *testing.T) {
+	testClose(t, false)
+}
+
+func TestCloseCompressed(t *testing.T) {
+	testClose(t, true)
+}
+
+func testClose(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	var addrs []uintptr
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	if err := oi.Close(); err != nil {
+		t.Fatal("Failed to close: ", err)
+	}
+
+	if _, err := oi.AddOrGet([]byte("metric"), true); err != ErrClosed {
+		t.Fatalf("Expected AddOrGet to return ErrClosed, instead got %v", err)
+	}
+	if _, err := oi.GetStringFromPtr(addrs[0]); err != ErrClosed {
+		t.Fatalf("Expected GetStringFromPtr to return ErrClosed, instead got %v", err)
+	}
+	if _, err := oi.Delete(addrs[0]); err != ErrClosed {
+		t.Fatalf("Expected Delete to return ErrClosed, instead got %v", err)
+	}
+	if mem, err := oi.MemStatsTotal(); mem != 0 || err == nil {
+		t.Fatalf("Expected MemStatsTotal to report 0 and an error, instead got %d and %v", mem, err)
+	}
+	if err := oi.Reset(); err != ErrClosed {
+		t.Fatalf("Expected Reset to return ErrClosed, instead got %v", err)
+	}
+	if err := oi.Close(); err != ErrClosed {
+		t.Fatalf("Expected second Close to return ErrClosed, instead got %v", err)
+	}
+}
+
+func TestNoRefCounting(t *testing.T) {
+	testNoRefCounting(t, false, false)
+}
+
+func TestNoRefCountingCompressed(t *testing.T) {
+	testNoRefCounting(t, true, false)
+}
+
+func TestNoRefCountingHashed(t *testing.T) {
+	testNoRefCounting(t, false, true)
+}
+
+func testNoRefCounting(t *testing.T, compress bool, hashed bool) {
+	cnf := NewConfig()
+	cnf.DisableRefCounting = true
+	cnf.HashedIndex = hashed
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	addrs := make([]uintptr, 0, len(testBytes))
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	for i, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		if addr != addrs[i] {
+			t.Fatalf("Expected %s to be interned at %d, instead got %d", b, addrs[i], addr)
+		}
+
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil {
+			t.Fatal("Failed to GetStringFromPtr: ", err)
+		}
+		if str != string(b) {
+			t.Fatalf("Expected %s, instead got %s", b, str)
+		}
+
+		str, err = oi.AddOrGetString(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGetString: ", err)
+		}
+		if str != string(b) {
+			t.Fatalf("Expected %s, instead got %s", b, str)
+		}
+
+		ptr, err := oi.GetPtrFromByte(b)
+		if err != nil || ptr != addr {
+			t.Fatalf("Expected GetPtrFromByte to return %d, instead got %d and %v", addr, ptr, err)
+		}
+	}
+
+	joined, err := oi.JoinStrings(addrs, ".")
+	if err != nil {
+		t.Fatal("Failed to JoinStrings: ", err)
+	}
+	if joined != strings.Join(testStrings, ".") {
+		t.Fatalf("Expected %s, instead got %s", strings.Join(testStrings, "."), joined)
+	}
+
+	if _, err = oi.RefCnt(addrs[0]); err != ErrNoRefCounting {
+		t.Fatalf("Expected RefCnt to return ErrNoRefCounting, instead got %v", err)
+	}
+	if _, err = oi.IncRefCnt(addrs[0]); err != ErrNoRefCounting {
+		t.Fatalf("Expected IncRefCnt to return ErrNoRefCounting, instead got %v", err)
+	}
+	if _, err = oi.Delete(addrs[0]); err != ErrNoRefCounting {
+		t.Fatalf("Expected Delete to return ErrNoRefCounting, instead got %v", err)
+	}
+	oi.DeleteBatch(addrs)
+	for _, b := range testBytes {
+		if _, err = oi.GetPtrFromByte(b); err != nil {
+			t.Fatalf("Object %s was deleted without reference counting", b)
+		}
+	}
+}
+
+func TestNoRefCountingMemory(t *testing.T) {
+	cnf := NewConfig()
+	withRefCnt := NewObjectIntern(cnf)
+	cnf.DisableRefCounting = true
+	withoutRefCnt := NewObjectIntern(cnf)
+
+	for _, b := range testBytes {
+		withRefCnt.AddOrGet(b, true)
+		withoutRefCnt.AddOrGet(b, true)
+	}
+
+	// objects are 4 bytes smaller, so they end up in different pools
+	if _, err := withoutRefCnt.MemStatsByObjSize(uint8(len("metric"))); err != nil {
+		t.Fatal("Expected object to be stored without reference count: ", err)
+	}
+	if _, err := withRefCnt.MemStatsByObjSize(uint8(len("metric"))); err == nil {
+		t.Fatal("Expected object to be stored with reference count")
+	}
+}
+
+// TestRefCountingLiteralConfig checks that a config which is not created by NewConfig
+// still counts references
+func TestRefCountingLiteralConfig(t *testing.T) {
+	oi := NewObjectIntern(ObjectInternConfig{SlabSize: 100, NewStore: NewGosStore})
+
+	addr, err := oi.AddOrGet([]byte("metric"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if _, err := oi.AddOrGet([]byte("metric"), true); err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if cnt, err := oi.RefCnt(addr); err != nil || cnt != 2 {
+		t.Fatalf("Expected a reference count of 2, got %d: %v", cnt, err)
+	}
+	if removed, err := oi.Delete(addr); err != nil || removed {
+		t.Fatalf("Expected the reference count to be decremented, got %t: %v", removed, err)
+	}
+}
+
+func TestJoinStringsCompressed(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	testJoinStrings(t, cnf)
+}
+
+func TestJoinStringsUncompressed(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = None
+	testJoinStrings(t, cnf)
+}
+
+func testJoinStrings(t *testing.T, cnf ObjectInternConfig) {
+	oi := NewObjectIntern(cnf)
+
+	addrs := make([]uintptr, 0)
+	for _, tmpBytes := range testBytes {
+		addr, err := oi.AddOrGet(tmpBytes, true)
+		if err != nil {
+			t.Error("Failed to add object to object store")
+		}
+		addrs = append(addrs, addr)
+	}
+
+	expected := "SmallString.LongerString.AnEvenLongerString.metric.root.server.servername1234.servername4321.servername91FFXX.AndTheLongestStringWeDealWithWithEvenASmallAmountOfSpaceMoreToGetUsOverTheGiganticLimitOfStuff"
+
+	joinedString, err := oi.JoinStrings(addrs, ".")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if joinedString != expected {
+		t.Errorf("Expected: %s\nActual: %s\n", expected, joinedString)
+		return
+	}
+
+	joinedString, err = oi.JoinStrings([]uintptr{}, ".")
+	if err == nil {
+		t.Error("We should have an error here")
+		return
+	}
+
+	joinedString, err = oi.JoinStrings([]uintptr{addrs[0]}, ".")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if joinedString != string(testBytes[0]) {
+		t.Errorf("Expected: %s\nActual: %s\n", string(testBytes[0]), joinedString)
+		return
+	}
+}
+
+func TestJoinStringsSingleCharCompressed(t *testing.T) {
+	// every byte value, the non-ASCII ones and NUL are expanded to 2 bytes by shoco
+	chars := make([][]byte, 0, 256)
+	for c := 0; c < 256; c++ {
+		chars = append(chars, []byte{byte(c)})
+	}
+	// separators which shoco would compress, and which contain bytes it expands
+	seps := []string{"th", "\x00\x00", "\xc3\xa9", " -> ", "a"}
+
+	for _, cacheSize := range []int{0, 64} {
+		cnf := NewConfig()
+		cnf.Compression = Shoco
+		cnf.CacheSize = cacheSize
+		oi := NewObjectIntern(cnf)
+
+		addrs := make([]uintptr, 0, 2*len(chars))
+		var values []string
+		for round := 0; round < 2; round++ {
+			for _, c := range chars {
+				addr, err := oi.AddOrGet(c, true)
+				if err != nil {
+					t.Fatalf("Failed to AddOrGet %v: %v", c, err)
+				}
+				addrs = append(addrs, addr)
+				values = append(values, string(c))
+			}
+		}
+		if size := oi.SizeOf([]byte{0xff}); size != 4+2 {
+			t.Fatalf("Expected 0xff to be stored expanded to 2 bytes, got a size of %d", size)
+		}
+
+		for _, sep := range seps {
+			expected := strings.Join(values, sep)
+			joined, err := oi.JoinStrings(addrs, sep)
+			if err != nil {
+				t.Fatal("Failed to JoinStrings: ", err)
+			}
+			if joined != expected {
+				t.Fatalf("Expected %d bytes joined with %q, got %d bytes: %q", len(expected), sep, len(joined), joined)
+			}
+		}
+	}
+}
+
+func TestJoinSize(t *testing.T) {
+	// sum many large synthetic lengths the same way JoinStrings does
+	const sep = 1
+	lengths := make([]int, 1000)
+	for i := range lengths {
+		lengths[i] = maxJoinSize / 500
+	}
+
+	var size int
+	var err error
+	for idx, n := range lengths {
+		if idx > 0 {
+			size, err = joinSize(size, sep)
+			if err != nil {
+				break
+			}
+		}
+		size, err = joinSize(size, n)
+		if err != nil {
+			break
+		}
+		if size < 0 {
+			t.Fatalf("Size overflowed to %d after %d lengths", size, idx+1)
+		}
+	}
+	if err != ErrJoinTooLarge {
+		t.Fatalf("Expected ErrJoinTooLarge, got %v with size %d", err, size)
+	}
+
+	if size, err := joinSize(maxJoinSize-10, 10); err != nil || size != maxJoinSize {
+		t.Fatalf("Expected %d, got %d: %v", maxJoinSize, size, err)
+	}
+	if _, err := joinSize(maxJoinSize-10, 11); err != ErrJoinTooLarge {
+		t.Fatalf("Expected ErrJoinTooLarge, got %v", err)
+	}
+}
+
+func TestToStringSlice(t *testing.T) {
+	testToStringSlice(t, false)
+}
+
+func TestToStringSliceCompressed(t *testing.T) {
+	testToStringSlice(t, true)
+}
+
+func testToStringSlice(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	addrs := make([]uintptr, 0)
+	for _, tmpBytes := range testBytes {
+		addr, err := oi.AddOrGet(tmpBytes, true)
+		if err != nil {
+			t.Fatal("Failed to add object to object store")
+		}
+		addrs = append(addrs, addr)
+	}
+
+	strs, err := oi.ToStringSlice(addrs)
+	if err != nil {
+		t.Fatal("Failed to ToStringSlice: ", err)
+	}
+	if !reflect.DeepEqual(strs, testStrings) {
+		t.Fatalf("Expected: %v\nActual: %v\n", testStrings, strs)
+	}
+
+	strs, err = oi.ToStringSlice(nil)
+	if err != nil || len(strs) != 0 {
+		t.Fatalf("Expected empty result for no addresses, got %v: %v", strs, err)
+	}
+
+	// the error needs to identify the first bad address
+	bad := append([]uintptr{addrs[0], 0}, addrs[1:]...)
+	strs, err = oi.ToStringSlice(bad)
+	if err == nil {
+		t.Fatal("ToStringSlice should fail for an unknown address")
+	}
+	if strs != nil {
+		t.Fatalf("Expected nil result on failure, got %v", strs)
+	}
+	if !strings.Contains(err.Error(), "object 1 at 0") {
+		t.Fatalf("Error does not identify the bad address: %v", err)
+	}
+}
+
+func TestResolveInto(t *testing.T) {
+	testResolveInto(t, false)
+}
+
+func TestResolveIntoCompressed(t *testing.T) {
+	testResolveInto(t, true)
+}
+
+func testResolveInto(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	addrs := make([]uintptr, 0, len(testBytes))
+	for _, tmpBytes := range testBytes {
+		addr, err := oi.AddOrGet(tmpBytes, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	// a buffer which is too small is replaced
+	strs, err := oi.ResolveInto(addrs, make([]string, 1))
+	if err != nil || !reflect.DeepEqual(strs, testStrings) {
+		t.Fatalf("Expected %v, got %v: %v", testStrings, strs, err)
+	}
+
+	// a large enough buffer is reused
+	dst := strs
+	strs, err = oi.ResolveInto(addrs[1:3], dst)
+	if err != nil || !reflect.DeepEqual(strs, testStrings[1:3]) {
+		t.Fatalf("Expected %v, got %v: %v", testStrings[1:3], strs, err)
+	}
+	if &strs[0] != &dst[0] {
+		t.Fatal("Expected the buffer to be reused")
+	}
+
+	strs, err = oi.ResolveInto([]uintptr{addrs[0], 0}, dst)
+	if err == nil || len(strs) != 0 || cap(strs) != cap(dst) {
+		t.Fatalf("Expected an empty buffer and an error for an unknown address, got %v: %v", strs, err)
+	}
+	if !strings.Contains(err.Error(), "object 1 at 0") {
+		t.Fatalf("Error does not identify the bad address: %v", err)
+	}
+}
+
+func BenchmarkResolveInto(b *testing.B) {
+	cnf := NewConfig()
+	oi := NewObjectIntern(cnf)
+
+	addrs := make([]uintptr, 0, len(testBytes))
+	for _, tmpBytes := range testBytes {
+		addr, err := oi.AddOrGet(tmpBytes, true)
+		if err != nil {
+			b.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	b.Run("ToStringSlice", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := oi.ToStringSlice(addrs); err != nil {
+				b.Fatal("Failed to ToStringSlice: ", err)
+			}
+		}
+	})
+
+	b.Run("ResolveInto", func(b *testing.B) {
+		b.ReportAllocs()
+		var dst []string
+		var err error
+		for i := 0; i < b.N; i++ {
+			if dst, err = oi.ResolveInto(addrs, dst); err != nil {
+				b.Fatal("Failed to ResolveInto: ", err)
+			}
+		}
+	})
+}
+
+func TestReset(t *testing.T) {
+	c := NewConfig()
+	oi := NewObjectIntern(c)
+
+	data := make([][]byte, 0, 10000)
+	rand.Seed(time.Now().UnixNano())
+	l := len(testStrings)
+
+	for i := 0; i < 10000; i++ {
+		data = append(data, []byte(fmt.Sprintf(testStrings[rand.Intn(l)]+"%d", i)))
+		oi.AddOrGet(data[i], false)
+	}
+
+	if len(oi.objIndex) != 10000 {
+		t.Fatalf("Length of object index should be 10000, instead found: %d", len(oi.objIndex))
+	}
+
+	err := oi.Reset()
+	if err != nil {
+		t.Fatalf("Reset returned an error: %s", err)
+	}
+
+	if len(oi.objIndex) != 0 {
+		t.Fatalf("Length of object index should be 0, instead found: %d", len(oi.objIndex))
+	}
+}
+
+func TestIgnoreMissingOnDelete(t *testing.T) {
+	testIgnoreMissingOnDelete(t, false)
+}
+
+func TestIgnoreMissingOnDeleteCompressed(t *testing.T) {
+	testIgnoreMissingOnDelete(t, true)
+}
+
+func testIgnoreMissingOnDelete(t *testing.T, compress bool) {
+	for _, ignore := range []bool{false, true} {
+		c := NewConfig()
+		if compress {
+			c.Compression = Shoco
+		}
+		c.IgnoreMissingOnDelete = ignore
+		oi := NewObjectIntern(c)
+
+		addr, err := oi.AddOrGet([]byte("servername1234"), true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+
+		results := map[string]func() (bool, error){
+			"Delete":         func() (bool, error) { return oi.Delete(0) },
+			"DeleteByByte":   func() (bool, error) { return oi.DeleteByByte([]byte("doesNotExist")) },
+			"DeleteByString": func() (bool, error) { return oi.DeleteByString("doesNotExist") },
+		}
+		for name, fn := range results {
+			ok, err := fn()
+			if ok {
+				t.Fatalf("%s of a missing object should not report a removal", name)
+			}
+			if ignore && err != nil {
+				t.Fatalf("%s of a missing object should not return an error, got %v", name, err)
+			}
+			if !ignore && err == nil {
+				t.Fatalf("%s of a missing object should return an error", name)
+			}
+		}
+
+		// existing objects are deleted as usual
+		ok, err := oi.DeleteByString("servername1234")
+		if err != nil || !ok {
+			t.Fatalf("Expected the object to be removed, got %t: %v", ok, err)
+		}
+		if _, err := oi.DeleteByString("servername1234"); (err == nil) != ignore {
+			t.Fatalf("Unexpected result deleting a removed object with IgnoreMissingOnDelete %t: %v", ignore, err)
+		}
+		if cnt := oi.Count(); cnt != 0 {
+			t.Fatalf("Expected Count 0, got %d", cnt)
+		}
+
+		// other errors are still returned
+		if err := oi.Close(); err != nil {
+			t.Fatal("Failed to Close: ", err)
+		}
+		if _, err := oi.Delete(addr); err != ErrClosed {
+			t.Fatalf("Expected ErrClosed, got %v", err)
+		}
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	testDeleteAll(t, false, false)
+}
+
+func TestDeleteAllCompressed(t *testing.T) {
+	testDeleteAll(t, true, false)
+}
+
+func TestDeleteAllHashed(t *testing.T) {
+	testDeleteAll(t, false, true)
+}
+
+func testDeleteAll(t *testing.T, compress bool, hashed bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	c.HashedIndex = hashed
+	oi := NewObjectIntern(c)
+	store := oi.store
+	objIndex, hashIndex := oi.objIndex, oi.hashIndex
+
+	// enough objects to fill and free many slabs
+	data := generateTestData(10000, 0.2)
+	for _, obj := range data {
+		if _, err := oi.AddOrGet(obj, true); err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+	}
+	cnt := oi.Count()
+
+	n, err := oi.DeleteAll()
+	if err != nil {
+		t.Fatal("DeleteAll returned an error: ", err)
+	}
+	if n != cnt {
+		t.Fatalf("Expected %d objects to be removed, got %d", cnt, n)
+	}
+	if cnt := oi.Count(); cnt != 0 {
+		t.Fatalf("Expected Count 0 after DeleteAll, got %d", cnt)
+	}
+	if mem, _ := oi.MemStatsTotal(); mem != 0 {
+		t.Fatalf("Expected no memory to be used after DeleteAll, got %d", mem)
+	}
+
+	// the table must keep its store and index
+	if oi.store != store {
+		t.Fatal("DeleteAll replaced the object store")
+	}
+	if reflect.ValueOf(oi.objIndex).Pointer() != reflect.ValueOf(objIndex).Pointer() ||
+		reflect.ValueOf(oi.hashIndex).Pointer() != reflect.ValueOf(hashIndex).Pointer() {
+		t.Fatal("DeleteAll replaced the index")
+	}
+
+	for idx, obj := range testBytes {
+		addr, err := oi.AddOrGet(obj, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet after DeleteAll: ", err)
+		}
+		str, err := oi.GetStringFromPtr(addr)
+		if err != nil || str != testStrings[idx] {
+			t.Fatalf("Expected %q after DeleteAll, got %q: %v", testStrings[idx], str, err)
+		}
+		refCnt, err := oi.RefCnt(addr)
+		if err != nil || refCnt != 1 {
+			t.Fatalf("Expected reference count 1 after DeleteAll, got %d: %v", refCnt, err)
+		}
+	}
+	if err := oi.Verify(); err != nil {
+		t.Fatal("Verify failed: ", err)
+	}
+
+	n, err = oi.DeleteAll()
+	if err != nil || n != len(testBytes) {
+		t.Fatalf("Expected %d objects to be removed, got %d: %v", len(testBytes), n, err)
+	}
+
+	oi.Close()
+	if _, err := oi.DeleteAll(); err != ErrClosed {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestAddOrGetAndDeleteByVal25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	testAddOrGetAndDeleteByVal(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByVal250(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	testAddOrGetAndDeleteByVal(t, 250, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByValNoCprsn25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = None
+	testAddOrGetAndDeleteByVal(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByValNoCprsn250(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = None
+	testAddOrGetAndDeleteByVal(t, 250, 501, cnf)
+}
+
+func testAddOrGetAndDeleteByVal(t *testing.T, keySize int, numKeys int, cnf ObjectInternConfig) {
+	oi := NewObjectIntern(cnf)
+
+	// slice to store addresses
+	addrs := make([]uintptr, 0)
+	// generate numKeys random strings of keySize length
+	originalSzs := make([]string, 0)
+	// also generate compressed versions stored in []byte
+	decompBytes := make([][]byte, 0)
+	for i := 0; i < numKeys; i++ {
+		sz := randStringBytesMaskImprSrc(keySize)
+		originalSzs = append(originalSzs, sz)
+		decompBytes = append(decompBytes, []byte(sz))
+	}
+
+	// reference count should be 1 after this finishes
+	for _, sz := range originalSzs {
+		addr, err := oi.AddOrGet([]byte(sz), true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", []byte(sz))
+			return
+		}
+		// add addr to addrs
+		addrs = append(addrs, addr)
+	}
+
+	// reference count should be 2 after this finishes
+	for _, sz := range originalSzs {
+		_, err := oi.AddOrGet([]byte(sz), true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", []byte(sz))
+			return
+		}
+	}
+
+	// decrease reference count by 1, it should now be 1 again
+	for _, compObj := range decompBytes {
+		ok, err := oi.DeleteByByte(compObj)
+		if err != nil {
+			t.Error("Failed to delete object (possibly not found in the object store): ", compObj)
+			return
+		}
+		if ok {
+			t.Error("Ok should be false since reference count is at 1 now")
+			return
+		}
+	}
+
+	// decrease reference count by 1, now objects should be deleted (slabs are deleted as well)
+	for _, compObj := range decompBytes {
+		ok, err := oi.DeleteByByte(compObj)
+		if err != nil {
+			t.Error("Failed to delete object (possibly not found in the object store): ", compObj)
+			return
+		}
+		if !ok {
+			t.Error("Ok should be true since object should have been deleted")
+			return
+		}
+	}
+
+}
+
+func TestAddOrGetAndDeleteByValSz25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	testAddOrGetAndDeleteByValSz(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByValSz250(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = Shoco
+	testAddOrGetAndDeleteByValSz(t, 250, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByValSzNoCprsn25(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = None
+	testAddOrGetAndDeleteByValSz(t, 25, 501, cnf)
+}
+
+func TestAddOrGetAndDeleteByValSzNoCprsn250(t *testing.T) {
+	cnf := NewConfig()
+	cnf.Compression = None
+	testAddOrGetAndDeleteByValSz(t, 250, 501, cnf)
+}
+
+func testAddOrGetAndDeleteByValSz(t *testing.T, keySize int, numKeys int, cnf ObjectInternConfig) {
+	oi := NewObjectIntern(cnf)
+
+	// slice to store addresses
+	addrs := make([]uintptr, 0)
+	// generate numKeys random strings of keySize length
+	originalSzs := make([]string, 0)
+	for i := 0; i < numKeys; i++ {
+		sz := randStringBytesMaskImprSrc(keySize)
+		originalSzs = append(originalSzs, sz)
+	}
+
+	// reference count should be 1 after this finishes
+	for _, sz := range originalSzs {
+		addr, err := oi.AddOrGet([]byte(sz), true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", []byte(sz))
+			return
+		}
+		// add addr to addrs
+		addrs = append(addrs, addr)
+	}
+
+	// reference count should be 2 after this finishes
+	for _, sz := range originalSzs {
+		_, err := oi.AddOrGet([]byte(sz), true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", []byte(sz))
+			return
+		}
+	}
+
+	// decrease reference count by 1, it should now be 1 again
+	for _, sz := range originalSzs {
+		ok, err := oi.DeleteByString(sz)
+		if err != nil {
+			t.Error("Failed to delete object (possibly not found in the object store): ", sz)
+			return
+		}
+		if ok {
+			t.Error("Ok should be false since reference count is at 1 now")
+			return
+		}
+	}
+
+	// decrease reference count by 1, now objects should be deleted (slabs are deleted as well)
+	for _, sz := range originalSzs {
+		ok, err := oi.DeleteByString(sz)
+		if err != nil {
+			t.Error("Failed to delete object (possibly not found in the object store): ", sz)
+			return
+		}
+		if !ok {
+			t.Error("Ok should be true since object should have been deleted")
+			return
+		}
+	}
+
+}
+
+func TestObjBytes(t *testing.T) {
+	testObjBytes(t, false)
+}
+
+func TestObjBytesCompressed(t *testing.T) {
+	testObjBytes(t, true)
+}
+
+func testObjBytes(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	objAddrs := make([]uintptr, 0)
+
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", b)
+			return
+		}
+		objAddrs = append(objAddrs, addr)
+	}
+
+	for idx, addr := range objAddrs {
+		valFromStore, err := oi.ObjBytes(addr)
+		if err != nil {
+			t.Error("Failed while getting ObjBytes")
+			return
+		}
+		if !bytes.Equal(valFromStore, testBytes[idx]) {
+			t.Error("Original and returned values do not match")
+			return
+		}
+	}
+}
+
+func TestEmptyAndSingleByte(t *testing.T) {
+	testEmptyAndSingleByte(t, false)
+}
+
+func TestEmptyAndSingleByteCompressed(t *testing.T) {
+	testEmptyAndSingleByte(t, true)
+}
+
+func testEmptyAndSingleByte(t *testing.T, compress bool) {
+	for _, refCounting := range []bool{true, false} {
+		c := NewConfig()
+		c.DisableRefCounting = !refCounting
+		if compress {
+			c.Compression = Shoco
+		}
+		oi := NewObjectIntern(c)
+
+		// empty objects are never interned, so no object ever consists of a reference count only
+		if _, err := oi.AddOrGet([]byte{}, true); err != ErrEmptyObject {
+			t.Fatalf("Expected ErrEmptyObject, got %v", err)
+		}
+		if _, err := oi.AddOrGetFromString("", true); err != ErrEmptyObject {
+			t.Fatalf("Expected ErrEmptyObject, got %v", err)
+		}
+		if _, err := oi.GetPtrFromByte(nil); err == nil {
+			t.Fatal("Expected an error looking up an empty object")
+		}
+		if oi.Count() != 0 {
+			t.Fatalf("Expected no objects, got %d", oi.Count())
+		}
+
+		// 'a' compresses to itself, the others need a sentinel byte in shoco's encoding
+		values := [][]byte{{'a'}, {0x00}, {0x01}, {0x80}, {0xff}}
+		addrs := make([]uintptr, len(values))
+		for i, v := range values {
+			addr, err := oi.AddOrGet(v, true)
+			if err != nil {
+				t.Fatalf("Failed to AddOrGet %v: %v", v, err)
+			}
+			addrs[i] = addr
+		}
+
+		for i, v := range values {
+			str, err := oi.GetStringFromPtr(addrs[i])
+			if err != nil || str != string(v) {
+				t.Fatalf("Expected %q, got %q: %v", v, str, err)
+			}
+			b, err := oi.ObjBytes(addrs[i])
+			if err != nil || !bytes.Equal(b, v) {
+				t.Fatalf("Expected %v, got %v: %v", v, b, err)
+			}
+			if addr, err := oi.GetPtrFromByte(v); err != nil || addr != addrs[i] {
+				t.Fatalf("Expected to find %v at %d, got %d: %v", v, addrs[i], addr, err)
+			}
+			if !compress {
+				if lens, all := oi.Len(addrs[i : i+1]); !all || lens[0] != 1 {
+					t.Fatalf("Expected a length of 1 for %v, got %v", v, lens)
+				}
+			}
+		}
+		if !compress {
+			if total, all := oi.TotalLen(addrs); !all || total != len(values) {
+				t.Fatalf("Expected a total length of %d, got %d", len(values), total)
+			}
+		}
+
+		if !refCounting {
+			continue
+		}
+		// the index key of every object is its single byte, so deleting one leaves the others
+		for i, v := range values {
+			removed, err := oi.DeleteByByte(v)
+			if err != nil || !removed {
+				t.Fatalf("Failed to DeleteByByte %v: %v", v, err)
+			}
+			if _, err := oi.GetPtrFromByte(v); err == nil {
+				t.Fatalf("Expected %v to be deleted", v)
+			}
+			if oi.Count() != len(values)-i-1 {
+				t.Fatalf("Expected %d objects, got %d", len(values)-i-1, oi.Count())
+			}
+			if err := oi.Verify(); err != nil {
+				t.Fatal("Failed to Verify: ", err)
+			}
+		}
+	}
+}
+
+func TestEqualAddr(t *testing.T) {
+	testEqualAddr(t, false)
+}
+
+func TestEqualAddrCompressed(t *testing.T) {
+	testEqualAddr(t, true)
+}
+
+func testEqualAddr(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	addrs := make([]uintptr, 0, len(testBytes))
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	for i, a := range addrs {
+		for j, b := range addrs {
+			if equal, err := oi.EqualAddr(a, b); err != nil || equal != (i == j) {
+				t.Fatalf("Expected %q and %q to be equal: %t, got %t: %v", testBytes[i], testBytes[j], i == j, equal, err)
+			}
+		}
+
+		if equal, err := oi.EqualValue(a, testBytes[i]); err != nil || !equal {
+			t.Fatalf("Expected %q to be equal to itself: %v", testBytes[i], err)
+		}
+		other := testBytes[(i+1)%len(testBytes)]
+		if equal, err := oi.EqualValue(a, other); err != nil || equal {
+			t.Fatalf("Expected %q not to be equal to %q: %v", testBytes[i], other, err)
+		}
+		// a prefix of the stored value is not equal either
+		if len(testBytes[i]) > 1 {
+			prefix := testBytes[i][:len(testBytes[i])-1]
+			if equal, err := oi.EqualValue(a, prefix); err != nil || equal {
+				t.Fatalf("Expected %q not to be equal to %q: %v", testBytes[i], prefix, err)
+			}
+		}
+	}
+
+	if _, err := oi.EqualValue(addrs[0], nil); err != ErrEmptyObject {
+		t.Fatalf("Expected ErrEmptyObject, got %v", err)
+	}
+
+	// the slab of a deleted object stays mapped as long as another object of the same size is
+	// stored in it, the deleted address must be rejected anyway
+	kept, err := oi.AddOrGetFromString("equal-1", true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetFromString: ", err)
+	}
+	deleted, err := oi.AddOrGetFromString("equal-2", true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetFromString: ", err)
+	}
+	if oi.SizeOf([]byte("equal-1")) != oi.SizeOf([]byte("equal-2")) {
+		t.Fatal("Expected both objects to have the same size")
+	}
+	if removed, err := oi.Delete(deleted); err != nil || !removed {
+		t.Fatal("Failed to Delete: ", err)
+	}
+	if _, err := oi.EqualAddr(kept, deleted); err == nil {
+		t.Fatal("Expected an error comparing a deleted object")
+	}
+	if _, err := oi.EqualValue(deleted, []byte("equal-2")); err == nil {
+		t.Fatal("Expected an error comparing a deleted object")
+	}
+}
+
+func TestGetBytesInto(t *testing.T) {
+	testGetBytesInto(t, false, 0)
+}
+
+func TestGetBytesIntoCompressed(t *testing.T) {
+	testGetBytesInto(t, true, 0)
+}
+
+func TestGetBytesIntoCompressedCached(t *testing.T) {
+	testGetBytesInto(t, true, 100)
+}
+
+func testGetBytesInto(t *testing.T, compress bool, cacheSize int) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	c.CacheSize = cacheSize
+	oi := NewObjectIntern(c)
+
+	objAddrs := make([]uintptr, 0)
+
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", b)
+			return
+		}
+		objAddrs = append(objAddrs, addr)
+	}
+
+	// start out too small, so the buffer has to grow
+	buf := make([]byte, 0, 4)
+	for idx, addr := range objAddrs {
+		var err error
+		buf, err = oi.GetBytesInto(addr, buf)
+		if err != nil {
+			t.Error("Failed while getting GetBytesInto")
+			return
+		}
+		if !bytes.Equal(buf, testBytes[idx]) {
+			t.Error("Original and returned values do not match")
+			return
+		}
+	}
+
+	// a buffer that is large enough is used as is
+	buf = make([]byte, 0, 256)
+	res, err := oi.GetBytesInto(objAddrs[0], buf)
+	if err != nil {
+		t.Error("Failed while getting GetBytesInto")
+		return
+	}
+	if &res[:1][0] != &buf[:1][0] {
+		t.Error("GetBytesInto allocated although dst was large enough")
+	}
+
+	// the result must not alias the object store
+	res[0] = 'X'
+	str, err := oi.ObjString(objAddrs[0])
+	if err != nil || str != string(testBytes[0]) {
+		t.Errorf("Modifying the result changed the interned object to %q", str)
+	}
+
+	res, err = oi.GetBytesInto(0, buf)
+	if err == nil {
+		t.Error("GetBytesInto should fail for an unknown address")
+	}
+	if len(res) != 0 {
+		t.Errorf("Expected empty result on failure, got %q", res)
+	}
+}
+
+func TestObjBytesBatch(t *testing.T) {
+	testObjBytesBatch(t, false)
+}
+
+func TestObjBytesBatchCompressed(t *testing.T) {
+	testObjBytesBatch(t, true)
+}
+
+func testObjBytesBatch(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	addrs := make([]uintptr, len(testBytes))
+	for idx, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Fatal("Failed to AddOrGet: ", b)
+		}
+		addrs[idx] = addr
+	}
+
+	objs, err := oi.ObjBytesBatch(addrs, nil)
+	if err != nil {
+		t.Fatal("Failed to ObjBytesBatch: ", err)
+	}
+	if len(objs) != len(testBytes) {
+		t.Fatalf("Expected %d objects, got %d", len(testBytes), len(objs))
+	}
+	for idx, obj := range objs {
+		if !bytes.Equal(obj, testBytes[idx]) {
+			t.Fatalf("Expected %q, got %q", testBytes[idx], obj)
+		}
+	}
+
+	// reuse the result in reverse order
+	reversed := make([]uintptr, len(addrs))
+	for idx, addr := range addrs {
+		reversed[len(addrs)-1-idx] = addr
+	}
+	objs, err = oi.ObjBytesBatch(reversed, objs)
+	if err != nil {
+		t.Fatal("Failed to ObjBytesBatch: ", err)
+	}
+	for idx, obj := range objs {
+		if !bytes.Equal(obj, testBytes[len(testBytes)-1-idx]) {
+			t.Fatalf("Expected %q, got %q", testBytes[len(testBytes)-1-idx], obj)
+		}
+	}
+
+	// a smaller batch shrinks the result
+	objs, err = oi.ObjBytesBatch(addrs[:2], objs)
+	if err != nil || len(objs) != 2 || !bytes.Equal(objs[1], testBytes[1]) {
+		t.Fatalf("Expected the first 2 objects, got %q: %v", objs, err)
+	}
+
+	if _, err := oi.ObjBytesBatch([]uintptr{addrs[0], 0}, nil); err == nil {
+		t.Fatal("Expected an error for an address which is not in the store")
+	}
+}
+
+func TestObjString(t *testing.T) {
+	testObjString(t, false)
+}
+
+func TestObjStringCompressed(t *testing.T) {
+	testObjString(t, true)
+}
+
+func testObjString(t *testing.T, compress bool) {
+	c := NewConfig()
+	if compress {
+		c.Compression = Shoco
+	}
+	oi := NewObjectIntern(c)
+
+	objAddrs := make([]uintptr, 0)
+
+	for _, b := range testBytes {
+		addr, err := oi.AddOrGet(b, true)
+		if err != nil {
+			t.Error("Failed to AddOrGet: ", b)
+			return
+		}
+		objAddrs = append(objAddrs, addr)
+	}
+
+	for idx, addr := range objAddrs {
+		valFromStore, err := oi.ObjString(addr)
+		if err != nil {
+			t.Error("Failed while getting ObjString")
+			return
+		}
+		if valFromStore != testStrings[idx] {
+			t.Error("Original and returned values do not match")
+			return
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, compression := range []Compression{None, Shoco} {
+		c := NewConfig()
+		c.Compression = compression
+		oi := NewObjectIntern(c)
+		if oi.Compression() != compression {
+			t.Fatalf("Expected compression %d, got %d", compression, oi.Compression())
+		}
+	}
+}
+
+func TestCompressDecompress(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+	testResults := make([][]byte, 0)
+
+	for _, b := range testBytes {
+		c := oi.Compress(b)
+		d, err := oi.Decompress(c)
+		if err != nil {
+			t.Error("Decompression failed for: ", c)
+			return
+		}
+		testResults = append(testResults, d)
+	}
+
+	for i, res := range testResults {
+		for k, v := range res {
+			if v != testBytes[i][k] {
+				t.Error("Mismatched: ", v, " - ", testBytes[i][k])
+				return
+			}
+		}
+	}
+}
+
+func TestCompressSzDecompressSz(t *testing.T) {
+	oi := NewObjectIntern(NewConfig())
+	testResults := make([]string, 0)
+
+	for _, sz := range testStrings {
+		cSz := oi.CompressString(sz)
+		dSz, err := oi.DecompressString(cSz)
+		if err != nil {
+			t.Error("Decompression failed for: ", cSz)
+			return
+		}
+		testResults = append(testResults, dSz)
+	}
+
+	for i, res := range testResults {
+		if res != testStrings[i] {
+			t.Error("Mismatched: ", res, " - ", testStrings[i])
+			return
+		}
+	}
+}
+
+var globalPtr uintptr
+var globalStr string
+
+func BenchmarkAddOrGet(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		num         int
+		compression bool
+		safe        bool
+		dupe        bool
+		short       bool
+		stringTest  bool
+	}{
+		// AddOrGet
+		{"CompressedUintptr-10", 10, true, true, false, false, false},
+		{"CompressedUintptr-100", 100, true, true, false, false, false},
+		{"CompressedUintptr-1000", 1000, true, true, false, false, false},
+		{"CompressedUintptr-10000", 10000, true, true, false, false, false},
+		// skip short
+		{"CompressedUintptr-100000", 100000, true, true, false, true, false},
+		{"CompressedUintptr-1000000", 1000000, true, true, false, true, false},
+		{"CompressedUintptr-5000000", 5000000, true, true, false, true, false},
+
+		// dupes
+		{"CompressedDuplicatesUintptr-10", 10, true, true, true, false, false},
+		{"CompressedDuplicatesUintptr-100", 100, true, true, true, false, false},
+		{"CompressedDuplicatesUintptr-1000", 1000, true, true, true, false, false},
+		{"CompressedDuplicatesUintptr-10000", 10000, true, true, true, false, false},
+		// skip short
+		{"CompressedDuplicatesUintptr-100000", 100000, true, true, true, true, false},
+		{"CompressedDuplicatesUintptr-1000000", 1000000, true, true, true, true, false},
+		{"CompressedDuplicatesUintptr-5000000", 5000000, true, true, true, true, false},
+
+		{"UnsafeUintptr-10", 10, false, false, false, false, false},
+		{"UnsafeUintptr-100", 100, false, false, false, false, false},
+		{"UnsafeUintptr-1000", 1000, false, false, false, false, false},
+		{"UnsafeUintptr-10000", 10000, false, false, false, false, false},
+		// skip short
+		{"UnsafeUintptr-100000", 100000, false, false, false, true, false},
+		{"UnsafeUintptr-1000000", 1000000, false, false, false, true, false},
+		{"UnsafeUintptr-5000000", 5000000, false, false, false, true, false},
+
+		// dupes
+		{"UnsafeDuplicatesUintptr-10", 10, false, false, true, false, false},
+		{"UnsafeDuplicatesUintptr-100", 100, false, false, true, false, false},
+		{"UnsafeDuplicatesUintptr-1000", 1000, false, false, true, false, false},
+		{"UnsafeDuplicatesUintptr-10000", 10000, false, false, true, false, false},
+		// skip short
+		{"UnsafeDuplicatesUintptr-100000", 100000, false, false, true, true, false},
+		{"UnsafeDuplicatesUintptr-1000000", 1000000, false, false, true, true, false},
+		{"UnsafeDuplicatesUintptr-5000000", 5000000, false, false, true, true, false},
+
+		{"SafeUintptr-10", 10, false, true, false, false, false},
+		{"SafeUintptr-100", 100, false, true, false, false, false},
+		{"SafeUintptr-1000", 1000, false, true, false, false, false},
+		{"SafeUintptr-10000", 10000, false, true, false, false, false},
+		// skip short
+		{"SafeUintptr-100000", 100000, false, true, false, true, false},
+		{"SafeUintptr-1000000", 1000000, false, true, false, true, false},
+		{"SafeUintptr-5000000", 5000000, false, true, false, true, false},
+
+		// dupes
+		{"SafeDuplicatesUintptr-10", 10, false, true, true, false, false},
+		{"SafeDuplicatesUintptr-100", 100, false, true, true, false, false},
+		{"SafeDuplicatesUintptr-1000", 1000, false, true, true, false, false},
+		{"SafeDuplicatesUintptr-10000", 10000, false, true, true, false, false},
+		// skip short
+		{"SafeDuplicatesUintptr-100000", 100000, false, true, true, true, false},
+		{"SafeDuplicatesUintptr-1000000", 1000000, false, true, true, true, false},
+		{"SafeDuplicatesUintptr-5000000", 5000000, false, true, true, true, false},
+
+		// AddOrGetString
+		{"CompressedString-10", 10, true, true, false, false, true},
+		{"CompressedString-100", 100, true, true, false, false, true},
+		{"CompressedString-1000", 1000, true, true, false, false, true},
+		{"CompressedString-10000", 10000, true, true, false, false, true},
+		// skip short
+		{"CompressedString-100000", 100000, true, true, false, true, true},
+		{"CompressedString-1000000", 1000000, true, true, false, true, true},
+		{"CompressedString-5000000", 5000000, true, true, false, true, true},
+
+		// dupes
+		{"CompressedDuplicatesString-10", 10, true, true, true, false, true},
+		{"CompressedDuplicatesString-100", 100, true, true, true, false, true},
+		{"CompressedDuplicatesString-1000", 1000, true, true, true, false, true},
+		{"CompressedDuplicatesString-10000", 10000, true, true, true, false, true},
+		// skip short
+		{"CompressedDuplicatesString-100000", 100000, true, true, true, true, true},
+		{"CompressedDuplicatesString-1000000", 1000000, true, true, true, true, true},
+		{"CompressedDuplicatesString-5000000", 5000000, true, true, true, true, true},
+
+		{"UnsafeString-10", 10, false, false, false, false, true},
+		{"UnsafeString-100", 100, false, false, false, false, true},
+		{"UnsafeString-1000", 1000, false, false, false, false, true},
+		{"UnsafeString-10000", 10000, false, false, false, false, true},
+		// skip short
+		{"UnsafeString-100000", 100000, false, false, false, true, true},
+		{"UnsafeString-1000000", 1000000, false, false, false, true, true},
+		{"UnsafeString-5000000", 5000000, false, false, false, true, true},
+
+		// dupes
+		{"UnsafeDuplicatesString-10", 10, false, false, true, false, true},
+		{"UnsafeDuplicatesString-100", 100, false, false, true, false, true},
+		{"UnsafeDuplicatesString-1000", 1000, false, false, true, false, true},
+		{"UnsafeDuplicatesString-10000", 10000, false, false, true, false, true},
+		// skip short
+		{"UnsafeDuplicatesString-100000", 100000, false, false, true, true, true},
+		{"UnsafeDuplicatesString-1000000", 1000000, false, false, true, true, true},
+		{"UnsafeDuplicatesString-5000000", 5000000, false, false, true, true, true},
+
+		{"SafeString-10", 10, false, true, false, false, true},
+		{"SafeString-100", 100, false, true, false, false, true},
+		{"SafeString-1000", 1000, false, true, false, false, true},
+		{"SafeString-10000", 10000, false, true, false, false, true},
+		// skip short
+		{"SafeString-100000", 100000, false, true, false, true, true},
+		{"SafeString-1000000", 1000000, false, true, false, true, true},
+		{"SafeString-5000000", 5000000, false, true, false, true, true},
+
+		// dupes
+		{"SafeDuplicatesString-10", 10, false, true, true, false, true},
+		{"SafeDuplicatesString-100", 100, false, true, true, false, true},
+		{"SafeDuplicatesString-1000", 1000, false, true, true, false, true},
+		{"SafeDuplicatesString-10000", 10000, false, true, true, false, true},
+		// skip short
+		{"SafeDuplicatesString-100000", 100000, false, true, true, true, true},
+		{"SafeDuplicatesString-1000000", 1000000, false, true, true, true, true},
+		{"SafeDuplicatesString-5000000", 5000000, false, true, true, true, true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			if testing.Short() && bm.short {
+				b.Skip()
+			}
+
+			c := NewConfig()
+			if bm.compression {
+				c.Compression = Shoco
+			}
+
+			oi := NewObjectIntern(c)
+
+			data := make([][]byte, 0, bm.num)
+			for i := 0; i < bm.num; i++ {
+				data = append(data, []byte(fmt.Sprintf("words%d", i)))
+			}
+
+			if bm.dupe {
+				for i := 2; i < bm.num; i += 2 {
+					data[i] = []byte(fmt.Sprintf("words%d", i-1))
+				}
+			}
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			if bm.stringTest {
+				for i := 0; i < b.N; i++ {
+					for _, obj := range data {
+						globalStr, _ = oi.AddOrGetString(obj, bm.safe)
+					}
+				}
+			} else {
+				for i := 0; i < b.N; i++ {
+					for _, obj := range data {
+						globalPtr, _ = oi.AddOrGet(obj, bm.safe)
+					}
+				}
+			}
+		})
+	}
+}
+
+// if you don't use the -short flag while running these benchmarks, they will take
+// a very long time to complete
+func BenchmarkDelete(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		num         int
+		compression bool
+		byByte      bool
+		byString    bool
+		short       bool
+	}{
+		// Delete
+		{"Uintptr-10", 10, false, false, false, false},
+		{"Uintptr-100", 100, false, false, false, false},
+		{"Uintptr-1000", 1000, false, false, false, false},
+		{"Uintptr-10000", 10000, false, false, false, false},
+		// skip short
+		{"Uintptr-100000", 100000, false, false, false, true},
+		{"Uintptr-1000000", 1000000, false, false, false, true},
+		{"Uintptr-5000000", 5000000, false, false, false, true},
+
+		// Delete By Byte
+		{"Byte-10", 10, false, true, false, false},
+		{"Byte-100", 100, false, true, false, false},
+		{"Byte-1000", 1000, false, true, false, false},
+		{"Byte-10000", 10000, false, true, false, false},
+		// skip short
+		{"Byte-100000", 100000, false, true, false, true},
+		{"Byte-1000000", 1000000, false, true, false, true},
+		{"Byte-5000000", 5000000, false, true, false, true},
+
+		// Delete By Byte Compressed
+		{"CompressedByte-10", 10, true, true, false, false},
+		{"CompressedByte-100", 100, true, true, false, false},
+		{"CompressedByte-1000", 1000, true, true, false, false},
+		{"CompressedByte-10000", 10000, true, true, false, false},
+		// skip short
+		{"CompressedByte-100000", 100000, true, true, false, true},
+		{"CompressedByte-1000000", 1000000, true, true, false, true},
+		{"CompressedByte-5000000", 5000000, true, true, false, true},
+
+		// Delete By String
+		{"String-10", 10, false, false, true, false},
+		{"String-100", 100, false, false, true, false},
+		{"String-1000", 1000, false, false, true, false},
+		{"String-10000", 10000, false, false, true, false},
+		// skip short
+		{"String-100000", 100000, false, false, true, true},
+		{"String-1000000", 1000000, false, false, true, true},
+		{"String-5000000", 5000000, false, false, true, true},
+
+		// Delete By String Compressed
+		{"CompressedString-10", 10, true, false, true, false},
+		{"CompressedString-100", 100, true, false, true, false},
+		{"CompressedString-1000", 1000, true, false, true, false},
+		{"CompressedString-10000", 10000, true, false, true, false},
+		// skip short
+		{"CompressedString-100000", 100000, true, false, true, true},
+		{"CompressedString-1000000", 1000000, true, false, true, true},
+		{"CompressedString-5000000", 5000000, true, false, true, true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			if testing.Short() && bm.short {
+				b.Skip()
+			}
+
+			c := NewConfig()
+			if bm.compression {
+				c.Compression = Shoco
+			}
+
+			oi := NewObjectIntern(c)
+
+			var ok bool
+			var err error
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			if bm.byByte {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+
+					data := make([][]byte, 0, bm.num)
+					rand.Seed(time.Now().UnixNano())
+					l := len(testStrings)
+
+					for i := 0; i < bm.num; i++ {
+						data = append(data, []byte(fmt.Sprintf(testStrings[rand.Intn(l)]+"%d", i)))
+						oi.AddOrGet(data[i], false)
+					}
 
-	for _, b := range testBytes {
-		c := oi.Compress(b)
-		d, err := oi.Decompress(c)
-		if err != nil {
-			t.Error("Decompression failed for: ", c)
-			return
-		}
-		testResults = append(testResults, d)
-	}
+					b.StartTimer()
+					for _, obj := range data {
+						ok, err = oi.DeleteByByte(obj)
+						if !ok {
+							b.Fatalf("Failed to delete byte: %v -- %v", obj, err)
+						}
+					}
+				}
+			} else if bm.byString {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
 
-	for i, res := range testResults {
-		for k, v := range res {
-			if v != testBytes[i][k] {
-				t.Error("Mismatched: ", v, " - ", testBytes[i][k])
-				return
-			}
-		}
-	}
-}
+					strs := make([]string, 0, bm.num)
+					data := make([][]byte, 0, bm.num)
+					rand.Seed(time.Now().UnixNano())
+					l := len(testStrings)
 
-func TestCompressSzDecompressSz(t *testing.T) {
-	oi := NewObjectIntern(NewConfig())
-	testResults := make([]string, 0)
+					for i := 0; i < bm.num; i++ {
+						data = append(data, []byte(fmt.Sprintf(testStrings[rand.Intn(l)]+"%d", i)))
+						strs = append(strs, string(data[i]))
+						oi.AddOrGet(data[i], false)
+					}
 
-	for _, sz := range testStrings {
-		cSz := oi.CompressString(sz)
-		dSz, err := oi.DecompressString(cSz)
-		if err != nil {
-			t.Error("Decompression failed for: ", cSz)
-			return
-		}
-		testResults = append(testResults, dSz)
-	}
+					b.StartTimer()
+					for _, str := range strs {
+						ok, err = oi.DeleteByString(str)
+						if !ok {
+							b.Fatalf("Failed to delete string: %s -- %v", str, err)
+						}
+					}
+				}
+			} else {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
 
-	for i, res := range testResults {
-		if res != testStrings[i] {
-			t.Error("Mismatched: ", res, " - ", testStrings[i])
-			return
-		}
+					ptrs := make([]uintptr, 0, bm.num)
+					data := make([][]byte, 0, bm.num)
+					rand.Seed(time.Now().UnixNano())
+					l := len(testStrings)
+
+					for i := 0; i < bm.num; i++ {
+						data = append(data, []byte(fmt.Sprintf(testStrings[rand.Intn(l)]+"%d", i)))
+						globalPtr, _ = oi.AddOrGet(data[i], false)
+						ptrs = append(ptrs, globalPtr)
+					}
+
+					b.StartTimer()
+					for _, ptr := range ptrs {
+						ok, err = oi.Delete(ptr)
+						if !ok {
+							b.Fatalf("Failed to delete by uintptr: %d -- %v", ptr, err)
+						}
+					}
+				}
+			}
+		})
 	}
 }
 
-var globalPtr uintptr
-var globalStr string
-
-func BenchmarkAddOrGet(b *testing.B) {
+// BenchmarkLifecycle interns a set of objects and then deletes all of them again,
+// every op is a whole round trip of the set.
+func BenchmarkLifecycle(b *testing.B) {
 	benchmarks := []struct {
 		name        string
 		num         int
 		compression bool
-		safe        bool
-		dupe        bool
+		dupeRate    float64
 		short       bool
-		stringTest  bool
 	}{
-		// AddOrGet
-		{"CompressedUintptr-10", 10, true, true, false, false, false},
-		{"CompressedUintptr-100", 100, true, true, false, false, false},
-		{"CompressedUintptr-1000", 1000, true, true, false, false, false},
-		{"CompressedUintptr-10000", 10000, true, true, false, false, false},
+		{"None-10", 10, false, 0, false},
+		{"None-100", 100, false, 0, false},
+		{"None-1000", 1000, false, 0, false},
+		{"None-10000", 10000, false, 0, false},
 		// skip short
-		{"CompressedUintptr-100000", 100000, true, true, false, true, false},
-		{"CompressedUintptr-1000000", 1000000, true, true, false, true, false},
-		{"CompressedUintptr-5000000", 5000000, true, true, false, true, false},
+		{"None-100000", 100000, false, 0, true},
+		{"None-1000000", 1000000, false, 0, true},
 
 		// dupes
-		{"CompressedDuplicatesUintptr-10", 10, true, true, true, false, false},
-		{"CompressedDuplicatesUintptr-100", 100, true, true, true, false, false},
-		{"CompressedDuplicatesUintptr-1000", 1000, true, true, true, false, false},
-		{"CompressedDuplicatesUintptr-10000", 10000, true, true, true, false, false},
+		{"NoneDuplicates-10", 10, false, 0.5, false},
+		{"NoneDuplicates-100", 100, false, 0.5, false},
+		{"NoneDuplicates-1000", 1000, false, 0.5, false},
+		{"NoneDuplicates-10000", 10000, false, 0.5, false},
 		// skip short
-		{"CompressedDuplicatesUintptr-100000", 100000, true, true, true, true, false},
-		{"CompressedDuplicatesUintptr-1000000", 1000000, true, true, true, true, false},
-		{"CompressedDuplicatesUintptr-5000000", 5000000, true, true, true, true, false},
+		{"NoneDuplicates-100000", 100000, false, 0.5, true},
+		{"NoneDuplicates-1000000", 1000000, false, 0.5, true},
 
-		{"UnsafeUintptr-10", 10, false, false, false, false, false},
-		{"UnsafeUintptr-100", 100, false, false, false, false, false},
-		{"UnsafeUintptr-1000", 1000, false, false, false, false, false},
-		{"UnsafeUintptr-10000", 10000, false, false, false, false, false},
+		{"Shoco-10", 10, true, 0, false},
+		{"Shoco-100", 100, true, 0, false},
+		{"Shoco-1000", 1000, true, 0, false},
+		{"Shoco-10000", 10000, true, 0, false},
 		// skip short
-		{"UnsafeUintptr-100000", 100000, false, false, false, true, false},
-		{"UnsafeUintptr-1000000", 1000000, false, false, false, true, false},
-		{"UnsafeUintptr-5000000", 5000000, false, false, false, true, false},
+		{"Shoco-100000", 100000, true, 0, true},
+		{"Shoco-1000000", 1000000, true, 0, true},
 
 		// dupes
-		{"UnsafeDuplicatesUintptr-10", 10, false, false, true, false, false},
-		{"UnsafeDuplicatesUintptr-100", 100, false, false, true, false, false},
-		{"UnsafeDuplicatesUintptr-1000", 1000, false, false, true, false, false},
-		{"UnsafeDuplicatesUintptr-10000", 10000, false, false, true, false, false},
+		{"ShocoDuplicates-10", 10, true, 0.5, false},
+		{"ShocoDuplicates-100", 100, true, 0.5, false},
+		{"ShocoDuplicates-1000", 1000, true, 0.5, false},
+		{"ShocoDuplicates-10000", 10000, true, 0.5, false},
 		// skip short
-		{"UnsafeDuplicatesUintptr-100000", 100000, false, false, true, true, false},
-		{"UnsafeDuplicatesUintptr-1000000", 1000000, false, false, true, true, false},
-		{"UnsafeDuplicatesUintptr-5000000", 5000000, false, false, true, true, false},
+		{"ShocoDuplicates-100000", 100000, true, 0.5, true},
+		{"ShocoDuplicates-1000000", 1000000, true, 0.5, true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			if testing.Short() && bm.short {
+				b.Skip()
+			}
 
-		{"SafeUintptr-10", 10, false, true, false, false, false},
-		{"SafeUintptr-100", 100, false, true, false, false, false},
-		{"SafeUintptr-1000", 1000, false, true, false, false, false},
-		{"SafeUintptr-10000", 10000, false, true, false, false, false},
-		// skip short
-		{"SafeUintptr-100000", 100000, false, true, false, true, false},
-		{"SafeUintptr-1000000", 1000000, false, true, false, true, false},
-		{"SafeUintptr-5000000", 5000000, false, true, false, true, false},
+			c := NewConfig()
+			if bm.compression {
+				c.Compression = Shoco
+			}
 
-		// dupes
-		{"SafeDuplicatesUintptr-10", 10, false, true, true, false, false},
-		{"SafeDuplicatesUintptr-100", 100, false, true, true, false, false},
-		{"SafeDuplicatesUintptr-1000", 1000, false, true, true, false, false},
-		{"SafeDuplicatesUintptr-10000", 10000, false, true, true, false, false},
-		// skip short
-		{"SafeDuplicatesUintptr-100000", 100000, false, true, true, true, false},
-		{"SafeDuplicatesUintptr-1000000", 1000000, false, true, true, true, false},
-		{"SafeDuplicatesUintptr-5000000", 5000000, false, true, true, true, false},
+			oi := NewObjectIntern(c)
+			data := generateTestData(bm.num, bm.dupeRate)
+			ptrs := make([]uintptr, len(data))
 
-		// AddOrGetString
-		{"CompressedString-10", 10, true, true, false, false, true},
-		{"CompressedString-100", 100, true, true, false, false, true},
-		{"CompressedString-1000", 1000, true, true, false, false, true},
-		{"CompressedString-10000", 10000, true, true, false, false, true},
-		// skip short
-		{"CompressedString-100000", 100000, true, true, false, true, true},
-		{"CompressedString-1000000", 1000000, true, true, false, true, true},
-		{"CompressedString-5000000", 5000000, true, true, false, true, true},
+			b.ResetTimer()
+			b.ReportAllocs()
 
-		// dupes
-		{"CompressedDuplicatesString-10", 10, true, true, true, false, true},
-		{"CompressedDuplicatesString-100", 100, true, true, true, false, true},
-		{"CompressedDuplicatesString-1000", 1000, true, true, true, false, true},
-		{"CompressedDuplicatesString-10000", 10000, true, true, true, false, true},
-		// skip short
-		{"CompressedDuplicatesString-100000", 100000, true, true, true, true, true},
-		{"CompressedDuplicatesString-1000000", 1000000, true, true, true, true, true},
-		{"CompressedDuplicatesString-5000000", 5000000, true, true, true, true, true},
+			for i := 0; i < b.N; i++ {
+				for idx, obj := range data {
+					ptrs[idx], _ = oi.AddOrGet(obj, true)
+				}
+				for _, ptr := range ptrs {
+					if _, err := oi.Delete(ptr); err != nil {
+						b.Fatalf("Failed to delete by uintptr: %d -- %v", ptr, err)
+					}
+				}
+			}
+		})
+	}
+}
 
-		{"UnsafeString-10", 10, false, false, false, false, true},
-		{"UnsafeString-100", 100, false, false, false, false, true},
-		{"UnsafeString-1000", 1000, false, false, false, false, true},
-		{"UnsafeString-10000", 10000, false, false, false, false, true},
-		// skip short
-		{"UnsafeString-100000", 100000, false, false, false, true, true},
-		{"UnsafeString-1000000", 1000000, false, false, false, true, true},
-		{"UnsafeString-5000000", 5000000, false, false, false, true, true},
+func BenchmarkJoinStrings(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		num         int
+		compression bool
+	}{
+		{"Uncompressed-2", 2, false},
+		{"Uncompressed-5", 5, false},
+		{"Uncompressed-10", 10, false},
+		{"Compressed-2", 2, true},
+		{"Compressed-5", 5, true},
+		{"Compressed-10", 10, true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c := NewConfig()
+			if bm.compression {
+				c.Compression = Shoco
+			}
 
-		// dupes
-		{"UnsafeDuplicatesString-10", 10, false, false, true, false, true},
-		{"UnsafeDuplicatesString-100", 100, false, false, true, false, true},
-		{"UnsafeDuplicatesString-1000", 1000, false, false, true, false, true},
-		{"UnsafeDuplicatesString-10000", 10000, false, false, true, false, true},
-		// skip short
-		{"UnsafeDuplicatesString-100000", 100000, false, false, true, true, true},
-		{"UnsafeDuplicatesString-1000000", 1000000, false, false, true, true, true},
-		{"UnsafeDuplicatesString-5000000", 5000000, false, false, true, true, true},
+			oi := NewObjectIntern(c)
 
-		{"SafeString-10", 10, false, true, false, false, true},
-		{"SafeString-100", 100, false, true, false, false, true},
-		{"SafeString-1000", 1000, false, true, false, false, true},
-		{"SafeString-10000", 10000, false, true, false, false, true},
-		// skip short
-		{"SafeString-100000", 100000, false, true, false, true, true},
-		{"SafeString-1000000", 1000000, false, true, false, true, true},
-		{"SafeString-5000000", 5000000, false, true, false, true, true},
+			nodes := make([]uintptr, 0, bm.num)
+			for _, obj := range testBytes[:bm.num] {
+				addr, err := oi.AddOrGet(obj, true)
+				if err != nil {
+					b.Fatal("Failed to AddOrGet: ", err)
+				}
+				nodes = append(nodes, addr)
+			}
 
-		// dupes
-		{"SafeDuplicatesString-10", 10, false, true, true, false, true},
-		{"SafeDuplicatesString-100", 100, false, true, true, false, true},
-		{"SafeDuplicatesString-1000", 1000, false, true, true, false, true},
-		{"SafeDuplicatesString-10000", 10000, false, true, true, false, true},
-		// skip short
-		{"SafeDuplicatesString-100000", 100000, false, true, true, true, true},
-		{"SafeDuplicatesString-1000000", 1000000, false, true, true, true, true},
-		{"SafeDuplicatesString-5000000", 5000000, false, true, true, true, true},
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				globalStr, _ = oi.JoinStrings(nodes, ".")
+			}
+		})
+	}
+}
+
+func BenchmarkGetStringFromPtr(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		compression Compression
+		cacheSize   int
+	}{
+		{"Uncompressed", None, 0},
+		{"Compressed", Shoco, 0},
+		{"CompressedCached", Shoco, 100},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c := NewConfig()
+			c.Compression = bm.compression
+			c.CacheSize = bm.cacheSize
+			oi := NewObjectIntern(c)
+
+			addrs := make([]uintptr, 0, len(testBytes))
+			for _, obj := range testBytes {
+				addr, err := oi.AddOrGet(obj, true)
+				if err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
+				}
+				addrs = append(addrs, addr)
+			}
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				for _, addr := range addrs {
+					globalStr, _ = oi.GetStringFromPtr(addr)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetStringFromPtrRepeated(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		compression Compression
+		burst       int
+	}{
+		{"Uncompressed", None, 1},
+		{"Compressed", Shoco, 1},
+		{"CompressedBurst", Shoco, 10},
 	}
 	for _, bm := range benchmarks {
 		b.Run(bm.name, func(b *testing.B) {
-			if testing.Short() && bm.short {
-				b.Skip()
-			}
-
 			c := NewConfig()
-			if bm.compression {
-				c.Compression = Shoco
+			c.Compression = bm.compression
+			oi := NewObjectIntern(c)
+
+			addrs := make([]uintptr, 0, len(testBytes))
+			for _, obj := range testBytes {
+				addr, err := oi.AddOrGet(obj, true)
+				if err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
+				}
+				addrs = append(addrs, addr)
 			}
 
-			oi := NewObjectIntern(c)
+			b.ResetTimer()
+			b.ReportAllocs()
 
-			data := make([][]byte, 0, bm.num)
-			for i := 0; i < bm.num; i++ {
-				data = append(data, []byte(fmt.Sprintf("words%d", i)))
+			// every address is read burst times in a row
+			for i := 0; i < b.N; i++ {
+				globalStr, _ = oi.GetStringFromPtr(addrs[i/bm.burst%len(addrs)])
 			}
+		})
+	}
+}
 
-			if bm.dupe {
-				for i := 2; i < bm.num; i += 2 {
-					data[i] = []byte(fmt.Sprintf("words%d", i-1))
+func BenchmarkGetStringFromPtrUnsafe(b *testing.B) {
+	benchmarks := []struct {
+		name   string
+		unsafe bool
+	}{
+		{"Safe", false},
+		{"Unsafe", true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			oi := NewObjectIntern(NewConfig())
+
+			addrs := make([]uintptr, 0, len(testBytes))
+			for _, obj := range testBytes {
+				addr, err := oi.AddOrGet(obj, true)
+				if err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
 				}
+				addrs = append(addrs, addr)
 			}
 
 			b.ResetTimer()
 			b.ReportAllocs()
 
-			if bm.stringTest {
+			if bm.unsafe {
 				for i := 0; i < b.N; i++ {
-					for _, obj := range data {
-						globalStr, _ = oi.AddOrGetString(obj, bm.safe)
+					for idx, addr := range addrs {
+						globalStr = oi.GetStringFromPtrUnsafe(addr, len(testBytes[idx]))
 					}
 				}
 			} else {
 				for i := 0; i < b.N; i++ {
-					for _, obj := range data {
-						globalPtr, _ = oi.AddOrGet(obj, bm.safe)
+					for _, addr := range addrs {
+						globalStr, _ = oi.GetStringFromPtr(addr)
 					}
 				}
 			}
@@ -1141,154 +4518,264 @@ func BenchmarkAddOrGet(b *testing.B) {
 	}
 }
 
-// if you don't use the -short flag while running these benchmarks, they will take
-// a very long time to complete
-func BenchmarkDelete(b *testing.B) {
+func BenchmarkGetBytesInto(b *testing.B) {
 	benchmarks := []struct {
 		name        string
-		num         int
-		compression bool
-		byByte      bool
-		byString    bool
-		short       bool
+		compression Compression
+		cacheSize   int
+		into        bool
 	}{
-		// Delete
-		{"Uintptr-10", 10, false, false, false, false},
-		{"Uintptr-100", 100, false, false, false, false},
-		{"Uintptr-1000", 1000, false, false, false, false},
-		{"Uintptr-10000", 10000, false, false, false, false},
-		// skip short
-		{"Uintptr-100000", 100000, false, false, false, true},
-		{"Uintptr-1000000", 1000000, false, false, false, true},
-		{"Uintptr-5000000", 5000000, false, false, false, true},
+		{"UncompressedObjBytes", None, 0, false},
+		{"UncompressedInto", None, 0, true},
+		{"CompressedObjBytes", Shoco, 0, false},
+		{"CompressedInto", Shoco, 0, true},
+		{"CompressedCachedObjBytes", Shoco, 100, false},
+		{"CompressedCachedInto", Shoco, 100, true},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c := NewConfig()
+			c.Compression = bm.compression
+			c.CacheSize = bm.cacheSize
+			oi := NewObjectIntern(c)
 
-		// Delete By Byte
-		{"Byte-10", 10, false, true, false, false},
-		{"Byte-100", 100, false, true, false, false},
-		{"Byte-1000", 1000, false, true, false, false},
-		{"Byte-10000", 10000, false, true, false, false},
-		// skip short
-		{"Byte-100000", 100000, false, true, false, true},
-		{"Byte-1000000", 1000000, false, true, false, true},
-		{"Byte-5000000", 5000000, false, true, false, true},
+			addrs := make([]uintptr, 0, len(testBytes))
+			for _, obj := range testBytes {
+				addr, err := oi.AddOrGet(obj, true)
+				if err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
+				}
+				addrs = append(addrs, addr)
+			}
 
-		// Delete By Byte Compressed
-		{"CompressedByte-10", 10, true, true, false, false},
-		{"CompressedByte-100", 100, true, true, false, false},
-		{"CompressedByte-1000", 1000, true, true, false, false},
-		{"CompressedByte-10000", 10000, true, true, false, false},
-		// skip short
-		{"CompressedByte-100000", 100000, true, true, false, true},
-		{"CompressedByte-1000000", 1000000, true, true, false, true},
-		{"CompressedByte-5000000", 5000000, true, true, false, true},
+			var buf []byte
 
-		// Delete By String
-		{"String-10", 10, false, false, true, false},
-		{"String-100", 100, false, false, true, false},
-		{"String-1000", 1000, false, false, true, false},
-		{"String-10000", 10000, false, false, true, false},
-		// skip short
-		{"String-100000", 100000, false, false, true, true},
-		{"String-1000000", 1000000, false, false, true, true},
-		{"String-5000000", 5000000, false, false, true, true},
+			b.ResetTimer()
+			b.ReportAllocs()
 
-		// Delete By String Compressed
-		{"CompressedString-10", 10, true, false, true, false},
-		{"CompressedString-100", 100, true, false, true, false},
-		{"CompressedString-1000", 1000, true, false, true, false},
-		{"CompressedString-10000", 10000, true, false, true, false},
-		// skip short
-		{"CompressedString-100000", 100000, true, false, true, true},
-		{"CompressedString-1000000", 1000000, true, false, true, true},
-		{"CompressedString-5000000", 5000000, true, false, true, true},
+			if bm.into {
+				for i := 0; i < b.N; i++ {
+					for _, addr := range addrs {
+						buf, _ = oi.GetBytesInto(addr, buf)
+					}
+				}
+			} else {
+				for i := 0; i < b.N; i++ {
+					for _, addr := range addrs {
+						buf, _ = oi.ObjBytes(addr)
+					}
+				}
+			}
+			globalBSlice = buf
+		})
+	}
+}
+
+func BenchmarkGetPtrFromByteBatch(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		compression Compression
+		batch       bool
+	}{
+		{"Uncompressed", None, false},
+		{"UncompressedBatch", None, true},
+		{"Compressed", Shoco, false},
+		{"CompressedBatch", Shoco, true},
 	}
+	data := generateTestData(1000, 0)
 	for _, bm := range benchmarks {
 		b.Run(bm.name, func(b *testing.B) {
-			if testing.Short() && bm.short {
-				b.Skip()
+			c := NewConfig()
+			c.Compression = bm.compression
+			oi := NewObjectIntern(c)
+
+			for _, obj := range data {
+				if _, err := oi.AddOrGet(obj, true); err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
+				}
 			}
 
-			c := NewConfig()
-			if bm.compression {
-				c.Compression = Shoco
+			addrs := make([]uintptr, len(data))
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			if bm.batch {
+				for i := 0; i < b.N; i++ {
+					addrs, _ = oi.GetPtrFromByteBatch(data)
+				}
+			} else {
+				for i := 0; i < b.N; i++ {
+					for idx, obj := range data {
+						addrs[idx], _ = oi.GetPtrFromByte(obj)
+					}
+				}
 			}
+			globalPtr = addrs[0]
+		})
+	}
+}
+
+func BenchmarkGetPtrFromByteCompressed(b *testing.B) {
+	c := NewConfig()
+	c.Compression = Shoco
+	oi := NewObjectIntern(c)
+
+	data := generateTestData(1000, 0)
+	for _, obj := range data {
+		if _, err := oi.AddOrGet(obj, true); err != nil {
+			b.Fatalf("Failed to AddOrGet: %v", obj)
+		}
+	}
+
+	var addr uintptr
+
+	b.ResetTimer()
+	b.ReportAllocs()
 
+	for i := 0; i < b.N; i++ {
+		addr, _ = oi.GetPtrFromByte(data[i%len(data)])
+	}
+	globalPtr = addr
+}
+
+func BenchmarkDeleteByValueCompressed(b *testing.B) {
+	benchmarks := []struct {
+		name   string
+		string bool
+	}{
+		{"Byte", false},
+		{"String", true},
+	}
+	data := generateTestData(100, 0)
+	strs := make([]string, len(data))
+	for idx, obj := range data {
+		strs[idx] = string(obj)
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c := NewConfig()
+			c.Compression = Shoco
 			oi := NewObjectIntern(c)
 
-			var ok bool
-			var err error
+			// every object is referenced often enough to only be decremented
+			for _, obj := range data {
+				addr, err := oi.AddOrGet(obj, true)
+				if err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
+				}
+				if _, err := oi.IncRefCntBy(addr, uint32(b.N)); err != nil {
+					b.Fatal("Failed to IncRefCntBy: ", err)
+				}
+			}
 
 			b.ResetTimer()
 			b.ReportAllocs()
 
-			if bm.byByte {
+			if bm.string {
 				for i := 0; i < b.N; i++ {
-					b.StopTimer()
-
-					data := make([][]byte, 0, bm.num)
-					rand.Seed(time.Now().UnixNano())
-					l := len(testStrings)
+					oi.DeleteByString(strs[i%len(strs)])
+				}
+			} else {
+				for i := 0; i < b.N; i++ {
+					oi.DeleteByByte(data[i%len(data)])
+				}
+			}
+		})
+	}
+}
 
-					for i := 0; i < bm.num; i++ {
-						data = append(data, []byte(fmt.Sprintf(testStrings[rand.Intn(l)]+"%d", i)))
-						oi.AddOrGet(data[i], false)
-					}
+func BenchmarkAddOrGetFromString(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		compression Compression
+		fromString  bool
+	}{
+		{"UncompressedBytes", None, false},
+		{"UncompressedString", None, true},
+		{"CompressedBytes", Shoco, false},
+		{"CompressedString", Shoco, true},
+	}
+	data := generateTestData(1000, 0)
+	strs := make([]string, len(data))
+	for idx, obj := range data {
+		strs[idx] = string(obj)
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c := NewConfig()
+			c.Compression = bm.compression
+			oi := NewObjectIntern(c)
 
-					b.StartTimer()
-					for _, obj := range data {
-						ok, err = oi.DeleteByByte(obj)
-						if !ok {
-							b.Fatalf("Failed to delete byte: %v -- %v", obj, err)
-						}
-					}
+			// every call in the benchmark is a hit
+			for _, obj := range data {
+				if _, err := oi.AddOrGet(obj, true); err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
 				}
-			} else if bm.byString {
-				for i := 0; i < b.N; i++ {
-					b.StopTimer()
+			}
 
-					strs := make([]string, 0, bm.num)
-					data := make([][]byte, 0, bm.num)
-					rand.Seed(time.Now().UnixNano())
-					l := len(testStrings)
+			var addr uintptr
 
-					for i := 0; i < bm.num; i++ {
-						data = append(data, []byte(fmt.Sprintf(testStrings[rand.Intn(l)]+"%d", i)))
-						strs = append(strs, string(data[i]))
-						oi.AddOrGet(data[i], false)
-					}
+			b.ResetTimer()
+			b.ReportAllocs()
 
-					b.StartTimer()
-					for _, str := range strs {
-						ok, err = oi.DeleteByString(str)
-						if !ok {
-							b.Fatalf("Failed to delete string: %s -- %v", str, err)
-						}
-					}
+			if bm.fromString {
+				for i := 0; i < b.N; i++ {
+					addr, _ = oi.AddOrGetFromString(strs[i%len(strs)], true)
 				}
 			} else {
 				for i := 0; i < b.N; i++ {
-					b.StopTimer()
+					addr, _ = oi.AddOrGet([]byte(strs[i%len(strs)]), true)
+				}
+			}
+			globalPtr = addr
+		})
+	}
+}
 
-					ptrs := make([]uintptr, 0, bm.num)
-					data := make([][]byte, 0, bm.num)
-					rand.Seed(time.Now().UnixNano())
-					l := len(testStrings)
+func BenchmarkObjBytesBatch(b *testing.B) {
+	benchmarks := []struct {
+		name        string
+		compression Compression
+		batch       bool
+	}{
+		{"Uncompressed", None, false},
+		{"UncompressedBatch", None, true},
+		{"Compressed", Shoco, false},
+		{"CompressedBatch", Shoco, true},
+	}
+	data := generateTestData(1000, 0)
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			c := NewConfig()
+			c.Compression = bm.compression
+			oi := NewObjectIntern(c)
 
-					for i := 0; i < bm.num; i++ {
-						data = append(data, []byte(fmt.Sprintf(testStrings[rand.Intn(l)]+"%d", i)))
-						globalPtr, _ = oi.AddOrGet(data[i], false)
-						ptrs = append(ptrs, globalPtr)
-					}
+			addrs := make([]uintptr, len(data))
+			for idx, obj := range data {
+				var err error
+				if addrs[idx], err = oi.AddOrGet(obj, true); err != nil {
+					b.Fatalf("Failed to AddOrGet: %v", obj)
+				}
+			}
 
-					b.StartTimer()
-					for _, ptr := range ptrs {
-						ok, err = oi.Delete(ptr)
-						if !ok {
-							b.Fatalf("Failed to delete by uintptr: %d -- %v", ptr, err)
-						}
+			objs := make([][]byte, len(addrs))
+
+			b.ResetTimer()
+			b.ReportAllocs()
+
+			if bm.batch {
+				for i := 0; i < b.N; i++ {
+					objs, _ = oi.ObjBytesBatch(addrs, objs)
+				}
+			} else {
+				for i := 0; i < b.N; i++ {
+					for idx, addr := range addrs {
+						objs[idx], _ = oi.ObjBytes(addr)
 					}
 				}
 			}
+			globalBSlice = objs[0]
 		})
 	}
 }
@@ -0,0 +1,112 @@
+package goi
+
+import (
+	"fmt"
+	"time"
+)
+
+// AddOrGetWithTTL does the same thing as AddOrGet, but the object expires once ttl has
+// passed. Expired objects are treated as absent by GetPtrFromByte, and Expire removes
+// them from the object store regardless of their reference counts, which invalidates
+// every address of them handed out before. If ttl is not positive it returns 0 and an error.
+//
+// If the object is already interned with a deadline, the later of both deadlines is kept,
+// so interning it again extends its lifetime. If it is already interned by AddOrGet it
+// never expires, only its reference count is increased. Objects interned by any method
+// other than AddOrGetWithTTL don't expire either.
+func (oi *ObjectIntern) AddOrGetWithTTL(obj []byte, ttl time.Duration, safe bool) (uintptr, error) {
+	if oi.Frozen() {
+		return 0, ErrFrozen
+	}
+
+	if ttl <= 0 {
+		return 0, fmt.Errorf("Invalid TTL: %s", ttl)
+	}
+
+	obj = oi.normalize(obj)
+	if len(obj) == 0 {
+		return 0, ErrEmptyObject
+	}
+
+	if oi.conf.Compression != None {
+		// this returns a new byte slice, so we don't need to check for safe
+		obj = oi.compress(obj)
+	} else if safe {
+		// we add 4 bytes to the capacity in case we need to append a reference count
+		objCopy := make([]byte, len(obj), len(obj)+4)
+		copy(objCopy, obj)
+		obj = objCopy
+	}
+
+	// the deadline needs to be set together with the reference, so this always
+	// takes the write lock
+	oi.lock()
+	defer oi.Unlock()
+
+	deadline := time.Now().Add(ttl)
+
+	addr, ok := oi.getAndIncrement(obj)
+	if ok {
+		if old, ok := oi.deadlines[addr]; ok && deadline.After(old) {
+			oi.deadlines[addr] = deadline
+		}
+		return addr, nil
+	}
+
+	addr, err := oi.add(obj)
+	if err != nil {
+		return 0, err
+	}
+	oi.deadlines[addr] = deadline
+
+	return addr, nil
+}
+
+// Expire removes every object whose deadline set by AddOrGetWithTTL has passed from the
+// index and the object store, regardless of its reference count, and returns the number
+// of objects removed. OnEvict is called for each of them.
+func (oi *ObjectIntern) Expire() int {
+	if oi.Frozen() {
+		return 0
+	}
+
+	oi.lock()
+
+	now := time.Now()
+	var evicted []eviction
+	var removed int
+	for addr, deadline := range oi.deadlines {
+		if now.Before(deadline) {
+			continue
+		}
+
+		obj, err := oi.store.Get(addr)
+		if err != nil {
+			delete(oi.deadlines, addr)
+			continue
+		}
+		// evict also deletes addr from the deadlines
+		evicted, err = oi.evict(obj, addr, evicted)
+		if err == nil {
+			removed++
+		}
+	}
+
+	oi.Unlock()
+
+	oi.notifyEvicted(evicted)
+
+	return removed
+}
+
+// expired returns true if the object at addr has been added by AddOrGetWithTTL and its
+// deadline has passed.
+//
+// The caller is responsible for locking and unlocking.
+func (oi *ObjectIntern) expired(addr uintptr) bool {
+	if len(oi.deadlines) == 0 {
+		return false
+	}
+	deadline, ok := oi.deadlines[addr]
+	return ok && !time.Now().Before(deadline)
+}
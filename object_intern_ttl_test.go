@@ -0,0 +1,92 @@
+package goi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddOrGetWithTTL(t *testing.T) {
+	testAddOrGetWithTTL(t, false)
+}
+
+func TestAddOrGetWithTTLCompressed(t *testing.T) {
+	testAddOrGetWithTTL(t, true)
+}
+
+func testAddOrGetWithTTL(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	var evicted []string
+	cnf.OnEvict = func(addr uintptr, data []byte) {
+		evicted = append(evicted, string(data))
+	}
+	oi := NewObjectIntern(cnf)
+
+	if _, err := oi.AddOrGetWithTTL([]byte("short"), 0, true); err == nil {
+		t.Fatal("Expected an error for a TTL of 0")
+	}
+
+	permanent, err := oi.AddOrGet([]byte("permanent"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	short, err := oi.AddOrGetWithTTL([]byte("short"), 20*time.Millisecond, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetWithTTL: ", err)
+	}
+	// interning it again with a longer TTL extends its deadline
+	extended, err := oi.AddOrGetWithTTL([]byte("extended"), 20*time.Millisecond, true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGetWithTTL: ", err)
+	}
+	if addr, err := oi.AddOrGetWithTTL([]byte("extended"), time.Hour, true); err != nil || addr != extended {
+		t.Fatalf("Expected %d, got %d: %v", extended, addr, err)
+	}
+	// objects interned without a TTL never expire
+	if addr, err := oi.AddOrGetWithTTL([]byte("permanent"), 20*time.Millisecond, true); err != nil || addr != permanent {
+		t.Fatalf("Expected %d, got %d: %v", permanent, addr, err)
+	}
+
+	if addr, err := oi.GetPtrFromByte([]byte("short")); err != nil || addr != short {
+		t.Fatalf("Expected %d, got %d: %v", short, addr, err)
+	}
+	if removed := oi.Expire(); removed != 0 {
+		t.Fatalf("Expected no object to expire yet, got %d", removed)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, err := oi.GetPtrFromByte([]byte("short")); err == nil {
+		t.Fatal("Expected the expired object to be absent")
+	}
+	addrs, errs := oi.GetPtrFromByteBatch([][]byte{[]byte("short"), []byte("permanent")})
+	if errs[0] == nil || addrs[0] != 0 {
+		t.Fatalf("Expected the expired object to be absent from the batch, got %d: %v", addrs[0], errs[0])
+	}
+	if errs[1] != nil || addrs[1] != permanent {
+		t.Fatalf("Expected %d, got %d: %v", permanent, addrs[1], errs[1])
+	}
+	if removed := oi.Expire(); removed != 1 {
+		t.Fatalf("Expected 1 object to expire, got %d", removed)
+	}
+	if len(evicted) != 1 || evicted[0] != "short" {
+		t.Fatalf("Expected OnEvict to be called for short, got %q", evicted)
+	}
+	if oi.Count() != 2 {
+		t.Fatalf("Expected 2 objects to remain, got %d", oi.Count())
+	}
+	if removed := oi.Expire(); removed != 0 {
+		t.Fatalf("Expected no object to expire twice, got %d", removed)
+	}
+
+	for _, obj := range []string{"permanent", "extended"} {
+		if _, err := oi.GetPtrFromByte([]byte(obj)); err != nil {
+			t.Fatalf("Expected %s to remain: %v", obj, err)
+		}
+	}
+	if cnt, err := oi.RefCnt(permanent); err != nil || cnt != 2 {
+		t.Fatalf("Expected a reference count of 2, got %d: %v", cnt, err)
+	}
+}
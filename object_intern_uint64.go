@@ -0,0 +1,96 @@
+package goi
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrNotUint64 is returned by Uint64FromPtr when the object at the given address
+// was not interned with AddOrGetUint64.
+var ErrNotUint64 = errors.New("Object is not a uint64")
+
+// uint64Size is the length of an interned uint64
+const uint64Size = 8
+
+// AddOrGetUint64 finds or adds the integer v and returns its address and nil upon success.
+// On failure it returns 0 and an error
+//
+// v is interned as its 8 byte big endian encoding, so it is deduplicated and reference
+// counted just like any other object. Normalization is never applied.
+//
+// Integers are never compressed. If compression is turned on they are stored in shoco's
+// literal encoding, so every other method still decompresses them correctly. If it is
+// turned off, AddOrGet, GetPtrFromByte and DeleteByByte find the same object when they
+// are given the encoding of v.
+func (oi *ObjectIntern) AddOrGetUint64(v uint64) (uintptr, error) {
+	var buf [uint64Size]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+
+	// we add 4 bytes to the capacity in case we need to append a reference count,
+	// the literal encoding needs at most one sentinel byte per byte of the integer
+	obj := make([]byte, 0, uint64Size*2+4)
+	if oi.conf.Compression != None {
+		obj = appendShocoLiteral(obj, buf[:])
+	} else {
+		obj = append(obj, buf[:]...)
+	}
+
+	// acquire lock
+	oi.RLock()
+
+	addr, ok := oi.getAndIncrement(obj)
+	if ok {
+		oi.RUnlock()
+		return addr, nil
+	}
+
+	oi.RUnlock()
+
+	oi.lock()
+
+	// re-check everything
+	addr, ok = oi.getAndIncrement(obj)
+	if ok {
+		oi.Unlock()
+		return addr, nil
+	}
+
+	addr, err := oi.add(obj)
+	if err != nil {
+		oi.Unlock()
+		return 0, err
+	}
+
+	oi.Unlock()
+	return addr, nil
+}
+
+// Uint64FromPtr returns the integer interned with AddOrGetUint64 at objAddr and nil.
+// If the object is not 8 bytes long it returns 0 and ErrNotUint64.
+// On failure it returns 0 and an error.
+//
+// This method does not increase the reference count of the interned object.
+func (oi *ObjectIntern) Uint64FromPtr(objAddr uintptr) (uint64, error) {
+	oi.RLock()
+	defer oi.RUnlock()
+
+	b, err := oi.store.Get(objAddr)
+	if err != nil {
+		return 0, err
+	}
+
+	// remove leading bytes for reference count
+	b = b[oi.refCntSize:]
+
+	if oi.conf.Compression != None {
+		b, err = oi.decompress(b)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(b) != uint64Size {
+		return 0, ErrNotUint64
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
@@ -0,0 +1,60 @@
+package goi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUint64(t *testing.T) {
+	testUint64(t, false)
+}
+
+func TestUint64Compressed(t *testing.T) {
+	testUint64(t, true)
+}
+
+func testUint64(t *testing.T, compress bool) {
+	cnf := NewConfig()
+	if compress {
+		cnf.Compression = Shoco
+	}
+	oi := NewObjectIntern(cnf)
+
+	values := []uint64{0, 1, 0x7f, 0x80, 0xff, 256, 0x6162636465666768, math.MaxUint32, math.MaxInt64, math.MaxUint64 - 1, math.MaxUint64}
+	addrs := make(map[uint64]uintptr, len(values))
+	for _, v := range values {
+		addr, err := oi.AddOrGetUint64(v)
+		if err != nil {
+			t.Fatalf("Failed to AddOrGetUint64 %d: %v", v, err)
+		}
+		addrs[v] = addr
+	}
+
+	for _, v := range values {
+		got, err := oi.Uint64FromPtr(addrs[v])
+		if err != nil || got != v {
+			t.Fatalf("Expected %d, got %d: %v", v, got, err)
+		}
+
+		// interning the same value again must find the same object
+		addr, err := oi.AddOrGetUint64(v)
+		if err != nil || addr != addrs[v] {
+			t.Fatalf("Expected %d to be deduplicated at %d, got %d: %v", v, addrs[v], addr, err)
+		}
+		if cnt, err := oi.RefCnt(addr); err != nil || cnt != 2 {
+			t.Fatalf("Expected a reference count of 2 for %d, got %d: %v", v, cnt, err)
+		}
+	}
+
+	if oi.Count() != len(values) {
+		t.Fatalf("Expected %d distinct objects, got %d", len(values), oi.Count())
+	}
+
+	addr, err := oi.AddOrGet([]byte("not an integer"), true)
+	if err != nil {
+		t.Fatal("Failed to AddOrGet: ", err)
+	}
+	if _, err := oi.Uint64FromPtr(addr); err != ErrNotUint64 {
+		t.Fatalf("Expected ErrNotUint64, got %v", err)
+	}
+}